@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// outputPathData is the data available to -output-template
+type outputPathData struct {
+	Path  string // Input path as given or found in a directory
+	Dir   string // Directory of the input
+	Name  string // File name of the input
+	Base  string // File name without the .json or .json.gz extension
+	Index int    // Position of the input, starting at 0
+}
+
+// conversionJob is one input and the output path it converts to
+type conversionJob struct {
+	input  string
+	output string
+}
+
+// expandOutputTemplate lists the trace files in args, descending one level
+// into directories, and renders each one's output path from tmpl
+func expandOutputTemplate(tmpl string, args []string) ([]conversionJob, error) {
+	t, err := template.New("output").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -output-template: %w", err)
+	}
+
+	var inputs []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			inputs = append(inputs, arg)
+			continue
+		}
+		entries, err := os.ReadDir(arg)
+		if err != nil {
+			return nil, err
+		}
+		var found []string
+		for _, e := range entries {
+			if !e.IsDir() && isTraceFileName(e.Name()) {
+				found = append(found, filepath.Join(arg, e.Name()))
+			}
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("no .json or .json.gz traces in %s", arg)
+		}
+		sort.Strings(found)
+		inputs = append(inputs, found...)
+	}
+
+	jobs := make([]conversionJob, 0, len(inputs))
+	outputs := make(map[string]string, len(inputs))
+	for i, input := range inputs {
+		name := filepath.Base(input)
+		var b strings.Builder
+		err := t.Execute(&b, outputPathData{
+			Path:  input,
+			Dir:   filepath.Dir(input),
+			Name:  name,
+			Base:  traceBaseName(name),
+			Index: i,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("rendering -output-template for %s: %w", input, err)
+		}
+		output := filepath.Clean(b.String())
+		if prev, ok := outputs[output]; ok {
+			return nil, fmt.Errorf("%s and %s would both be written to %s", prev, input, output)
+		}
+		outputs[output] = input
+		jobs = append(jobs, conversionJob{input: input, output: output})
+	}
+	return jobs, nil
+}
+
+// isTraceFileName reports whether name looks like a trace file
+func isTraceFileName(name string) bool {
+	return strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".json.gz")
+}
+
+// defaultOutputPath derives the profile path of a trace when no output is
+// given: trace.json and trace.json.gz become trace.pb.gz in the same directory
+func defaultOutputPath(input string) string {
+	if isTraceFileName(input) {
+		return traceBaseName(input) + ".pb.gz"
+	}
+	return input + ".pb.gz"
+}
+
+// splitConvertArgs splits the arguments of convert into the inputs and
+// the output, named by -o or by the second of exactly two arguments when
+// it is not a trace. An empty output writes each input next to itself.
+// Several inputs are only merged into an output named by -o, so a trace
+// is never overwritten as the output of the others.
+func splitConvertArgs(args []string, output string) (inputs []string, out string, err error) {
+	last := args[len(args)-1]
+	switch {
+	case output != "":
+		return args, output, nil
+	case len(args) == 2 && !isTraceFileName(last):
+		return args[:1], last, nil
+	case len(args) > 2 && !isTraceFileName(last):
+		return nil, "", fmt.Errorf("%s is not a .json or .json.gz trace: name the output of merged traces with -o", last)
+	}
+	return args, "", nil
+}
+
+// sourceLabels returns the shortest suffix of each input path, in whole
+// path elements, that no other input ends with: r0.json and r1.json stay
+// as they are, while run0/trace.json and run1/trace.json keep their
+// directories
+func sourceLabels(inputs []string) []string {
+	parts := make([][]string, len(inputs))
+	for i, input := range inputs {
+		parts[i] = strings.Split(filepath.ToSlash(filepath.Clean(input)), "/")
+	}
+	suffix := func(p []string, n int) string {
+		return strings.Join(p[max(len(p)-n, 0):], "/")
+	}
+	labels := make([]string, len(inputs))
+	for i, p := range parts {
+		n := 1
+		for ; n < len(p); n++ {
+			unique := true
+			for j, other := range parts {
+				if j != i && suffix(other, n) == suffix(p, n) {
+					unique = false
+					break
+				}
+			}
+			if unique {
+				break
+			}
+		}
+		labels[i] = suffix(p, n)
+	}
+	return labels
+}
+
+// traceBaseName strips the .json or .json.gz extension from a file name
+func traceBaseName(name string) string {
+	name = strings.TrimSuffix(name, ".gz")
+	return strings.TrimSuffix(name, ".json")
+}
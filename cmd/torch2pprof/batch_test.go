@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandOutputTemplate(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.json.gz", "a.json", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	empty := filepath.Join(dir, "empty")
+	if err := os.Mkdir(empty, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	a, b := filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json.gz")
+
+	tests := []struct {
+		name string
+		tmpl string
+		args []string
+		want []conversionJob
+		err  string
+	}{
+		{
+			name: "directory",
+			tmpl: "{{.Dir}}/{{.Base}}.pb.gz",
+			args: []string{dir},
+			want: []conversionJob{{a, filepath.Join(dir, "a.pb.gz")}, {b, filepath.Join(dir, "b.pb.gz")}},
+		},
+		{
+			name: "files in order",
+			tmpl: "out/{{.Index}}-{{.Name}}",
+			args: []string{b, a},
+			want: []conversionJob{{b, "out/0-b.json.gz"}, {a, "out/1-a.json"}},
+		},
+		{
+			name: "path",
+			tmpl: "{{.Path}}.pb.gz",
+			args: []string{a},
+			want: []conversionJob{{a, a + ".pb.gz"}},
+		},
+		{name: "same output", tmpl: "out.pb.gz", args: []string{a, b}, err: "would both be written to out.pb.gz"},
+		{name: "unknown field", tmpl: "{{.Rank}}.pb.gz", args: []string{a}, err: "rendering -output-template"},
+		{name: "invalid template", tmpl: "{{.Base", args: []string{a}, err: "invalid -output-template"},
+		{name: "no traces", tmpl: "{{.Base}}.pb.gz", args: []string{empty}, err: "no .json or .json.gz traces"},
+		{name: "missing input", tmpl: "{{.Base}}.pb.gz", args: []string{filepath.Join(dir, "missing.json")}, err: "missing.json"},
+	}
+	for _, tt := range tests {
+		jobs, err := expandOutputTemplate(tt.tmpl, tt.args)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.err, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(jobs, tt.want) {
+			t.Errorf("%s: expected %v, got %v, %v", tt.name, tt.want, jobs, err)
+		}
+	}
+}
+
+func TestDefaultOutputPath(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"trace.json", "trace.pb.gz"},
+		{"trace.json.gz", "trace.pb.gz"},
+		{"runs/rank0.pt.trace.json", "runs/rank0.pt.trace.pb.gz"},
+		{"trace", "trace.pb.gz"},
+		{"trace.gz", "trace.gz.pb.gz"},
+	}
+	for _, tt := range tests {
+		if got := defaultOutputPath(tt.input); got != tt.want {
+			t.Errorf("defaultOutputPath(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestSplitConvertArgs(t *testing.T) {
+	tests := []struct {
+		args   []string
+		output string
+		inputs []string
+		out    string
+		err    bool
+	}{
+		{args: []string{"trace.json"}, inputs: []string{"trace.json"}},
+		{args: []string{"trace"}, inputs: []string{"trace"}},
+		{args: []string{"trace.json", "profile.pb.gz"}, inputs: []string{"trace.json"}, out: "profile.pb.gz"},
+		{args: []string{"r0.json", "r1.json.gz"}, inputs: []string{"r0.json", "r1.json.gz"}},
+		{args: []string{"r0.json", "r1.json", "r2.json"}, inputs: []string{"r0.json", "r1.json", "r2.json"}},
+		{args: []string{"r0.json", "r1.json"}, output: "ranks.pb.gz", inputs: []string{"r0.json", "r1.json"}, out: "ranks.pb.gz"},
+		{args: []string{"trace.json"}, output: "profile.pb.gz", inputs: []string{"trace.json"}, out: "profile.pb.gz"},
+		// -o names the output, so a last argument without a trace
+		// extension is another input
+		{args: []string{"r0.json", "r1"}, output: "ranks.pb.gz", inputs: []string{"r0.json", "r1"}, out: "ranks.pb.gz"},
+		{args: []string{"r0.json", "r1.json", "ranks.pb.gz"}, err: true},
+		{args: []string{"r0.json", "r1", "r2"}, err: true},
+	}
+	for _, tt := range tests {
+		inputs, out, err := splitConvertArgs(tt.args, tt.output)
+		if tt.err {
+			if err == nil {
+				t.Errorf("splitConvertArgs(%q, %q): expected an error, got %q and %q", tt.args, tt.output, inputs, out)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(inputs, tt.inputs) || out != tt.out {
+			t.Errorf("splitConvertArgs(%q, %q) = %q, %q, %v, want %q, %q", tt.args, tt.output, inputs, out, err, tt.inputs, tt.out)
+		}
+	}
+}
+
+func TestSourceLabels(t *testing.T) {
+	tests := []struct {
+		inputs []string
+		want   []string
+	}{
+		{[]string{"r0.json", "r1.json"}, []string{"r0.json", "r1.json"}},
+		{[]string{"runs/r0.json", "runs/r1.json"}, []string{"r0.json", "r1.json"}},
+		{[]string{"run0/trace.json", "run1/trace.json"}, []string{"run0/trace.json", "run1/trace.json"}},
+		{[]string{"a/x/trace.json", "b/x/trace.json", "c.json"}, []string{"a/x/trace.json", "b/x/trace.json", "c.json"}},
+		{[]string{"./run0//trace.json", "run1/trace.json"}, []string{"run0/trace.json", "run1/trace.json"}},
+		// Identical paths cannot be told apart and keep all their elements
+		{[]string{"a/t.json", "a/t.json"}, []string{"a/t.json", "a/t.json"}},
+		{[]string{"trace.json"}, []string{"trace.json"}},
+	}
+	for _, tt := range tests {
+		if got := sourceLabels(tt.inputs); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sourceLabels(%q) = %q, want %q", tt.inputs, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olka/torch2pprof/pkg/converter"
+)
+
+func genCommand(args []string) {
+	fs := flag.NewFlagSet("gen", flag.ExitOnError)
+	lf := addLogFlags(fs)
+	var opts converter.GenerateOptions
+	fs.Func("events", "Number of events to generate, e.g. 500k or 10M (default: 100k)", func(v string) error {
+		n, err := parseCount(v)
+		opts.Events = n
+		return err
+	})
+	fs.IntVar(&opts.Threads, "threads", 4, "Number of CPU threads")
+	fs.IntVar(&opts.Depth, "depth", 10, "Deepest nesting of operators")
+	fs.IntVar(&opts.GPUs, "gpus", 1, "Number of GPUs the threads launch kernels on")
+	fs.BoolVar(&opts.NoGPU, "no-gpu", false, "Generate a CPU-only trace")
+	fs.Int64Var(&opts.Seed, "seed", 1, "Random seed; the same options generate the same trace")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: torch2pprof gen [options] <output.json>\n")
+		fmt.Fprintf(os.Stderr, "\nWrite a synthetic PyTorch profiler trace for testing and benchmarking\n")
+		fmt.Fprintf(os.Stderr, "(gzip-compressed if the output ends in .gz)\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	inputs, err := parseInterspersed(fs, args)
+	if err != nil {
+		fatalf("parsing arguments: %v", err)
+	}
+	lf.setup(false)
+
+	if len(inputs) != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if opts.Threads <= 0 || opts.Depth <= 0 || opts.GPUs <= 0 {
+		fatalf("-threads, -depth and -gpus must be positive")
+	}
+
+	start := time.Now()
+	if err := converter.GenerateTraceFile(inputs[0], opts); err != nil {
+		fatalf("writing trace: %v", withExitCode(exitWrite, err))
+	}
+	slog.Info("Trace generated", "path", inputs[0], "duration", time.Since(start).Round(time.Millisecond))
+}
+
+// parseCount parses a count flag such as 500k, 10M or 2000000
+func parseCount(v string) (int, error) {
+	multiplier := 1.0
+	num := v
+	switch {
+	case strings.HasSuffix(strings.ToLower(v), "k"):
+		multiplier, num = 1e3, v[:len(v)-1]
+	case strings.HasSuffix(v, "M"):
+		multiplier, num = 1e6, v[:len(v)-1]
+	case strings.HasSuffix(v, "G"), strings.HasSuffix(v, "B"):
+		multiplier, num = 1e9, v[:len(v)-1]
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid count %q (want e.g. 500k or 10M)", v)
+	}
+	return int(n * multiplier), nil
+}
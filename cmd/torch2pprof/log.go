@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logFlags holds the verbosity, log format and self-profiling flags shared
+// by all commands. Logs go to stderr so stdout only carries reports and
+// data.
+type logFlags struct {
+	quiet       bool
+	verbose     bool
+	format      string
+	errorFormat string
+	noProgress  bool
+	cpuProfile  string
+	memProfile  string
+	traceFile   string
+}
+
+func addLogFlags(fs *flag.FlagSet) *logFlags {
+	lf := &logFlags{}
+	fs.BoolVar(&lf.quiet, "q", false, "Only log warnings and errors")
+	fs.BoolVar(&lf.quiet, "quiet", false, "Only log warnings and errors")
+	fs.BoolVar(&lf.verbose, "v", false, "Log debug details")
+	fs.BoolVar(&lf.verbose, "verbose", false, "Log debug details")
+	fs.StringVar(&lf.format, "log-format", "text", "Log format: text or json")
+	fs.StringVar(&lf.errorFormat, "error-format", "text", "Format of the fatal error: text, or json for a single machine-readable object")
+	fs.BoolVar(&lf.noProgress, "no-progress", false, "Disable progress bars (they are only shown on terminals)")
+	fs.StringVar(&lf.cpuProfile, "cpuprofile", "", "Write a CPU profile of torch2pprof itself to this file")
+	fs.StringVar(&lf.memProfile, "memprofile", "", "Write a memory profile of torch2pprof itself to this file on exit")
+	fs.StringVar(&lf.traceFile, "trace", "", "Write a Go execution trace of torch2pprof itself to this file")
+	return lf
+}
+
+// setup installs the default logger; timestamps selects whether text logs
+// carry the time, which JSON logs always do
+func (lf *logFlags) setup(timestamps bool) {
+	level := slog.LevelInfo
+	if lf.quiet {
+		level = slog.LevelWarn
+	}
+	if lf.verbose {
+		level = slog.LevelDebug
+	}
+
+	var handler slog.Handler
+	switch lf.format {
+	case "text":
+		handler = &textHandler{w: os.Stderr, level: level, timestamps: timestamps, mu: &sync.Mutex{}}
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown -log-format %q (want text or json)\n", lf.format)
+		os.Exit(exitUsage)
+	}
+	slog.SetDefault(slog.New(handler))
+
+	switch lf.errorFormat {
+	case "text", "json":
+		errorFormat = lf.errorFormat
+	default:
+		fatalf("%v", withExitCode(exitUsage, fmt.Errorf("unknown -error-format %q (want text or json)", lf.errorFormat)))
+	}
+
+	showProgress = !lf.noProgress && !lf.quiet && lf.format == "text" && isTerminal(os.Stderr)
+
+	if err := lf.startSelfProfiling(); err != nil {
+		fatalf("%v", withExitCode(exitWrite, err))
+	}
+}
+
+// Exit codes, so scripts can tell failures apart
+const (
+	exitFailure    = 1 // any other error
+	exitUsage      = 2 // invalid arguments, as for flag parsing errors
+	exitParse      = 3 // an input trace or profile could not be read or parsed
+	exitEmpty      = 4 // a trace has no events to convert
+	exitWrite      = 5 // an output could not be written
+	exitValidation = 6 // validate found problems
+	exitRegression = 7 // check found a performance regression
+
+	exitInterrupted = 130 // cancelled by Ctrl-C, as shells report SIGINT
+)
+
+// exitKinds names the exit codes in JSON errors
+var exitKinds = map[int]string{
+	exitFailure:    "failure",
+	exitUsage:      "usage",
+	exitParse:      "parse",
+	exitEmpty:      "empty_trace",
+	exitWrite:      "write",
+	exitValidation: "validation",
+	exitRegression: "regression",
+
+	exitInterrupted: "interrupted",
+}
+
+// errorFormat is the -error-format of the running command
+var errorFormat = "text"
+
+// exitCodeError attaches an exit code to an error
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string { return e.err.Error() }
+func (e *exitCodeError) Unwrap() error { return e.err }
+
+// withExitCode attaches an exit code to err for fatalf
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitCodeError{code: code, err: err}
+}
+
+// fatalf reports an error on stderr and exits with the exitCode of args
+func fatalf(format string, args ...interface{}) {
+	code := exitCode(args...)
+	msg := fmt.Sprintf(format, args...)
+	if code == exitInterrupted {
+		msg = "interrupted"
+	}
+
+	if errorFormat == "json" {
+		data, _ := json.Marshal(struct {
+			Error string `json:"error"`
+			Kind  string `json:"kind"`
+			Code  int    `json:"code"`
+		}{msg, exitKinds[code], code})
+		fmt.Fprintf(os.Stderr, "%s\n", data)
+	} else {
+		slog.Error(msg)
+	}
+	exit(code)
+}
+
+// exitCode returns the exit code of the first argument carrying one (see
+// withExitCode), or exitFailure. Errors from an interrupted command exit
+// with exitInterrupted.
+func exitCode(args ...interface{}) int {
+	for _, arg := range args {
+		err, ok := arg.(error)
+		if !ok {
+			continue
+		}
+		if errors.Is(err, context.Canceled) {
+			return exitInterrupted
+		}
+		var ce *exitCodeError
+		if errors.As(err, &ce) {
+			return ce.code
+		}
+	}
+	return exitFailure
+}
+
+// textHandler writes human-readable log lines: the message followed by
+// key=value attributes, with warnings and errors prefixed by their level
+type textHandler struct {
+	w          io.Writer
+	level      slog.Level
+	timestamps bool
+	attrs      []slog.Attr
+	mu         *sync.Mutex
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	if h.timestamps && !r.Time.IsZero() {
+		b.WriteString(r.Time.Format(time.TimeOnly) + " ")
+	}
+	switch {
+	case r.Level >= slog.LevelError:
+		b.WriteString("Error: ")
+	case r.Level >= slog.LevelWarn:
+		b.WriteString("Warning: ")
+	}
+	b.WriteString(r.Message)
+
+	writeAttr := func(a slog.Attr) bool {
+		value := a.Value.Resolve().String()
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		b.WriteString(" " + a.Key + "=" + value)
+		return true
+	}
+	for _, a := range h.attrs {
+		writeAttr(a)
+	}
+	r.Attrs(writeAttr)
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &clone
+}
+
+// WithGroup is a no-op; the commands do not use attribute groups
+func (h *textHandler) WithGroup(string) slog.Handler {
+	return h
+}
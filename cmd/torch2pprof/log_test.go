@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		args []interface{}
+		want int
+	}{
+		{"no arguments", nil, exitFailure},
+		{"plain error", []interface{}{errors.New("boom")}, exitFailure},
+		{"not an error", []interface{}{"trace.json", 3}, exitFailure},
+		{"with code", []interface{}{withExitCode(exitParse, errors.New("bad trace"))}, exitParse},
+		{"wrapped", []interface{}{fmt.Errorf("trace.json: %w", withExitCode(exitEmpty, errors.New("no events")))}, exitEmpty},
+		{"after other arguments", []interface{}{"out.pb.gz", withExitCode(exitWrite, errors.New("disk full"))}, exitWrite},
+		{"first code wins", []interface{}{withExitCode(exitUsage, errors.New("a")), withExitCode(exitWrite, errors.New("b"))}, exitUsage},
+		{"nil error", []interface{}{withExitCode(exitWrite, nil)}, exitFailure},
+		{"interrupted", []interface{}{fmt.Errorf("converting: %w", context.Canceled)}, exitInterrupted},
+		{"interrupted with code", []interface{}{withExitCode(exitParse, context.Canceled)}, exitInterrupted},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.args...); got != tt.want {
+			t.Errorf("%s: exitCode = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExitKinds(t *testing.T) {
+	codes := []int{exitFailure, exitUsage, exitParse, exitEmpty, exitWrite, exitValidation, exitRegression, exitInterrupted}
+	kinds := make(map[string]bool)
+	for _, code := range codes {
+		kind := exitKinds[code]
+		if kind == "" || kinds[kind] {
+			t.Errorf("Exit code %d has kind %q, want a unique name", code, kind)
+		}
+		kinds[kind] = true
+	}
+	if len(exitKinds) != len(codes) {
+		t.Errorf("Expected %d exit kinds, got %v", len(codes), exitKinds)
+	}
+}
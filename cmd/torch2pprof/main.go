@@ -1,144 +1,577 @@
 package main
 
 import (
+	"bufio"
 	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
-	"pytorch-to-pprof/internal/converter"
+	"github.com/olka/torch2pprof/internal/tui"
+	"github.com/olka/torch2pprof/internal/web"
+	"github.com/olka/torch2pprof/pkg/converter"
+	"github.com/olka/torch2pprof/pkg/profile"
 )
 
+// ctx is cancelled by the first interrupt, so loading, converting and
+// analyzing stop early; a second interrupt kills the process as usual
+var ctx = context.Background()
+
 func main() {
 	if len(os.Args) < 2 {
 		printUsage()
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
+	var stop context.CancelFunc
+	ctx, stop = signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
 	switch os.Args[1] {
 	case "convert":
 		convertCommand(os.Args[2:])
 	case "analyze":
 		analyzeCommand(os.Args[2:])
+	case "merge":
+		mergeCommand(os.Args[2:])
+	case "diff":
+		diffCommand(os.Args[2:])
+	case "serve":
+		serveCommand(os.Args[2:])
+	case "server":
+		serverCommand(os.Args[2:])
+	case "check":
+		checkCommand(os.Args[2:])
+	case "stragglers":
+		stragglersCommand(os.Args[2:])
+	case "top":
+		topCommand(os.Args[2:])
+	case "flamegraph":
+		flamegraphCommand(os.Args[2:])
+	case "watch":
+		watchCommand(os.Args[2:])
+	case "validate":
+		validateCommand(os.Args[2:])
+	case "extract":
+		extractCommand(os.Args[2:])
+	case "split":
+		splitCommand(os.Args[2:])
+	case "info":
+		infoCommand(os.Args[2:])
+	case "grep":
+		grepCommand(os.Args[2:])
+	case "timeline":
+		timelineCommand(os.Args[2:])
+	case "upload":
+		uploadCommand(os.Args[2:])
+	case "spans":
+		spansCommand(os.Args[2:])
+	case "gen":
+		genCommand(os.Args[2:])
 	case "-h", "--help", "help":
 		printUsage()
 	default:
 		// Default behavior for backwards compatibility: convert
 		convertCommand(os.Args[1:])
 	}
+	stopSelfProfiling()
 }
 
 func printUsage() {
 	fmt.Fprintf(os.Stderr, `torch2pprof - PyTorch profiler trace to pprof converter
 
 Usage:
-  torch2pprof convert <input.json> <output.pb.gz>   Convert trace to pprof format
+  torch2pprof convert <input.json> <output.pb.gz>   Convert a trace to pprof format
+  torch2pprof convert <inputs...> -o <output.pb.gz> Convert traces to one pprof profile
+  torch2pprof convert <input.json>...               Convert to input.pb.gz
   torch2pprof analyze [options] <input.json>        Analyze trace statistics
+  torch2pprof merge <inputs...> -o <output.pb.gz>   Merge profiles and/or traces
+  torch2pprof diff <baseline> <candidate>           Compare two traces or profiles
+  torch2pprof check -baseline <file> <input.json>   Fail on a performance regression
+  torch2pprof stragglers <rank traces...>           Find the rank others wait for
+  torch2pprof serve [options] <input.json>          View a trace in the browser
+  torch2pprof server [-listen ADDR]                 Serve a trace conversion HTTP API
+  torch2pprof top [options] <input.json>            Show operations by self time
+  torch2pprof flamegraph [-tui] <input.json>        Terminal flame graph or folded stacks
+  torch2pprof watch [options] <dir> <outdir>        Convert traces as they appear
+  torch2pprof validate <profile.pb.gz>...           Check profiles for malformed data
+  torch2pprof extract [options] <input> <output>    Write a time slice of a trace
+  torch2pprof split -by step|rank|gpu <input> <dir> Write one output per step, rank or GPU
+  torch2pprof info [-json] <input.json>             Show what a trace contains
+  torch2pprof grep [options] <regex> <input.json>   Print events whose name matches
+  torch2pprof timeline [options] <input.json>       Show thread and stream utilization over time
+  torch2pprof upload -server URL [options] <input>  Push a profile to Pyroscope or Parca
+  torch2pprof spans [options] <input.json>          Export steps and annotations as OTLP spans
+  torch2pprof gen [options] <output.json.gz>        Write a synthetic trace for benchmarking
   torch2pprof <input.json> <output.pb.gz>           Convert (default, for compatibility)
 
 Commands:
   convert     Convert PyTorch trace to pprof format
   analyze     Analyze PyTorch trace and show statistics
+  merge       Merge converted profiles and/or traces into one profile
+  diff        Write a delta profile and print a regression table
+  check       Compare wall, step and operation times with a baseline for CI
+  stragglers  Compare step times and collective arrival across the ranks of a job
+  serve       Convert in memory and serve a flame graph/top web UI
+  server      Serve POST /convert (trace in, pprof out) and POST /analyze (JSON out)
+  top         Show a pprof-style top table with self time computed from the trace
+  flamegraph  Browse a flame graph in the terminal, or print folded stacks
+  watch       Convert new traces in a directory during a training run
+  validate    Check the referential integrity of pprof profiles
+  extract     Write the events of a time range or profiler steps as a smaller trace
+  split       Write one profile or trace per profiler step, rank or GPU
+  info        Show trace metadata: time span, processes, devices, recorded data
+  grep        Print matching events with their timestamps, durations and args
+  timeline    Show per-thread and per-stream utilization in time buckets
+  upload      Convert and push a profile to a Pyroscope or Parca server
+  spans       Export steps, annotations and top-level ops to an OpenTelemetry collector
+  gen         Generate a synthetic PyTorch trace of any size for tests and benchmarks
+
+Options for convert:
+  -o FILE              Write the profile to FILE; several inputs are merged
+  -f, -force           Overwrite outputs derived from input names
+  -output-template T   Convert all inputs (files or directories) to paths
+                       rendered from T, e.g. '{{.Dir}}/{{.Base}}.pb.gz'
+  -thread-roots        Root each stack at its process/thread or GPU stream
+  -comm-root           Group NCCL/c10d communication under a
+                       'Communication' root
+  -focus REGEX         Only keep stacks with a frame matching REGEX
+  -ignore REGEX        Drop stacks with a frame matching REGEX
+  -rewrite FILE        Rename, merge or drop frames with YAML rules
+  -min-dur D           Drop events shorter than D (e.g. 5us)
+  -collapse-recursion  Merge repeated frames, e.g. 'forward (x3)'
+  -normalize-kernels   Demangle and shorten GPU kernel names
+  -raw-kernel-label    Keep the original kernel name as a label
+  -launch-latency      Add kernel launch delays under a 'launch latency' root
+  -idle                Add '<idle>' samples for gaps on threads/streams
+  -sample-rate R       Keep fraction R of leaf events, scaling values
+  -event-budget N      Downsample to roughly N converted events
+  -clock wall|thread   Use wall time (dur) or thread CPU time (tdur)
+  -sample-type LIST    Value columns to write: samples, time (default: both)
+  -label KEY=VALUE     Attach a label to every sample (repeatable)
+  -overlap POLICY      Partially overlapping events: split, parent or drop
+  -epsilon D           Tolerance when comparing event boundaries
+  -max-memory SIZE     Spill aggregated stacks to disk beyond SIZE and fail if
+                       the profile outgrows it (e.g. 4GiB)
+  -workers N           Convert N threads in parallel (default: per CPU)
 
 Options for analyze:
-  -top N      Show top N operations (default: 20)
+  -top N             Show top N operations (default: 20)
+  -json              Print the full analysis as JSON
+  -format F          Print tables as text (default), csv or markdown
+  -by-thread         Show busy time and utilization per thread and GPU stream
+  -overlaps          Show per-thread counts of partially overlapping events
+  -by-device         Show kernel, memcpy and idle time, utilization and
+                     largest idle gaps per GPU
+  -percentiles       Show min/mean/stddev/p50/p95/p99/max duration per
+                     operation
+  -launches          Show kernel launch latency percentiles and the slowest
+                     launches
+  -comm-overlap      Show how much NCCL communication overlaps compute
+                     kernels, per GPU and step
+  -dataloader        Show the time each step waited for DataLoader batches
+                     and flag steps stalled on input
+  -precision         Show GPU kernel time by precision and the share on
+                     Tensor Cores
+  -memory            Show peak memory per device, the ops open at the peak
+                     and the top allocation sites (traces recorded with
+                     profile_memory=True)
+  -group-by G        Aggregate operations by name (default), cat,
+                     name+shape, thread or stream
+  -interactive       Browse, sort, filter and export in a terminal table
+
+Options for serve:
+  -http ADDR  Listen address (default: localhost:8080)
+  All convert options are accepted as well
+
+Options for server:
+  -listen ADDR       Listen address (default: localhost:8080; :8080 for all interfaces)
+  -max-size N        Largest accepted upload in bytes (default: 1 GiB, 0: no limit)
+
+Options for top:
+  -flat, -cum        Sort by self time (default) or total time
+  -sample-index S    Report time or samples (event counts)
+  -n N               Show top N operations (default: 20)
+
+Options for flamegraph:
+  -tui               Navigate the flame graph interactively
+  -sample-index S    Sample type to show: samples or time (default)
+  All convert options are accepted as well
+
+Options for watch:
+  -interval D        How often to scan for new traces (default: 2s)
+  All convert options are accepted as well
+
+Options for extract:
+  -steps A:B         Keep ProfilerStep#A through #B (A:, :B or a single step)
+  -start-ts, -end-ts, -start-step, -end-step are accepted as well
+
+Options for split:
+  -by KEY            Group by step (ProfilerStep), rank (process) or gpu (device)
+  -format F          Write pprof profiles (default) or trace slices
+  All convert options are accepted as well
+
+Options for grep:
+  -cat REGEX         Only match events whose category matches REGEX
+  -json              Print matching events as JSON, one per line
+  -count             Only print the number of matching events
+  -max N             Stop after N matches (default: all)
+
+Options for timeline:
+  -buckets N         Number of time buckets (default: 100)
+  -format F          Print text (default) or write an HTML page
+  -gpu               Only show GPU streams
+  -o FILE            Write to FILE instead of stdout
+
+Options for upload:
+  -backend B         Server type: pyroscope (default) or parca
+  -server URL        Server, e.g. http://pyroscope:4040 (required)
+  -app NAME          Application name (default: torch2pprof); -label pairs
+                     become series labels
+  -token T           Bearer token (default: $PYROSCOPE_AUTH_TOKEN or
+                     $PARCA_BEARER_TOKEN)
+  -timeout D         Upload request timeout (default: 30s)
+
+Options for spans:
+  -endpoint URL      OTLP/HTTP collector (default: $OTEL_EXPORTER_OTLP_ENDPOINT
+                     or http://localhost:4318)
+  -service NAME      service.name (default: $OTEL_SERVICE_NAME or pytorch)
+  -profile-url URL   Link the spans to the converted profile
+  -ops=false         Only export ProfilerStep and user annotation spans
+  -header K=V        HTTP header of export requests (repeatable)
+  -base-time T       RFC 3339 time of trace timestamp 0 (default: from the trace)
+  -o FILE            Write OTLP JSON to FILE ('-' for stdout) instead of sending
+
+Options for gen:
+  -events N          Number of events, e.g. 500k or 10M (default: 100k)
+  -threads N         Number of CPU threads (default: 4)
+  -depth N           Deepest nesting of operators (default: 10)
+  -gpus N            GPUs the threads launch kernels on (default: 1)
+  -no-gpu            Generate a CPU-only trace
+  -seed N            Random seed (default: 1)
+
+Options for all commands:
+  -q, -quiet         Only log warnings and errors
+  -v, -verbose       Log debug details
+  -log-format F      Log format: text (default) or json; logs go to stderr
+  -no-progress       Disable progress bars (only shown on terminals)
+  -error-format F    Fatal error format: text (default) or json
+  -cpuprofile FILE   Write a CPU profile of torch2pprof itself to FILE
+  -memprofile FILE   Write a heap allocation profile of torch2pprof to FILE
+  -trace FILE        Write a Go execution trace of torch2pprof to FILE
+
+Exit codes:
+  1 other errors, 2 invalid arguments, 3 unreadable input, 4 empty trace,
+  5 output write failure, 6 validation problems, 130 interrupted (Ctrl-C)
+
+Options for convert and analyze:
+  -start-ts, -end-ts      Only use events within this trace timestamp range (us)
+  -start-step, -end-step  Only use events within these ProfilerStep spans
 
 Examples:
   # Convert trace to pprof
   torch2pprof convert trace.json profile.pb.gz
   torch2pprof trace.json profile.pb.gz
 
+  # Convert two ranks into one profile with a 'source' label per file
+  torch2pprof convert -o ranks.pb.gz r0.json r1.json
+
+  # Carry experiment metadata into a profile store
+  torch2pprof convert -label experiment=bert-large -label run=1234 trace.json
+
+  # Convert several traces to rank0.pb.gz, rank1.pb.gz, ...
+  torch2pprof convert rank0.json.gz rank1.json.gz
+
+  # Convert a directory of rank traces
+  torch2pprof convert -output-template '{{.Dir}}/{{.Base}}.pb.gz' traces/
+
   # Analyze trace
   torch2pprof analyze trace.json
   torch2pprof analyze -top 50 trace.json
+  torch2pprof analyze -format markdown trace.json > report.md
+  torch2pprof analyze -group-by name+shape -percentiles trace.json
+
+  # Merge nightly runs
+  torch2pprof merge mon.pb.gz tue.pb.gz wed.json -o week.pb.gz
+
+  # Compare a PR against main
+  torch2pprof diff main.json pr.json -o delta.pb.gz
+
+  # Quick triage without writing a profile
+  torch2pprof top -cum trace.json
+
+  # Flame graph in the terminal of a remote GPU box
+  torch2pprof flamegraph -tui trace.json
+
+  # Convert traces written by torch.profiler.tensorboard_trace_handler
+  torch2pprof watch ./log/resnet ./profiles
+
+  # Share steps 10 to 20 of a large trace
+  torch2pprof extract -steps 10:20 trace.json.gz slice.json.gz
+
+  # One profile per training step, to find the anomalous iteration
+  torch2pprof split -by step trace.json steps/
+
+  # Did aten::conv2d run at all?
+  torch2pprof grep -count 'aten::conv2d' trace.json.gz
+
+  # Spot gaps between kernels and serialized streams
+  torch2pprof timeline -format html -o timeline.html trace.json.gz
+
+  # Check that a trace was recorded with stacks and shapes
+  torch2pprof info trace.json.gz
+
+  # Find out why pprof reports a malformed profile
+  torch2pprof validate profile.pb.gz
+
+  # Browse a trace on http://localhost:8080
+  torch2pprof serve -normalize-kernels trace.json
 
 `)
 }
 
 func convertCommand(args []string) {
 	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	lf := addLogFlags(fs)
+	output := fs.String("o", "", "Write the profile to this file; several inputs are merged into it, labeled by source")
+	outputTemplate := fs.String("output-template", "", "Convert every input (files or directories) to a path built from this Go template, e.g. '{{.Dir}}/{{.Base}}.pb.gz'")
+	var force bool
+	fs.BoolVar(&force, "f", false, "Overwrite outputs derived from input names (trace.json -> trace.pb.gz)")
+	fs.BoolVar(&force, "force", false, "Overwrite outputs derived from input names (trace.json -> trace.pb.gz)")
+	cf := addConvertFlags(fs)
+	fs.BoolVar(&cf.report, "report", false, "Also print the analyze summary of each trace, gathered in the same pass as the profile")
 	fs.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: torch2pprof convert <input.json> <output.pb.gz>\n")
-		fmt.Fprintf(os.Stderr, "\nConvert PyTorch profiler trace to pprof format\n")
+		fmt.Fprintf(os.Stderr, "Usage: torch2pprof convert [options] <input.json> <output.pb.gz>\n")
+		fmt.Fprintf(os.Stderr, "       torch2pprof convert [options] -o <output.pb.gz> <input.json>...   (merges several inputs)\n")
+		fmt.Fprintf(os.Stderr, "       torch2pprof convert [options] <input.json>...   (writes input.pb.gz)\n")
+		fmt.Fprintf(os.Stderr, "       torch2pprof convert [options] -output-template TEMPLATE <input>...\n")
+		fmt.Fprintf(os.Stderr, "\nConvert PyTorch profiler trace to pprof format\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
 	}
 
-	if err := fs.Parse(args); err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing arguments: %v\n", err)
-		os.Exit(1)
+	args, err := parseInterspersed(fs, args)
+	if err != nil {
+		fatalf("parsing arguments: %v", err)
+	}
+	lf.setup(false)
+
+	if *outputTemplate != "" {
+		if *output != "" {
+			fatalf("%v", withExitCode(exitUsage, errors.New("-o and -output-template cannot be combined")))
+		}
+		if len(args) == 0 {
+			fs.Usage()
+			os.Exit(exitUsage)
+		}
+		jobs, err := expandOutputTemplate(*outputTemplate, args)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		for i, job := range jobs {
+			slog.Info(fmt.Sprintf("[%d/%d] converting", i+1, len(jobs)), "input", job.input, "output", job.output)
+			if err := os.MkdirAll(filepath.Dir(job.output), 0755); err != nil {
+				fatalf("%v", withExitCode(exitWrite, err))
+			}
+			if err := convertFile(job.input, job.output, cf); err != nil {
+				fatalf("converting %s: %v", job.input, err)
+			}
+		}
+		return
 	}
 
-	if fs.NArg() != 2 {
+	if len(args) == 0 {
 		fs.Usage()
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
-	inputFile := fs.Arg(0)
-	outputFile := fs.Arg(1)
-	numWorkers := runtime.NumCPU()
+	inputs, outputFile, err := splitConvertArgs(args, *output)
+	if err != nil {
+		fatalf("%v", withExitCode(exitUsage, err))
+	}
+
+	// Without an output, each trace is written next to itself
+	if outputFile == "" {
+		for _, input := range inputs {
+			if _, err := os.Stat(defaultOutputPath(input)); err == nil && !force {
+				fatalf("%v", withExitCode(exitWrite, fmt.Errorf("%s already exists (use -f to overwrite)", defaultOutputPath(input))))
+			}
+		}
+		for _, input := range inputs {
+			if err := convertFile(input, defaultOutputPath(input), cf); err != nil {
+				fatalf("converting %s: %v", input, err)
+			}
+		}
+		return
+	}
+
+	if len(inputs) == 1 {
+		if err := convertFile(inputs[0], outputFile, cf); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+
+	// Several inputs are merged into one profile, labeled by source file
+	profiles := make([]*profile.Profile, len(inputs))
+	sources := sourceLabels(inputs)
+	for i, input := range inputs {
+		slog.Info(fmt.Sprintf("[%d/%d] converting", i+1, len(inputs)), "input", input)
+		p, err := cf.convertFile(input)
+		if err != nil {
+			fatalf("converting %s: %v", input, err)
+		}
+		p.SetLabel("source", sources[i])
+		profiles[i] = p
+	}
+	merged, err := profile.Merge(profiles)
+	if err != nil {
+		fatalf("merging profiles: %v", err)
+	}
+	if err := writeProfile(outputFile, merged); err != nil {
+		fatalf("%v", err)
+	}
+	logProfileWritten(outputFile, merged)
+}
 
-	fmt.Printf("Loading %s...\n", inputFile)
-	fmt.Printf("Using %d CPU cores\n", numWorkers)
+// convertFile converts the trace inputFile to the profile outputFile,
+// logging progress
+func convertFile(inputFile, outputFile string, cf *convertFlags) error {
+	traceData, err := loadTrace(inputFile, converter.UsedArgs())
+	if err != nil {
+		return err
+	}
 
-	traceData, err := converter.LoadTraceFile(inputFile)
+	traceData, err = cf.window.apply(traceData)
 	if err != nil {
-		fmt.Printf("Error reading file: %v\n", err)
-		os.Exit(1)
+		return err
 	}
 
-	fmt.Printf("Loaded %d trace events\n", len(traceData.TraceEvents))
+	opts, err := cf.options(traceData)
+	if err != nil {
+		return err
+	}
+	if opts.SampleRate < 1 {
+		slog.Info("Downsampling leaf events", "rate", fmt.Sprintf("%.4f", opts.SampleRate))
+	}
 
-	fmt.Println("Building call stacks (parallel)...")
+	slog.Debug("Building call stacks")
 	start := time.Now()
 
-	profile := converter.ConvertTrace(traceData, converter.ConvertOptions{
-		NumWorkers: numWorkers,
-	})
+	bars := &stageBars{}
+	opts.Progress = bars.update
+	profile, diag, err := converter.ConvertTraceWithDiagnostics(ctx, traceData, opts)
+	bars.finish()
+	if err != nil {
+		return err
+	}
+	logDiagnostics(diag)
+	logMemory(diag)
+	if err := printReport(diag); err != nil {
+		return err
+	}
 
 	elapsed := time.Since(start)
-	fmt.Printf("Conversion complete in %.2fs\n", elapsed.Seconds())
+	slog.Info("Conversion complete", "elapsed", elapsed.Round(time.Millisecond))
 
-	fmt.Println("Encoding profile...")
-	profileBytes, err := profile.Encode()
-	if err != nil {
-		fmt.Printf("Error encoding profile: %v\n", err)
-		os.Exit(1)
+	slog.Debug("Writing profile", "path", outputFile)
+	if err := writeProfile(outputFile, profile); err != nil {
+		return err
 	}
 
-	fmt.Printf("Writing to %s...\n", outputFile)
-	f, err := os.Create(outputFile)
+	logProfileWritten(outputFile, profile)
+	return nil
+}
+
+// loadTrace loads the trace at path, logging progress. Only the event
+// args in args are kept, unless it is nil.
+func loadTrace(path string, args []string) (*converter.TraceData, error) {
+	slog.Info("Loading trace", "path", path)
+
+	bars := &stageBars{}
+	traceData, err := converter.LoadTraceFileWithOptions(ctx, path, converter.LoadOptions{
+		Progress: bars.update,
+		Logger:   slog.Default(),
+		Args:     args,
+	})
+	bars.finish()
 	if err != nil {
-		fmt.Printf("Error creating output file: %v\n", err)
-		os.Exit(1)
+		err = fmt.Errorf("reading file: %w", err)
 	}
-
-	gz := gzip.NewWriter(f)
-	if _, writeErr := gz.Write(profileBytes); writeErr != nil {
-		_ = f.Close()
-		fmt.Printf("Error writing profile: %v\n", writeErr)
-		os.Exit(1)
+	traceData, err = checkTrace(traceData, err)
+	if err != nil {
+		return nil, err
 	}
-	if closeErr := gz.Close(); closeErr != nil {
-		_ = f.Close()
-		fmt.Printf("Error closing gzip: %v\n", closeErr)
-		os.Exit(1)
+
+	slog.Info("Loaded trace events", "events", len(traceData.TraceEvents))
+	return traceData, nil
+}
+
+// loadUsedArgs loads the trace at path for conversion or analysis, keeping
+// only the event args they read
+func loadUsedArgs(path string) (*converter.TraceData, error) {
+	return converter.LoadTraceFileWithOptions(ctx, path, converter.LoadOptions{Args: converter.UsedArgs()})
+}
+
+// checkTrace tags a trace loading error with exitParse and rejects traces
+// without complete events with exitEmpty. It takes the results of
+// LoadTraceFileContext directly.
+func checkTrace(traceData *converter.TraceData, err error) (*converter.TraceData, error) {
+	if err != nil {
+		return nil, withExitCode(exitParse, err)
 	}
-	if closeErr := f.Close(); closeErr != nil {
-		fmt.Printf("Error closing file: %v\n", closeErr)
-		os.Exit(1)
+	if countCompleteEvents(traceData) == 0 {
+		return nil, withExitCode(exitEmpty, fmt.Errorf("trace has no complete events (ph=X) to convert"))
 	}
-
-	fmt.Println("\nSuccess!")
-	fmt.Printf("  - %d samples\n", len(profile.Sample))
-	fmt.Printf("  - %d locations\n", len(profile.Location))
-	fmt.Printf("  - %d functions\n", len(profile.Function))
-	fmt.Printf("  - %d strings\n", len(profile.StringTable))
+	return traceData, nil
 }
 
 func analyzeCommand(args []string) {
 	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	lf := addLogFlags(fs)
 	topN := fs.Int("top", 20, "Number of top operations to display")
+	jsonOutput := fs.Bool("json", false, "Print the full analysis as JSON instead of tables")
+	interactive := fs.Bool("interactive", false, "Browse operations and categories in an interactive terminal table")
+	format := fs.String("format", "text", "Output format: text, csv or markdown")
+	byThread := fs.Bool("by-thread", false, "Show busy time and utilization per thread and GPU stream")
+	showOverlaps := fs.Bool("overlaps", false, "Show per-thread counts of partially overlapping (non-nested) events")
+	byDevice := fs.Bool("by-device", false, "Show kernel, memcpy and idle time, utilization and largest idle gaps per GPU")
+	percentiles := fs.Bool("percentiles", false, "Show min, mean, standard deviation, p50, p95, p99 and max duration per operation")
+	launches := fs.Bool("launches", false, "Show the delays between kernel launch calls and the kernels starting, and the slowest launches")
+	streams := fs.Bool("streams", false, "Show how many GPU streams were active at once per device, against the streams in use")
+	commOverlap := fs.Bool("comm-overlap", false, "Show how much collective communication overlaps compute kernels or runs exposed, per GPU and step")
+	stepTrend := fs.Bool("step-trend", false, "Show kernel, memcpy, communication, GPU idle, Python and DataLoader time per step, flagging categories that drift")
+	dataLoader := fs.Bool("dataloader", false, "Show the time each step waited for DataLoader batches versus computing, flagging stalled steps")
+	precision := fs.Bool("precision", false, "Show GPU kernel time by precision (fp16, bf16, fp32...) and the share running on Tensor Cores, guessed from kernel names")
+	memory := fs.Bool("memory", false, "Show peak memory per device, the ops open at the peak and the top allocation sites, from [memory] events")
+	python := fs.Bool("python", false, "Show time in pure Python frames outside any operator versus operator and GPU time, per step, for traces recorded with with_stack=True")
+	transfers := fs.Bool("transfers", false, "Show host-device copies per direction: bytes, bandwidth, pinned and synchronous copies, and the ops making them")
+	allocator := fs.Bool("allocator", false, "Show cudaMalloc, cudaFree and cudaMemGetInfo calls, their time and the op stacks making them, flagging allocator thrash")
+	recommendations := fs.Bool("recommend", false, "Print suggestions from rules of thumb (CUDA Graphs, GEMM batching, bucket sizes, allocator settings...) with the numbers behind them")
+	histogram := fs.String("histogram", "", "Show a log-scale histogram of the durations of the operations matching this regex")
+	outliers := fs.String("outliers", "", "Show the events longer than this threshold of their operation, when they ran and under which stack: N standard deviations above the mean (e.g. 3) or a multiple of a percentile (e.g. 2xp99)")
+	groupByFlag := fs.String("group-by", "name", "Aggregate operations by name, cat, name+shape, thread or stream")
+	epsilon := fs.Duration("epsilon", 0, "Tolerance when comparing event boundaries (e.g. 1us for rounded timestamps)")
+	window := addWindowFlags(fs)
 	fs.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: torch2pprof analyze [options] <input.json>\n")
 		fmt.Fprintf(os.Stderr, "\nAnalyze PyTorch profiler trace and show statistics\n\n")
@@ -147,54 +580,2204 @@ func analyzeCommand(args []string) {
 	}
 
 	if err := fs.Parse(args); err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing arguments: %v\n", err)
-		os.Exit(1)
+		fatalf("parsing arguments: %v", err)
 	}
+	lf.setup(false)
 
 	if fs.NArg() != 1 {
 		fs.Usage()
-		os.Exit(1)
+		os.Exit(exitUsage)
+	}
+
+	switch *format {
+	case "text", "csv", "markdown":
+	default:
+		fatalf("unknown -format %q (want text, csv or markdown)", *format)
+	}
+	groupBy, err := converter.ParseGroupBy(*groupByFlag)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if *interactive && groupBy != converter.GroupByName {
+		fatalf("-interactive only supports -group-by name")
+	}
+	histogramRe, err := compileRegexp(*histogram)
+	if err != nil {
+		fatalf("invalid -histogram: %v", err)
+	}
+	var outlierThreshold converter.OutlierThreshold
+	if *outliers != "" {
+		if outlierThreshold, err = converter.ParseOutlierThreshold(*outliers); err != nil {
+			fatalf("invalid -outliers: %v", err)
+		}
 	}
 
 	inputFile := fs.Arg(0)
 
-	traceData, err := converter.LoadTraceFile(inputFile)
+	traceData, err := checkTrace(loadUsedArgs(inputFile))
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	traceData, err = window.apply(traceData)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	// Reports that keep events until the end are only computed when shown
+	reports := converter.AllReports
+	if !*jsonOutput {
+		reports = 0
+		for _, r := range []struct {
+			shown  bool
+			report converter.Reports
+		}{
+			{*byDevice, converter.ReportIdleGaps},
+			{*memory, converter.ReportMemory},
+			{*allocator || *recommendations, converter.ReportAllocator},
+			{*transfers, converter.ReportTransfers},
+			{*python, converter.ReportPython},
+			{*stepTrend, converter.ReportStepTrend},
+			{*percentiles, converter.ReportPercentiles},
+		} {
+			if r.shown {
+				reports |= r.report
+			}
+		}
+	}
+	analysis, err := converter.AnalyzeTraceReports(ctx, traceData, groupBy, reports)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+		fatalf("%v", err)
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(analysis); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+
+	if *interactive {
+		if err := runInteractiveAnalysis(traceData, analysis); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+
+	var overlaps []converter.ThreadOverlap
+	if *showOverlaps && *format != "csv" {
+		overlaps = converter.DetectOverlaps(traceData, *epsilon)
+	}
+	opts := reportOptions{
+		topN:        *topN,
+		format:      *format,
+		byThread:    *byThread,
+		byDevice:    *byDevice,
+		percentiles: *percentiles,
+		launches:    *launches,
+		streams:     *streams,
+		memory:      *memory,
+		allocator:   *allocator,
+		transfers:   *transfers,
+		python:      *python,
+		commOverlap: *commOverlap,
+		dataLoader:  *dataLoader,
+		stepTrend:   *stepTrend,
+		precision:   *precision,
+		recommend:   *recommendations,
+	}
+	if histogramRe != nil {
+		opts.histogram = converter.DurationHistogramOf(traceData, histogramRe)
+		opts.histogramPattern = *histogram
+	}
+	if *outliers != "" {
+		opts.outliers = converter.FindOutliers(traceData, outlierThreshold, *topN)
+		opts.outlierThreshold = outlierThreshold
 	}
+	if err := printAnalysis(os.Stdout, analysis, overlaps, opts); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// reportOptions selects what printAnalysis prints and how
+type reportOptions struct {
+	topN        int
+	format      string // text, csv or markdown
+	byThread    bool
+	byDevice    bool
+	percentiles bool
+	launches    bool
+	streams     bool
+	memory      bool
+	allocator   bool
+	transfers   bool
+	python      bool
+	commOverlap bool
+	dataLoader  bool
+	stepTrend   bool
+	precision   bool
+	recommend   bool
+
+	// histogram is the duration histogram of the operations matching
+	// histogramPattern, if any
+	histogram        *converter.DurationHistogram
+	histogramPattern string
 
-	analysis := converter.AnalyzeTrace(traceData)
+	// outliers are the events beyond outlierThreshold, when set
+	outliers         []converter.Outlier
+	outlierThreshold converter.OutlierThreshold
+}
 
-	fmt.Printf("PyTorch Profile Analysis\n")
-	fmt.Printf("========================\n\n")
-	fmt.Printf("Total events:           %d\n", analysis.TotalEvents)
-	fmt.Printf("Complete events (ph=X): %d\n", analysis.CompleteEvents)
-	fmt.Printf("Skipped (dur<=0):       %d\n", analysis.SkippedZeroDuration)
-	fmt.Printf("Converted events:       %d\n", analysis.ConvertedEvents)
-	fmt.Printf("Unique operations:      %d\n", analysis.UniqueOperations)
-	fmt.Printf("Total time:             %.3f ms (%.3f s)\n\n", float64(analysis.TotalTimeNs)/1e6, float64(analysis.TotalTimeNs)/1e9)
+// printAnalysis prints the analysis summary, category table, top operations
+// and the optional breakdowns. CSV output only holds the tables, separated by
+// a blank line.
+func printAnalysis(w io.Writer, analysis *converter.TraceAnalysis, overlaps []converter.ThreadOverlap, opts reportOptions) error {
+	topN, format := opts.topN, opts.format
+	summary := [][2]string{
+		{"Total events", strconv.Itoa(analysis.TotalEvents)},
+		{"Complete events (ph=X)", strconv.Itoa(analysis.CompleteEvents)},
+		{"Skipped (dur<=0)", strconv.Itoa(analysis.SkippedZeroDuration)},
+		{"Converted events", strconv.Itoa(analysis.ConvertedEvents)},
+		{"Unique operations", strconv.Itoa(analysis.UniqueOperations)},
+		{"Total time", fmt.Sprintf("%.3f ms (%.3f s)", float64(analysis.TotalTimeNs)/1e6, float64(analysis.TotalTimeNs)/1e9)},
+	}
+	switch format {
+	case "markdown":
+		fmt.Fprintf(w, "## PyTorch Profile Analysis\n\n")
+		for _, item := range summary {
+			fmt.Fprintf(w, "- %s: %s\n", item[0], item[1])
+		}
+		fmt.Fprintln(w)
+		if len(overlaps) > 0 {
+			fmt.Fprintf(w, "Partially overlapping events (not properly nested):\n\n")
+			printOverlaps(w, overlaps, topN, "- ")
+			fmt.Fprintln(w)
+		}
+	case "text":
+		fmt.Fprintf(w, "PyTorch Profile Analysis\n")
+		fmt.Fprintf(w, "========================\n\n")
+		for _, item := range summary {
+			fmt.Fprintf(w, "%-24s%s\n", item[0]+":", item[1])
+		}
+		fmt.Fprintln(w)
+		if len(overlaps) > 0 {
+			fmt.Fprintf(w, "Partially overlapping events (not properly nested):\n")
+			printOverlaps(w, overlaps, topN, "  ")
+			fmt.Fprintln(w)
+		}
+	}
 
-	// Display categories
-	fmt.Printf("By Category:\n")
-	fmt.Printf("%-30s %12s %10s\n", "Category", "Time (ms)", "Count")
-	fmt.Printf("%s\n", "------------------------------------------------------")
+	categories := &table{
+		title:   "By Category",
+		columns: []column{{"Category", 30}, {"Time (ms)", 12}, {"Count", 10}},
+	}
 	for _, c := range analysis.GetSortedCategories() {
-		fmt.Printf("%-30s %12.3f %10d\n", c.Name, float64(c.TimeNs)/1e6, c.Count)
+		categories.addRow(c.Name, fmt.Sprintf("%.3f", float64(c.TimeNs)/1e6), strconv.Itoa(c.Count))
 	}
 
-	// Top operations
-	fmt.Printf("\nTop %d Operations:\n", *topN)
-	fmt.Printf("%-60s %12s %10s\n", "Operation", "Time (ms)", "Count")
-	fmt.Printf("%s\n", "------------------------------------------------------------------------------------")
-	for i, o := range analysis.GetSortedOperations() {
-		if i >= *topN {
+	title, groupColumn := "Operations", "Operation"
+	switch analysis.GroupBy {
+	case converter.GroupByCategory:
+		title, groupColumn = "Categories", "Category"
+	case converter.GroupByNameShape:
+		title, groupColumn = "Operations by Input Shape", "Operation [input dims]"
+	case converter.GroupByThread:
+		title, groupColumn = "Threads", "Thread"
+	case converter.GroupByStream:
+		title, groupColumn = "GPU Streams", "Stream"
+	}
+	operations := &table{
+		title:   fmt.Sprintf("Top %d %s", topN, title),
+		columns: []column{{groupColumn, 60}, {"Time (ms)", 12}, {"Count", 10}},
+	}
+	if opts.percentiles {
+		for _, name := range []string{"Min", "Mean", "StdDev", "P50", "P95", "P99", "Max"} {
+			operations.columns = append(operations.columns, column{name + " (ms)", 10})
+		}
+	}
+	ms := func(ns int64) string { return fmt.Sprintf("%.3f", float64(ns)/1e6) }
+	sorted := analysis.GetSortedOperations()
+	// Operations are shown by label, and by key too when labels repeat
+	labels := make(map[string]int)
+	for _, o := range sorted {
+		labels[o.Label]++
+	}
+	for i, o := range sorted {
+		if i >= topN {
 			break
 		}
 		name := o.Name
-		if len(name) > 58 {
-			name = name[:55] + "..."
+		if o.Label != "" {
+			name = o.Label
+			if labels[o.Label] > 1 {
+				name += " [" + o.Name + "]"
+			}
+		}
+		row := []string{name, ms(o.TimeNs), strconv.Itoa(o.Count)}
+		if opts.percentiles {
+			s := analysis.OperationStats[o.Name]
+			row = append(row, ms(s.MinNs), ms(s.MeanNs), ms(s.StdDevNs), ms(s.P50Ns), ms(s.P95Ns), ms(s.P99Ns), ms(s.MaxNs))
+		}
+		operations.addRow(row...)
+	}
+
+	tables := []*table{categories, operations}
+	if len(analysis.Kernels) > 0 {
+		kernels := &table{
+			title:   fmt.Sprintf("Top %d GPU Kernels", topN),
+			columns: []column{{"Kernel", 60}, {"Time (ms)", 12}, {"Count", 10}},
+		}
+		for _, k := range analysis.Kernels[:min(topN, len(analysis.Kernels))] {
+			kernels.addRow(k.Name, ms(k.SelfNs), strconv.Itoa(k.Count))
+		}
+		tables = append(tables, kernels)
+	}
+	if len(analysis.CPUOperators) > 0 {
+		cpuOps := &table{
+			title:   fmt.Sprintf("Top %d CPU Operators", topN),
+			columns: []column{{"Operator", 60}, {"Self (ms)", 12}, {"Total (ms)", 12}, {"Count", 10}},
+		}
+		for _, o := range analysis.CPUOperators[:min(topN, len(analysis.CPUOperators))] {
+			cpuOps.addRow(o.Name, ms(o.SelfNs), ms(o.TotalNs), strconv.Itoa(o.Count))
+		}
+		tables = append(tables, cpuOps)
+	}
+	if opts.byThread {
+		threads := &table{
+			title:   "By Thread",
+			columns: []column{{"Thread", 60}, {"Busy (ms)", 12}, {"Util %", 8}, {"Events", 10}},
+		}
+		for _, t := range analysis.Threads {
+			threads.addRow(t.Name, fmt.Sprintf("%.3f", float64(t.BusyNs)/1e6),
+				fmt.Sprintf("%.1f", t.Utilization*100), strconv.Itoa(t.Events))
+		}
+		tables = append(tables, threads)
+	}
+	if opts.byDevice {
+		devices := &table{
+			title: "By GPU Device",
+			columns: []column{{"Device", 10}, {"Kernel (ms)", 12}, {"Memcpy (ms)", 12},
+				{"Idle (ms)", 12}, {"Util %", 8}, {"Kernels", 10}},
+			empty: "No GPU events in trace",
+		}
+		for _, d := range analysis.Devices {
+			devices.addRow("GPU "+d.Device, fmt.Sprintf("%.3f", float64(d.KernelNs)/1e6),
+				fmt.Sprintf("%.3f", float64(d.MemcpyNs)/1e6), fmt.Sprintf("%.3f", float64(d.IdleNs)/1e6),
+				fmt.Sprintf("%.1f", d.Utilization*100), strconv.Itoa(d.Kernels))
+		}
+		gaps := &table{
+			title:   "Largest GPU Idle Gaps",
+			columns: []column{{"Device", 10}, {"Start (ms)", 12}, {"Idle (ms)", 12}, {"CPU op", 60}},
+			empty:   "No idle gaps between GPU events",
+		}
+		for _, d := range analysis.Devices {
+			for _, g := range d.IdleGaps {
+				gaps.addRow("GPU "+d.Device, ms(g.StartNs), ms(g.DurNs), g.CPUOp)
+			}
+		}
+		tables = append(tables, devices)
+		if len(analysis.Devices) > 0 {
+			tables = append(tables, gaps)
+		}
+	}
+	if l := analysis.LaunchLatency; opts.launches && l != nil {
+		latency := &table{
+			title: "Kernel Launch Latency",
+			columns: []column{{"Launches", 10}, {"Total (ms)", 12}, {"Min (ms)", 10}, {"Mean (ms)", 10},
+				{"StdDev (ms)", 10}, {"P50 (ms)", 10}, {"P95 (ms)", 10}, {"P99 (ms)", 10}, {"Max (ms)", 10}},
+		}
+		latency.addRow(strconv.Itoa(l.Launches), ms(l.TotalNs), ms(l.MinNs), ms(l.MeanNs),
+			ms(l.StdDevNs), ms(l.P50Ns), ms(l.P95Ns), ms(l.P99Ns), ms(l.MaxNs))
+		worst := &table{
+			title:   "Slowest Kernel Launches",
+			columns: []column{{"Kernel", 60}, {"Call", 20}, {"Start (ms)", 12}, {"Latency (ms)", 12}},
+		}
+		for _, w := range l.Worst {
+			worst.addRow(w.Kernel, w.Call, ms(w.StartNs), ms(w.LatencyNs))
+		}
+		tables = append(tables, latency, worst)
+	}
+	if opts.commOverlap {
+		overlap := &table{
+			title: "Communication Overlap",
+			columns: []column{{"GPU", 16}, {"Step", 8}, {"Comm (ms)", 12}, {"Overlapped (ms)", 16},
+				{"Exposed (ms)", 13}, {"Overlap %", 10}},
+			empty: "No NCCL collective kernels in trace",
+		}
+		for _, o := range analysis.CommOverlap {
+			gpu, step := "GPU "+o.Device, "all"
+			if o.Rank >= 0 {
+				gpu = fmt.Sprintf("rank %d GPU %s", o.Rank, o.Device)
+			}
+			if o.Step >= 0 {
+				step = strconv.Itoa(o.Step)
+			}
+			overlap.addRow(gpu, step, ms(o.CommNs), ms(o.OverlappedNs), ms(o.ExposedNs), fmt.Sprintf("%.1f", o.Overlap*100))
+		}
+		tables = append(tables, overlap)
+	}
+	if opts.dataLoader {
+		data := &table{
+			title: "Data Loading",
+			columns: []column{{"Step", 8}, {"Time (ms)", 12}, {"Data wait (ms)", 15}, {"Compute (ms)", 13},
+				{"Data %", 8}, {"Stalled", 8}},
+			empty: "No DataLoader events in trace",
+		}
+		for _, d := range analysis.DataLoader {
+			step, stalled := "all", ""
+			if d.Step >= 0 {
+				step = strconv.Itoa(d.Step)
+			}
+			if d.Stalled {
+				stalled = "yes"
+			}
+			data.addRow(step, ms(d.StepNs), ms(d.DataNs), ms(d.ComputeNs), fmt.Sprintf("%.1f", d.DataFraction*100), stalled)
+		}
+		tables = append(tables, data)
+	}
+	if opts.stepTrend {
+		steps := &table{
+			title: "Time per Step by Category (ms)",
+			columns: []column{{"Step", 8}, {"Total", 10}, {"Kernel", 10}, {"Memcpy", 10}, {"Comm", 10},
+				{"GPU idle", 10}, {"Python", 10}, {"DataLoader", 11}},
+			empty: "No ProfilerStep events in trace",
+		}
+		for _, s := range analysis.StepCategories {
+			steps.addRow(strconv.Itoa(s.Step), ms(s.StepNs), ms(s.KernelNs), ms(s.MemcpyNs), ms(s.CommNs),
+				ms(s.GPUIdleNs), ms(s.PythonNs), ms(s.DataLoaderNs))
+		}
+		tables = append(tables, steps)
+		if len(analysis.StepTrends) > 0 {
+			trends := &table{
+				title: "Step Trends",
+				columns: []column{{"Category", 16}, {"First (ms)", 11}, {"Last (ms)", 11}, {"Mean (ms)", 11},
+					{"Slope (ms/step)", 16}, {"Change %", 9}, {"Drift", 6}},
+			}
+			for _, t := range analysis.StepTrends {
+				drift := ""
+				if t.Drifting {
+					drift = "yes"
+				}
+				trends.addRow(t.Category, ms(t.FirstNs), ms(t.LastNs), ms(t.MeanNs), fmt.Sprintf("%+.3f", t.SlopeNs/1e6),
+					fmt.Sprintf("%+.1f", t.Change*100), drift)
+			}
+			tables = append(tables, trends)
+		}
+	}
+	if p := analysis.Precision; opts.precision && p != nil {
+		pct := func(ns int64) string { return fmt.Sprintf("%.1f", float64(ns)/float64(max(p.KernelNs, 1))*100) }
+		precisions := &table{
+			title:   "GPU Kernel Precision",
+			columns: []column{{"Precision", 30}, {"Time (ms)", 12}, {"% of kernels", 13}, {"Kernels", 10}},
+		}
+		for _, t := range p.Precisions {
+			precisions.addRow(t.Precision, ms(t.TimeNs), pct(t.TimeNs), strconv.Itoa(t.Kernels))
+		}
+		precisions.addRow("Matrix kernels", ms(p.MatrixNs), pct(p.MatrixNs), "")
+		precisions.addRow("Tensor Core kernels", ms(p.TensorCoreNs), pct(p.TensorCoreNs), "")
+		tables = append(tables, precisions)
+	}
+	if opts.streams && len(analysis.StreamConcurrency) > 0 {
+		concurrency := &table{
+			title: "GPU Stream Concurrency",
+			columns: []column{{"Device", 10}, {"Streams", 8}, {"Busy (ms)", 12}, {"Max active", 11},
+				{"Mean active", 12}, {"Concurrency %", 14}},
+		}
+		levels := &table{
+			title:   "GPU Busy Time by Active Streams",
+			columns: []column{{"Device", 20}, {"Active", 8}, {"Time (ms)", 12}, {"% of busy", 10}},
+		}
+		for _, s := range analysis.StreamConcurrency {
+			concurrency.addRow("GPU "+s.Device, strconv.Itoa(s.Streams), ms(s.BusyNs), strconv.Itoa(s.MaxActive),
+				fmt.Sprintf("%.2f", s.MeanActive), fmt.Sprintf("%.1f", s.Concurrency*100))
+			for _, l := range s.Levels {
+				levels.addRow("GPU "+s.Device, strconv.Itoa(l.Active), ms(l.TimeNs), fmt.Sprintf("%.1f", l.Fraction*100))
+			}
+		}
+		tables = append(tables, concurrency, levels)
+	}
+	if opts.memory {
+		tables = append(tables, memoryTables(analysis.Memory)...)
+	}
+	if opts.python {
+		python := &table{
+			title: "Python Overhead",
+			columns: []column{{"Step", 10}, {"Span (ms)", 12}, {"Python (ms)", 12}, {"Pure (ms)", 12},
+				{"Operators (ms)", 15}, {"GPU (ms)", 12}, {"Overhead %", 11}},
+			empty: "No python_function events in trace (record with with_stack=True)",
+		}
+		for _, p := range analysis.PythonOverhead {
+			step := "all"
+			if p.Step >= 0 {
+				step = strconv.Itoa(p.Step)
+			}
+			python.addRow(step, ms(p.StepNs), ms(p.PythonNs), ms(p.PurePythonNs), ms(p.OperatorNs), ms(p.GPUNs),
+				fmt.Sprintf("%.1f", p.Overhead*100))
+		}
+		tables = append(tables, python)
+	}
+	if opts.transfers {
+		transfers := &table{
+			title: "Host-Device Transfers",
+			columns: []column{{"Direction", 12}, {"Count", 10}, {"MB", 12}, {"Time (ms)", 12}, {"GB/s", 10},
+				{"Pinned %", 9}, {"Synchronous", 12}},
+			empty: "No host-device memcpy events in trace",
+		}
+		sites := &table{
+			title:   "Transfer Sites",
+			columns: []column{{"Op", 50}, {"Direction", 12}, {"Count", 10}, {"MB", 12}, {"Time (ms)", 12}, {"Synchronous", 12}},
+		}
+		mb := func(bytes int64) string { return fmt.Sprintf("%.3f", float64(bytes)/1e6) }
+		for _, t := range analysis.Transfers {
+			transfers.addRow(t.Direction, strconv.Itoa(t.Count), mb(t.Bytes), ms(t.TimeNs), fmt.Sprintf("%.2f", t.BandwidthGBs),
+				fmt.Sprintf("%.1f", float64(t.Pinned)/float64(t.Count)*100), strconv.Itoa(t.Synchronous))
+			for _, s := range t.Sites {
+				op := s.Op
+				if op == "" {
+					op = "(outside any op)"
+				}
+				sites.addRow(op, t.Direction, strconv.Itoa(s.Count), mb(s.Bytes), ms(s.TimeNs), strconv.Itoa(s.Synchronous))
+			}
+		}
+		tables = append(tables, transfers)
+		if len(analysis.Transfers) > 0 {
+			tables = append(tables, sites)
+		}
+	}
+	if c := analysis.Allocator; opts.allocator {
+		calls := &table{
+			title:   "CUDA Allocator Calls",
+			columns: []column{{"Call", 20}, {"Count", 10}, {"Time (ms)", 12}, {"Avg (us)", 10}, {"Per step", 10}},
+			empty:   "No cudaMalloc, cudaFree or cudaMemGetInfo calls in trace",
+		}
+		tables = append(tables, calls)
+		if c != nil && c.Count > 0 {
+			if c.Thrashing {
+				calls.title += fmt.Sprintf(" (thrashing: %.1f per step)", c.CallsPerStep)
+			}
+			perStep := func(count int) string {
+				return fmt.Sprintf("%.1f", c.CallsPerStep*float64(count)/float64(c.Count))
+			}
+			for _, call := range c.Calls {
+				calls.addRow(call.Name, strconv.Itoa(call.Count), ms(call.TimeNs),
+					fmt.Sprintf("%.1f", float64(call.TimeNs)/float64(call.Count)/1e3), perStep(call.Count))
+			}
+			calls.addRow("Total", strconv.Itoa(c.Count), ms(c.TimeNs), fmt.Sprintf("%.1f", float64(c.TimeNs)/float64(c.Count)/1e3), perStep(c.Count))
+			sites := &table{
+				title:   "Allocator Call Sites",
+				columns: []column{{"Stack, innermost first", 90}, {"Calls", 10}, {"Time (ms)", 12}},
+			}
+			for _, s := range c.Sites {
+				stack := make([]string, 0, len(s.Stack))
+				for i := len(s.Stack) - 1; i >= 0; i-- {
+					stack = append(stack, s.Stack[i])
+				}
+				site := strings.Join(stack, " < ")
+				if site == "" {
+					site = "(outside any op)"
+				}
+				sites.addRow(site, strconv.Itoa(s.Count), ms(s.TimeNs))
+			}
+			tables = append(tables, sites)
+		}
+	}
+	if opts.recommend && format == "csv" {
+		recommendations := &table{
+			title:   "Recommendations",
+			columns: []column{{"Rule", 20}, {"At stake (ms)", 14}, {"Suggestion", 0}, {"Evidence", 0}},
+		}
+		for _, r := range analysis.Recommendations {
+			recommendations.addRow(r.Rule, ms(r.ImpactNs), r.Suggestion, r.Evidence)
+		}
+		tables = append(tables, recommendations)
+	}
+	if opts.histogram != nil {
+		tables = append(tables, histogramTable(opts.histogram, opts.histogramPattern, format))
+	}
+	if opts.outlierThreshold != (converter.OutlierThreshold{}) {
+		outliers := &table{
+			title:   fmt.Sprintf("Outliers Beyond %v of Their Operation", opts.outlierThreshold),
+			columns: []column{{"Operation", 50}, {"Step", 6}, {"Start (ms)", 12}, {"Dur (ms)", 10}, {"Median (ms)", 12}, {"x Median", 9}},
+		}
+		stacks := &table{
+			title:   "Outlier Stacks",
+			columns: []column{{"Outlier < enclosing events, innermost first", 120}},
+		}
+		for _, o := range opts.outliers {
+			step := ""
+			if o.Step >= 0 {
+				step = strconv.Itoa(o.Step)
+			}
+			outliers.addRow(o.Name, step, ms(o.StartNs), ms(o.DurNs), ms(o.MedianNs), fmt.Sprintf("%.1f", float64(o.DurNs)/float64(max(o.MedianNs, 1))))
+			stack := []string{o.Name}
+			for i := len(o.Stack) - 1; i >= 0; i-- {
+				stack = append(stack, o.Stack[i])
+			}
+			stacks.addRow(strings.Join(stack, " < "))
+		}
+		tables = append(tables, outliers, stacks)
+	}
+
+	for i, t := range tables {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		if err := t.write(w, format); err != nil {
+			return err
+		}
+	}
+	if opts.recommend && format != "csv" {
+		printRecommendations(w, analysis.Recommendations, format)
+	}
+	return nil
+}
+
+// printRecommendations prints recommendations as a numbered list, the most
+// time at stake first, since their text does not fit a table column
+func printRecommendations(w io.Writer, recommendations []converter.Recommendation, format string) {
+	if format == "markdown" {
+		fmt.Fprintf(w, "\n### Recommendations\n\n")
+	} else {
+		fmt.Fprintf(w, "\nRecommendations:\n")
+	}
+	if len(recommendations) == 0 {
+		fmt.Fprintf(w, "None: no rule applies to this trace\n")
+		return
+	}
+	for i, r := range recommendations {
+		if format == "markdown" {
+			fmt.Fprintf(w, "%d. **%s** (%.3f ms at stake): %s\n   - %s\n", i+1, r.Rule, float64(r.ImpactNs)/1e6, r.Suggestion, r.Evidence)
+			continue
+		}
+		fmt.Fprintf(w, "%2d. [%s] %s\n    %s; %.3f ms at stake\n", i+1, r.Rule, r.Suggestion, r.Evidence, float64(r.ImpactNs)/1e6)
+	}
+}
+
+// histogramBarWidth is the length of the bar of the fullest bucket
+const histogramBarWidth = 40
+
+// histogramTable returns the table of a duration histogram, with a bar per
+// bucket except in CSV
+func histogramTable(h *converter.DurationHistogram, pattern, format string) *table {
+	t := &table{
+		title:   fmt.Sprintf("Duration Histogram of %q (%d events)", pattern, h.Events),
+		columns: []column{{"Duration", 24}, {"Count", 10}, {"% of events", 12}, {"Time (ms)", 12}},
+	}
+	bars := format != "csv"
+	if bars {
+		t.columns = append(t.columns, column{"", histogramBarWidth})
+	}
+	maxCount := 0
+	for _, b := range h.Buckets {
+		maxCount = max(maxCount, b.Count)
+	}
+	for i, b := range h.Buckets {
+		label := fmt.Sprintf("%v - %v", time.Duration(b.LowNs), time.Duration(b.HighNs))
+		if i == len(h.Buckets)-1 && b.HighNs == math.MaxInt64 {
+			label = fmt.Sprintf(">= %v", time.Duration(b.LowNs))
+		}
+		row := []string{label, strconv.Itoa(b.Count), fmt.Sprintf("%.1f", float64(b.Count)/float64(h.Events)*100), fmt.Sprintf("%.3f", float64(b.TimeNs)/1e6)}
+		if bars {
+			// Padded to the column, so the bar is left-aligned
+			bar := strings.Repeat("#", (b.Count*histogramBarWidth+maxCount-1)/maxCount)
+			row = append(row, fmt.Sprintf("%-*s", histogramBarWidth, bar))
+		}
+		t.addRow(row...)
+	}
+	return t
+}
+
+// memoryTables returns the tables of the memory analysis: the peaks of
+// each device, the ops open at each peak and the top allocation sites, or
+// only the empty peaks table without [memory] events
+func memoryTables(devices []converter.DeviceMemoryStats) []*table {
+	mib := func(bytes int64) string { return fmt.Sprintf("%.1f", float64(bytes)/(1<<20)) }
+	name := func(device string) string {
+		if device == "cpu" {
+			return "CPU"
+		}
+		return "GPU " + device
+	}
+	peaks := &table{
+		title: "Memory by Device",
+		columns: []column{{"Device", 10}, {"Peak alloc (MiB)", 17}, {"Peak reserved (MiB)", 20}, {"Peak at (ms)", 12},
+			{"Allocs", 10}, {"Allocated (MiB)", 16}, {"Freed (MiB)", 12}},
+		empty: "No [memory] events in trace (record with profile_memory=True)",
+	}
+	if len(devices) == 0 {
+		return []*table{peaks}
+	}
+	sites := &table{
+		title:   "Top Allocation Sites",
+		columns: []column{{"Op", 60}, {"Device", 10}, {"Allocs", 10}, {"Allocated (MiB)", 16}},
+	}
+	tables := []*table{peaks}
+	for _, d := range devices {
+		peaks.addRow(name(d.Device), mib(d.PeakAllocatedBytes), mib(d.PeakReservedBytes),
+			fmt.Sprintf("%.3f", float64(d.PeakNs)/1e6), strconv.Itoa(d.Allocations), mib(d.AllocatedBytes), mib(d.FreedBytes))
+		stack := &table{
+			title:   "Ops Open at Peak Memory of " + name(d.Device),
+			columns: []column{{"Op", 80}},
+		}
+		for _, op := range d.PeakStack {
+			stack.addRow(op)
+		}
+		tables = append(tables, stack)
+		for _, s := range d.TopSites {
+			op := s.Op
+			if op == "" {
+				op = "(no op)"
+			}
+			sites.addRow(op, name(d.Device), strconv.Itoa(s.Allocations), mib(s.Bytes))
+		}
+	}
+	return append(tables, sites)
+}
+
+func mergeCommand(args []string) {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	lf := addLogFlags(fs)
+	output := fs.String("o", "merged.pb.gz", "Output pprof file")
+	cf := addConvertFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: torch2pprof merge [options] <input>... -o <output.pb.gz>\n")
+		fmt.Fprintf(os.Stderr, "\nMerge pprof profiles and/or PyTorch traces into a single profile\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	inputs, err := parseInterspersed(fs, args)
+	if err != nil {
+		fatalf("parsing arguments: %v", err)
+	}
+	lf.setup(false)
+
+	if len(inputs) == 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	var profiles []*profile.Profile
+	for _, input := range inputs {
+		slog.Info("Loading", "path", input)
+		p, err := loadInput(input, cf)
+		if err != nil {
+			fatalf("loading %s: %v", input, err)
+		}
+		profiles = append(profiles, p)
+	}
+
+	merged, err := profile.Merge(profiles)
+	if err != nil {
+		fatalf("merging profiles: %v", err)
+	}
+
+	if err := writeProfile(*output, merged); err != nil {
+		fatalf("%v", err)
+	}
+	logProfileWritten(*output, merged)
+}
+
+func diffCommand(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	lf := addLogFlags(fs)
+	output := fs.String("o", "diff.pb.gz", "Output delta pprof file (candidate minus baseline)")
+	topN := fs.Int("top", 20, "Number of operations to show in the regression table")
+	cf := addConvertFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: torch2pprof diff [options] <baseline> <candidate>\n")
+		fmt.Fprintf(os.Stderr, "\nCompare two PyTorch traces or pprof profiles\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	inputs, err := parseInterspersed(fs, args)
+	if err != nil {
+		fatalf("parsing arguments: %v", err)
+	}
+	lf.setup(false)
+
+	if len(inputs) != 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	var profiles [2]*profile.Profile
+	for i, input := range inputs {
+		slog.Info("Loading", "path", input)
+		profiles[i], err = loadInput(input, cf)
+		if err != nil {
+			fatalf("loading %s: %v", input, err)
+		}
+	}
+	base, candidate := profiles[0], profiles[1]
+
+	delta, err := profile.Diff(base, candidate)
+	if err != nil {
+		fatalf("comparing profiles: %v", err)
+	}
+
+	if err := writeProfile(*output, delta); err != nil {
+		fatalf("%v", err)
+	}
+	logProfileWritten(*output, delta)
+
+	printRegressions(base, candidate, *topN)
+}
+
+// printRegressions prints per-operation time in both profiles for the
+// operations that changed the most
+func printRegressions(base, candidate *profile.Profile, n int) {
+	index := timeSampleIndex(candidate)
+	baseTotals := base.LeafTotals(index)
+	candTotals := candidate.LeafTotals(index)
+
+	var names []string
+	var baseTotal, candTotal int64
+	for name, v := range candTotals {
+		if v != baseTotals[name] {
+			names = append(names, name)
+		}
+		candTotal += v
+	}
+	for name, v := range baseTotals {
+		if _, ok := candTotals[name]; !ok && v != 0 {
+			names = append(names, name)
+		}
+		baseTotal += v
+	}
+	absDelta := func(name string) int64 {
+		d := candTotals[name] - baseTotals[name]
+		if d < 0 {
+			return -d
+		}
+		return d
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if di, dj := absDelta(names[i]), absDelta(names[j]); di != dj {
+			return di > dj
+		}
+		return names[i] < names[j]
+	})
+
+	fmt.Printf("Total: %.3f ms -> %.3f ms (%s)\n\n", float64(baseTotal)/1e6, float64(candTotal)/1e6, percentChange(baseTotal, candTotal))
+	fmt.Printf("%-50s %12s %12s %12s %9s\n", "Operation", "Base (ms)", "New (ms)", "Delta (ms)", "Change")
+	fmt.Printf("%s\n", "----------------------------------------------------------------------------------------------------")
+	for i, name := range names {
+		if i >= n {
+			break
+		}
+		b, c := baseTotals[name], candTotals[name]
+		if len(name) > 48 {
+			name = name[:45] + "..."
+		}
+		fmt.Printf("%-50s %12.3f %12.3f %+12.3f %9s\n", name, float64(b)/1e6, float64(c)/1e6, float64(c-b)/1e6, percentChange(b, c))
+	}
+}
+
+// timeSampleIndex returns the index of the first nanosecond sample type
+func timeSampleIndex(p *profile.Profile) int {
+	for i, st := range p.SampleType {
+		if p.StringTable[st.Unit] == "nanoseconds" {
+			return i
+		}
+	}
+	return len(p.SampleType) - 1
+}
+
+// percentChange formats the relative change from base to candidate
+func percentChange(base, candidate int64) string {
+	if base == 0 {
+		if candidate == 0 {
+			return "0.0%"
+		}
+		return "new"
+	}
+	return fmt.Sprintf("%+.1f%%", float64(candidate-base)/float64(base)*100)
+}
+
+func serveCommand(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	lf := addLogFlags(fs)
+	addr := fs.String("http", "localhost:8080", "Address to serve the web UI on")
+	cf := addConvertFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: torch2pprof serve [options] <input.json>\n")
+		fmt.Fprintf(os.Stderr, "\nConvert a PyTorch trace or read a pprof profile and serve a web UI for it\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	inputs, err := parseInterspersed(fs, args)
+	if err != nil {
+		fatalf("parsing arguments: %v", err)
+	}
+	lf.setup(false)
+
+	if len(inputs) != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	slog.Info("Loading", "path", inputs[0])
+	p, err := loadInput(inputs[0], cf)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	slog.Info("Serving web UI", "url", "http://"+listener.Addr().String())
+	server := newHTTPServer(web.NewServer(p))
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fatalf("%v", err)
+	}
+}
+
+func serverCommand(args []string) {
+	fs := flag.NewFlagSet("server", flag.ExitOnError)
+	lf := addLogFlags(fs)
+	listen := fs.String("listen", "localhost:8080", "Address to listen on, e.g. :8080 for all interfaces")
+	maxSize := fs.Int64("max-size", 1<<30, "Largest accepted trace upload in bytes, as sent (0 for no limit)")
+	maxTraceSize := fs.Int64("max-trace-size", 8<<30, "Largest accepted trace in bytes once decompressed (0 for no limit)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: torch2pprof server [options]\n")
+		fmt.Fprintf(os.Stderr, "\nServe an HTTP API: POST a trace to /convert for a pprof profile, or to /analyze\n")
+		fmt.Fprintf(os.Stderr, "for JSON statistics. Conversion options are query parameters, e.g. ?thread-roots=1\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	inputs, err := parseInterspersed(fs, args)
+	if err != nil {
+		fatalf("parsing arguments: %v", err)
+	}
+	lf.setup(true)
+
+	if len(inputs) != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if *maxSize < 0 || *maxTraceSize < 0 {
+		fatalf("%v", withExitCode(exitUsage, errors.New("-max-size and -max-trace-size must not be negative")))
+	}
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	slog.Info("Serving API", "url", "http://"+listener.Addr().String(), "endpoints", "POST /convert, POST /analyze")
+	server := newHTTPServer(web.NewAPIServer(*maxSize, *maxTraceSize, slog.Default()))
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fatalf("%v", err)
+	}
+}
+
+// HTTP server timeouts: clients get a while to send a large trace, but
+// cannot hold connections open by sending headers slowly or staying idle
+const (
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = 10 * time.Minute
+	idleTimeout       = 2 * time.Minute
+)
+
+// newHTTPServer returns a server for handler with the timeouts above
+func newHTTPServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+}
+
+func checkCommand(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	lf := addLogFlags(fs)
+	baseline := fs.String("baseline", "", "Baseline trace, or analysis written by analyze -json (required)")
+	maxRegression := fs.String("max-regression", "5%", "Largest allowed slowdown, as a percentage or a fraction")
+	ops := fs.String("ops", "", "Also check the time of each operation matching this regex")
+	var budgets []converter.OpBudget
+	fs.Func("budget", "Check an operation against its own budget: OP=DURATION for at most that time (aten::mm=10ms) or OP=PERCENT for at most that slowdown (aten::mm=10%) (repeatable)", func(v string) error {
+		b, err := converter.ParseOpBudget(v)
+		if err != nil {
+			return err
+		}
+		budgets = append(budgets, b)
+		return nil
+	})
+	format := fs.String("format", "text", "Output format: text, csv or markdown")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: torch2pprof check -baseline <file> [options] <input.json>\n")
+		fmt.Fprintf(os.Stderr, "\nExit with status %d when wall time, step time or operation times regress\n\n", exitRegression)
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	inputs, err := parseInterspersed(fs, args)
+	if err != nil {
+		fatalf("parsing arguments: %v", err)
+	}
+	lf.setup(false)
+
+	if len(inputs) != 1 || *baseline == "" {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	switch *format {
+	case "text", "csv", "markdown":
+	default:
+		fatalf("%v", withExitCode(exitUsage, fmt.Errorf("unknown -format %q (want text, csv or markdown)", *format)))
+	}
+	limit, err := converter.ParseRegression(*maxRegression)
+	if err != nil {
+		fatalf("%v", withExitCode(exitUsage, err))
+	}
+	opsRe, err := compileRegexp(*ops)
+	if err != nil {
+		fatalf("%v", withExitCode(exitUsage, fmt.Errorf("invalid -ops: %w", err)))
+	}
+
+	base, err := loadAnalysis(*baseline)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	candidate, err := loadAnalysis(inputs[0])
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	checks := converter.CheckRegressions(base, candidate, limit, opsRe, budgets)
+	results := &table{
+		title: "Regression Check",
+		columns: []column{{"Check", 50}, {"Baseline (ms)", 14}, {"Candidate (ms)", 15},
+			{"Change %", 9}, {"Limit", 12}, {"Result", 7}},
+	}
+	regressed := 0
+	for _, c := range checks {
+		change := fmt.Sprintf("%+.1f", c.Change*100)
+		if math.IsInf(c.Change, 1) {
+			change = "new"
+		}
+		result := "ok"
+		if c.Regressed {
+			result = "FAIL"
+			regressed++
+		}
+		limit := fmt.Sprintf("%+.1f%%", c.MaxChange*100)
+		if c.MaxNs > 0 {
+			limit = fmt.Sprintf("%.3f ms", float64(c.MaxNs)/1e6)
+		}
+		results.addRow(c.Name, fmt.Sprintf("%.3f", float64(c.BaselineNs)/1e6), fmt.Sprintf("%.3f", float64(c.CandidateNs)/1e6),
+			change, limit, result)
+	}
+	if err := results.write(os.Stdout, *format); err != nil {
+		fatalf("%v", err)
+	}
+	if regressed > 0 {
+		fatalf("%v", withExitCode(exitRegression, fmt.Errorf("%d of %d checks exceeded their limit", regressed, len(checks))))
+	}
+}
+
+// loadAnalysis analyzes the trace at path by operation name, or reads the
+// analysis written there by analyze -json
+func loadAnalysis(path string) (*converter.TraceAnalysis, error) {
+	isAnalysis, err := isAnalysisFile(path)
+	if err != nil {
+		return nil, withExitCode(exitParse, err)
+	}
+	if isAnalysis {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, withExitCode(exitParse, err)
+		}
+		var analysis converter.TraceAnalysis
+		if err := json.Unmarshal(data, &analysis); err != nil {
+			return nil, withExitCode(exitParse, fmt.Errorf("%s: %w", path, err))
+		}
+		return &analysis, nil
+	}
+
+	traceData, err := checkTrace(loadUsedArgs(path))
+	if err != nil {
+		return nil, err
+	}
+	return converter.AnalyzeTraceReports(ctx, traceData, converter.GroupByName, 0)
+}
+
+// isAnalysisFile reports whether path holds the output of analyze -json,
+// an object whose first key is total_events, rather than a trace
+func isAnalysisFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return false, nil
+	}
+	key, err := dec.Token()
+	return err == nil && key == "total_events", nil
+}
+
+func stragglersCommand(args []string) {
+	fs := flag.NewFlagSet("stragglers", flag.ExitOnError)
+	lf := addLogFlags(fs)
+	jsonOutput := fs.Bool("json", false, "Print the analysis as JSON instead of tables")
+	format := fs.String("format", "text", "Output format: text, csv or markdown")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: torch2pprof stragglers [options] <rank0.json> <rank1.json>...\n")
+		fmt.Fprintf(os.Stderr, "\nCompare step times and collective arrival times across the traces of all ranks\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	inputs, err := parseInterspersed(fs, args)
+	if err != nil {
+		fatalf("parsing arguments: %v", err)
+	}
+	lf.setup(false)
+
+	if len(inputs) < 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	switch *format {
+	case "text", "csv", "markdown":
+	default:
+		fatalf("unknown -format %q (want text, csv or markdown)", *format)
+	}
+
+	var timelines []*converter.RankTimeline
+	for i, input := range inputs {
+		slog.Info("Loading", "path", input)
+		traceData, err := checkTrace(loadUsedArgs(input))
+		if err != nil {
+			fatalf("%v", err)
+		}
+		t := converter.NewRankTimeline(traceData)
+		if t.Rank < 0 {
+			slog.Warn("Trace records no rank, numbering it by position", "path", input, "rank", i)
+			t.Rank = i
+		}
+		timelines = append(timelines, t)
+	}
+	analysis := converter.AnalyzeStragglers(timelines)
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(analysis); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+	if err := printStragglers(os.Stdout, analysis, *format); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// printStragglers prints the ranks, their step times and, except in CSV,
+// the skew of collective arrivals and the straggler
+func printStragglers(w io.Writer, analysis *converter.StragglerAnalysis, format string) error {
+	ms := func(ns int64) string { return fmt.Sprintf("%.3f", float64(ns)/1e6) }
+	if format != "csv" {
+		summary := [][2]string{
+			{"Collectives matched", strconv.Itoa(analysis.Collectives)},
+			{"Arrival skew (mean)", ms(analysis.MeanSkewNs) + " ms"},
+			{"Arrival skew (p50)", ms(analysis.P50SkewNs) + " ms"},
+			{"Arrival skew (max)", ms(analysis.MaxSkewNs) + " ms"},
+			{"Straggler", "none"},
+		}
+		if analysis.Straggler >= 0 {
+			summary[4][1] = fmt.Sprintf("rank %d", analysis.Straggler)
+		}
+		for _, item := range summary {
+			if format == "markdown" {
+				fmt.Fprintf(w, "- %s: %s\n", item[0], item[1])
+			} else {
+				fmt.Fprintf(w, "%-24s%s\n", item[0]+":", item[1])
+			}
+		}
+		fmt.Fprintln(w)
+	}
+
+	ranks := &table{
+		title: "Ranks",
+		columns: []column{{"Rank", 8}, {"Steps", 6}, {"Mean step (ms)", 15}, {"Max step (ms)", 14},
+			{"Last arrivals", 14}, {"% last", 7}, {"Mean late (ms)", 15}, {"Clock offset (ms)", 18}},
+	}
+	steps := &table{title: "Step Times by Rank (ms)", columns: []column{{"Step", 8}}}
+	rows := make(map[int][]string)
+	var order []int
+	for r, rank := range analysis.Ranks {
+		ranks.addRow(strconv.Itoa(rank.Rank), strconv.Itoa(len(rank.Steps)), ms(rank.MeanStepNs), ms(rank.MaxStepNs),
+			strconv.Itoa(rank.LastArrivals), fmt.Sprintf("%.1f", rank.LastFraction*100), ms(rank.MeanLateNs), ms(rank.ClockOffsetNs))
+		steps.columns = append(steps.columns, column{"Rank " + strconv.Itoa(rank.Rank), 12})
+		for _, s := range rank.Steps {
+			row, ok := rows[s.Step]
+			if !ok {
+				row = make([]string, len(analysis.Ranks)+1)
+				row[0] = strconv.Itoa(s.Step)
+				order = append(order, s.Step)
+			}
+			row[r+1] = ms(s.DurNs)
+			rows[s.Step] = row
+		}
+	}
+	sort.Ints(order)
+	for _, step := range order {
+		steps.addRow(rows[step]...)
+	}
+
+	if err := ranks.write(w, format); err != nil {
+		return err
+	}
+	if len(order) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+	return steps.write(w, format)
+}
+
+func topCommand(args []string) {
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	lf := addLogFlags(fs)
+	// -flat and -cum behave like pprof: the last one given wins
+	cum := false
+	fs.BoolFunc("cum", "Sort by total (cumulative) time", func(string) error { cum = true; return nil })
+	fs.BoolFunc("flat", "Sort by self time (default)", func(string) error { cum = false; return nil })
+	sampleIndex := fs.String("sample-index", "time", "Value to report: time or samples (event counts)")
+	topN := fs.Int("n", 20, "Number of operations to display")
+	epsilon := fs.Duration("epsilon", 0, "Tolerance when comparing event boundaries (e.g. 1us for rounded timestamps)")
+	window := addWindowFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: torch2pprof top [options] <input.json>\n")
+		fmt.Fprintf(os.Stderr, "\nShow operations by self or total time, computed directly from the trace\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	inputs, err := parseInterspersed(fs, args)
+	if err != nil {
+		fatalf("parsing arguments: %v", err)
+	}
+	lf.setup(false)
+
+	if len(inputs) != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if *sampleIndex != "time" && *sampleIndex != "samples" {
+		fatalf("unknown -sample-index %q (want time or samples)", *sampleIndex)
+	}
+
+	traceData, err := checkTrace(loadUsedArgs(inputs[0]))
+	if err != nil {
+		fatalf("%v", err)
+	}
+	traceData, err = window.apply(traceData)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	ops := converter.OperationTimes(traceData, *epsilon)
+	flat := func(o converter.OperationTime) int64 { return o.SelfNs }
+	cumulative := func(o converter.OperationTime) int64 { return o.TotalNs }
+	format := func(v int64) string { return fmt.Sprintf("%.3fms", float64(v)/1e6) }
+	if *sampleIndex == "samples" {
+		flat = func(o converter.OperationTime) int64 { return int64(o.Count) }
+		cumulative = flat
+		format = func(v int64) string { return strconv.FormatInt(v, 10) }
+	}
+	if cum {
+		sort.SliceStable(ops, func(i, j int) bool { return cumulative(ops[i]) > cumulative(ops[j]) })
+	} else {
+		sort.SliceStable(ops, func(i, j int) bool { return flat(ops[i]) > flat(ops[j]) })
+	}
+
+	var total int64
+	for _, o := range ops {
+		total += flat(o)
+	}
+	shown := min(*topN, len(ops))
+	fmt.Printf("Showing top %d of %d operations, %s total\n", shown, len(ops), format(total))
+	fmt.Printf("%12s %7s %7s %12s %7s  %s\n", "flat", "flat%", "sum%", "cum", "cum%", "name")
+	var sum int64
+	for _, o := range ops[:shown] {
+		sum += flat(o)
+		fmt.Printf("%12s %6.2f%% %6.2f%% %12s %6.2f%%  %s\n",
+			format(flat(o)), percent(flat(o), total), percent(sum, total),
+			format(cumulative(o)), percent(cumulative(o), total), o.Name)
+	}
+}
+
+func flamegraphCommand(args []string) {
+	fs := flag.NewFlagSet("flamegraph", flag.ExitOnError)
+	lf := addLogFlags(fs)
+	tuiMode := fs.Bool("tui", false, "Navigate the flame graph interactively in the terminal")
+	sampleIndex := fs.String("sample-index", "", "Sample type to show (default: last, i.e. time)")
+	cf := addConvertFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: torch2pprof flamegraph [options] <input.json>\n")
+		fmt.Fprintf(os.Stderr, "\nBrowse a flame graph in the terminal (-tui) or print folded stacks for flamegraph.pl\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	inputs, err := parseInterspersed(fs, args)
+	if err != nil {
+		fatalf("parsing arguments: %v", err)
+	}
+	lf.setup(false)
+
+	if len(inputs) != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	p, err := loadInput(inputs[0], cf)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	index, err := p.SampleIndex(*sampleIndex)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	if !*tuiMode {
+		if err := p.WriteFolded(os.Stdout, index); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		fatalf("-tui requires a terminal: %v", err)
+	}
+	defer tty.Close()
+
+	view := tui.NewFlameView(p.CallTree(index), p.StringTable[p.SampleType[index].Unit])
+	if err := tui.Run(view, tty); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+func extractCommand(args []string) {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	lf := addLogFlags(fs)
+	window := addWindowFlags(fs)
+	fs.Func("steps", "Keep ProfilerStep spans A through B, given as A:B, A:, :B or a single step", func(v string) error {
+		first, last, err := parseStepRange(v)
+		if err != nil {
+			return err
+		}
+		*window.startStep, *window.endStep = first, last
+		return nil
+	})
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: torch2pprof extract [options] <input.json> <output.json>\n")
+		fmt.Fprintf(os.Stderr, "\nWrite the events of a time range or profiler steps as a smaller Chrome trace\n")
+		fmt.Fprintf(os.Stderr, "(gzip-compressed if the output ends in .gz)\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	inputs, err := parseInterspersed(fs, args)
+	if err != nil {
+		fatalf("parsing arguments: %v", err)
+	}
+	lf.setup(false)
+
+	if len(inputs) != 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if *window.startTs == 0 && *window.endTs == 0 && *window.startStep < 0 && *window.endStep < 0 &&
+		*window.skipSteps == 0 && !*window.skipWarmup {
+		fatalf("no range selected: use -steps, -start-step/-end-step, -start-ts/-end-ts, -skip-steps or -skip-warmup")
+	}
+
+	traceData, err := loadTrace(inputs[0], nil)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	total := len(traceData.TraceEvents)
+	traceData, err = window.apply(traceData)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	if err := converter.WriteTraceFile(inputs[1], traceData); err != nil {
+		fatalf("writing trace: %v", withExitCode(exitWrite, err))
+	}
+	slog.Info("Trace slice written", "path", inputs[1], "events", len(traceData.TraceEvents), "of", total)
+}
+
+func splitCommand(args []string) {
+	fs := flag.NewFlagSet("split", flag.ExitOnError)
+	lf := addLogFlags(fs)
+	by := fs.String("by", "step", "Group events by step (ProfilerStep span), rank (process) or gpu (device)")
+	format := fs.String("format", "pprof", "Output format: pprof or trace")
+	cf := addConvertFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: torch2pprof split [options] <input.json> <outdir>\n")
+		fmt.Fprintf(os.Stderr, "\nWrite one pprof profile or trace per profiler step, rank or GPU\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	inputs, err := parseInterspersed(fs, args)
+	if err != nil {
+		fatalf("parsing arguments: %v", err)
+	}
+	lf.setup(false)
+
+	if len(inputs) != 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	key, err := converter.ParseSplitKey(*by)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if *format != "pprof" && *format != "trace" {
+		fatalf("unknown -format %q (want pprof or trace)", *format)
+	}
+	outDir := inputs[1]
+
+	traceData, err := loadTrace(inputs[0], nil)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	traceData, err = cf.window.apply(traceData)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	groups, err := converter.SplitTrace(traceData, key)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	slog.Info("Split trace", "by", key, "groups", len(groups))
+
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		fatalf("creating output directory: %v", withExitCode(exitWrite, err))
+	}
+	for _, g := range groups {
+		if *format == "trace" {
+			path := filepath.Join(outDir, g.Name+".json.gz")
+			if err := converter.WriteTraceFile(path, g.Trace); err != nil {
+				fatalf("writing trace: %v", withExitCode(exitWrite, err))
+			}
+			slog.Info("Trace written", "path", path, "events", len(g.Trace.TraceEvents))
+			continue
+		}
+
+		opts, err := cf.options(g.Trace)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		p, err := converter.ConvertTraceContext(ctx, g.Trace, opts)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		path := filepath.Join(outDir, g.Name+".pb.gz")
+		if err := writeProfile(path, p); err != nil {
+			fatalf("%v", err)
+		}
+		logProfileWritten(path, p)
+	}
+}
+
+// parseStepRange parses a step range such as "10:20", "10:", ":20" or "15".
+// An open bound is returned as -1.
+func parseStepRange(v string) (first, last int, err error) {
+	firstStr, lastStr, isRange := strings.Cut(v, ":")
+	if !isRange {
+		lastStr = firstStr
+	}
+	first, last = -1, -1
+	if firstStr != "" {
+		if first, err = strconv.Atoi(firstStr); err != nil || first < 0 {
+			return 0, 0, fmt.Errorf("invalid step %q", firstStr)
+		}
+	}
+	if lastStr != "" {
+		if last, err = strconv.Atoi(lastStr); err != nil || last < 0 {
+			return 0, 0, fmt.Errorf("invalid step %q", lastStr)
+		}
+	}
+	if first < 0 && last < 0 {
+		return 0, 0, fmt.Errorf("empty step range %q", v)
+	}
+	if first >= 0 && last >= 0 && last < first {
+		return 0, 0, fmt.Errorf("step range %q ends before it starts", v)
+	}
+	return first, last, nil
+}
+
+func grepCommand(args []string) {
+	fs := flag.NewFlagSet("grep", flag.ExitOnError)
+	lf := addLogFlags(fs)
+	catPattern := fs.String("cat", "", "Only match events whose category matches this regular expression")
+	jsonOutput := fs.Bool("json", false, "Print matching events as JSON, one per line")
+	countOnly := fs.Bool("count", false, "Only print the number of matching events")
+	maxMatches := fs.Int("max", 0, "Stop after this many matches (0: all)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: torch2pprof grep [options] <regex> <input.json>\n")
+		fmt.Fprintf(os.Stderr, "\nPrint the events whose name matches a regular expression\n")
+		fmt.Fprintf(os.Stderr, "(exits with status 1 if no event matches)\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	inputs, err := parseInterspersed(fs, args)
+	if err != nil {
+		fatalf("parsing arguments: %v", err)
+	}
+	lf.setup(false)
+
+	if len(inputs) != 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	nameRe, err := compileRegexp(inputs[0])
+	if err != nil {
+		fatalf("%v", withExitCode(exitUsage, err))
+	}
+	catRe, err := compileRegexp(*catPattern)
+	if err != nil {
+		fatalf("%v", withExitCode(exitUsage, err))
+	}
+
+	traceData, err := converter.LoadTraceFileContext(ctx, inputs[1], nil)
+	if err != nil {
+		fatalf("%v", withExitCode(exitParse, err))
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	if !*jsonOutput && !*countOnly {
+		fmt.Fprintf(w, "%18s %12s %-8s %-10s %-16s %s\n", "ts (us)", "dur (us)", "pid", "tid", "cat", "name / args")
+	}
+	enc := json.NewEncoder(w)
+	matches := 0
+	for _, e := range traceData.TraceEvents {
+		if e.Ph == "M" || !nameRe.MatchString(e.Name) || (catRe != nil && !catRe.MatchString(e.Cat)) {
+			continue
+		}
+		matches++
+		switch {
+		case *countOnly:
+		case *jsonOutput:
+			if err := enc.Encode(e); err != nil {
+				fatalf("%v", err)
+			}
+		default:
+			fmt.Fprintf(w, "%18.3f %12.3f %-8v %-10v %-16s %s", e.Ts, e.Dur, e.Pid, e.Tid, e.Cat, e.Name)
+			if len(e.Args) > 0 {
+				args, _ := json.Marshal(e.Args)
+				fmt.Fprintf(w, " %s", args)
+			}
+			fmt.Fprintln(w)
+		}
+		if *maxMatches > 0 && matches >= *maxMatches {
+			break
+		}
+	}
+	if *countOnly {
+		fmt.Fprintln(w, matches)
+	}
+	if matches == 0 {
+		w.Flush()
+		exit(exitFailure)
+	}
+}
+
+func timelineCommand(args []string) {
+	fs := flag.NewFlagSet("timeline", flag.ExitOnError)
+	lf := addLogFlags(fs)
+	buckets := fs.Int("buckets", 100, "Number of time buckets")
+	format := fs.String("format", "text", "Output format: text or html")
+	gpuOnly := fs.Bool("gpu", false, "Only show GPU streams")
+	output := fs.String("o", "", "Write the timeline to this file instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: torch2pprof timeline [options] <input.json>\n")
+		fmt.Fprintf(os.Stderr, "\nShow how busy each thread and GPU stream is over time, to spot gaps and serialization\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	inputs, err := parseInterspersed(fs, args)
+	if err != nil {
+		fatalf("parsing arguments: %v", err)
+	}
+	lf.setup(false)
+
+	if len(inputs) != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if *format != "text" && *format != "html" {
+		fatalf("%v", withExitCode(exitUsage, fmt.Errorf("unknown format %q (want text or html)", *format)))
+	}
+	if *buckets < 1 {
+		fatalf("%v", withExitCode(exitUsage, fmt.Errorf("-buckets must be at least 1")))
+	}
+
+	traceData, err := checkTrace(loadUsedArgs(inputs[0]))
+	if err != nil {
+		fatalf("%v", err)
+	}
+	tl := converter.BuildTimeline(traceData, *buckets)
+	if *gpuOnly {
+		lanes := tl.Lanes[:0]
+		for _, lane := range tl.Lanes {
+			if lane.GPU {
+				lanes = append(lanes, lane)
+			}
+		}
+		tl.Lanes = lanes
+	}
+	if len(tl.Lanes) == 0 {
+		fatalf("%v", withExitCode(exitEmpty, fmt.Errorf("no events to show in %s", inputs[0])))
+	}
+
+	w := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fatalf("%v", withExitCode(exitWrite, err))
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "html" {
+		err = writeTimelineHTML(w, tl, "Timeline of "+filepath.Base(inputs[0]))
+	} else {
+		err = writeTimelineText(w, tl)
+	}
+	if err != nil {
+		fatalf("%v", withExitCode(exitWrite, err))
+	}
+	if *output != "" {
+		slog.Info("Wrote timeline", "output", *output, "lanes", len(tl.Lanes))
+	}
+}
+
+func infoCommand(args []string) {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	lf := addLogFlags(fs)
+	jsonOutput := fs.Bool("json", false, "Print the trace information as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: torch2pprof info [options] <input.json>\n")
+		fmt.Fprintf(os.Stderr, "\nShow trace metadata and what the profiler recorded\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	inputs, err := parseInterspersed(fs, args)
+	if err != nil {
+		fatalf("parsing arguments: %v", err)
+	}
+	lf.setup(false)
+
+	if len(inputs) != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	traceData, err := loadUsedArgs(inputs[0])
+	if err != nil {
+		fatalf("%v", withExitCode(exitParse, err))
+	}
+	info := converter.Info(traceData)
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(info); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+
+	phases := make([]string, 0, len(info.EventsByPhase))
+	for ph := range info.EventsByPhase {
+		phases = append(phases, ph)
+	}
+	sort.Slice(phases, func(i, j int) bool { return info.EventsByPhase[phases[i]] > info.EventsByPhase[phases[j]] })
+	byPhase := make([]string, len(phases))
+	for i, ph := range phases {
+		byPhase[i] = fmt.Sprintf("%s %d", ph, info.EventsByPhase[ph])
+	}
+	yesNo := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+
+	fmt.Printf("Trace:           %s\n", inputs[0])
+	if info.SchemaVersion != nil {
+		fmt.Printf("Schema version:  %s\n", info.SchemaVersion)
+	}
+	fmt.Printf("Time span:       %.3f ms (ts %.3f to %.3f us)\n", float64(info.SpanNs)/1e6, info.StartUs, info.EndUs)
+	fmt.Printf("Events:          %d (%s)\n", info.Events, strings.Join(byPhase, ", "))
+	fmt.Printf("Profiler steps:  %d\n", info.ProfilerSteps)
+	fmt.Printf("Threads:         %d\n", info.Threads)
+	fmt.Printf("Processes:       %d\n", len(info.Processes))
+	for _, p := range info.Processes {
+		fmt.Printf("  pid %-10s %-30s %d events\n", p.Pid, p.Name, p.Events)
+	}
+	fmt.Printf("GPU devices:     %d\n", len(info.Devices))
+	for _, d := range info.Devices {
+		fmt.Printf("  GPU %-10s %s\n", d.ID, d.Name)
+	}
+	if di := info.DistributedInfo; di != nil {
+		fmt.Printf("Distributed:     rank %d of %d (%s)\n", di.Rank, di.WorldSize, di.Backend)
+	}
+	fmt.Printf("Recorded:        stacks %s, shapes %s, memory %s, flops %s\n",
+		yesNo(info.Stacks), yesNo(info.Shapes), yesNo(info.Memory), yesNo(info.Flops))
+}
+
+func validateCommand(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	lf := addLogFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: torch2pprof validate [options] <profile.pb.gz>...\n")
+		fmt.Fprintf(os.Stderr, "\nDecode pprof profiles and check string indices, ids and sample values\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	inputs, err := parseInterspersed(fs, args)
+	if err != nil {
+		fatalf("parsing arguments: %v", err)
+	}
+	lf.setup(false)
+
+	if len(inputs) == 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	invalid := 0
+	for _, input := range inputs {
+		problems, err := validateFile(input)
+		if err != nil {
+			slog.Error("Cannot decode profile", "path", input, "error", err)
+			invalid++
+			continue
+		}
+		for _, problem := range problems {
+			fmt.Printf("%s: %v\n", input, problem)
+		}
+		if len(problems) > 0 {
+			invalid++
+		}
+	}
+	if invalid > 0 {
+		fatalf("%v", withExitCode(exitValidation, fmt.Errorf("%d of %d profiles are invalid", invalid, len(inputs))))
+	}
+}
+
+// validateFile decodes a pprof profile and returns its integrity problems
+func validateFile(path string) ([]error, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p, err := profile.Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("decoding profile: %w", err)
+	}
+	problems := p.Validate()
+	if len(problems) == 0 {
+		fmt.Printf("%s: OK (%d samples, %d locations, %d functions)\n",
+			path, len(p.Sample), len(p.Location), len(p.Function))
+	}
+	return problems, nil
+}
+
+// percent returns v as a percentage of total
+func percent(v, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(v) / float64(total) * 100
+}
+
+// parseInterspersed parses flags that may appear before, between or after
+// positional arguments and returns the positional arguments
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// loadInput reads a pprof profile, or converts a PyTorch trace (plain or
+// gzipped JSON) with the options in cf
+func loadInput(path string, cf *convertFlags) (*profile.Profile, error) {
+	isTrace, err := isTraceFile(path)
+	if err != nil {
+		return nil, withExitCode(exitParse, err)
+	}
+	if isTrace {
+		return cf.convertFile(path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, withExitCode(exitParse, err)
+	}
+	defer f.Close()
+	p, err := profile.Parse(f)
+	if err != nil {
+		return nil, withExitCode(exitParse, fmt.Errorf("%s: %w", path, err))
+	}
+	return p, nil
+}
+
+// isTraceFile reports whether path holds JSON rather than a pprof protobuf
+func isTraceFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	if magic, err := r.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return false, err
+		}
+		defer gz.Close()
+		r = bufio.NewReader(gz)
+	}
+
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b == '{' || b == '[', nil
+	}
+}
+
+// logDiagnostics summarizes the events a conversion skipped, with the
+// reasons that apply
+func logDiagnostics(d *converter.Diagnostics) {
+	attrs := []any{"events", d.Events, "converted", d.Converted, "skipped", d.Skipped()}
+	if len(d.SkippedPhases) > 0 {
+		phases := make([]string, 0, len(d.SkippedPhases))
+		for ph, n := range d.SkippedPhases {
+			phases = append(phases, fmt.Sprintf("%s=%d", ph, n))
+		}
+		sort.Strings(phases)
+		attrs = append(attrs, "phases", strings.Join(phases, ","))
+	}
+	for _, reason := range []struct {
+		key   string
+		count int
+	}{
+		{"zero_duration", d.ZeroDuration},
+		{"min_dur", d.ShorterThanMin},
+		{"mapper", d.DroppedByMapper},
+	} {
+		if reason.count > 0 {
+			attrs = append(attrs, reason.key, reason.count)
+		}
+	}
+	slog.Info("Converted events", attrs...)
+}
+
+// logProfileWritten logs the size of a profile written to path
+func logProfileWritten(path string, p *profile.Profile) {
+	slog.Info("Wrote profile", "path", path, "samples", len(p.Sample), "locations", len(p.Location),
+		"functions", len(p.Function), "strings", len(p.StringTable))
+}
+
+// writeProfile writes p gzip-compressed to path, encoding it straight into
+// the gzip writer rather than into memory first. Errors carry exitWrite.
+func writeProfile(path string, p *profile.Profile) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return withExitCode(exitWrite, fmt.Errorf("creating output file: %w", err))
+	}
+
+	gz := gzip.NewWriter(f)
+	if _, err := p.WriteTo(gz); err != nil {
+		_ = f.Close()
+		return withExitCode(exitWrite, fmt.Errorf("writing profile: %w", err))
+	}
+	if err := gz.Close(); err != nil {
+		_ = f.Close()
+		return withExitCode(exitWrite, fmt.Errorf("closing gzip: %w", err))
+	}
+	if err := f.Close(); err != nil {
+		return withExitCode(exitWrite, fmt.Errorf("closing file: %w", err))
+	}
+	return nil
+}
+
+// convertFlags holds the conversion options shared by commands that convert
+// traces
+type convertFlags struct {
+	threadRoots      *bool
+	commRoot         *bool
+	focus            *string
+	ignore           *string
+	rewrite          *string
+	collapse         *bool
+	normalizeKernels *bool
+	rawKernelLabel   *bool
+	launchLatency    *bool
+	idle             *bool
+	sampleRate       *float64
+	eventBudget      *int
+	clock            *string
+	overlap          *string
+	sampleTypes      *string
+	maxMemory        *string
+	workers          *int
+	epsilon          *time.Duration
+	minDur           *time.Duration
+	labels           map[string]string
+	window           *windowFlags
+
+	// report prints the analysis of each trace, set by convert -report
+	report bool
+}
+
+func addConvertFlags(fs *flag.FlagSet) *convertFlags {
+	labels := make(map[string]string)
+	fs.Func("label", "Attach key=value to every sample and as a profile comment (repeatable)", func(v string) error {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("want key=value, got %q", v)
+		}
+		labels[key] = value
+		return nil
+	})
+	return &convertFlags{
+		threadRoots:      fs.Bool("thread-roots", false, "Prefix stacks with a root frame naming the process/thread or GPU stream"),
+		commRoot:         fs.Bool("comm-root", false, "Group NCCL/c10d communication stacks under a synthetic 'Communication' root"),
+		focus:            fs.String("focus", "", "Only keep stacks with a frame matching this regex"),
+		ignore:           fs.String("ignore", "", "Drop stacks with a frame matching this regex"),
+		rewrite:          fs.String("rewrite", "", "Rename, merge or drop frames with the rules in this YAML file"),
+		collapse:         fs.Bool("collapse-recursion", false, "Merge consecutive identical frames into one frame with a repetition count"),
+		normalizeKernels: fs.Bool("normalize-kernels", false, "Demangle and shorten GPU kernel names (strip templates, params, generated suffixes)"),
+		rawKernelLabel:   fs.Bool("raw-kernel-label", false, "With -normalize-kernels, keep the original kernel name as a 'raw_name' label"),
+		launchLatency:    fs.Bool("launch-latency", false, "Attribute the delay between kernel launch calls and kernel start to the calls under a 'launch latency' root frame"),
+		idle:             fs.Bool("idle", false, "Add synthetic '<idle>' samples for gaps between events on each thread/stream"),
+		sampleRate:       fs.Float64("sample-rate", 1, "Keep this fraction of leaf events (0-1], scaling values accordingly"),
+		eventBudget:      fs.Int("event-budget", 0, "Downsample leaf events so roughly this many events are converted"),
+		clock:            fs.String("clock", "wall", "Sample value clock: wall (dur) or thread (tdur, thread CPU time)"),
+		overlap:          fs.String("overlap", "split", "How to attribute partially overlapping events: split, parent or drop"),
+		sampleTypes:      fs.String("sample-type", "", "Comma-separated value columns to write: samples, time (default: both)"),
+		maxMemory:        fs.String("max-memory", "", "Spill aggregated stacks to temporary files beyond this size, e.g. 4GiB, and fail if the profile outgrows it, or 0 for no limit (default: half the available memory, for traces that may need it)"),
+		workers:          fs.Int("workers", 0, "Number of threads converted in parallel (default: one per usable CPU, fewer for small traces)"),
+		epsilon:          fs.Duration("epsilon", 0, "Tolerance when comparing event boundaries (e.g. 1us for rounded timestamps)"),
+		minDur:           fs.Duration("min-dur", 0, "Drop events shorter than this duration (e.g. 5us)"),
+		labels:           labels,
+		window:           addWindowFlags(fs),
+	}
+}
+
+// options validates the flags and returns the conversion options for
+// traceData; the event budget depends on the number of events in the trace
+func (cf *convertFlags) options(traceData *converter.TraceData) (converter.ConvertOptions, error) {
+	focusRe, err := compileRegexp(*cf.focus)
+	if err != nil {
+		return converter.ConvertOptions{}, fmt.Errorf("invalid -focus: %w", err)
+	}
+	ignoreRe, err := compileRegexp(*cf.ignore)
+	if err != nil {
+		return converter.ConvertOptions{}, fmt.Errorf("invalid -ignore: %w", err)
+	}
+	var rewriter converter.FrameRewriter
+	if *cf.rewrite != "" {
+		rules, err := converter.LoadRewriteRules(*cf.rewrite)
+		if err != nil {
+			return converter.ConvertOptions{}, fmt.Errorf("invalid -rewrite: %w", err)
+		}
+		rewriter = rules
+	}
+	overlapPolicy, err := converter.ParseOverlapPolicy(*cf.overlap)
+	if err != nil {
+		return converter.ConvertOptions{}, err
+	}
+	clockMode, err := converter.ParseClock(*cf.clock)
+	if err != nil {
+		return converter.ConvertOptions{}, err
+	}
+	sampleTypes, err := converter.ParseSampleTypes(*cf.sampleTypes)
+	if err != nil {
+		return converter.ConvertOptions{}, err
+	}
+	maxMemory, err := parseByteSize(*cf.maxMemory)
+	if err != nil {
+		return converter.ConvertOptions{}, fmt.Errorf("invalid -max-memory: %w", err)
+	}
+
+	rate := *cf.sampleRate
+	if *cf.eventBudget > 0 {
+		if complete := countCompleteEvents(traceData); complete > *cf.eventBudget {
+			rate = min(rate, float64(*cf.eventBudget)/float64(complete))
+		}
+	}
+	if rate <= 0 || rate > 1 {
+		return converter.ConvertOptions{}, fmt.Errorf("-sample-rate must be in (0, 1]")
+	}
+
+	var analyzeBy converter.GroupBy
+	if cf.report {
+		analyzeBy = converter.GroupByName
+	}
+
+	if *cf.workers < 0 {
+		return converter.ConvertOptions{}, fmt.Errorf("-workers must not be negative")
+	}
+
+	opts := converter.ConvertOptions{
+		ThreadRoots:          *cf.threadRoots,
+		CommunicationRoot:    *cf.commRoot,
+		Focus:                focusRe,
+		Ignore:               ignoreRe,
+		CollapseRecursion:    *cf.collapse,
+		NormalizeKernelNames: *cf.normalizeKernels,
+		RawKernelNameLabel:   *cf.rawKernelLabel,
+		MinDuration:          *cf.minDur,
+		LaunchLatency:        *cf.launchLatency,
+		IdleFrames:           *cf.idle,
+		SampleRate:           rate,
+		Clock:                clockMode,
+		Epsilon:              *cf.epsilon,
+		Overlap:              overlapPolicy,
+		SampleTypes:          sampleTypes,
+		Labels:               cf.labels,
+		Rewrite:              rewriter,
+		AnalyzeBy:            analyzeBy,
+		Logger:               slog.Default(),
+	}
+
+	// Resources are tuned to the machine and trace unless set by flags
+	res := converter.DetectResources()
+	opts = converter.AutoTune(opts, traceData, res)
+	if *cf.workers > 0 {
+		opts.NumWorkers = *cf.workers
+	}
+	if *cf.maxMemory != "" {
+		opts.MaxMemory = maxMemory
+	}
+	slog.Debug("Using resources", "cpus", res.CPUs, "available_memory", res.Memory,
+		"workers", opts.NumWorkers, "max_memory", opts.MaxMemory)
+	return opts, nil
+}
+
+// convertFile loads the trace at path and converts it with the flag options
+func (cf *convertFlags) convertFile(path string) (*profile.Profile, error) {
+	traceData, err := checkTrace(loadUsedArgs(path))
+	if err != nil {
+		return nil, err
+	}
+	traceData, err = cf.window.apply(traceData)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := cf.options(traceData)
+	if err != nil {
+		return nil, err
+	}
+	p, diag, err := converter.ConvertTraceWithDiagnostics(ctx, traceData, opts)
+	if err != nil {
+		return nil, err
+	}
+	logDiagnostics(diag)
+	logMemory(diag)
+	if err := printReport(diag); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// printReport prints the analysis gathered while converting with -report,
+// as analyze does with its default options
+func printReport(diag *converter.Diagnostics) error {
+	if diag.Analysis == nil {
+		return nil
+	}
+	return printAnalysis(os.Stdout, diag.Analysis, diag.Overlaps, reportOptions{topN: 20, format: "text"})
+}
+
+// runInteractiveAnalysis shows the analysis in a terminal table. Stacks for
+// drill-down are converted from the trace on first use.
+func runInteractiveAnalysis(traceData *converter.TraceData, analysis *converter.TraceAnalysis) error {
+	var operations, categories []tui.TableRow
+	for _, o := range analysis.GetSortedOperations() {
+		operations = append(operations, tui.TableRow{
+			Name:     o.Name,
+			Category: analysis.OperationStats[o.Name].Category,
+			Count:    o.Count,
+			TimeNs:   o.TimeNs,
+		})
+	}
+	for _, c := range analysis.GetSortedCategories() {
+		categories = append(categories, tui.TableRow{Name: c.Name, Count: c.Count, TimeNs: c.TimeNs})
+	}
+
+	var p *profile.Profile
+	stacks := func(operation string) []profile.Stack {
+		if p == nil {
+			p = converter.ConvertTrace(traceData, converter.ConvertOptions{NumWorkers: runtime.NumCPU()})
+		}
+		index, _ := p.SampleIndex("")
+		return p.LeafStacks(index, operation)
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("-interactive requires a terminal: %w", err)
+	}
+	defer tty.Close()
+	return tui.Run(tui.NewAnalyzeView(operations, categories, stacks), tty)
+}
+
+// windowFlags holds the time-window selection shared by convert and analyze
+type windowFlags struct {
+	startTs    *float64
+	endTs      *float64
+	startStep  *int
+	endStep    *int
+	skipSteps  *int
+	skipWarmup *bool
+}
+
+func addWindowFlags(fs *flag.FlagSet) *windowFlags {
+	return &windowFlags{
+		startTs:    fs.Float64("start-ts", 0, "Only use events after this trace timestamp (microseconds)"),
+		endTs:      fs.Float64("end-ts", 0, "Only use events before this trace timestamp (microseconds)"),
+		startStep:  fs.Int("start-step", -1, "Only use events from this ProfilerStep onwards"),
+		endStep:    fs.Int("end-step", -1, "Only use events up to and including this ProfilerStep"),
+		skipSteps:  fs.Int("skip-steps", 0, "Drop the events before the ProfilerStep after the first N, such as warmup steps"),
+		skipWarmup: fs.Bool("skip-warmup", false, "Drop the leading ProfilerSteps taking over 1.5x the median step, as warmup"),
+	}
+}
+
+// apply restricts the trace to the selected window; step bounds take
+// precedence over timestamp bounds, and skipped steps start the window no
+// earlier than they end
+func (wf *windowFlags) apply(traceData *converter.TraceData) (*converter.TraceData, error) {
+	window := converter.TimeWindow{Start: *wf.startTs, End: *wf.endTs}
+	if *wf.startStep >= 0 || *wf.endStep >= 0 {
+		steps, err := converter.StepWindow(traceData, *wf.startStep, *wf.endStep)
+		if err != nil {
+			return nil, err
+		}
+		if *wf.startStep >= 0 {
+			window.Start = steps.Start
+		}
+		if *wf.endStep >= 0 {
+			window.End = steps.End
+		}
+	}
+	skip := *wf.skipSteps
+	if *wf.skipWarmup {
+		warmup := converter.WarmupSteps(traceData)
+		slog.Info("Detected warmup steps", "steps", warmup)
+		skip = max(skip, warmup)
+	}
+	if skip > 0 {
+		skipped, err := converter.SkipStepsWindow(traceData, skip)
+		if err != nil {
+			return nil, err
+		}
+		window.Start = max(window.Start, skipped.Start)
+	}
+	return converter.FilterTimeWindow(traceData, window), nil
+}
+
+// compileRegexp compiles an optional regex flag; an empty pattern yields nil
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(pattern)
+}
+
+// parseByteSize parses a size flag such as 512MB, 2GiB or 1073741824; an
+// empty value yields 0
+func parseByteSize(v string) (int64, error) {
+	if v == "" {
+		return 0, nil
+	}
+	num := strings.TrimRightFunc(strings.ToUpper(v), func(r rune) bool { return r >= 'A' && r <= 'Z' })
+	multiplier, ok := map[string]float64{
+		"": 1, "B": 1,
+		"K": 1 << 10, "KB": 1e3, "KIB": 1 << 10,
+		"M": 1 << 20, "MB": 1e6, "MIB": 1 << 20,
+		"G": 1 << 30, "GB": 1e9, "GIB": 1 << 30,
+		"T": 1 << 40, "TB": 1e12, "TIB": 1 << 40,
+	}[strings.ToUpper(v[len(num):])]
+	n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if !ok || err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (want e.g. 512MB or 2GiB)", v)
+	}
+	return int64(n * multiplier), nil
+}
+
+// printOverlaps prints per-thread partial overlap counts, limited to n threads
+func printOverlaps(w io.Writer, overlaps []converter.ThreadOverlap, n int, prefix string) {
+	for i, o := range overlaps {
+		if i >= n {
+			fmt.Fprintf(w, "%s... and %d more threads\n", prefix, len(overlaps)-n)
+			break
+		}
+		fmt.Fprintf(w, "%spid %v tid %v: %d of %d events\n", prefix, o.Pid, o.Tid, o.Overlaps, o.Events)
+	}
+}
+
+// countCompleteEvents counts the events conversion would turn into samples
+func countCompleteEvents(traceData *converter.TraceData) int {
+	n := 0
+	for _, e := range traceData.TraceEvents {
+		if e.Ph == "X" && e.Dur > 0 {
+			n++
 		}
-		fmt.Printf("%-60s %12.3f %10d\n", name, float64(o.TimeNs)/1e6, o.Count)
 	}
+	return n
 }
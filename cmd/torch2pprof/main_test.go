@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/olka/torch2pprof/pkg/converter"
+)
+
+func TestPrintAnalysisEmptyReports(t *testing.T) {
+	traceData := &converter.TraceData{TraceEvents: []converter.TraceEvent{
+		{Ph: "X", Name: "aten::mm", Cat: "cpu_op", Pid: float64(1), Tid: float64(1), Dur: 10},
+	}}
+	analysis := converter.AnalyzeTraceBy(traceData, converter.GroupByName)
+	opts := reportOptions{
+		topN: 10, byDevice: true, memory: true, allocator: true, transfers: true,
+		python: true, commOverlap: true, dataLoader: true, stepTrend: true,
+	}
+	want := []string{
+		"No GPU events in trace",
+		"No NCCL collective kernels in trace",
+		"No DataLoader events in trace",
+		"No ProfilerStep events in trace",
+		"No [memory] events in trace",
+		"No python_function events in trace",
+		"No host-device memcpy events in trace",
+		"No cudaMalloc, cudaFree or cudaMemGetInfo calls in trace",
+	}
+	for _, format := range []string{"text", "markdown"} {
+		opts.format = format
+		var b strings.Builder
+		if err := printAnalysis(&b, analysis, nil, opts); err != nil {
+			t.Fatalf("%s: printAnalysis: %v", format, err)
+		}
+		for _, line := range want {
+			if !strings.Contains(b.String(), line) {
+				t.Errorf("%s: expected %q in\n%s", format, line, b.String())
+			}
+		}
+		if strings.Contains(b.String(), "Largest GPU Idle Gaps") || strings.Contains(b.String(), "Allocator Call Sites") {
+			t.Errorf("%s: expected no tables following an empty report in\n%s", format, b.String())
+		}
+	}
+
+	// CSV keeps the header of empty tables, so every table has columns
+	opts.format = "csv"
+	var b strings.Builder
+	if err := printAnalysis(&b, analysis, nil, opts); err != nil {
+		t.Fatalf("csv: printAnalysis: %v", err)
+	}
+	if strings.Contains(b.String(), "No ") || !strings.Contains(b.String(), "Direction,Count,MB") {
+		t.Errorf("csv: expected the headers of empty tables only, got\n%s", b.String())
+	}
+}
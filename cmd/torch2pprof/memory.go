@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/olka/torch2pprof/pkg/converter"
+)
+
+// logMemory logs the peak resident memory of the process and the heap use
+// of each phase of a conversion
+func logMemory(d *converter.Diagnostics) {
+	var attrs []any
+	if rss := peakRSS(); rss > 0 {
+		attrs = append(attrs, "peak_rss", formatByteSize(rss))
+	}
+	for _, phase := range d.Memory {
+		attrs = append(attrs, phase.Stage+"_heap", formatByteSize(phase.HeapBytes),
+			phase.Stage+"_alloc", formatByteSize(phase.AllocatedBytes))
+	}
+	slog.Info("Memory usage", attrs...)
+}
+
+// formatByteSize formats n bytes with a binary unit, e.g. 1.5GiB
+func formatByteSize(n uint64) string {
+	const units = "KMGTPE"
+	if n < 1<<10 {
+		return fmt.Sprintf("%dB", n)
+	}
+	value, unit := float64(n)/(1<<10), 0
+	for value >= 1<<10 && unit < len(units)-1 {
+		value /= 1 << 10
+		unit++
+	}
+	return fmt.Sprintf("%.1f%ciB", value, units[unit])
+}
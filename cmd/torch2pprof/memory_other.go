@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// peakRSS returns 0: the peak resident set size is only known on Unix
+func peakRSS() uint64 {
+	return 0
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olka/torch2pprof/pkg/converter"
+)
+
+// otlpBatchSize is the number of spans sent per export request, keeping
+// requests well below the body size limits of collectors
+const otlpBatchSize = 1000
+
+// OTLP/HTTP JSON encoding of an ExportTraceServiceRequest. IDs are hex
+// and 64-bit integers are strings, as in the protobuf JSON mapping.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue,omitempty"`
+	IntValue    string `json:"intValue,omitempty"`
+}
+
+// otlpSpanKindInternal is SPAN_KIND_INTERNAL
+const otlpSpanKindInternal = 1
+
+func spansCommand(args []string) {
+	fs := flag.NewFlagSet("spans", flag.ExitOnError)
+	lf := addLogFlags(fs)
+	endpoint := fs.String("endpoint", "", "OTLP/HTTP collector URL (default: $OTEL_EXPORTER_OTLP_ENDPOINT or http://localhost:4318)")
+	service := fs.String("service", "", "service.name of the spans (default: $OTEL_SERVICE_NAME or pytorch)")
+	profileURL := fs.String("profile-url", "", "URL or path of the converted profile, attached to the spans as pprof.profile.url")
+	ops := fs.Bool("ops", true, "Also export top-level CPU ops, not only ProfilerStep and user annotation spans")
+	baseTime := fs.String("base-time", "", "RFC 3339 time of trace timestamp 0 (default: the trace's baseTimeNanoseconds)")
+	output := fs.String("o", "", "Write the OTLP JSON request to this file instead of sending it ('-' for stdout)")
+	timeout := fs.Duration("timeout", 30*time.Second, "Timeout of each export request")
+	headers := make(map[string]string)
+	fs.Func("header", "Add key=value as an HTTP header of export requests, e.g. for authentication (repeatable)", func(v string) error {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("want key=value, got %q", v)
+		}
+		headers[key] = value
+		return nil
+	})
+	window := addWindowFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: torch2pprof spans [options] <input.json>\n")
+		fmt.Fprintf(os.Stderr, "\nExport ProfilerStep, user annotation and top-level op events as OpenTelemetry spans\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	inputs, err := parseInterspersed(fs, args)
+	if err != nil {
+		fatalf("parsing arguments: %v", err)
+	}
+	lf.setup(false)
+
+	if len(inputs) != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if *endpoint == "" {
+		*endpoint = cmp.Or(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "http://localhost:4318")
+	}
+	if *service == "" {
+		*service = cmp.Or(os.Getenv("OTEL_SERVICE_NAME"), "pytorch")
+	}
+	opts := converter.SpanOptions{Ops: *ops}
+	if *baseTime != "" {
+		opts.BaseTime, err = time.Parse(time.RFC3339Nano, *baseTime)
+		if err != nil {
+			fatalf("%v", withExitCode(exitUsage, fmt.Errorf("invalid -base-time: %w", err)))
+		}
+	}
+
+	traceData, err := loadTrace(inputs[0], converter.UsedArgs())
+	if err != nil {
+		fatalf("%v", err)
+	}
+	traceData, err = window.apply(traceData)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	spans := converter.TraceSpans(traceData, opts)
+	if len(spans) == 0 {
+		fatalf("%v", withExitCode(exitEmpty, fmt.Errorf("%s: no ProfilerStep, annotation or op events to export", inputs[0])))
+	}
+
+	resource := otlpResource{Attributes: otlpAttributes("service.name", *service, "pprof.profile.url", *profileURL)}
+	batches := otlpBatches(spans, filepath.Base(inputs[0]), resource)
+	if *output != "" {
+		w := os.Stdout
+		if *output != "-" {
+			if w, err = os.Create(*output); err != nil {
+				fatalf("%v", withExitCode(exitWrite, err))
+			}
+		}
+		enc := json.NewEncoder(w)
+		for _, batch := range batches {
+			if err := enc.Encode(batch); err != nil {
+				fatalf("%v", withExitCode(exitWrite, err))
+			}
+		}
+		if err := w.Close(); err != nil && *output != "-" {
+			fatalf("%v", withExitCode(exitWrite, err))
+		}
+		return
+	}
+
+	url := strings.TrimRight(*endpoint, "/") + "/v1/traces"
+	for _, batch := range batches {
+		exportCtx, cancel := context.WithTimeout(ctx, *timeout)
+		err := exportOTLP(exportCtx, url, headers, batch)
+		cancel()
+		if err != nil {
+			fatalf("%v", withExitCode(exitWrite, err))
+		}
+	}
+	slog.Info("Exported spans", "endpoint", redactURL(url), "spans", len(spans)+1, "trace_id", batches[0].ResourceSpans[0].ScopeSpans[0].Spans[0].TraceID)
+}
+
+// otlpBatches converts spans to export requests of one new trace, under a
+// root span named after the trace file and covering all spans
+func otlpBatches(spans []converter.Span, name string, resource otlpResource) []otlpRequest {
+	traceID := randomHex(16)
+	root := otlpSpan{
+		TraceID: traceID,
+		SpanID:  randomHex(8),
+		Name:    name,
+		Kind:    otlpSpanKindInternal,
+	}
+	start, end := spans[0].Start, spans[0].End
+	ids := make([]string, len(spans))
+	out := []otlpSpan{root}
+	for i, s := range spans {
+		start, end = minTime(start, s.Start), maxTime(end, s.End)
+		ids[i] = randomHex(8)
+		parent := root.SpanID
+		if s.Parent >= 0 {
+			parent = ids[s.Parent]
+		}
+		attrs := otlpAttributes("pytorch.category", s.Category, "pytorch.pid", s.Pid, "pytorch.tid", s.Tid, "pytorch.thread", s.Thread)
+		if s.Step >= 0 {
+			attrs = append(attrs, otlpAttribute{Key: "pytorch.step", Value: otlpValue{IntValue: strconv.Itoa(s.Step)}})
+		}
+		out = append(out, otlpSpan{
+			TraceID:           traceID,
+			SpanID:            ids[i],
+			ParentSpanID:      parent,
+			Name:              s.Name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        attrs,
+		})
+	}
+	out[0].StartTimeUnixNano = strconv.FormatInt(start.UnixNano(), 10)
+	out[0].EndTimeUnixNano = strconv.FormatInt(end.UnixNano(), 10)
+
+	var batches []otlpRequest
+	for len(out) > 0 {
+		n := min(len(out), otlpBatchSize)
+		batches = append(batches, otlpRequest{ResourceSpans: []otlpResourceSpans{{
+			Resource:   resource,
+			ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "torch2pprof"}, Spans: out[:n]}},
+		}}})
+		out = out[n:]
+	}
+	return batches
+}
+
+// otlpAttributes returns string attributes from key, value pairs, leaving
+// out empty values
+func otlpAttributes(kv ...string) []otlpAttribute {
+	var attrs []otlpAttribute
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] != "" {
+			attrs = append(attrs, otlpAttribute{Key: kv[i], Value: otlpValue{StringValue: kv[i+1]}})
+		}
+	}
+	return attrs
+}
+
+// exportOTLP posts one export request to the traces endpoint of a collector
+func exportOTLP(ctx context.Context, url string, headers map[string]string, request otlpRequest) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid -endpoint: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("exporting spans: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("exporting spans: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// randomHex returns n random bytes in hex, for trace and span IDs
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/olka/torch2pprof/pkg/converter"
+)
+
+func TestOTLPBatches(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	resource := otlpResource{Attributes: otlpAttributes("service.name", "train")}
+
+	// Sizes around the batch size, counting the root span
+	tests := []struct {
+		spans   int
+		batches []int
+	}{
+		{1, []int{2}},
+		{otlpBatchSize - 1, []int{otlpBatchSize}},
+		{otlpBatchSize, []int{otlpBatchSize, 1}},
+		{2*otlpBatchSize + 499, []int{otlpBatchSize, otlpBatchSize, 500}},
+	}
+	for _, tt := range tests {
+		// A parent span on one thread with all other spans nested in it
+		spans := make([]converter.Span, tt.spans)
+		for i := range spans {
+			spans[i] = converter.Span{
+				Name:   "op" + strconv.Itoa(i),
+				Pid:    "1",
+				Tid:    "2",
+				Step:   -1,
+				Start:  base.Add(time.Duration(i+1) * time.Microsecond),
+				End:    base.Add(time.Duration(i+2) * time.Microsecond),
+				Parent: 0,
+			}
+		}
+		spans[0].Parent, spans[0].Step = -1, 3
+		spans[0].Start, spans[0].End = base, base.Add(time.Duration(tt.spans+2)*time.Microsecond)
+
+		batches := otlpBatches(spans, "trace.json", resource)
+		var sizes []int
+		var all []otlpSpan
+		for _, b := range batches {
+			if len(b.ResourceSpans) != 1 || len(b.ResourceSpans[0].ScopeSpans) != 1 {
+				t.Fatalf("%d spans: expected one resource and scope per batch, got %+v", tt.spans, b)
+			}
+			if !reflect.DeepEqual(b.ResourceSpans[0].Resource, resource) {
+				t.Errorf("%d spans: expected resource %+v in every batch, got %+v", tt.spans, resource, b.ResourceSpans[0].Resource)
+			}
+			batch := b.ResourceSpans[0].ScopeSpans[0].Spans
+			sizes = append(sizes, len(batch))
+			all = append(all, batch...)
+		}
+		if !reflect.DeepEqual(sizes, tt.batches) {
+			t.Errorf("%d spans: expected batches of %v, got %v", tt.spans, tt.batches, sizes)
+			continue
+		}
+
+		root := all[0]
+		if root.Name != "trace.json" || root.ParentSpanID != "" || len(root.TraceID) != 32 {
+			t.Errorf("%d spans: unexpected root %+v", tt.spans, root)
+		}
+		if root.StartTimeUnixNano != strconv.FormatInt(spans[0].Start.UnixNano(), 10) || root.EndTimeUnixNano != strconv.FormatInt(spans[0].End.UnixNano(), 10) {
+			t.Errorf("%d spans: expected the root to cover all spans, got %s-%s", tt.spans, root.StartTimeUnixNano, root.EndTimeUnixNano)
+		}
+		ids := make(map[string]bool)
+		for i, s := range all {
+			if s.TraceID != root.TraceID || ids[s.SpanID] {
+				t.Fatalf("%d spans: span %d has trace %s and a repeated id %v", tt.spans, i, s.TraceID, ids[s.SpanID])
+			}
+			ids[s.SpanID] = true
+		}
+		if all[1].ParentSpanID != root.SpanID {
+			t.Errorf("%d spans: expected the top span under the root", tt.spans)
+		}
+		for _, s := range all[2:] {
+			if s.ParentSpanID != all[1].SpanID {
+				t.Fatalf("%d spans: expected %s under %s, got %s", tt.spans, s.Name, all[1].Name, s.ParentSpanID)
+			}
+		}
+		if step := all[1].Attributes[len(all[1].Attributes)-1]; step.Key != "pytorch.step" || step.Value.IntValue != "3" {
+			t.Errorf("%d spans: expected the step attribute, got %+v", tt.spans, all[1].Attributes)
+		}
+	}
+}
+
+func TestOTLPAttributes(t *testing.T) {
+	got := otlpAttributes("pytorch.category", "cpu_op", "pytorch.thread", "", "pytorch.pid", "1", "dangling")
+	want := []otlpAttribute{
+		{Key: "pytorch.category", Value: otlpValue{StringValue: "cpu_op"}},
+		{Key: "pytorch.pid", Value: otlpValue{StringValue: "1"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
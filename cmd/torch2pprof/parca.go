@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/olka/torch2pprof/pkg/profile"
+)
+
+// parcaWriteRaw is the gRPC method of Parca's profile store that ingests
+// pprof profiles
+const parcaWriteRaw = "/parca.profilestore.v1alpha1.ProfileStoreService/WriteRaw"
+
+// parcaLabelName matches the label names Parca accepts, as in Prometheus
+var parcaLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// parcaLabels returns the series labels of a profile: __name__ set to app
+// and the user labels, sorted by name
+func parcaLabels(app string, labels map[string]string) ([][2]string, error) {
+	if app == "" {
+		return nil, fmt.Errorf("invalid -app %q", app)
+	}
+	series := [][2]string{{"__name__", app}}
+	for name, value := range labels {
+		if !parcaLabelName.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("label %s: Parca label names must match %s and not start with __", name, parcaLabelName)
+		}
+		series = append(series, [2]string{name, value})
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i][0] < series[j][0] })
+	return series, nil
+}
+
+// pushParca sends p to the WriteRaw gRPC method of the Parca server. gRPC
+// runs over HTTP/2: with TLS for https:// servers and in cleartext (h2c) for
+// http:// ones.
+func pushParca(ctx context.Context, server string, labels [][2]string, token string, p *profile.Profile) error {
+	msg, err := parcaWriteRawRequest(p, labels)
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(strings.TrimRight(server, "/") + parcaWriteRaw)
+	if err != nil {
+		return fmt.Errorf("invalid -server: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid -server %q: want an http:// or https:// URL", server)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(grpcFrame(msg)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	protocols := new(http.Protocols)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("uploading profile: %w", err)
+	}
+	defer resp.Body.Close()
+	// Trailers are only available once the body was read
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return fmt.Errorf("uploading profile: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("uploading profile: %s", resp.Status)
+	}
+
+	// Errors without a response message come in the headers instead
+	status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if status != "0" {
+		if m, err := url.PathUnescape(message); err == nil {
+			message = m
+		}
+		return fmt.Errorf("uploading profile: gRPC status %s: %s", status, message)
+	}
+	return nil
+}
+
+// parcaWriteRawRequest encodes a WriteRawRequest protobuf message with one
+// series holding p gzipped:
+//
+//	WriteRawRequest { repeated RawProfileSeries series = 2; }
+//	RawProfileSeries { LabelSet labels = 1; repeated RawSample samples = 2; }
+//	LabelSet { repeated Label labels = 1; }
+//	Label { string name = 1; string value = 2; }
+//	RawSample { bytes raw_profile = 1; }
+func parcaWriteRawRequest(p *profile.Profile, labels [][2]string) ([]byte, error) {
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	if _, err := p.WriteTo(gz); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	var labelSet []byte
+	for _, l := range labels {
+		var label []byte
+		label = appendProtoBytes(label, 1, []byte(l[0]))
+		label = appendProtoBytes(label, 2, []byte(l[1]))
+		labelSet = appendProtoBytes(labelSet, 1, label)
+	}
+	var series []byte
+	series = appendProtoBytes(series, 1, labelSet)
+	series = appendProtoBytes(series, 2, appendProtoBytes(nil, 1, gzipped.Bytes()))
+	return appendProtoBytes(nil, 2, series), nil
+}
+
+// grpcFrame frames a gRPC message by an uncompressed flag and its length
+func grpcFrame(msg []byte) []byte {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+// appendProtoBytes appends a length-delimited protobuf field
+func appendProtoBytes(buf []byte, fieldNum int, data []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(fieldNum)<<3|2)
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/olka/torch2pprof/pkg/profile"
+)
+
+// protoField is a length-delimited field of a protobuf message
+type protoField struct {
+	num  int
+	data []byte
+}
+
+// readProtoBytes splits a message made only of length-delimited fields
+func readProtoBytes(t *testing.T, msg []byte) []protoField {
+	t.Helper()
+	var fields []protoField
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 || tag&7 != 2 {
+			t.Fatalf("Expected a length-delimited field, got tag %d", tag)
+		}
+		size, m := binary.Uvarint(msg[n:])
+		if m <= 0 || uint64(len(msg)-n-m) < size {
+			t.Fatalf("Truncated field %d", tag>>3)
+		}
+		fields = append(fields, protoField{int(tag >> 3), msg[n+m : n+m+int(size)]})
+		msg = msg[n+m+int(size):]
+	}
+	return fields
+}
+
+func TestGRPCFrame(t *testing.T) {
+	tests := []struct {
+		msg  []byte
+		want []byte
+	}{
+		{nil, []byte{0, 0, 0, 0, 0}},
+		{[]byte("abc"), []byte{0, 0, 0, 0, 3, 'a', 'b', 'c'}},
+		{make([]byte, 300), append([]byte{0, 0, 0, 1, 44}, make([]byte, 300)...)},
+	}
+	for _, tt := range tests {
+		if got := grpcFrame(tt.msg); !bytes.Equal(got, tt.want) {
+			t.Errorf("grpcFrame(%d bytes) = %v, want %v", len(tt.msg), got[:min(len(got), 8)], tt.want[:min(len(tt.want), 8)])
+		}
+	}
+}
+
+func TestParcaWriteRawRequest(t *testing.T) {
+	p := &profile.Profile{
+		SampleType:  []*profile.ValueType{{Type: 1, Unit: 2}},
+		Sample:      []*profile.Sample{{Value: []int64{42}}},
+		StringTable: []string{"", "time", "nanoseconds"},
+	}
+	labels := [][2]string{{"__name__", "train"}, {"rank", "0"}}
+	msg, err := parcaWriteRawRequest(p, labels)
+	if err != nil {
+		t.Fatalf("parcaWriteRawRequest: %v", err)
+	}
+
+	// WriteRawRequest.series
+	request := readProtoBytes(t, msg)
+	if len(request) != 1 || request[0].num != 2 {
+		t.Fatalf("Expected one series, got %+v", request)
+	}
+	series := readProtoBytes(t, request[0].data)
+	if len(series) != 2 || series[0].num != 1 || series[1].num != 2 {
+		t.Fatalf("Expected a label set and a sample, got %+v", series)
+	}
+
+	var got [][2]string
+	for _, label := range readProtoBytes(t, series[0].data) {
+		fields := readProtoBytes(t, label.data)
+		if label.num != 1 || len(fields) != 2 || fields[0].num != 1 || fields[1].num != 2 {
+			t.Fatalf("Unexpected label %+v", fields)
+		}
+		got = append(got, [2]string{string(fields[0].data), string(fields[1].data)})
+	}
+	if !reflect.DeepEqual(got, labels) {
+		t.Errorf("Expected labels %v, got %v", labels, got)
+	}
+
+	sample := readProtoBytes(t, series[1].data)
+	if len(sample) != 1 || sample[0].num != 1 {
+		t.Fatalf("Expected a raw profile, got %+v", sample)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(sample[0].data))
+	if err != nil {
+		t.Fatalf("Raw profile is not gzipped: %v", err)
+	}
+	raw, err := profile.Parse(gz)
+	if err != nil {
+		t.Fatalf("Parsing raw profile: %v", err)
+	}
+	if len(raw.Sample) != 1 || raw.Sample[0].Value[0] != 42 {
+		t.Errorf("Expected the sample of the profile, got %+v", raw.Sample)
+	}
+}
+
+func TestParcaLabels(t *testing.T) {
+	tests := []struct {
+		app    string
+		labels map[string]string
+		want   [][2]string
+		err    string
+	}{
+		{app: "train", want: [][2]string{{"__name__", "train"}}},
+		{app: "train", labels: map[string]string{"rank": "0", "job": "a b"}, want: [][2]string{{"__name__", "train"}, {"job", "a b"}, {"rank", "0"}}},
+		{app: "", err: "invalid -app"},
+		{app: "train", labels: map[string]string{"0rank": "0"}, err: "label 0rank"},
+		{app: "train", labels: map[string]string{"__name__": "x"}, err: "label __name__"},
+	}
+	for _, tt := range tests {
+		got, err := parcaLabels(tt.app, tt.labels)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parcaLabels(%q, %v): expected error containing %q, got %v", tt.app, tt.labels, tt.err, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parcaLabels(%q, %v) = %v, %v, want %v", tt.app, tt.labels, got, err, tt.want)
+		}
+	}
+}
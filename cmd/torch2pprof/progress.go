@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olka/torch2pprof/pkg/converter"
+)
+
+// showProgress enables progress bars; set from the log flags
+var showProgress bool
+
+// progressBarWidth is the width of the bar in characters
+const progressBarWidth = 30
+
+// progressBar draws a single-line progress bar with an ETA on stderr.
+// A nil *progressBar is valid and draws nothing.
+type progressBar struct {
+	label string
+	start time.Time
+	last  time.Time
+	mu    sync.Mutex
+}
+
+// newProgressBar returns a progress bar for a stage, or nil if progress
+// bars are disabled
+func newProgressBar(label string) *progressBar {
+	if !showProgress {
+		return nil
+	}
+	return &progressBar{label: label, start: time.Now()}
+}
+
+// update redraws the bar for done out of total, with detail describing the
+// progress in domain terms (e.g. an event count). Redraws are throttled.
+func (b *progressBar) update(done, total int64, detail string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(b.last) < 100*time.Millisecond && done < total {
+		return
+	}
+	b.last = now
+
+	fraction := 0.0
+	if total > 0 {
+		fraction = min(float64(done)/float64(total), 1)
+	}
+	filled := int(fraction * progressBarWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+
+	eta := "--"
+	if elapsed := now.Sub(b.start); fraction > 0 && fraction < 1 {
+		eta = (time.Duration(float64(elapsed) * (1 - fraction) / fraction)).Round(time.Second).String()
+	} else if fraction >= 1 {
+		eta = "0s"
+	}
+	fmt.Fprintf(os.Stderr, "\r\x1b[K%-11s [%s] %3.0f%% %s ETA %s", b.label, bar, fraction*100, detail, eta)
+}
+
+// finish clears the bar so log lines continue on a clean line
+func (b *progressBar) finish() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	fmt.Fprint(os.Stderr, "\r\x1b[K")
+}
+
+// stageBars draws a progress bar for each stage reported to a
+// converter.ProgressFunc, replacing the bar when the stage changes
+type stageBars struct {
+	stage string
+	bar   *progressBar
+	mu    sync.Mutex
+}
+
+// stageLabels are the bar labels of the converter stages
+var stageLabels = map[string]string{
+	converter.StageParse:     "Parsing",
+	converter.StageBuild:     "Converting",
+	converter.StageAggregate: "Aggregating",
+}
+
+// update is a converter.ProgressFunc
+func (s *stageBars) update(stage string, done, total int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stage != s.stage {
+		s.bar.finish()
+		s.stage = stage
+		s.bar = newProgressBar(stageLabels[stage])
+	}
+
+	var detail string
+	switch stage {
+	case converter.StageParse:
+		detail = fmt.Sprintf("%.1f/%.1f MB", float64(done)/1e6, float64(total)/1e6)
+	case converter.StageAggregate:
+		detail = fmt.Sprintf("%d/%d samples", done, total)
+	default:
+		detail = fmt.Sprintf("%d/%d events", done, total)
+	}
+	s.bar.update(done, total, detail)
+}
+
+// finish clears the bar of the current stage
+func (s *stageBars) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bar.finish()
+}
+
+// isTerminal reports whether f is a character device such as a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
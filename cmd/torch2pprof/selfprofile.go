@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+// stopSelfProfiling finishes the profiles started by startSelfProfiling.
+// main calls it after the command returns and exit before exiting.
+var stopSelfProfiling = func() {}
+
+// exit stops self-profiling, so the profiles are complete, and exits
+func exit(code int) {
+	stopSelfProfiling()
+	os.Exit(code)
+}
+
+// startSelfProfiling starts the -cpuprofile and -trace recordings of
+// torch2pprof itself; the -memprofile heap profile is written when they
+// stop
+func (lf *logFlags) startSelfProfiling() error {
+	var stops []func()
+	stopSelfProfiling = func() {
+		// Run once, most recently started first
+		stopSelfProfiling = func() {}
+		for i := len(stops) - 1; i >= 0; i-- {
+			stops[i]()
+		}
+	}
+
+	if lf.cpuProfile != "" {
+		f, err := os.Create(lf.cpuProfile)
+		if err != nil {
+			return fmt.Errorf("-cpuprofile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("-cpuprofile: %w", err)
+		}
+		stops = append(stops, func() {
+			pprof.StopCPUProfile()
+			closeSelfProfile(f)
+		})
+	}
+	if lf.traceFile != "" {
+		f, err := os.Create(lf.traceFile)
+		if err != nil {
+			return fmt.Errorf("-trace: %w", err)
+		}
+		if err := trace.Start(f); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("-trace: %w", err)
+		}
+		stops = append(stops, func() {
+			trace.Stop()
+			closeSelfProfile(f)
+		})
+	}
+	if lf.memProfile != "" {
+		path := lf.memProfile
+		stops = append(stops, func() {
+			f, err := os.Create(path)
+			if err != nil {
+				slog.Error("Writing -memprofile failed", "error", err)
+				return
+			}
+			// Collect garbage so the in-use numbers are up to date
+			runtime.GC()
+			if err := pprof.Lookup("allocs").WriteTo(f, 0); err != nil {
+				slog.Error("Writing -memprofile failed", "error", err)
+			}
+			closeSelfProfile(f)
+		})
+	}
+	return nil
+}
+
+// closeSelfProfile closes a self-profiling output, logging where it went
+func closeSelfProfile(f *os.File) {
+	if err := f.Close(); err != nil {
+		slog.Error("Writing self-profile failed", "path", f.Name(), "error", err)
+		return
+	}
+	slog.Debug("Wrote self-profile", "path", f.Name())
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// table is a report table printed as aligned text, CSV or Markdown. The first
+// column is left-aligned and the others right-aligned.
+type table struct {
+	title   string
+	columns []column
+	rows    [][]string
+
+	// empty, if set, is printed instead of the header of a table without
+	// rows; CSV output keeps the header
+	empty string
+}
+
+// column is a table column; width is only used for text output
+type column struct {
+	name  string
+	width int
+}
+
+func (t *table) addRow(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+// write prints the table in format: text, csv or markdown
+func (t *table) write(w io.Writer, format string) error {
+	switch format {
+	case "csv":
+		return t.writeCSV(w)
+	case "markdown":
+		return t.writeMarkdown(w)
+	default:
+		return t.writeText(w)
+	}
+}
+
+func (t *table) writeText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s:\n", t.title)
+	if len(t.rows) == 0 && t.empty != "" {
+		fmt.Fprintf(&b, "%s\n", t.empty)
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+	header := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		header[i] = c.name
+	}
+	t.writeTextRow(&b, header)
+	width := len(t.columns) - 1
+	for _, c := range t.columns {
+		width += c.width
+	}
+	fmt.Fprintf(&b, "%s\n", strings.Repeat("-", width))
+	for _, row := range t.rows {
+		t.writeTextRow(&b, row)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (t *table) writeTextRow(b *strings.Builder, cells []string) {
+	for i, c := range t.columns {
+		if i > 0 {
+			b.WriteByte(' ')
+			fmt.Fprintf(b, "%*s", c.width, cells[i])
+			continue
+		}
+		cell := cells[i]
+		if len(cell) > c.width-2 {
+			cell = cell[:c.width-5] + "..."
+		}
+		fmt.Fprintf(b, "%-*s", c.width, cell)
+	}
+	b.WriteByte('\n')
+}
+
+func (t *table) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	header := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		header[i] = c.name
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(t.rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+func (t *table) writeMarkdown(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "### %s\n\n", t.title)
+	if len(t.rows) == 0 && t.empty != "" {
+		fmt.Fprintf(&b, "%s\n", markdownEscape(t.empty))
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+	b.WriteString("|")
+	for _, c := range t.columns {
+		fmt.Fprintf(&b, " %s |", markdownEscape(c.name))
+	}
+	b.WriteString("\n|")
+	for i := range t.columns {
+		if i == 0 {
+			b.WriteString(" --- |")
+		} else {
+			b.WriteString(" ---: |")
+		}
+	}
+	b.WriteByte('\n')
+	for _, row := range t.rows {
+		b.WriteString("|")
+		for _, cell := range row {
+			fmt.Fprintf(&b, " %s |", markdownEscape(cell))
+		}
+		b.WriteByte('\n')
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// markdownEscape escapes characters that would break a table cell
+func markdownEscape(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+
+	"github.com/olka/torch2pprof/pkg/converter"
+)
+
+// timelineNameWidth is the width of the lane name column in text timelines
+const timelineNameWidth = 56
+
+// timelineShades are the characters for busy fractions of 0, <25%, <50%,
+// <75% and above
+const timelineShades = " .:+#"
+
+// writeTimelineText prints one row of shade characters per lane, followed
+// by the lane's overall utilization
+func writeTimelineText(w io.Writer, tl *converter.Timeline) error {
+	var b strings.Builder
+	spanMs := (tl.EndUs - tl.StartUs) / 1000
+	buckets := len(tl.Lanes[0].Utilization)
+	fmt.Fprintf(&b, "Timeline: %.3f ms in %d buckets of %.3f us ('%c' idle, '%c' <25%%, '%c' <50%%, '%c' <75%%, '%c' busy)\n",
+		spanMs, buckets, tl.BucketUs,
+		timelineShades[0], timelineShades[1], timelineShades[2], timelineShades[3], timelineShades[4])
+	axis := fmt.Sprintf("%.3f ms", spanMs)
+	fmt.Fprintf(&b, "%-*s |0 ms%*s|\n", timelineNameWidth, "", max(buckets-4, len(axis)), axis)
+	for _, lane := range tl.Lanes {
+		name := lane.Name
+		if len(name) > timelineNameWidth {
+			name = name[:timelineNameWidth-3] + "..."
+		}
+		fmt.Fprintf(&b, "%-*s |", timelineNameWidth, name)
+		for _, u := range lane.Utilization {
+			b.WriteByte(timelineShade(u))
+		}
+		fmt.Fprintf(&b, "| %5.1f%%\n", 100*laneUtilization(tl, lane))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// timelineShade maps a busy fraction to a character of timelineShades
+func timelineShade(u float64) byte {
+	switch {
+	case u <= 0:
+		return timelineShades[0]
+	case u < 0.25:
+		return timelineShades[1]
+	case u < 0.5:
+		return timelineShades[2]
+	case u < 0.75:
+		return timelineShades[3]
+	default:
+		return timelineShades[4]
+	}
+}
+
+// laneUtilization is the busy fraction of a lane over the whole timeline
+func laneUtilization(tl *converter.Timeline, lane converter.TimelineLane) float64 {
+	spanNs := (tl.EndUs - tl.StartUs) * 1000
+	if spanNs <= 0 {
+		return 0
+	}
+	return float64(lane.BusyNs) / spanNs
+}
+
+var timelineHTML = template.Must(template.New("timeline").Funcs(template.FuncMap{
+	"pct": func(u float64) string { return fmt.Sprintf("%.0f%%", 100*u) },
+	"alpha": func(u float64) template.CSS {
+		return template.CSS(fmt.Sprintf("%.2f", u))
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; }
+table { border-collapse: collapse; }
+th { text-align: left; font-weight: normal; padding-right: 8px; white-space: nowrap; }
+td.b { width: {{.CellWidth}}px; height: 18px; padding: 0; }
+td.u { padding-left: 8px; text-align: right; }
+tr.cpu td.b { background: rgba(52, 120, 198, var(--u)); }
+tr.gpu td.b { background: rgba(118, 185, 0, var(--u)); }
+</style>
+</head>
+<body>
+<h3>{{.Title}}</h3>
+<p>{{printf "%.3f" .SpanMs}} ms in {{len .Buckets}} buckets of {{printf "%.3f" .Timeline.BucketUs}} us; darker is busier.</p>
+<table>
+{{range .Lanes}}<tr class="{{if .GPU}}gpu{{else}}cpu{{end}}"><th>{{.Name}}</th>
+{{- range $i, $u := .Utilization}}<td class="b" style="--u: {{alpha $u}}" title="{{index $.Buckets $i}}: {{pct $u}}"></td>{{end -}}
+<td class="u">{{pct .Total}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// writeTimelineHTML writes a standalone page with one row of shaded cells
+// per lane
+func writeTimelineHTML(w io.Writer, tl *converter.Timeline, title string) error {
+	type lane struct {
+		converter.TimelineLane
+		Total float64
+	}
+	data := struct {
+		Title     string
+		Timeline  *converter.Timeline
+		SpanMs    float64
+		CellWidth int
+		Buckets   []string
+		Lanes     []lane
+	}{
+		Title:    title,
+		Timeline: tl,
+		SpanMs:   (tl.EndUs - tl.StartUs) / 1000,
+	}
+	n := len(tl.Lanes[0].Utilization)
+	data.CellWidth = max(1, 1000/n)
+	for i := range n {
+		data.Buckets = append(data.Buckets, fmt.Sprintf("%.3f ms", float64(i)*tl.BucketUs/1000))
+	}
+	for _, l := range tl.Lanes {
+		data.Lanes = append(data.Lanes, lane{TimelineLane: l, Total: laneUtilization(tl, l)})
+	}
+	return timelineHTML.Execute(w, data)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/olka/torch2pprof/pkg/profile"
+)
+
+func TestPyroscopeName(t *testing.T) {
+	tests := []struct {
+		app    string
+		labels map[string]string
+		want   string
+		err    string
+	}{
+		{app: "train", want: "train"},
+		{app: "train", labels: map[string]string{"rank": "0"}, want: "train{rank=0}"},
+		{app: "train", labels: map[string]string{"rank": "0", "job": "a b"}, want: "train{job=a b,rank=0}"},
+		{app: "", err: "invalid -app"},
+		{app: "train{x}", err: "invalid -app"},
+		{app: "train", labels: map[string]string{"a,b": "0"}, err: "label a,b=0"},
+		{app: "train", labels: map[string]string{"rank": "a=b"}, err: "label rank=a=b"},
+	}
+	for _, tt := range tests {
+		got, err := pyroscopeName(tt.app, tt.labels)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("pyroscopeName(%q, %v): expected error containing %q, got %v", tt.app, tt.labels, tt.err, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("pyroscopeName(%q, %v) = %q, %v, want %q", tt.app, tt.labels, got, err, tt.want)
+		}
+	}
+}
+
+func TestPyroscopeForm(t *testing.T) {
+	p := &profile.Profile{
+		SampleType:  []*profile.ValueType{{Type: 1, Unit: 2}, {Type: 3, Unit: 4}},
+		Sample:      []*profile.Sample{{Value: []int64{1, 42}}},
+		StringTable: []string{"", "samples", "count", "time", "nanoseconds"},
+	}
+	body, contentType, err := pyroscopeForm(p)
+	if err != nil {
+		t.Fatalf("pyroscopeForm: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" {
+		t.Fatalf("Expected a multipart form, got %q, %v", contentType, err)
+	}
+
+	parts := make(map[string][]byte)
+	mr := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Reading form: %v", err)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("Reading %s: %v", part.FormName(), err)
+		}
+		parts[part.FormName()] = data
+	}
+	if len(parts) != 2 {
+		t.Fatalf("Expected a profile and a sample type config, got %d parts", len(parts))
+	}
+
+	gz, err := gzip.NewReader(strings.NewReader(string(parts["profile"])))
+	if err != nil {
+		t.Fatalf("Profile is not gzipped: %v", err)
+	}
+	got, err := profile.Parse(gz)
+	if err != nil || len(got.Sample) != 1 || got.Sample[0].Value[1] != 42 {
+		t.Errorf("Expected the sample of the profile, got %+v, %v", got, err)
+	}
+
+	var config map[string]pyroscopeSampleType
+	if err := json.Unmarshal(parts["sample_type_config"], &config); err != nil {
+		t.Fatalf("Parsing sample_type_config: %v", err)
+	}
+	want := map[string]pyroscopeSampleType{
+		"samples": {Units: "count", Aggregation: "sum", DisplayName: "samples"},
+		"time":    {Units: "nanoseconds", Aggregation: "sum", DisplayName: "time"},
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("Expected sample type config %+v, got %+v", want, config)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// fileState identifies a version of a file for change detection
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// watcher converts traces appearing in a directory. A trace is converted
+// once its size and modification time are unchanged between two polls, so
+// files still being written by the profiler are not picked up.
+type watcher struct {
+	dir    string
+	outDir string
+	cf     *convertFlags
+
+	pending map[string]fileState // Last state seen of unconverted traces
+	handled map[string]fileState // State converted, or that failed to convert
+}
+
+func watchCommand(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	lf := addLogFlags(fs)
+	interval := fs.Duration("interval", 2*time.Second, "How often to scan the directory for new traces")
+	cf := addConvertFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: torch2pprof watch [options] <dir> <outdir>\n")
+		fmt.Fprintf(os.Stderr, "\nConvert traces appearing in dir (e.g. a tensorboard_trace_handler directory) into outdir\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	dirs, err := parseInterspersed(fs, args)
+	if err != nil {
+		fatalf("parsing arguments: %v", err)
+	}
+	lf.setup(true)
+
+	if len(dirs) != 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if *interval <= 0 {
+		fatalf("-interval must be positive")
+	}
+	if err := os.MkdirAll(dirs[1], 0755); err != nil {
+		fatalf("%v", err)
+	}
+
+	w := &watcher{
+		dir:     dirs[0],
+		outDir:  dirs[1],
+		cf:      cf,
+		pending: make(map[string]fileState),
+		handled: make(map[string]fileState),
+	}
+	slog.Info("Watching for traces", "dir", w.dir, "outdir", w.outDir)
+	for {
+		if err := w.poll(); err != nil {
+			fatalf("%v", err)
+		}
+		select {
+		case <-ctx.Done():
+			slog.Info("Stopped watching", "dir", w.dir)
+			return
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// poll scans the directory once and converts traces that stopped changing
+func (w *watcher) poll() error {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, e := range entries {
+		if e.IsDir() || !isTraceFileName(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue // Removed since the scan
+		}
+		path := filepath.Join(w.dir, e.Name())
+		state := fileState{size: info.Size(), modTime: info.ModTime()}
+		if w.handled[path] == state {
+			continue
+		}
+		if prev, ok := w.pending[path]; !ok || prev != state {
+			w.pending[path] = state
+			continue
+		}
+
+		delete(w.pending, path)
+		w.handled[path] = state
+		output := filepath.Join(w.outDir, traceBaseName(e.Name())+".pb.gz")
+		if out, err := os.Stat(output); err == nil && !out.ModTime().Before(state.modTime) {
+			continue // Converted by an earlier run
+		}
+		w.convert(path, output)
+	}
+	return nil
+}
+
+func (w *watcher) convert(input, output string) {
+	start := time.Now()
+	p, err := w.cf.convertFile(input)
+	if err == nil {
+		err = writeProfile(output, p)
+	}
+	if err != nil {
+		slog.Error("Conversion failed", "input", input, "err", err)
+		return
+	}
+	slog.Info("Converted", "input", input, "output", output, "samples", len(p.Sample),
+		"elapsed", time.Since(start).Round(time.Millisecond))
+}
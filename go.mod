@@ -1,4 +1,4 @@
-module pytorch-to-pprof
+module github.com/olka/torch2pprof
 
 go 1.24.0
 
@@ -0,0 +1,338 @@
+package tui
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/olka/torch2pprof/pkg/profile"
+)
+
+// TableRow is one operation or category of the analyzer table
+type TableRow struct {
+	Name     string
+	Category string
+	Count    int
+	TimeNs   int64
+}
+
+// sortKey selects the analyzer table column rows are sorted by
+type sortKey int
+
+const (
+	sortByTime sortKey = iota
+	sortByCount
+	sortByAverage
+	sortByName
+	numSortKeys
+)
+
+var sortKeyNames = [...]string{"time", "count", "avg", "name"}
+
+// analyzeMode is the table or drill-down shown by the analyzer
+type analyzeMode int
+
+const (
+	modeOperations analyzeMode = iota
+	modeCategories
+	modeStacks
+)
+
+// AnalyzeView is a keyboard-driven table of operations and categories with
+// sorting, filtering, drill-down into call stacks and CSV export
+type AnalyzeView struct {
+	operations []TableRow
+	categories []TableRow
+	stacks     func(operation string) []profile.Stack
+
+	mode       analyzeMode
+	category   string // Category the operations are restricted to
+	operation  string // Operation whose stacks are shown
+	stackRows  []profile.Stack
+	sortBy     sortKey
+	reverse    bool
+	filter     string
+	filtering  bool
+	cursor     int
+	offset     int
+	width      int
+	height     int
+	status     string
+	exportFile func(name string) (io.WriteCloser, error)
+}
+
+// NewAnalyzeView returns an analyzer over operations and categories. stacks
+// returns the call stacks ending in an operation and is called lazily when
+// drilling down, as building them can be slow for large traces.
+func NewAnalyzeView(operations, categories []TableRow, stacks func(operation string) []profile.Stack) *AnalyzeView {
+	return &AnalyzeView{
+		operations: operations,
+		categories: categories,
+		stacks:     stacks,
+		width:      80,
+		height:     24,
+		exportFile: func(name string) (io.WriteCloser, error) { return os.Create(name) },
+	}
+}
+
+// SetSize sets the terminal size in characters
+func (v *AnalyzeView) SetSize(width, height int) {
+	v.width, v.height = max(width, 40), max(height, 5)
+}
+
+// rows returns the filtered and sorted rows of the current table
+func (v *AnalyzeView) rows() []TableRow {
+	source := v.operations
+	if v.mode == modeCategories {
+		source = v.categories
+	}
+	filter := strings.ToLower(v.filter)
+	var rows []TableRow
+	for _, r := range source {
+		if v.mode == modeOperations && v.category != "" && r.Category != v.category {
+			continue
+		}
+		if filter != "" && !strings.Contains(strings.ToLower(r.Name), filter) {
+			continue
+		}
+		rows = append(rows, r)
+	}
+
+	less := func(a, b TableRow) bool {
+		switch v.sortBy {
+		case sortByCount:
+			return a.Count > b.Count
+		case sortByAverage:
+			return average(a) > average(b)
+		case sortByName:
+			return a.Name < b.Name
+		}
+		return a.TimeNs > b.TimeNs
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if v.reverse {
+			return less(rows[j], rows[i])
+		}
+		return less(rows[i], rows[j])
+	})
+	return rows
+}
+
+func average(r TableRow) int64 {
+	if r.Count == 0 {
+		return 0
+	}
+	return r.TimeNs / int64(r.Count)
+}
+
+// length returns the number of rows in the current mode
+func (v *AnalyzeView) length() int {
+	if v.mode == modeStacks {
+		return len(v.stackRows)
+	}
+	return len(v.rows())
+}
+
+// HandleKey applies a key press and reports whether the view should close
+func (v *AnalyzeView) HandleKey(key string) bool {
+	if v.filtering {
+		switch key {
+		case "enter", "esc":
+			v.filtering = false
+		case "backspace":
+			if r := []rune(v.filter); len(r) > 0 {
+				v.filter = string(r[:len(r)-1])
+			}
+		default:
+			if len([]rune(key)) == 1 {
+				v.filter += key
+			}
+		}
+		v.cursor = 0
+		return false
+	}
+
+	v.status = ""
+	switch key {
+	case "q":
+		return true
+	case "up", "k":
+		v.cursor--
+	case "down", "j":
+		v.cursor++
+	case "pgup":
+		v.cursor -= v.pageSize()
+	case "pgdown", " ":
+		v.cursor += v.pageSize()
+	case "g":
+		v.cursor = 0
+	case "G":
+		v.cursor = v.length() - 1
+	case "\t":
+		if v.mode == modeOperations {
+			v.mode = modeCategories
+		} else {
+			v.mode = modeOperations
+		}
+		v.category, v.filter, v.cursor = "", "", 0
+	case "s":
+		v.sortBy = (v.sortBy + 1) % numSortKeys
+	case "r":
+		v.reverse = !v.reverse
+	case "/":
+		if v.mode != modeStacks {
+			v.filtering = true
+		}
+	case "enter", "right", "l":
+		v.drillDown()
+	case "esc", "left", "h", "backspace":
+		v.back()
+	case "x":
+		v.export()
+	}
+	v.cursor = min(max(v.cursor, 0), max(v.length()-1, 0))
+	return false
+}
+
+func (v *AnalyzeView) drillDown() {
+	rows := v.rows()
+	if v.mode == modeStacks || len(rows) == 0 {
+		return
+	}
+	selected := rows[min(v.cursor, len(rows)-1)]
+	if v.mode == modeCategories {
+		v.mode, v.category, v.filter, v.cursor = modeOperations, selected.Name, "", 0
+		return
+	}
+	v.mode, v.operation, v.cursor = modeStacks, selected.Name, 0
+	v.stackRows = v.stacks(selected.Name)
+}
+
+func (v *AnalyzeView) back() {
+	switch {
+	case v.mode == modeStacks:
+		v.mode, v.cursor = modeOperations, 0
+	case v.mode == modeOperations && v.category != "":
+		v.mode, v.category, v.cursor = modeCategories, "", 0
+	case v.filter != "":
+		v.filter = ""
+	}
+}
+
+// export writes the current table to a CSV file in the working directory
+func (v *AnalyzeView) export() {
+	name := "operations.csv"
+	records := [][]string{{"name", "category", "count", "time_ns", "avg_ns"}}
+	switch v.mode {
+	case modeCategories:
+		name = "categories.csv"
+		records[0] = []string{"name", "count", "time_ns", "avg_ns"}
+	case modeStacks:
+		name = "stacks.csv"
+		records[0] = []string{"stack", "time_ns"}
+	}
+
+	if v.mode == modeStacks {
+		for _, st := range v.stackRows {
+			records = append(records, []string{strings.Join(st.Frames, ";"), strconv.FormatInt(st.Value, 10)})
+		}
+	} else {
+		for _, r := range v.rows() {
+			record := []string{r.Name, r.Category, strconv.Itoa(r.Count), strconv.FormatInt(r.TimeNs, 10), strconv.FormatInt(average(r), 10)}
+			if v.mode == modeCategories {
+				record = append(record[:1], record[2:]...)
+			}
+			records = append(records, record)
+		}
+	}
+
+	f, err := v.exportFile(name)
+	if err != nil {
+		v.status = "Export failed: " + err.Error()
+		return
+	}
+	w := csv.NewWriter(f)
+	err = w.WriteAll(records)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		v.status = "Export failed: " + err.Error()
+		return
+	}
+	v.status = fmt.Sprintf("Exported %d rows to %s", len(records)-1, name)
+}
+
+func (v *AnalyzeView) pageSize() int {
+	return max(v.height-3, 1)
+}
+
+// Render draws the view, using \r\n line endings for raw-mode terminals
+func (v *AnalyzeView) Render(w io.Writer) error {
+	var title, header string
+	var lines []string
+	nameWidth := max(v.width-42, 10)
+	switch v.mode {
+	case modeStacks:
+		title = "Stacks ending in " + v.operation
+		header = fmt.Sprintf("%12s  %s", "Time (ms)", "Stack")
+		for _, st := range v.stackRows {
+			lines = append(lines, fmt.Sprintf("%12.3f  %s", float64(st.Value)/1e6, strings.Join(st.Frames, " > ")))
+		}
+	default:
+		title = "Operations"
+		if v.mode == modeCategories {
+			title = "Categories"
+		} else if v.category != "" {
+			title = "Operations in " + v.category
+		}
+		title += fmt.Sprintf(" - sorted by %s", sortKeyNames[v.sortBy])
+		if v.reverse {
+			title += " (reversed)"
+		}
+		if v.filter != "" {
+			title += fmt.Sprintf(" - filter %q", v.filter)
+		}
+		header = fmt.Sprintf("%12s %10s %12s  %s", "Time (ms)", "Count", "Avg (us)", "Name")
+		for _, r := range v.rows() {
+			lines = append(lines, fmt.Sprintf("%12.3f %10d %12.3f  %s", float64(r.TimeNs)/1e6, r.Count,
+				float64(average(r))/1e3, truncate(r.Name, nameWidth)))
+		}
+	}
+
+	page := v.pageSize()
+	if v.cursor < v.offset {
+		v.offset = v.cursor
+	} else if v.cursor >= v.offset+page {
+		v.offset = v.cursor - page + 1
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&b, "\x1b[1m%s\x1b[0m\r\n%s\r\n", truncate(title, v.width), truncate(header, v.width))
+	for i := v.offset; i < v.offset+page; i++ {
+		if i < len(lines) {
+			line := truncate(lines[i], v.width)
+			if i == v.cursor {
+				line = "\x1b[7m" + line + "\x1b[0m"
+			}
+			b.WriteString(line)
+		}
+		b.WriteString("\r\n")
+	}
+
+	switch {
+	case v.filtering:
+		b.WriteString(truncate("/"+v.filter, v.width))
+	case v.status != "":
+		b.WriteString(truncate(v.status, v.width))
+	default:
+		b.WriteString(truncate("↑↓ move  tab ops/categories  s sort  r reverse  / filter  enter drill down  ← back  x export CSV  q quit", v.width))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,91 @@
+package tui
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/olka/torch2pprof/pkg/profile"
+)
+
+type nopCloser struct{ *bytes.Buffer }
+
+func (nopCloser) Close() error { return nil }
+
+func testAnalyzeView() *AnalyzeView {
+	operations := []TableRow{
+		{Name: "aten::mm", Category: "cpu_op", Count: 2, TimeNs: 300},
+		{Name: "aten::relu", Category: "cpu_op", Count: 10, TimeNs: 100},
+		{Name: "gemm_kernel", Category: "kernel", Count: 1, TimeNs: 500},
+	}
+	categories := []TableRow{
+		{Name: "kernel", Count: 1, TimeNs: 500},
+		{Name: "cpu_op", Count: 12, TimeNs: 400},
+	}
+	stacks := func(op string) []profile.Stack {
+		return []profile.Stack{{Frames: []string{"forward", op}, Value: 42}}
+	}
+	return NewAnalyzeView(operations, categories, stacks)
+}
+
+func rowNames(rows []TableRow) string {
+	var names []string
+	for _, r := range rows {
+		names = append(names, r.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestAnalyzeViewSortAndFilter(t *testing.T) {
+	v := testAnalyzeView()
+	if got := rowNames(v.rows()); got != "gemm_kernel,aten::mm,aten::relu" {
+		t.Errorf("Expected rows by time, got %s", got)
+	}
+	v.HandleKey("s")
+	if got := rowNames(v.rows()); got != "aten::relu,aten::mm,gemm_kernel" {
+		t.Errorf("Expected rows by count, got %s", got)
+	}
+	v.HandleKey("r")
+	if got := rowNames(v.rows()); got != "gemm_kernel,aten::mm,aten::relu" {
+		t.Errorf("Expected reversed rows by count, got %s", got)
+	}
+
+	for _, key := range []string{"/", "A", "T", "E", "N", "enter"} {
+		v.HandleKey(key)
+	}
+	if got := rowNames(v.rows()); got != "aten::mm,aten::relu" {
+		t.Errorf("Expected filtered rows, got %s", got)
+	}
+}
+
+func TestAnalyzeViewDrillDown(t *testing.T) {
+	v := testAnalyzeView()
+	v.HandleKey("\t")
+	v.HandleKey("down")
+	v.HandleKey("enter") // cpu_op category
+	if got := rowNames(v.rows()); got != "aten::mm,aten::relu" {
+		t.Fatalf("Expected cpu_op operations, got %s", got)
+	}
+
+	v.HandleKey("enter") // aten::mm stacks
+	if v.mode != modeStacks || len(v.stackRows) != 1 || v.stackRows[0].Frames[1] != "aten::mm" {
+		t.Fatalf("Expected stacks of aten::mm, got %+v", v.stackRows)
+	}
+
+	var out bytes.Buffer
+	v.exportFile = func(name string) (io.WriteCloser, error) { return nopCloser{&out}, nil }
+	v.HandleKey("x")
+	if out.String() != "stack,time_ns\nforward;aten::mm,42\n" {
+		t.Errorf("Unexpected export: %q", out.String())
+	}
+
+	v.HandleKey("left")
+	v.HandleKey("left")
+	if v.mode != modeCategories {
+		t.Errorf("Expected to be back at categories, got mode %d", v.mode)
+	}
+	if !v.HandleKey("q") {
+		t.Error("Expected q to close the view")
+	}
+}
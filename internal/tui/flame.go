@@ -0,0 +1,299 @@
+// Package tui implements interactive terminal views of profiles, for remote
+// GPU machines without a browser.
+package tui
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/olka/torch2pprof/pkg/profile"
+)
+
+// barWidth is the width of the bar showing each frame's share of the total
+const barWidth = 20
+
+// FlameView is a navigable flame graph rendered as an expandable call tree:
+// each row is a frame with a bar proportional to its total value
+type FlameView struct {
+	root     *profile.Node
+	unit     string
+	parent   map[*profile.Node]*profile.Node
+	expanded map[*profile.Node]bool
+
+	cursor int
+	offset int
+	width  int
+	height int
+
+	searching bool
+	input     string
+	search    *regexp.Regexp
+	status    string
+}
+
+// row is a visible frame and its depth in the tree
+type row struct {
+	node  *profile.Node
+	depth int
+}
+
+// NewFlameView returns a view of root with values in unit. Only the root is
+// expanded initially.
+func NewFlameView(root *profile.Node, unit string) *FlameView {
+	v := &FlameView{
+		root:     root,
+		unit:     unit,
+		parent:   make(map[*profile.Node]*profile.Node),
+		expanded: map[*profile.Node]bool{root: true},
+		width:    80,
+		height:   24,
+	}
+	var index func(n *profile.Node)
+	index = func(n *profile.Node) {
+		for _, c := range n.Children {
+			v.parent[c] = n
+			index(c)
+		}
+	}
+	index(root)
+	return v
+}
+
+// SetSize sets the terminal size in characters
+func (v *FlameView) SetSize(width, height int) {
+	v.width, v.height = max(width, 20), max(height, 3)
+}
+
+// rows returns the visible frames in display order
+func (v *FlameView) rows() []row {
+	var rows []row
+	var walk func(n *profile.Node, depth int)
+	walk = func(n *profile.Node, depth int) {
+		rows = append(rows, row{node: n, depth: depth})
+		if v.expanded[n] {
+			for _, c := range n.Children {
+				walk(c, depth+1)
+			}
+		}
+	}
+	walk(v.root, 0)
+	return rows
+}
+
+// selected returns the frame under the cursor
+func (v *FlameView) selected() *profile.Node {
+	rows := v.rows()
+	v.cursor = min(max(v.cursor, 0), len(rows)-1)
+	return rows[v.cursor].node
+}
+
+// HandleKey applies a key press and reports whether the view should close.
+// Keys are single characters or the names "up", "down", "left", "right",
+// "pgup", "pgdown", "enter", "backspace" and "esc".
+func (v *FlameView) HandleKey(key string) bool {
+	if v.searching {
+		v.handleSearchKey(key)
+		return false
+	}
+
+	v.status = ""
+	switch key {
+	case "q", "esc":
+		return true
+	case "up", "k":
+		v.cursor--
+	case "down", "j":
+		v.cursor++
+	case "pgup":
+		v.cursor -= v.pageSize()
+	case "pgdown", " ":
+		v.cursor += v.pageSize()
+	case "g":
+		v.cursor = 0
+	case "G":
+		v.cursor = len(v.rows()) - 1
+	case "right", "l":
+		if n := v.selected(); len(n.Children) > 0 {
+			v.expanded[n] = true
+		}
+	case "left", "h":
+		n := v.selected()
+		if v.expanded[n] && n != v.root {
+			v.expanded[n] = false
+		} else if p := v.parent[n]; p != nil {
+			v.moveTo(p)
+		}
+	case "enter":
+		if n := v.selected(); n != v.root {
+			v.expanded[n] = !v.expanded[n]
+		}
+	case "e":
+		// Expand the hottest path below the cursor
+		for n := v.selected(); len(n.Children) > 0; n = n.Children[0] {
+			v.expanded[n] = true
+		}
+	case "c":
+		v.expanded = map[*profile.Node]bool{v.root: true}
+		v.cursor = 0
+	case "/":
+		v.searching = true
+		v.input = ""
+	case "n":
+		v.nextMatch(1)
+	case "N":
+		v.nextMatch(-1)
+	}
+	v.selected()
+	return false
+}
+
+func (v *FlameView) handleSearchKey(key string) {
+	switch key {
+	case "esc":
+		v.searching = false
+	case "enter":
+		v.searching = false
+		if v.input == "" {
+			v.search = nil
+			return
+		}
+		re, err := regexp.Compile("(?i)" + v.input)
+		if err != nil {
+			re = regexp.MustCompile("(?i)" + regexp.QuoteMeta(v.input))
+		}
+		v.search = re
+		v.nextMatch(1)
+	case "backspace":
+		if r := []rune(v.input); len(r) > 0 {
+			v.input = string(r[:len(r)-1])
+		}
+	default:
+		if len([]rune(key)) == 1 {
+			v.input += key
+		}
+	}
+}
+
+// nextMatch moves the cursor to the next (dir 1) or previous (dir -1) frame
+// in depth-first order matching the search, expanding its ancestors
+func (v *FlameView) nextMatch(dir int) {
+	if v.search == nil {
+		return
+	}
+	var all []*profile.Node
+	var walk func(n *profile.Node)
+	walk = func(n *profile.Node) {
+		all = append(all, n)
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	walk(v.root)
+
+	current := v.selected()
+	start := 0
+	for i, n := range all {
+		if n == current {
+			start = i
+			break
+		}
+	}
+	for i := 1; i <= len(all); i++ {
+		n := all[((start+dir*i)%len(all)+len(all))%len(all)]
+		if v.search.MatchString(n.Name) {
+			v.moveTo(n)
+			return
+		}
+	}
+	v.status = "No match for " + v.search.String()[len("(?i)"):]
+}
+
+// moveTo expands the ancestors of n and puts the cursor on it
+func (v *FlameView) moveTo(n *profile.Node) {
+	for p := v.parent[n]; p != nil; p = v.parent[p] {
+		v.expanded[p] = true
+	}
+	for i, r := range v.rows() {
+		if r.node == n {
+			v.cursor = i
+			return
+		}
+	}
+}
+
+func (v *FlameView) pageSize() int {
+	return max(v.height-2, 1)
+}
+
+// Render draws the view, using \r\n line endings for raw-mode terminals
+func (v *FlameView) Render(w io.Writer) error {
+	rows := v.rows()
+	page := v.pageSize()
+	if v.cursor < v.offset {
+		v.offset = v.cursor
+	} else if v.cursor >= v.offset+page {
+		v.offset = v.cursor - page + 1
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&b, "\x1b[1m%s\x1b[0m\r\n", v.fit(fmt.Sprintf("Total %s - %d frames shown", profile.FormatValue(v.root.Total, v.unit), len(rows))))
+	for i := v.offset; i < len(rows) && i < v.offset+page; i++ {
+		line := v.fit(v.formatRow(rows[i]))
+		switch {
+		case i == v.cursor:
+			line = "\x1b[7m" + line + "\x1b[0m"
+		case v.search != nil && v.search.MatchString(rows[i].node.Name):
+			line = "\x1b[33m" + line + "\x1b[0m"
+		}
+		b.WriteString(line + "\r\n")
+	}
+	for i := len(rows) - v.offset; i < page; i++ {
+		b.WriteString("\r\n")
+	}
+
+	switch {
+	case v.searching:
+		b.WriteString(v.fit("/" + v.input))
+	case v.status != "":
+		b.WriteString(v.fit(v.status))
+	default:
+		b.WriteString(v.fit("↑↓ move  →← expand/collapse  enter toggle  e hot path  c collapse all  / search  n/N next/prev  q quit"))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (v *FlameView) formatRow(r row) string {
+	marker := "  "
+	if len(r.node.Children) > 0 {
+		marker = "▸ "
+		if v.expanded[r.node] {
+			marker = "▾ "
+		}
+	}
+	share := 0.0
+	if v.root.Total != 0 {
+		share = float64(r.node.Total) / float64(v.root.Total)
+	}
+	filled := int(share*barWidth + 0.5)
+	bar := strings.Repeat("█", filled) + strings.Repeat("·", barWidth-filled)
+	return fmt.Sprintf("%s %6.2f%% %10s %s%s%s", bar, share*100, profile.FormatValue(r.node.Total, v.unit),
+		strings.Repeat("  ", r.depth), marker, r.node.Name)
+}
+
+// fit truncates s to the view width
+func (v *FlameView) fit(s string) string {
+	return truncate(s, v.width)
+}
+
+// truncate shortens s to at most width runes, marking the cut with an ellipsis
+func truncate(s string, width int) string {
+	r := []rune(s)
+	if len(r) <= width {
+		return s
+	}
+	return string(r[:width-1]) + "…"
+}
@@ -0,0 +1,77 @@
+package tui
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/olka/torch2pprof/pkg/profile"
+)
+
+func testTree() *profile.Node {
+	matmul := &profile.Node{Name: "matmul", Self: 60, Total: 60}
+	relu := &profile.Node{Name: "relu", Self: 10, Total: 10}
+	forward := &profile.Node{Name: "forward", Self: 10, Total: 80, Children: []*profile.Node{matmul, relu}}
+	optimizer := &profile.Node{Name: "optimizer", Self: 20, Total: 20}
+	return &profile.Node{Name: "root", Total: 100, Children: []*profile.Node{forward, optimizer}}
+}
+
+func visibleNames(v *FlameView) []string {
+	var names []string
+	for _, r := range v.rows() {
+		names = append(names, r.node.Name)
+	}
+	return names
+}
+
+func TestFlameViewNavigation(t *testing.T) {
+	v := NewFlameView(testTree(), "nanoseconds")
+	if got := strings.Join(visibleNames(v), ","); got != "root,forward,optimizer" {
+		t.Fatalf("Unexpected initial rows: %s", got)
+	}
+
+	v.HandleKey("down")
+	v.HandleKey("right")
+	if got := strings.Join(visibleNames(v), ","); got != "root,forward,matmul,relu,optimizer" {
+		t.Errorf("Expected forward expanded, got %s", got)
+	}
+
+	v.HandleKey("down")
+	v.HandleKey("left") // matmul has no children: move to parent
+	if v.selected().Name != "forward" {
+		t.Errorf("Expected cursor on forward, got %s", v.selected().Name)
+	}
+	v.HandleKey("left")
+	if got := len(v.rows()); got != 3 {
+		t.Errorf("Expected forward collapsed, got %d rows", got)
+	}
+
+	if !v.HandleKey("q") {
+		t.Error("Expected q to close the view")
+	}
+}
+
+func TestFlameViewSearch(t *testing.T) {
+	v := NewFlameView(testTree(), "nanoseconds")
+	for _, key := range []string{"/", "R", "e", "l", "u", "enter"} {
+		v.HandleKey(key)
+	}
+	if v.selected().Name != "relu" {
+		t.Fatalf("Expected search to select relu, got %s", v.selected().Name)
+	}
+
+	var buf bytes.Buffer
+	if err := v.Render(&buf); err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "10.00%") || !strings.Contains(buf.String(), "relu") {
+		t.Errorf("Expected relu row in output: %q", buf.String())
+	}
+
+	v.HandleKey("/")
+	v.HandleKey("x")
+	v.HandleKey("enter")
+	if v.selected().Name != "relu" || !strings.Contains(v.status, "No match") {
+		t.Errorf("Expected failed search to keep the cursor, got %s (%q)", v.selected().Name, v.status)
+	}
+}
@@ -0,0 +1,112 @@
+package tui
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// View is an interactive terminal view
+type View interface {
+	// SetSize sets the terminal size in characters
+	SetSize(width, height int)
+	// Render draws the view, using \r\n line endings
+	Render(w io.Writer) error
+	// HandleKey applies a key press and reports whether the view should close
+	HandleKey(key string) bool
+}
+
+// Run shows v on the terminal tty until the user quits. The terminal is put
+// into raw mode with stty and restored afterwards.
+func Run(v View, tty *os.File) error {
+	state, err := stty(tty, "-g")
+	if err != nil {
+		return fmt.Errorf("interactive mode requires a terminal: %w", err)
+	}
+	if _, err := stty(tty, "raw", "-echo"); err != nil {
+		return fmt.Errorf("interactive mode requires a terminal: %w", err)
+	}
+	defer func() {
+		_, _ = stty(tty, strings.TrimSpace(state))
+		fmt.Fprint(tty, "\x1b[?25h\x1b[H\x1b[2J")
+	}()
+	fmt.Fprint(tty, "\x1b[?25l")
+
+	r := bufio.NewReader(tty)
+	for {
+		var rows, cols int
+		if size, err := stty(tty, "size"); err == nil {
+			_, _ = fmt.Sscan(size, &rows, &cols)
+		}
+		if rows > 0 && cols > 0 {
+			v.SetSize(cols, rows)
+		}
+		if err := v.Render(tty); err != nil {
+			return err
+		}
+
+		key, err := readKey(r)
+		if err != nil {
+			return err
+		}
+		if key == "ctrl-c" || v.HandleKey(key) {
+			return nil
+		}
+	}
+}
+
+// stty runs stty with args on tty and returns its output
+func stty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// readKey reads one key press, decoding the escape sequences of arrow and
+// page keys
+func readKey(r *bufio.Reader) (string, error) {
+	c, _, err := r.ReadRune()
+	if err != nil {
+		return "", err
+	}
+	switch c {
+	case 3:
+		return "ctrl-c", nil
+	case '\r', '\n':
+		return "enter", nil
+	case 127, 8:
+		return "backspace", nil
+	case 27:
+		if r.Buffered() == 0 {
+			return "esc", nil
+		}
+		seq := []byte{}
+		for r.Buffered() > 0 && len(seq) < 4 {
+			b, _ := r.ReadByte()
+			seq = append(seq, b)
+			if b >= 'A' && b <= 'Z' || b == '~' {
+				break
+			}
+		}
+		switch string(seq) {
+		case "[A", "OA":
+			return "up", nil
+		case "[B", "OB":
+			return "down", nil
+		case "[C", "OC":
+			return "right", nil
+		case "[D", "OD":
+			return "left", nil
+		case "[5~":
+			return "pgup", nil
+		case "[6~":
+			return "pgdown", nil
+		}
+		return "", nil
+	}
+	return string(c), nil
+}
@@ -0,0 +1,300 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olka/torch2pprof/pkg/converter"
+)
+
+// APIServer converts and analyzes uploaded traces:
+//
+//	POST /convert  trace in, gzipped pprof profile out
+//	POST /analyze  trace in, TraceAnalysis JSON out
+//
+// The trace is the request body, plain or gzip-compressed, or the first
+// file of a multipart/form-data body. Conversion options are query
+// parameters named like the convert flags, e.g. /convert?thread-roots=1.
+type APIServer struct {
+	maxBytes      int64
+	maxTraceBytes int64
+	logger        *slog.Logger
+	mux           *http.ServeMux
+}
+
+// NewAPIServer returns an API server accepting uploads of up to maxBytes as
+// sent and traces of up to maxTraceBytes once decompressed (0 for no
+// limit), and logging requests to logger, which may be nil
+func NewAPIServer(maxBytes, maxTraceBytes int64, logger *slog.Logger) *APIServer {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	s := &APIServer{maxBytes: maxBytes, maxTraceBytes: maxTraceBytes, logger: logger, mux: http.NewServeMux()}
+	s.mux.HandleFunc("POST /convert", s.handleConvert)
+	s.mux.HandleFunc("POST /analyze", s.handleAnalyze)
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *APIServer) handleConvert(w http.ResponseWriter, r *http.Request) {
+	opts, err := convertOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.Logger = s.logger
+	traceData, ok := s.readTrace(w, r)
+	if !ok {
+		return
+	}
+
+	start := time.Now()
+	p, diag, err := converter.ConvertTraceWithDiagnostics(r.Context(), traceData, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, err := p.Encode()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := gz.Close(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.logger.Info("Converted trace", "remote", r.RemoteAddr, "events", diag.Events, "converted", diag.Converted,
+		"samples", len(p.Sample), "elapsed", time.Since(start).Round(time.Millisecond))
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="profile.pb.gz"`)
+	_, _ = w.Write(buf.Bytes())
+}
+
+func (s *APIServer) handleAnalyze(w http.ResponseWriter, r *http.Request) {
+	groupBy, err := converter.ParseGroupBy(cmp.Or(r.URL.Query().Get("group-by"), "name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	traceData, ok := s.readTrace(w, r)
+	if !ok {
+		return
+	}
+
+	start := time.Now()
+	analysis, err := converter.AnalyzeTraceContext(r.Context(), traceData, groupBy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.logger.Info("Analyzed trace", "remote", r.RemoteAddr, "events", len(traceData.TraceEvents),
+		"elapsed", time.Since(start).Round(time.Millisecond))
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(analysis)
+}
+
+// readTrace loads the trace of a request, replying with an error and
+// returning false if it cannot be read
+func (s *APIServer) readTrace(w http.ResponseWriter, r *http.Request) (*converter.TraceData, bool) {
+	var body io.Reader = r.Body
+	if s.maxBytes > 0 {
+		body = http.MaxBytesReader(w, r.Body, s.maxBytes)
+	}
+	if mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && mediaType == "multipart/form-data" {
+		part, err := firstFile(body, params["boundary"])
+		if err != nil {
+			s.replyReadError(w, err)
+			return nil, false
+		}
+		body = part
+	}
+
+	// Decompress here rather than in LoadTrace, so that a small gzip body
+	// cannot expand to more than the limit
+	br := bufio.NewReader(body)
+	body = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			s.replyReadError(w, err)
+			return nil, false
+		}
+		defer func() { _ = gz.Close() }()
+		body = gz
+	}
+	if s.maxTraceBytes > 0 {
+		body = http.MaxBytesReader(w, io.NopCloser(body), s.maxTraceBytes)
+	}
+
+	traceData, err := converter.LoadTrace(r.Context(), body, converter.LoadOptions{Logger: s.logger, Args: converter.UsedArgs()})
+	if err != nil {
+		s.replyReadError(w, err)
+		return nil, false
+	}
+	complete := 0
+	for _, e := range traceData.TraceEvents {
+		if e.Ph == "X" {
+			complete++
+		}
+	}
+	if complete == 0 {
+		http.Error(w, "trace has no complete events (ph=X)", http.StatusUnprocessableEntity)
+		return nil, false
+	}
+	return traceData, true
+}
+
+// replyReadError reports a trace that could not be read: too large, not a
+// trace or malformed
+func (s *APIServer) replyReadError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("trace larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// firstFile returns the content of the first file of a multipart body
+func firstFile(body io.Reader, boundary string) (io.Reader, error) {
+	if boundary == "" {
+		return nil, errors.New("multipart body without boundary")
+	}
+	mr := multipart.NewReader(body, boundary)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("multipart body without a file")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FileName() != "" {
+			return part, nil
+		}
+	}
+}
+
+// convertOptions parses the conversion query parameters of a request
+func convertOptions(q url.Values) (converter.ConvertOptions, error) {
+	opts := []converter.Option{converter.WithWorkers(runtime.NumCPU())}
+	for key, values := range q {
+		value := values[len(values)-1]
+		var opt converter.Option
+		var err error
+		switch key {
+		case "thread-roots":
+			opt, err = boolOption(value, converter.WithThreadRoots())
+		case "comm-root":
+			opt, err = boolOption(value, converter.WithCommunicationRoot())
+		case "collapse-recursion":
+			opt, err = boolOption(value, converter.WithCollapseRecursion())
+		case "normalize-kernels":
+			rawLabel := false
+			if q.Has("raw-kernel-label") {
+				rawLabel, _ = parseBool(q.Get("raw-kernel-label"))
+			}
+			opt, err = boolOption(value, converter.WithNormalizedKernelNames(rawLabel))
+		case "raw-kernel-label":
+			_, err = parseBool(value) // Applied with normalize-kernels
+		case "launch-latency":
+			opt, err = boolOption(value, converter.WithLaunchLatency())
+		case "idle":
+			opt, err = boolOption(value, converter.WithIdleFrames())
+		case "focus", "ignore":
+			var re *regexp.Regexp
+			if re, err = regexp.Compile(value); err == nil {
+				opt = converter.WithFocus(re)
+				if key == "ignore" {
+					opt = converter.WithIgnore(re)
+				}
+			}
+		case "min-dur", "epsilon":
+			var d time.Duration
+			if d, err = time.ParseDuration(value); err == nil {
+				opt = converter.WithMinDuration(d)
+				if key == "epsilon" {
+					opt = converter.WithEpsilon(d)
+				}
+			}
+		case "clock":
+			var c converter.Clock
+			if c, err = converter.ParseClock(value); err == nil {
+				opt = converter.WithClock(c)
+			}
+		case "overlap":
+			var p converter.OverlapPolicy
+			if p, err = converter.ParseOverlapPolicy(value); err == nil {
+				opt = converter.WithOverlap(p)
+			}
+		case "sample-type":
+			var types []converter.SampleType
+			if types, err = converter.ParseSampleTypes(value); err == nil {
+				opt = converter.WithSampleTypes(types...)
+			}
+		case "label":
+			for _, v := range values {
+				labelKey, labelValue, ok := strings.Cut(v, "=")
+				if !ok || labelKey == "" {
+					return converter.ConvertOptions{}, fmt.Errorf("label: want key=value, got %q", v)
+				}
+				opts = append(opts, converter.WithLabel(labelKey, labelValue))
+			}
+			continue
+		default:
+			return converter.ConvertOptions{}, fmt.Errorf("unknown parameter %q", key)
+		}
+		if err != nil {
+			return converter.ConvertOptions{}, fmt.Errorf("%s: %w", key, err)
+		}
+		if opt != nil {
+			opts = append(opts, opt)
+		}
+	}
+	return converter.NewConvertOptions(opts...)
+}
+
+// boolOption returns opt if value is true, or nil if it is false
+func boolOption(value string, opt converter.Option) (converter.Option, error) {
+	b, err := parseBool(value)
+	if err != nil || !b {
+		return nil, err
+	}
+	return opt, nil
+}
+
+// parseBool parses a boolean parameter; a parameter without a value, as in
+// ?thread-roots, is true
+func parseBool(value string) (bool, error) {
+	return strconv.ParseBool(cmp.Or(value, "true"))
+}
@@ -0,0 +1,283 @@
+// Package web serves a browser UI for profiles converted from traces, so
+// results can be viewed without go tool pprof or Graphviz installed, and an
+// HTTP API converting and analyzing uploaded traces.
+package web
+
+import (
+	"bytes"
+	"compress/gzip"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/olka/torch2pprof/pkg/profile"
+)
+
+//go:embed ui.html
+var uiTemplate string
+
+const (
+	// minFlameWidth is the narrowest flamegraph frame drawn, in percent
+	minFlameWidth = 0.05
+	// flameRowHeight is the height of a flamegraph row in pixels
+	flameRowHeight = 18
+)
+
+var templates = template.Must(template.New("ui").Funcs(template.FuncMap{
+	"pct": func(v, total int64) string {
+		if total == 0 {
+			return "0.00%"
+		}
+		return fmt.Sprintf("%.2f%%", float64(v)/float64(total)*100)
+	},
+	"value": profile.FormatValue,
+}).Parse(uiTemplate))
+
+// Server serves the flamegraph, top and peek views of a profile
+type Server struct {
+	profile *profile.Profile
+	mux     *http.ServeMux
+}
+
+// NewServer returns a server for p. Handlers only read p, so it must not be
+// modified while the server is running.
+func NewServer(p *profile.Profile) *Server {
+	s := &Server{profile: p, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/", s.handleIndex)
+	s.mux.HandleFunc("/flame", s.handleFlame)
+	s.mux.HandleFunc("/top", s.handleTop)
+	s.mux.HandleFunc("/peek", s.handlePeek)
+	s.mux.HandleFunc("/profile.pb.gz", s.handleDownload)
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// page holds the data shared by all views
+type page struct {
+	View        string
+	SampleType  string
+	SampleTypes []string
+	Unit        string
+	Total       int64
+	Flame       []flameRect
+	Height      int
+	Path        []crumb
+	Top         []profile.TopEntry
+	Function    string
+	Callers     []profile.TopEntry
+	Callees     []profile.TopEntry
+	Self        int64
+	Cum         int64
+}
+
+// flameRect is one positioned flamegraph frame
+type flameRect struct {
+	Name  string
+	Value string
+	Pct   string
+	X     float64
+	Width float64
+	Top   int
+	Node  string
+}
+
+// crumb is one step of the path to the zoomed flamegraph node
+type crumb struct {
+	Name string
+	Node string
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	http.Redirect(w, r, "/flame", http.StatusFound)
+}
+
+func (s *Server) handleFlame(w http.ResponseWriter, r *http.Request) {
+	pg, index, err := s.newPage(r, "flame")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Walk down the child indices in ?node=0.3.1 to the zoomed node
+	node := s.profile.CallTree(index)
+	path := ""
+	pg.Path = append(pg.Path, crumb{Name: node.Name})
+	if n := r.URL.Query().Get("node"); n != "" {
+		for _, part := range strings.Split(n, ".") {
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(node.Children) {
+				http.Error(w, "invalid node "+n, http.StatusBadRequest)
+				return
+			}
+			node = node.Children[i]
+			path = joinPath(path, i)
+			pg.Path = append(pg.Path, crumb{Name: node.Name, Node: path})
+		}
+	}
+
+	pg.Total = node.Total
+	s.layout(pg, node, path, 0, 0, node.Total)
+	s.render(w, pg)
+}
+
+// layout appends the frames of node and its descendants wide enough to draw
+func (s *Server) layout(pg *page, node *profile.Node, path string, x float64, depth int, total int64) {
+	if total == 0 {
+		return
+	}
+	width := float64(node.Total) / float64(total) * 100
+	if width < minFlameWidth {
+		return
+	}
+	pg.Flame = append(pg.Flame, flameRect{
+		Name:  node.Name,
+		Value: profile.FormatValue(node.Total, pg.Unit),
+		Pct:   fmt.Sprintf("%.2f%%", width),
+		X:     x,
+		Width: width,
+		Top:   depth * flameRowHeight,
+		Node:  path,
+	})
+	pg.Height = max(pg.Height, (depth+1)*flameRowHeight)
+	for i, child := range node.Children {
+		s.layout(pg, child, joinPath(path, i), x, depth+1, total)
+		x += float64(child.Total) / float64(total) * 100
+	}
+}
+
+func (s *Server) handleTop(w http.ResponseWriter, r *http.Request) {
+	pg, index, err := s.newPage(r, "top")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pg.Top = s.profile.Top(index)
+	pg.Total = s.profile.CallTree(index).Total
+	s.render(w, pg)
+}
+
+func (s *Server) handlePeek(w http.ResponseWriter, r *http.Request) {
+	pg, index, err := s.newPage(r, "peek")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pg.Function = r.URL.Query().Get("f")
+
+	root := s.profile.CallTree(index)
+	pg.Total = root.Total
+	callers := map[string]int64{}
+	callees := map[string]int64{}
+	var visit func(parent, node *profile.Node, onStack bool)
+	visit = func(parent, node *profile.Node, onStack bool) {
+		match := node.Name == pg.Function
+		if match {
+			pg.Self += node.Self
+			if !onStack {
+				pg.Cum += node.Total
+			}
+			if parent != root {
+				callers[parent.Name] += node.Total
+			}
+			for _, c := range node.Children {
+				callees[c.Name] += c.Total
+			}
+		}
+		for _, c := range node.Children {
+			visit(node, c, onStack || match)
+		}
+	}
+	for _, c := range root.Children {
+		visit(root, c, false)
+	}
+	pg.Callers = sortedEntries(callers)
+	pg.Callees = sortedEntries(callees)
+	s.render(w, pg)
+}
+
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	data, err := s.profile.Encode()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := gz.Close(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="profile.pb.gz"`)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// newPage resolves the ?si= sample type shared by all views
+func (s *Server) newPage(r *http.Request, view string) (*page, int, error) {
+	index, err := s.profile.SampleIndex(r.URL.Query().Get("si"))
+	if err != nil {
+		return nil, 0, err
+	}
+	pg := &page{View: view}
+	for i, st := range s.profile.SampleType {
+		name := s.profile.StringTable[st.Type]
+		pg.SampleTypes = append(pg.SampleTypes, name)
+		if i == index {
+			pg.SampleType = name
+			pg.Unit = s.profile.StringTable[st.Unit]
+		}
+	}
+	return pg, index, nil
+}
+
+func (s *Server) render(w http.ResponseWriter, pg *page) {
+	var buf bytes.Buffer
+	if err := templates.Execute(&buf, pg); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+func joinPath(path string, i int) string {
+	if path == "" {
+		return strconv.Itoa(i)
+	}
+	return path + "." + strconv.Itoa(i)
+}
+
+// sortedEntries converts per-function values to entries sorted by value
+func sortedEntries(values map[string]int64) []profile.TopEntry {
+	entries := make([]profile.TopEntry, 0, len(values))
+	for name, v := range values {
+		entries = append(entries, profile.TopEntry{Name: name, Cum: v})
+	}
+	sortEntries(entries)
+	return entries
+}
+
+func sortEntries(entries []profile.TopEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Cum != entries[j].Cum {
+			return entries[i].Cum > entries[j].Cum
+		}
+		return entries[i].Name < entries[j].Name
+	})
+}
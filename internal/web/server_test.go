@@ -0,0 +1,143 @@
+package web
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/olka/torch2pprof/pkg/profile"
+)
+
+func testProfile() *profile.Profile {
+	pb := profile.NewBuilder()
+	pb.SetSampleTypes([]struct{ Type, Unit string }{
+		{"samples", "count"},
+		{"time", "nanoseconds"},
+	})
+	_ = pb.AddSample([]profile.Frame{{Name: "forward"}, {Name: "matmul"}}, []int64{1, 2000000}, nil)
+	_ = pb.AddSample([]profile.Frame{{Name: "forward"}}, []int64{1, 1000000}, nil)
+	return pb.Build()
+}
+
+func get(t *testing.T, h http.Handler, url string) (int, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+	return rec.Code, rec.Body.String()
+}
+
+func TestServerViews(t *testing.T) {
+	s := NewServer(testProfile())
+
+	if code, body := get(t, s, "/flame"); code != http.StatusOK || !strings.Contains(body, `title="matmul (2.00ms, 66.67%)"`) {
+		t.Errorf("Unexpected flame view (%d): %s", code, body)
+	}
+	if code, body := get(t, s, "/flame?node=0.0"); code != http.StatusOK || !strings.Contains(body, `title="matmul (2.00ms, 100.00%)"`) {
+		t.Errorf("Unexpected zoomed flame view (%d): %s", code, body)
+	}
+	if code, body := get(t, s, "/top?si=samples"); code != http.StatusOK || !strings.Contains(body, "f=matmul") {
+		t.Errorf("Unexpected top view (%d): %s", code, body)
+	}
+	if code, body := get(t, s, "/peek?f=matmul"); code != http.StatusOK || !strings.Contains(body, "cumulative 2.00ms") {
+		t.Errorf("Unexpected peek view (%d): %s", code, body)
+	}
+	if code, _ := get(t, s, "/flame?node=5"); code != http.StatusBadRequest {
+		t.Errorf("Expected bad request for invalid node, got %d", code)
+	}
+	if code, _ := get(t, s, "/top?si=bogus"); code != http.StatusBadRequest {
+		t.Errorf("Expected bad request for unknown sample type, got %d", code)
+	}
+	if code, body := get(t, s, "/profile.pb.gz"); code != http.StatusOK || len(body) == 0 {
+		t.Errorf("Expected profile download, got %d", code)
+	}
+}
+
+const testTrace = `{"traceEvents": [
+	{"ph": "X", "name": "forward", "cat": "cpu_op", "pid": 1, "tid": 1, "ts": 0, "dur": 100},
+	{"ph": "X", "name": "matmul", "cat": "cpu_op", "pid": 1, "tid": 1, "ts": 10, "dur": 50}
+]}`
+
+func post(t *testing.T, h http.Handler, url, contentType string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPIServer(t *testing.T) {
+	s := NewAPIServer(1<<20, 4<<20, nil)
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	_, _ = gz.Write([]byte(testTrace))
+	_ = gz.Close()
+	rec := post(t, s, "/convert?sample-type=time&label=run=1", "application/gzip", gzipped.Bytes())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected convert response (%d): %s", rec.Code, rec.Body)
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("Expected a gzipped profile: %v", err)
+	}
+	p, err := profile.Parse(zr)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(p.SampleType) != 1 || len(p.Sample) != 2 {
+		t.Errorf("Expected 2 samples of 1 type, got %d of %d", len(p.Sample), len(p.SampleType))
+	}
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	_ = mw.WriteField("note", "not the trace")
+	fw, _ := mw.CreateFormFile("trace", "trace.json")
+	_, _ = fw.Write([]byte(testTrace))
+	_ = mw.Close()
+	rec = post(t, s, "/analyze", mw.FormDataContentType(), form.Bytes())
+	var analysis struct {
+		CompleteEvents int `json:"complete_events"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &analysis); rec.Code != http.StatusOK || err != nil || analysis.CompleteEvents != 2 {
+		t.Errorf("Unexpected analyze response (%d): %s", rec.Code, rec.Body)
+	}
+
+	for _, tc := range []struct {
+		url  string
+		body string
+		code int
+	}{
+		{"/convert?bogus=1", testTrace, http.StatusBadRequest},
+		{"/convert?min-dur=soon", testTrace, http.StatusBadRequest},
+		{"/analyze?group-by=color", testTrace, http.StatusBadRequest},
+		{"/convert", "hello", http.StatusBadRequest},
+		{"/convert", `{"traceEvents": []}`, http.StatusUnprocessableEntity},
+		{"/convert", `{"padding": "` + strings.Repeat("x", 1<<20) + `", ` + testTrace[1:], http.StatusRequestEntityTooLarge},
+	} {
+		if rec := post(t, s, tc.url, "application/json", []byte(tc.body)); rec.Code != tc.code {
+			t.Errorf("POST %s: expected %d, got %d: %s", tc.url, tc.code, rec.Code, rec.Body)
+		}
+	}
+
+	// A small gzip body expanding past the trace limit is rejected
+	gzipped.Reset()
+	gz = gzip.NewWriter(&gzipped)
+	_, _ = gz.Write([]byte(`{"padding": "` + strings.Repeat("x", 8<<20) + `", ` + testTrace[1:]))
+	_ = gz.Close()
+	if gzipped.Len() >= 1<<20 {
+		t.Fatalf("Expected the gzip body under the upload limit, got %d bytes", gzipped.Len())
+	}
+	if rec := post(t, s, "/convert", "application/gzip", gzipped.Bytes()); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected an oversized gzip body to be rejected with 413, got %d: %s", rec.Code, rec.Body)
+	}
+
+	if code, _ := get(t, s, "/convert"); code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET /convert to be rejected, got %d", code)
+	}
+}
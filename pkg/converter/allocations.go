@@ -0,0 +1,231 @@
+package converter
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+// memoryEventName is the name of the instant events recorded for every
+// allocation and free with profile_memory=True
+const memoryEventName = "[memory]"
+
+// DeviceMemoryStats is the memory use of one device from the [memory]
+// events of a trace recorded with profile_memory=True. Device is "cpu" or
+// the index of a GPU. The peaks are the largest totals the allocator
+// reported; PeakStack holds the CPU ops open on the thread allocating at
+// the allocated peak, outermost first. TopSites are the ops allocating the
+// most bytes, most first.
+type DeviceMemoryStats struct {
+	Device             string           `json:"device"`
+	Allocations        int              `json:"allocations"`
+	AllocatedBytes     int64            `json:"allocated_bytes"`
+	FreedBytes         int64            `json:"freed_bytes"`
+	PeakAllocatedBytes int64            `json:"peak_allocated_bytes"`
+	PeakReservedBytes  int64            `json:"peak_reserved_bytes"`
+	PeakNs             int64            `json:"peak_ns"` // Since the start of the trace
+	PeakStack          []string         `json:"peak_stack"`
+	TopSites           []AllocationSite `json:"top_sites"`
+}
+
+// AllocationSite is the innermost CPU op running on the allocating thread
+// when memory was allocated, "" for allocations outside any op
+type AllocationSite struct {
+	Op          string `json:"op"`
+	Allocations int    `json:"allocations"`
+	Bytes       int64  `json:"bytes"`
+}
+
+// maxAllocationSites is the number of TopSites reported per device
+const maxAllocationSites = 10
+
+// memoryEvent is an allocation (positive bytes) or free recorded by a
+// [memory] event, with the allocator totals after it
+type memoryEvent struct {
+	ts                  float64
+	thread              threadID
+	bytes               int64
+	allocated, reserved int64
+}
+
+// isMemoryEvent reports whether e records an allocation or free
+func isMemoryEvent(e TraceEvent) bool {
+	return e.Name == memoryEventName && e.Ph != "X"
+}
+
+// memoryDevice returns the device of a [memory] event: "cpu" for device
+// type 0, otherwise the device id
+func memoryDevice(e TraceEvent) string {
+	if t, ok := e.ArgInt("Device Type"); ok && t == 0 {
+		return "cpu"
+	}
+	if id, ok := e.ArgInt("Device Id"); ok {
+		return strconv.FormatInt(id, 10)
+	}
+	return "cpu"
+}
+
+// addMemory records a [memory] event
+func (a *traceAnalyzer) addMemory(e TraceEvent) {
+	bytes, _ := e.ArgInt("Bytes")
+	allocated, _ := e.ArgInt("Total Allocated")
+	reserved, _ := e.ArgInt("Total Reserved")
+	device := memoryDevice(e)
+	if _, ok := a.memory[device]; !ok {
+		a.memoryOrder = append(a.memoryOrder, device)
+	}
+	a.memory[device] = append(a.memory[device], memoryEvent{
+		ts:        e.Ts,
+		thread:    threadID{pid: getTid(e.Pid), tid: getTid(e.Tid)},
+		bytes:     bytes,
+		allocated: allocated,
+		reserved:  reserved,
+	})
+}
+
+// memoryStats returns the memory use of each device with [memory] events,
+// given the CPU events of each thread
+func (a *traceAnalyzer) memoryStats(byThread map[threadID][]cpuEvent) []DeviceMemoryStats {
+	if len(a.memory) == 0 {
+		return nil
+	}
+
+	sort.Slice(a.memoryOrder, func(i, j int) bool { return lessID(a.memoryOrder[i], a.memoryOrder[j]) })
+	var result []DeviceMemoryStats
+	for _, device := range a.memoryOrder {
+		events := a.memory[device]
+		stats := DeviceMemoryStats{Device: device}
+		peak := -1
+		for i, m := range events {
+			if m.bytes > 0 {
+				stats.Allocations++
+				stats.AllocatedBytes += m.bytes
+			} else {
+				stats.FreedBytes -= m.bytes
+			}
+			if peak < 0 || m.allocated > stats.PeakAllocatedBytes {
+				peak = i
+				stats.PeakAllocatedBytes = m.allocated
+			}
+			stats.PeakReservedBytes = max(stats.PeakReservedBytes, m.reserved)
+		}
+		if !math.IsInf(a.start, 1) {
+			stats.PeakNs = usToNs(events[peak].ts - a.start)
+		}
+		stats.PeakStack = openEvents(byThread[events[peak].thread], events[peak].ts)
+		stats.TopSites = allocationSites(events, byThread)
+		result = append(result, stats)
+	}
+	return result
+}
+
+// cpuEventsByThread returns the CPU events of each thread sorted by start,
+// outer events first, so they are pushed before the events they hold, and
+// linked to the events they are nested in
+func (a *traceAnalyzer) cpuEventsByThread() map[threadID][]cpuEvent {
+	byThread := make(map[threadID][]cpuEvent)
+	for _, e := range a.cpuEvents {
+		byThread[e.thread] = append(byThread[e.thread], e)
+	}
+	for _, events := range byThread {
+		sort.Slice(events, func(i, j int) bool {
+			if events[i].start != events[j].start {
+				return events[i].start < events[j].start
+			}
+			return events[i].end > events[j].end
+		})
+		var stack []int
+		for i := range events {
+			for len(stack) > 0 && events[stack[len(stack)-1]].end <= events[i].start {
+				stack = stack[:len(stack)-1]
+			}
+			events[i].parent = -1
+			if len(stack) > 0 {
+				events[i].parent = stack[len(stack)-1]
+			}
+			stack = append(stack, i)
+		}
+	}
+	return byThread
+}
+
+// openEvents returns the names of the events running at ts, outermost
+// first, from events sorted by start
+func openEvents(events []cpuEvent, ts float64) []string {
+	var names []string
+	for _, e := range events {
+		if e.start > ts {
+			break
+		}
+		if ts < e.end {
+			names = append(names, e.name)
+		}
+	}
+	return names
+}
+
+// allocationSites aggregates the allocations of events by the innermost
+// CPU event running on their thread, given the CPU events of each thread
+// sorted by start
+func allocationSites(events []memoryEvent, byThread map[threadID][]cpuEvent) []AllocationSite {
+	allocations := make(map[threadID][]memoryEvent)
+	for _, m := range events {
+		if m.bytes > 0 {
+			allocations[m.thread] = append(allocations[m.thread], m)
+		}
+	}
+
+	sites := make(map[string]*AllocationSite)
+	for thread, allocs := range allocations {
+		sort.SliceStable(allocs, func(i, j int) bool { return allocs[i].ts < allocs[j].ts })
+		eachStack(byThread[thread], len(allocs), func(i int) float64 { return allocs[i].ts }, func(i int, stack []cpuEvent) {
+			op := ""
+			if len(stack) > 0 {
+				op = stack[len(stack)-1].name
+			}
+			site := sites[op]
+			if site == nil {
+				site = &AllocationSite{Op: op}
+				sites[op] = site
+			}
+			site.Allocations++
+			site.Bytes += allocs[i].bytes
+		})
+	}
+
+	result := make([]AllocationSite, 0, len(sites))
+	for _, site := range sites {
+		result = append(result, *site)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Bytes != result[j].Bytes {
+			return result[i].Bytes > result[j].Bytes
+		}
+		return result[i].Op < result[j].Op
+	})
+	return result[:min(len(result), maxAllocationSites)]
+}
+
+// eachStack calls fn with the stack of CPU events running at each of n
+// times in increasing order, outermost first, given the events of the
+// thread sorted by start
+func eachStack(cpu []cpuEvent, n int, ts func(i int) float64, fn func(i int, stack []cpuEvent)) {
+	var stack []cpuEvent
+	next := 0
+	for i := range n {
+		t := ts(i)
+		// Sweep the events of the thread up to t, keeping those still
+		// running on the stack
+		for next < len(cpu) && cpu[next].start <= t {
+			for len(stack) > 0 && stack[len(stack)-1].end <= cpu[next].start {
+				stack = stack[:len(stack)-1]
+			}
+			stack = append(stack, cpu[next])
+			next++
+		}
+		for len(stack) > 0 && stack[len(stack)-1].end <= t {
+			stack = stack[:len(stack)-1]
+		}
+		fn(i, stack)
+	}
+}
@@ -0,0 +1,44 @@
+package converter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeTraceMemory(t *testing.T) {
+	memory := func(ts float64, deviceType, bytes, allocated float64) TraceEvent {
+		return TraceEvent{Ph: "i", Name: "[memory]", Pid: float64(1), Tid: float64(1), Ts: ts, Args: map[string]interface{}{
+			"Device Type": deviceType, "Device Id": float64(0), "Bytes": bytes,
+			"Total Allocated": allocated, "Total Reserved": float64(1 << 21)}}
+	}
+	analysis := AnalyzeTrace(&TraceData{TraceEvents: []TraceEvent{
+		cpuOp("step", 0, 100), cpuOp("aten::empty", 10, 10), cpuOp("aten::mm", 30, 20),
+		memory(15, 1, 100, 100),
+		memory(40, 1, 300, 400),
+		memory(60, 1, -100, 300),
+		memory(70, 0, 8, 8),
+		memory(80, 1, 50, 350),
+	}})
+
+	if len(analysis.Memory) != 2 {
+		t.Fatalf("Expected memory stats for a GPU and the CPU, got %+v", analysis.Memory)
+	}
+	gpu := analysis.Memory[0]
+	if gpu.Device != "0" || gpu.Allocations != 3 || gpu.AllocatedBytes != 450 || gpu.FreedBytes != 100 {
+		t.Errorf("Unexpected GPU allocations: %+v", gpu)
+	}
+	if gpu.PeakAllocatedBytes != 400 || gpu.PeakReservedBytes != 1<<21 || gpu.PeakNs != 40000 {
+		t.Errorf("Expected a 400-byte peak at 40us, got %+v", gpu)
+	}
+	if !reflect.DeepEqual(gpu.PeakStack, []string{"step", "aten::mm"}) {
+		t.Errorf("Expected the stack [step aten::mm] at the peak, got %v", gpu.PeakStack)
+	}
+	wantSites := []AllocationSite{{Op: "aten::mm", Allocations: 1, Bytes: 300}, {Op: "aten::empty", Allocations: 1, Bytes: 100},
+		{Op: "step", Allocations: 1, Bytes: 50}}
+	if !reflect.DeepEqual(gpu.TopSites, wantSites) {
+		t.Errorf("Expected allocation sites %+v, got %+v", wantSites, gpu.TopSites)
+	}
+	if cpu := analysis.Memory[1]; cpu.Device != "cpu" || cpu.PeakAllocatedBytes != 8 {
+		t.Errorf("Unexpected CPU memory: %+v", cpu)
+	}
+}
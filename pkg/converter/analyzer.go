@@ -0,0 +1,904 @@
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// CategoryStats holds statistics for a category
+type CategoryStats struct {
+	Count  int   `json:"count"`
+	TimeNs int64 `json:"time_ns"`
+}
+
+// OperationStats holds statistics for an operation. Category is the
+// category of the operation's first event. Label names the operation when
+// its key does not: the thread of a "pid/tid" key with GroupByThread. The
+// duration statistics describe
+// single events; StdDevNs is the population standard deviation and
+// percentiles use the nearest-rank method.
+type OperationStats struct {
+	Category string `json:"category"`
+	Label    string `json:"label,omitempty"`
+	Count    int    `json:"count"`
+	TimeNs   int64  `json:"time_ns"`
+	MinNs    int64  `json:"min_ns"`
+	MaxNs    int64  `json:"max_ns"`
+	MeanNs   int64  `json:"mean_ns"`
+	StdDevNs int64  `json:"stddev_ns"`
+	P50Ns    int64  `json:"p50_ns"`
+	P95Ns    int64  `json:"p95_ns"`
+	P99Ns    int64  `json:"p99_ns"`
+}
+
+// ThreadStats holds statistics for one thread or GPU stream. BusyNs is the
+// time covered by at least one event; Utilization is BusyNs over the span
+// of the trace.
+type ThreadStats struct {
+	Name        string  `json:"name"`
+	Pid         string  `json:"pid"`
+	Tid         string  `json:"tid"`
+	Events      int     `json:"events"`
+	BusyNs      int64   `json:"busy_ns"`
+	Utilization float64 `json:"utilization"`
+}
+
+// DeviceStats holds the GPU activity of one device. MemcpyNs includes
+// memsets. BusyNs is the time at least one stream of the device was busy;
+// IdleNs and Utilization are relative to the span of the trace. IdleGaps
+// are the longest periods the device was idle, longest first.
+type DeviceStats struct {
+	Device      string    `json:"device"`
+	Kernels     int       `json:"kernels"`
+	KernelNs    int64     `json:"kernel_ns"`
+	Memcpys     int       `json:"memcpys"`
+	MemcpyNs    int64     `json:"memcpy_ns"`
+	BusyNs      int64     `json:"busy_ns"`
+	IdleNs      int64     `json:"idle_ns"`
+	Utilization float64   `json:"utilization"`
+	IdleGaps    []IdleGap `json:"idle_gaps"`
+}
+
+// IdleGap is a period when no stream of a GPU device was busy, before,
+// between or after its events. CPUOp is the innermost CPU event running in
+// the middle of the gap, the most recently started one if several threads
+// were busy, which tells what kept the GPU waiting.
+type IdleGap struct {
+	StartNs int64  `json:"start_ns"` // Since the start of the trace
+	DurNs   int64  `json:"dur_ns"`
+	CPUOp   string `json:"cpu_op,omitempty"`
+}
+
+// maxIdleGaps is the number of IdleGaps reported per device
+const maxIdleGaps = 5
+
+// LaunchLatencyStats describes the delays between kernel launch calls, such
+// as cudaLaunchKernel, and the start of the GPU work they launched, matched
+// by correlation id. Long delays mean the CPU was ahead of the GPU: work
+// queued behind the kernels already launched, as in a GPU-bound trace.
+// Short delays with the GPU idle between kernels mean the GPU was waiting
+// for the CPU to launch them. The statistics are those of OperationStats;
+// Worst lists the longest delays, longest first. CallNs is the CPU time of
+// the launch calls.
+type LaunchLatencyStats struct {
+	Launches int             `json:"launches"`
+	CallNs   int64           `json:"call_ns"`
+	TotalNs  int64           `json:"total_ns"`
+	MinNs    int64           `json:"min_ns"`
+	MaxNs    int64           `json:"max_ns"`
+	MeanNs   int64           `json:"mean_ns"`
+	StdDevNs int64           `json:"stddev_ns"`
+	P50Ns    int64           `json:"p50_ns"`
+	P95Ns    int64           `json:"p95_ns"`
+	P99Ns    int64           `json:"p99_ns"`
+	Worst    []LaunchLatency `json:"worst"`
+}
+
+// LaunchLatency is the delay of a single kernel launch
+type LaunchLatency struct {
+	Call      string `json:"call"`
+	Kernel    string `json:"kernel"`
+	Thread    string `json:"thread"`
+	StartNs   int64  `json:"start_ns"` // Of the call, since the start of the trace
+	LatencyNs int64  `json:"latency_ns"`
+}
+
+// maxWorstLaunches is the number of launches in LaunchLatencyStats.Worst
+const maxWorstLaunches = 10
+
+// TraceAnalysis contains analysis results from a trace. The reports not
+// selected by Reports are nil, while a report that found no events for it
+// in the trace is empty, so the two stay apart in JSON (null and []).
+type TraceAnalysis struct {
+	TotalEvents         int                       `json:"total_events"`
+	CompleteEvents      int                       `json:"complete_events"`
+	SkippedZeroDuration int                       `json:"skipped_zero_duration"`
+	ConvertedEvents     int                       `json:"converted_events"`
+	UniqueOperations    int                       `json:"unique_operations"`
+	TotalTimeNs         int64                     `json:"total_time_ns"`
+	SpanNs              int64                     `json:"span_ns"`
+	Steps               int                       `json:"steps,omitempty"`
+	StepNs              int64                     `json:"step_ns,omitempty"` // Mean ProfilerStep duration
+	CategoryStats       map[string]CategoryStats  `json:"categories"`
+	OperationStats      map[string]OperationStats `json:"operations"`
+	Threads             []ThreadStats             `json:"threads"`
+	Devices             []DeviceStats             `json:"devices"`
+	LaunchLatency       *LaunchLatencyStats       `json:"launch_latency,omitempty"`
+	Memory              []DeviceMemoryStats       `json:"memory"`
+	CommOverlap         []CommOverlapStats        `json:"comm_overlap"`
+	DataLoader          []DataLoaderStats         `json:"dataloader"`
+	Precision           *PrecisionStats           `json:"precision,omitempty"`
+	StreamConcurrency   []StreamConcurrencyStats  `json:"stream_concurrency,omitempty"`
+	Allocator           *AllocatorStats           `json:"allocator"`
+	Transfers           []TransferStats           `json:"transfers"`
+	PythonOverhead      []PythonOverheadStats     `json:"python_overhead"`
+	Recommendations     []Recommendation          `json:"recommendations,omitempty"`
+	StepCategories      []StepCategoryTimes       `json:"step_categories"`
+	StepTrends          []CategoryTrend           `json:"step_trends"`
+
+	// Kernels are the GPU kernels by device time, with equal self and
+	// total time, and CPUOperators the cpu_op events by self time, which
+	// excludes the operators they call. Unlike OperationStats, they keep
+	// the two domains apart.
+	Kernels      []OperationTime `json:"kernels,omitempty"`
+	CPUOperators []OperationTime `json:"cpu_operators,omitempty"`
+
+	// GroupBy is the dimension OperationStats is keyed by
+	GroupBy GroupBy `json:"group_by"`
+}
+
+// GroupBy selects the dimension operations are aggregated by in analysis
+type GroupBy string
+
+const (
+	// GroupByName aggregates events by operation name
+	GroupByName GroupBy = "name"
+
+	// GroupByCategory aggregates events by category
+	GroupByCategory GroupBy = "cat"
+
+	// GroupByNameShape aggregates events by operation name and input shapes,
+	// as recorded with record_shapes=True
+	GroupByNameShape GroupBy = "name+shape"
+
+	// GroupByThread aggregates events by thread or GPU stream, keyed by
+	// pid and tid so threads sharing a name stay apart
+	GroupByThread GroupBy = "thread"
+
+	// GroupByStream aggregates GPU events by device and stream; CPU events
+	// are left out
+	GroupByStream GroupBy = "stream"
+)
+
+// ParseGroupBy parses a group-by dimension name
+func ParseGroupBy(s string) (GroupBy, error) {
+	switch g := GroupBy(s); g {
+	case GroupByName, GroupByCategory, GroupByNameShape, GroupByThread, GroupByStream:
+		return g, nil
+	default:
+		return "", fmt.Errorf("unknown group-by %q (want name, cat, name+shape, thread or stream)", s)
+	}
+}
+
+// groupKey returns the key of e in OperationStats, or "" to leave it out,
+// and its label if the key is not a name
+func (g GroupBy) groupKey(e TraceEvent, md *traceMetadata) (key, label string) {
+	switch g {
+	case GroupByCategory:
+		return e.Cat, ""
+	case GroupByNameShape:
+		dims, ok := e.Args["Input Dims"]
+		if !ok {
+			return e.Name, ""
+		}
+		shape, err := json.Marshal(dims)
+		if err != nil {
+			return e.Name, ""
+		}
+		return e.Name + " " + string(shape), ""
+	case GroupByThread:
+		return threadKey(e.Pid, e.Tid), md.rootFrame(e.Pid, e.Tid).name
+	case GroupByStream:
+		if !isGPUEvent(e) {
+			return "", ""
+		}
+		stream := idString(e.Tid)
+		if v, ok := e.ArgInt("stream"); ok {
+			stream = strconv.FormatInt(v, 10)
+		}
+		return "GPU " + gpuDevice(e) + " stream " + stream, ""
+	default:
+		return e.Name, ""
+	}
+}
+
+// AnalyzeTrace analyzes a PyTorch trace and returns statistics, with
+// operations aggregated by name
+func AnalyzeTrace(traceData *TraceData) *TraceAnalysis {
+	return AnalyzeTraceBy(traceData, GroupByName)
+}
+
+// AnalyzeTraceBy is like AnalyzeTrace but aggregates OperationStats by the
+// given dimension
+func AnalyzeTraceBy(traceData *TraceData, groupBy GroupBy) *TraceAnalysis {
+	analysis, _ := AnalyzeTraceContext(context.Background(), traceData, groupBy)
+	return analysis
+}
+
+// AnalyzeTraceContext is like AnalyzeTraceBy but stops and returns
+// ctx.Err() when ctx is cancelled
+func AnalyzeTraceContext(ctx context.Context, traceData *TraceData, groupBy GroupBy) (*TraceAnalysis, error) {
+	return AnalyzeTraceReports(ctx, traceData, groupBy, AllReports)
+}
+
+// AnalyzeTraceReports is like AnalyzeTraceContext but computes only the
+// optional reports selected by reports, leaving the others empty
+func AnalyzeTraceReports(ctx context.Context, traceData *TraceData, groupBy GroupBy, reports Reports) (*TraceAnalysis, error) {
+	a := newTraceAnalyzer(collectMetadata(traceData.TraceEvents), groupBy, traceRank(traceData), reports)
+	for i, e := range traceData.TraceEvents {
+		if i%progressInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		a.add(e)
+	}
+	return a.finish(), nil
+}
+
+// Reports selects the parts of a TraceAnalysis that need events of the
+// trace kept until it ends, or another pass over them. The others are
+// always computed.
+type Reports uint
+
+const (
+	// ReportIdleGaps lists the longest idle gaps of each GPU, with the CPU
+	// event running in each
+	ReportIdleGaps Reports = 1 << iota
+
+	// ReportMemory computes Memory
+	ReportMemory
+
+	// ReportAllocator computes Allocator
+	ReportAllocator
+
+	// ReportTransfers computes Transfers
+	ReportTransfers
+
+	// ReportPython computes PythonOverhead
+	ReportPython
+
+	// ReportStepTrend computes StepCategories and StepTrends
+	ReportStepTrend
+
+	// ReportPercentiles computes the percentiles of OperationStats, which
+	// keep the duration of every event until the end
+	ReportPercentiles
+
+	// AllReports selects every report
+	AllReports = ReportIdleGaps | ReportMemory | ReportAllocator | ReportTransfers | ReportPython | ReportStepTrend | ReportPercentiles
+
+	// cpuEventReports are the reports attributing time to CPU events
+	cpuEventReports = ReportIdleGaps | ReportMemory | ReportAllocator | ReportTransfers
+)
+
+// traceAnalyzer accumulates the statistics of a TraceAnalysis one event at
+// a time, so they can be collected while events are visited for another
+// purpose such as conversion
+type traceAnalyzer struct {
+	analysis     *TraceAnalysis
+	md           *traceMetadata
+	threads      map[threadID]*threadIntervals
+	threadOrder  []threadID
+	devices      map[string]*deviceIntervals
+	deviceOrder  []string
+	moments      map[string]*durationMoments
+	durations    map[string][]int64 // With ReportPercentiles
+	cpuEvents    []cpuEvent
+	launches     map[int64]launchEvent
+	gpuStarts    map[int64]launchEvent
+	memory       map[string][]memoryEvent
+	memoryOrder  []string
+	steps        map[int]TimeWindow // ProfilerStep spans by step
+	dataLoader   intervals
+	pythonFrames map[threadID]intervals // python_function spans by thread
+	precision    precisionCounter
+	kernels      map[string]*OperationTime
+	cpuOps       *selfTimes
+	allocator    []allocatorCall
+	transfers    map[int64]transfer // Host-device copies by correlation id
+	memcpyCalls  map[int64]memcpyCall
+	reports      Reports
+	rank         int
+	start, end   float64
+}
+
+// cpuEvent is the span of a CPU event, kept to find what the CPU was doing
+// while a GPU was idle or memory was allocated. Parent is the index of the
+// event it is nested in among the sorted events of its thread, or -1.
+type cpuEvent struct {
+	start, end float64
+	name       string
+	thread     threadID
+	parent     int
+}
+
+// launchEvent is a kernel launch call or the GPU activity it launched
+type launchEvent struct {
+	ts, dur  float64
+	name     string
+	pid, tid interface{}
+}
+
+// newTraceAnalyzer returns an analyzer aggregating operations by groupBy
+// for a trace of rank, or -1, computing the optional reports selected
+func newTraceAnalyzer(md *traceMetadata, groupBy GroupBy, rank int, reports Reports) *traceAnalyzer {
+	cpuOps := newSelfTimes(0)
+	if reports&ReportPython != 0 {
+		cpuOps.covered = make(map[threadID]intervals)
+	}
+	return &traceAnalyzer{
+		analysis: &TraceAnalysis{
+			CategoryStats:  make(map[string]CategoryStats),
+			OperationStats: make(map[string]OperationStats),
+			GroupBy:        groupBy,
+		},
+		md:           md,
+		threads:      make(map[threadID]*threadIntervals),
+		devices:      make(map[string]*deviceIntervals),
+		moments:      make(map[string]*durationMoments),
+		durations:    make(map[string][]int64),
+		launches:     make(map[int64]launchEvent),
+		gpuStarts:    make(map[int64]launchEvent),
+		transfers:    make(map[int64]transfer),
+		memcpyCalls:  make(map[int64]memcpyCall),
+		pythonFrames: make(map[threadID]intervals),
+		memory:       make(map[string][]memoryEvent),
+		steps:        make(map[int]TimeWindow),
+		kernels:      make(map[string]*OperationTime),
+		cpuOps:       cpuOps,
+		reports:      reports,
+		rank:         rank,
+		start:        math.Inf(1),
+		end:          math.Inf(-1),
+	}
+}
+
+// add accounts for the next event of the trace
+func (a *traceAnalyzer) add(e TraceEvent) {
+	analysis := a.analysis
+	analysis.TotalEvents++
+	if isMemoryEvent(e) {
+		if a.reports&ReportMemory != 0 {
+			a.addMemory(e)
+		}
+		return
+	}
+	if e.Ph != "X" {
+		return
+	}
+	analysis.CompleteEvents++
+	a.addLaunch(e)
+	if e.Dur <= 0 {
+		analysis.SkippedZeroDuration++
+		return
+	}
+
+	analysis.ConvertedEvents++
+	durNs := usToNs(e.Dur)
+	analysis.TotalTimeNs += durNs
+
+	// By category
+	cs := analysis.CategoryStats[e.Cat]
+	cs.Count++
+	cs.TimeNs += durNs
+	analysis.CategoryStats[e.Cat] = cs
+
+	// By operation
+	if group, label := analysis.GroupBy.groupKey(e, a.md); group != "" {
+		os := analysis.OperationStats[group]
+		if os.Count == 0 {
+			os.Category = e.Cat
+			os.Label = label
+		}
+		os.Count++
+		os.TimeNs += durNs
+		analysis.OperationStats[group] = os
+		m := a.moments[group]
+		if m == nil {
+			m = &durationMoments{}
+			a.moments[group] = m
+		}
+		m.add(durNs)
+		if a.reports&ReportPercentiles != 0 {
+			a.durations[group] = append(a.durations[group], durNs)
+		}
+	}
+
+	// By thread
+	key := threadID{pid: getTid(e.Pid), tid: getTid(e.Tid)}
+	t := a.threads[key]
+	if t == nil {
+		t = &threadIntervals{pid: e.Pid, tid: e.Tid}
+		a.threads[key] = t
+		a.threadOrder = append(a.threadOrder, key)
+	}
+	t.intervals = append(t.intervals, [2]float64{e.Ts, e.Ts + e.Dur})
+
+	// By GPU device
+	if isGPUEvent(e) {
+		device := gpuDevice(e)
+		d := a.devices[device]
+		if d == nil {
+			d = &deviceIntervals{stats: DeviceStats{Device: device}, streams: make(map[string]intervals)}
+			a.devices[device] = d
+			a.deviceOrder = append(a.deviceOrder, device)
+		}
+		if e.Cat == "kernel" {
+			d.stats.Kernels++
+			d.stats.KernelNs += durNs
+			a.precision.add(e.Name, durNs)
+			k := a.kernels[e.Name]
+			if k == nil {
+				k = &OperationTime{Name: e.Name}
+				a.kernels[e.Name] = k
+			}
+			k.Count++
+			k.SelfNs += durNs
+			k.TotalNs += durNs
+		} else {
+			d.stats.Memcpys++
+			d.stats.MemcpyNs += durNs
+			d.memcpy = append(d.memcpy, [2]float64{e.Ts, e.Ts + e.Dur})
+		}
+		d.intervals = append(d.intervals, [2]float64{e.Ts, e.Ts + e.Dur})
+		stream := idString(e.Tid)
+		d.streams[stream] = append(d.streams[stream], [2]float64{e.Ts, e.Ts + e.Dur})
+		if isCommunicationOp(e.Name) {
+			d.comm = append(d.comm, [2]float64{e.Ts, e.Ts + e.Dur})
+		} else if e.Cat == "kernel" {
+			d.compute = append(d.compute, [2]float64{e.Ts, e.Ts + e.Dur})
+		}
+	} else {
+		if a.reports&cpuEventReports != 0 {
+			a.cpuEvents = append(a.cpuEvents, cpuEvent{start: e.Ts, end: e.Ts + e.Dur, name: e.Name, thread: key})
+		}
+		if allocatorCalls[e.Name] && a.reports&ReportAllocator != 0 {
+			a.allocator = append(a.allocator, allocatorCall{ts: e.Ts, name: e.Name, thread: key, durNs: durNs})
+		}
+		if e.Cat == "cpu_op" {
+			a.cpuOps.add(key, e.Name, e.Ts, e.Dur)
+		}
+		if isDataLoaderEvent(e) {
+			a.dataLoader = append(a.dataLoader, [2]float64{e.Ts, e.Ts + e.Dur})
+		}
+		if e.Cat == "python_function" && a.reports&(ReportPython|ReportStepTrend) != 0 {
+			a.pythonFrames[key] = append(a.pythonFrames[key], [2]float64{e.Ts, e.Ts + e.Dur})
+		}
+	}
+	if a.reports&ReportTransfers != 0 {
+		a.addTransfer(e, key, durNs)
+	}
+	if step, ok := profilerStepNumber(e); ok {
+		w, seen := a.steps[step]
+		if !seen || e.Ts < w.Start {
+			w.Start = e.Ts
+		}
+		w.End = max(w.End, e.Ts+e.Dur)
+		a.steps[step] = w
+	}
+	a.start = min(a.start, e.Ts)
+	a.end = max(a.end, e.Ts+e.Dur)
+}
+
+// addLaunch records e if it is a kernel launch call or the GPU activity
+// of one, with the first activity of each correlation id
+func (a *traceAnalyzer) addLaunch(e TraceEvent) {
+	launch, gpu := isLaunchCall(e), isGPUEvent(e)
+	if !launch && !gpu {
+		return
+	}
+	id, ok := correlationID(e)
+	if !ok {
+		return
+	}
+	le := launchEvent{ts: e.Ts, dur: e.Dur, name: e.Name, pid: e.Pid, tid: e.Tid}
+	if launch {
+		a.launches[id] = le
+	} else if first, seen := a.gpuStarts[id]; !seen || e.Ts < first.ts {
+		a.gpuStarts[id] = le
+	}
+}
+
+// finish computes the statistics that need all events and returns the
+// analysis
+func (a *traceAnalyzer) finish() *TraceAnalysis {
+	analysis := a.analysis
+	analysis.UniqueOperations = len(analysis.OperationStats)
+	for name, m := range a.moments {
+		os := analysis.OperationStats[name]
+		os.MinNs, os.MaxNs = m.min, m.max
+		os.MeanNs = os.TimeNs / int64(m.n)
+		os.StdDevNs = m.stdDev()
+		if durs := a.durations[name]; len(durs) > 0 {
+			sort.Slice(durs, func(i, j int) bool { return durs[i] < durs[j] })
+			os.P50Ns = percentile(durs, 50)
+			os.P95Ns = percentile(durs, 95)
+			os.P99Ns = percentile(durs, 99)
+		}
+		analysis.OperationStats[name] = os
+	}
+	if analysis.ConvertedEvents > 0 {
+		analysis.SpanNs = usToNs(a.end - a.start)
+	}
+
+	for _, key := range a.threadOrder {
+		t := a.threads[key]
+		stats := ThreadStats{
+			Name:   a.md.rootFrame(t.pid, t.tid).name,
+			Pid:    idString(t.pid),
+			Tid:    idString(t.tid),
+			Events: len(t.intervals),
+			BusyNs: usToNs(t.intervals.union()),
+		}
+		if analysis.SpanNs > 0 {
+			stats.Utilization = float64(stats.BusyNs) / float64(analysis.SpanNs)
+		}
+		analysis.Threads = append(analysis.Threads, stats)
+	}
+	sort.SliceStable(analysis.Threads, func(i, j int) bool {
+		return analysis.Threads[i].BusyNs > analysis.Threads[j].BusyNs
+	})
+
+	// Shared by the reports attributing GPU idle time, memory, allocator
+	// calls and transfers to CPU events
+	cpu := a.cpuEventsByThread()
+	sort.Slice(a.deviceOrder, func(i, j int) bool { return lessID(a.deviceOrder[i], a.deviceOrder[j]) })
+	for _, device := range a.deviceOrder {
+		d := a.devices[device]
+		busy := d.intervals.merge()
+		d.stats.BusyNs = usToNs(busy.total())
+		d.stats.IdleNs = analysis.SpanNs - d.stats.BusyNs
+		if analysis.SpanNs > 0 {
+			d.stats.Utilization = float64(d.stats.BusyNs) / float64(analysis.SpanNs)
+		}
+		if a.reports&ReportIdleGaps != 0 {
+			d.stats.IdleGaps = orEmpty(a.idleGaps(busy, cpu))
+		}
+		analysis.Devices = append(analysis.Devices, d.stats)
+	}
+	analysis.LaunchLatency = a.launchLatency()
+	if a.reports&ReportMemory != 0 {
+		analysis.Memory = orEmpty(a.memoryStats(cpu))
+	}
+	analysis.CommOverlap = orEmpty(a.commOverlapStats())
+	if len(a.steps) > 0 {
+		var stepUs float64
+		for _, w := range a.steps {
+			stepUs += w.End - w.Start
+		}
+		analysis.Steps = len(a.steps)
+		analysis.StepNs = usToNs(stepUs / float64(len(a.steps)))
+	}
+	analysis.StreamConcurrency = a.streamConcurrencyStats()
+	analysis.DataLoader = orEmpty(a.dataLoaderStats())
+	if a.reports&ReportStepTrend != 0 {
+		analysis.StepCategories = orEmpty(a.stepCategoryTimes())
+		analysis.StepTrends = orEmpty(stepTrends(analysis.StepCategories))
+	}
+	analysis.Precision = a.precision.finish()
+	for _, k := range a.kernels {
+		analysis.Kernels = append(analysis.Kernels, *k)
+	}
+	sort.Slice(analysis.Kernels, func(i, j int) bool {
+		if analysis.Kernels[i].SelfNs != analysis.Kernels[j].SelfNs {
+			return analysis.Kernels[i].SelfNs > analysis.Kernels[j].SelfNs
+		}
+		return analysis.Kernels[i].Name < analysis.Kernels[j].Name
+	})
+	if len(a.cpuOps.counts) > 0 {
+		analysis.CPUOperators = a.cpuOps.finish()
+	}
+	if a.reports&ReportAllocator != 0 {
+		analysis.Allocator = a.allocatorStats(cpu)
+		if analysis.Allocator == nil {
+			analysis.Allocator = &AllocatorStats{Calls: []RuntimeCallStats{}, Sites: []AllocatorCallSites{}}
+		}
+	}
+	if a.reports&ReportTransfers != 0 {
+		analysis.Transfers = orEmpty(a.transferStats(cpu))
+	}
+	if a.reports&ReportPython != 0 {
+		analysis.PythonOverhead = orEmpty(a.pythonOverhead())
+	}
+	analysis.Recommendations = recommend(analysis)
+	return analysis
+}
+
+// orEmpty returns s, or an empty slice if s is nil, for the reports that
+// were computed but found nothing
+func orEmpty[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
+// launchLatency matches launch calls to the GPU activity they launched, or
+// returns nil if none match
+func (a *traceAnalyzer) launchLatency() *LaunchLatencyStats {
+	var launches []LaunchLatency
+	var callNs int64
+	for id, call := range a.launches {
+		gpu, ok := a.gpuStarts[id]
+		if !ok || gpu.ts <= call.ts {
+			continue
+		}
+		callNs += usToNs(call.dur)
+		launches = append(launches, LaunchLatency{
+			Call:      call.name,
+			Kernel:    gpu.name,
+			Thread:    a.md.rootFrame(call.pid, call.tid).name,
+			StartNs:   usToNs(call.ts - a.start),
+			LatencyNs: usToNs(gpu.ts - call.ts),
+		})
+	}
+	if len(launches) == 0 {
+		return nil
+	}
+	// Longest first, then in time order, as map order is random
+	sort.Slice(launches, func(i, j int) bool {
+		if launches[i].LatencyNs != launches[j].LatencyNs {
+			return launches[i].LatencyNs > launches[j].LatencyNs
+		}
+		if launches[i].StartNs != launches[j].StartNs {
+			return launches[i].StartNs < launches[j].StartNs
+		}
+		return launches[i].Kernel < launches[j].Kernel
+	})
+
+	latencies := make([]int64, len(launches))
+	stats := &LaunchLatencyStats{Launches: len(launches), CallNs: callNs}
+	for i, l := range launches {
+		latencies[len(launches)-1-i] = l.LatencyNs
+		stats.TotalNs += l.LatencyNs
+	}
+	stats.MinNs = latencies[0]
+	stats.MaxNs = latencies[len(latencies)-1]
+	stats.MeanNs = stats.TotalNs / int64(len(latencies))
+	stats.StdDevNs = stdDev(latencies)
+	stats.P50Ns = percentile(latencies, 50)
+	stats.P95Ns = percentile(latencies, 95)
+	stats.P99Ns = percentile(latencies, 99)
+	stats.Worst = append([]LaunchLatency(nil), launches[:min(len(launches), maxWorstLaunches)]...)
+	return stats
+}
+
+// idleGaps returns the longest gaps of the trace span not covered by the
+// disjoint intervals busy, longest first, with the CPU event active in
+// each, given the CPU events of each thread
+func (a *traceAnalyzer) idleGaps(busy intervals, cpu map[threadID][]cpuEvent) []IdleGap {
+	var gaps intervals
+	prev := a.start
+	for _, iv := range append(busy, [2]float64{a.end, a.end}) {
+		if iv[0] > prev {
+			gaps = append(gaps, [2]float64{prev, iv[0]})
+		}
+		prev = max(prev, iv[1])
+	}
+	sort.SliceStable(gaps, func(i, j int) bool { return gaps[i][1]-gaps[i][0] > gaps[j][1]-gaps[j][0] })
+
+	var result []IdleGap
+	for _, gap := range gaps[:min(len(gaps), maxIdleGaps)] {
+		result = append(result, IdleGap{
+			StartNs: usToNs(gap[0] - a.start),
+			DurNs:   usToNs(gap[1] - gap[0]),
+			CPUOp:   cpuEventAt(cpu, (gap[0]+gap[1])/2),
+		})
+	}
+	return result
+}
+
+// cpuEventAt returns the name of the latest started CPU event running at
+// ts, or "" if the CPU was idle too, given the CPU events of each thread
+// sorted by start
+func cpuEventAt(byThread map[threadID][]cpuEvent, ts float64) string {
+	var found *cpuEvent
+	for _, events := range byThread {
+		// Any event running at ts holds the last one started by then
+		i := sort.Search(len(events), func(i int) bool { return events[i].start > ts }) - 1
+		for i >= 0 && events[i].end <= ts {
+			i = events[i].parent
+		}
+		if i < 0 {
+			continue
+		}
+		if e := &events[i]; found == nil || e.start > found.start || e.start == found.start && e.name < found.name {
+			found = e
+		}
+	}
+	if found == nil {
+		return ""
+	}
+	return found.name
+}
+
+// threadIntervals collects the intervals of one thread's events
+type threadIntervals struct {
+	pid, tid  interface{}
+	intervals intervals
+}
+
+// deviceIntervals collects the GPU activity of one device: all of it, its
+// communication and compute kernels, its memory copies and sets, and the
+// activity of each stream
+type deviceIntervals struct {
+	stats     DeviceStats
+	intervals intervals
+	comm      intervals
+	compute   intervals
+	memcpy    intervals
+	streams   map[string]intervals
+}
+
+// intervals is a list of [start, end) event intervals in microseconds
+type intervals [][2]float64
+
+// union returns the time covered by at least one interval, in microseconds
+func (ivs intervals) union() float64 {
+	return ivs.merge().total()
+}
+
+// clip returns the parts of disjoint sorted intervals inside window
+func (ivs intervals) clip(window TimeWindow) intervals {
+	if window.IsZero() {
+		return ivs
+	}
+	var clipped intervals
+	for _, iv := range ivs {
+		start, end := max(iv[0], window.Start), min(iv[1], window.End)
+		if start < end {
+			clipped = append(clipped, [2]float64{start, end})
+		}
+	}
+	return clipped
+}
+
+// total returns the summed length of disjoint intervals, in microseconds
+func (ivs intervals) total() float64 {
+	var busy float64
+	for _, iv := range ivs {
+		busy += iv[1] - iv[0]
+	}
+	return busy
+}
+
+// merge sorts the intervals and joins overlapping ones, returning disjoint
+// intervals in time order
+func (ivs intervals) merge() intervals {
+	sort.Slice(ivs, func(i, j int) bool { return ivs[i][0] < ivs[j][0] })
+	var merged intervals
+	for _, iv := range ivs {
+		if n := len(merged); n > 0 && iv[0] <= merged[n-1][1] {
+			merged[n-1][1] = max(merged[n-1][1], iv[1])
+			continue
+		}
+		merged = append(merged, iv)
+	}
+	return merged
+}
+
+// subtract returns the parts of disjoint sorted intervals outside the
+// disjoint sorted intervals of other
+func (ivs intervals) subtract(other intervals) intervals {
+	var result intervals
+	j := 0
+	for _, iv := range ivs {
+		start := iv[0]
+		for j < len(other) && other[j][1] <= start {
+			j++
+		}
+		for k := j; k < len(other) && other[k][0] < iv[1]; k++ {
+			if other[k][0] > start {
+				result = append(result, [2]float64{start, other[k][0]})
+			}
+			start = max(start, other[k][1])
+		}
+		if start < iv[1] {
+			result = append(result, [2]float64{start, iv[1]})
+		}
+	}
+	return result
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted values
+func percentile(sorted []int64, p int) int64 {
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// stdDev returns the population standard deviation of values
+func stdDev(values []int64) int64 {
+	var sum float64
+	for _, v := range values {
+		sum += float64(v)
+	}
+	mean := sum / float64(len(values))
+	var squares float64
+	for _, v := range values {
+		d := float64(v) - mean
+		squares += d * d
+	}
+	return int64(math.Round(math.Sqrt(squares / float64(len(values)))))
+}
+
+// durationMoments holds the running minimum, maximum, mean and sum of
+// squared deviations of durations, updated with Welford's method so that
+// they need not be kept
+type durationMoments struct {
+	n        int
+	min, max int64
+	mean, m2 float64
+}
+
+// add accounts for a duration
+func (m *durationMoments) add(ns int64) {
+	if m.n == 0 || ns < m.min {
+		m.min = ns
+	}
+	if m.n == 0 || ns > m.max {
+		m.max = ns
+	}
+	m.n++
+	d := float64(ns) - m.mean
+	m.mean += d / float64(m.n)
+	m.m2 += d * (float64(ns) - m.mean)
+}
+
+// stdDev returns the population standard deviation of the durations
+func (m *durationMoments) stdDev() int64 {
+	return int64(math.Round(math.Sqrt(m.m2 / float64(m.n))))
+}
+
+// usToNs converts a trace duration in microseconds to nanoseconds, rounding
+// rather than truncating so fractional microseconds are not undercounted
+func usToNs(us float64) int64 {
+	return int64(math.Round(us * 1000))
+}
+
+// CategoryEntry is a helper for sorting categories
+type CategoryEntry struct {
+	Name   string
+	Count  int
+	TimeNs int64
+}
+
+// GetSortedCategories returns categories sorted by time descending
+func (a *TraceAnalysis) GetSortedCategories() []CategoryEntry {
+	entries := make([]CategoryEntry, 0, len(a.CategoryStats))
+	for name, s := range a.CategoryStats {
+		entries = append(entries, CategoryEntry{name, s.Count, s.TimeNs})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].TimeNs > entries[j].TimeNs })
+	return entries
+}
+
+// OperationEntry is a helper for sorting operations
+type OperationEntry struct {
+	Name   string
+	Label  string
+	Count  int
+	TimeNs int64
+}
+
+// GetSortedOperations returns operations sorted by time descending
+func (a *TraceAnalysis) GetSortedOperations() []OperationEntry {
+	entries := make([]OperationEntry, 0, len(a.OperationStats))
+	for name, s := range a.OperationStats {
+		entries = append(entries, OperationEntry{name, s.Label, s.Count, s.TimeNs})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].TimeNs > entries[j].TimeNs })
+	return entries
+}
@@ -0,0 +1,221 @@
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeTraceThreads(t *testing.T) {
+	analysis := AnalyzeTrace(&TraceData{TraceEvents: []TraceEvent{
+		{Ph: "M", Name: "thread_name", Pid: float64(1), Tid: float64(2), Args: map[string]interface{}{"name": "worker"}},
+		span("forward", 0, 100),
+		span("matmul", 10, 20),
+		threadSpan("load", "", 2, 0, 10),
+		threadSpan("load", "", 2, 150, 50),
+	}})
+
+	if analysis.SpanNs != 200000 {
+		t.Errorf("Expected span 200us, got %dns", analysis.SpanNs)
+	}
+	if len(analysis.Threads) != 2 {
+		t.Fatalf("Expected 2 threads, got %+v", analysis.Threads)
+	}
+	// Nested events count once towards busy time
+	if th := analysis.Threads[0]; th.Tid != "1" || th.Events != 2 || th.BusyNs != 100000 || th.Utilization != 0.5 {
+		t.Errorf("Unexpected first thread: %+v", th)
+	}
+	if th := analysis.Threads[1]; !strings.Contains(th.Name, "worker") || th.BusyNs != 60000 || th.Utilization != 0.3 {
+		t.Errorf("Unexpected second thread: %+v", th)
+	}
+}
+
+func TestAnalyzeTraceDevices(t *testing.T) {
+	onDevice := func(e TraceEvent, device float64) TraceEvent {
+		e.Pid = float64(1)
+		return withArgs(e, map[string]interface{}{"device": device})
+	}
+	process := func(e TraceEvent) TraceEvent {
+		e.Pid = float64(100)
+		return e
+	}
+	traceData := &TraceData{TraceEvents: []TraceEvent{
+		process(cpuOp("step", 0, 100)),
+		process(cpuOp("forward", 40, 20)),
+		process(cpuOp("aten::mm", 42, 3)),
+		process(cpuOp("backward", 62, 28)),
+		process(cpuOp("aten::add", 64, 2)),
+		process(threadSpan("loader", "cpu_op", 2, 6, 89)),
+		onDevice(gpuEvent("gemm", "kernel", 7, 10, 20), 1),
+		onDevice(gpuEvent("gemm", "kernel", 8, 20, 20), 1),
+		gpuEvent("Memcpy HtoD", "gpu_memcpy", 7, 50, 10),
+	}}
+	analysis := AnalyzeTrace(traceData)
+
+	if len(analysis.Devices) != 2 {
+		t.Fatalf("Expected 2 devices, got %+v", analysis.Devices)
+	}
+	if d := analysis.Devices[0]; d.Device != "0" || d.Memcpys != 1 || d.MemcpyNs != 10000 || d.IdleNs != 90000 {
+		t.Errorf("Unexpected device 0: %+v", d)
+	}
+	// Overlapping kernels on two streams count once towards busy time
+	if d := analysis.Devices[1]; d.Kernels != 2 || d.KernelNs != 40000 || d.BusyNs != 30000 || d.Utilization != 0.3 {
+		t.Errorf("Unexpected device 1: %+v", d)
+	}
+	// Idle gaps cover the span outside the device's events, longest
+	// first, with the latest started CPU event still running in between
+	want := []IdleGap{{StartNs: 40000, DurNs: 60000, CPUOp: "backward"}, {StartNs: 0, DurNs: 10000, CPUOp: "step"}}
+	if gaps := analysis.Devices[1].IdleGaps; !reflect.DeepEqual(gaps, want) {
+		t.Errorf("Expected idle gaps %+v, got %+v", want, gaps)
+	}
+
+	analysis, err := AnalyzeTraceReports(context.Background(), traceData, GroupByName, 0)
+	if err != nil || analysis.Devices[1].IdleGaps != nil || analysis.Devices[1].BusyNs != 30000 {
+		t.Errorf("Expected device statistics without idle gaps, got %+v, %v", analysis.Devices, err)
+	}
+}
+
+func TestAnalyzeTracePercentiles(t *testing.T) {
+	var events []TraceEvent
+	for i := 1; i <= 100; i++ {
+		events = append(events, TraceEvent{Ph: "X", Name: "all_reduce", Ts: float64(i * 1000), Dur: float64(i)})
+	}
+	stats := AnalyzeTrace(&TraceData{TraceEvents: events}).OperationStats["all_reduce"]
+
+	if stats.MinNs != 1000 || stats.MaxNs != 100000 || stats.MeanNs != 50500 {
+		t.Errorf("Unexpected min/max/mean: %+v", stats)
+	}
+	if stats.P50Ns != 50000 || stats.P95Ns != 95000 || stats.P99Ns != 99000 {
+		t.Errorf("Unexpected percentiles: %+v", stats)
+	}
+	// Population standard deviation of 1..100us: sqrt((100^2-1)/12)
+	if stats.StdDevNs != 28866 {
+		t.Errorf("Expected a standard deviation of 28866ns, got %d", stats.StdDevNs)
+	}
+
+	// A single slow outlier shows in the spread, not the median
+	events = []TraceEvent{{Ph: "X", Name: "all_gather", Dur: 1000}}
+	for i := range 99 {
+		events = append(events, TraceEvent{Ph: "X", Name: "all_gather", Ts: float64(i + 1), Dur: 10})
+	}
+	stats = AnalyzeTrace(&TraceData{TraceEvents: events}).OperationStats["all_gather"]
+	if stats.P50Ns != 10000 || stats.MaxNs != 1000000 || stats.StdDevNs != 98504 {
+		t.Errorf("Expected the outlier in max and stddev only, got %+v", stats)
+	}
+
+	// Without percentiles, durations are not kept but the rest is the same
+	analysis, err := AnalyzeTraceReports(context.Background(), &TraceData{TraceEvents: events}, GroupByName, 0)
+	want := stats
+	want.P50Ns, want.P95Ns, want.P99Ns = 0, 0, 0
+	if err != nil || analysis.OperationStats["all_gather"] != want {
+		t.Errorf("Expected %+v without percentiles, got %+v, %v", want, analysis.OperationStats["all_gather"], err)
+	}
+}
+
+func TestAnalyzeTraceEmptyReports(t *testing.T) {
+	traceData := &TraceData{TraceEvents: []TraceEvent{cpuOp("aten::mm", 0, 10), gpuKernel("gemm", 2, 5)}}
+	fields := func(reports Reports) map[string]json.RawMessage {
+		analysis, err := AnalyzeTraceReports(context.Background(), traceData, GroupByName, reports)
+		if err != nil {
+			t.Fatalf("AnalyzeTraceReports: %v", err)
+		}
+		data, err := json.Marshal(analysis)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		return m
+	}
+
+	// Requested reports without events in the trace are empty
+	all := fields(AllReports)
+	for _, key := range []string{"memory", "comm_overlap", "dataloader", "transfers", "python_overhead", "step_categories", "step_trends"} {
+		if string(all[key]) != "[]" {
+			t.Errorf("Expected an empty %s, got %s", key, all[key])
+		}
+	}
+	var allocator AllocatorStats
+	if err := json.Unmarshal(all["allocator"], &allocator); err != nil || allocator.Count != 0 || allocator.Calls == nil {
+		t.Errorf("Expected empty allocator statistics, got %s", all["allocator"])
+	}
+	var devices []DeviceStats
+	if err := json.Unmarshal(all["devices"], &devices); err != nil || len(devices) != 1 || devices[0].IdleGaps == nil {
+		t.Errorf("Expected a device with idle gaps, got %s", all["devices"])
+	}
+
+	// Reports left out are null
+	none := fields(0)
+	for _, key := range []string{"memory", "allocator", "transfers", "python_overhead", "step_categories", "step_trends"} {
+		if raw, ok := none[key]; !ok || string(raw) != "null" {
+			t.Errorf("Expected a null %s, got %s", key, raw)
+		}
+	}
+}
+
+func TestAnalyzeTraceBy(t *testing.T) {
+	inputDims := func(e TraceEvent, dims ...float64) TraceEvent {
+		var shape []interface{}
+		for _, d := range dims {
+			shape = append(shape, d)
+		}
+		return withArgs(e, map[string]interface{}{"Input Dims": []interface{}{shape}})
+	}
+	traceData := &TraceData{TraceEvents: []TraceEvent{
+		inputDims(cpuOp("aten::mm", 0, 10), 2, 3),
+		inputDims(cpuOp("aten::mm", 20, 30), 64, 64),
+		withArgs(gpuKernel("gemm", 5, 5), map[string]interface{}{"device": float64(0), "stream": float64(7)}),
+	}}
+
+	tests := []struct {
+		groupBy GroupBy
+		want    map[string]int64
+	}{
+		{GroupByName, map[string]int64{"aten::mm": 40000, "gemm": 5000}},
+		{GroupByCategory, map[string]int64{"cpu_op": 40000, "kernel": 5000}},
+		{GroupByNameShape, map[string]int64{"aten::mm [[2,3]]": 10000, "aten::mm [[64,64]]": 30000, "gemm": 5000}},
+		{GroupByStream, map[string]int64{"GPU 0 stream 7": 5000}},
+		{GroupByThread, map[string]int64{"1/1": 40000, "0/7": 5000}},
+	}
+	for _, tt := range tests {
+		analysis := AnalyzeTraceBy(traceData, tt.groupBy)
+		got := make(map[string]int64)
+		for name, s := range analysis.OperationStats {
+			got[name] = s.TimeNs
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.groupBy, tt.want, got)
+			continue
+		}
+		for name, ns := range tt.want {
+			if got[name] != ns {
+				t.Errorf("%s: expected %v, got %v", tt.groupBy, tt.want, got)
+				break
+			}
+		}
+	}
+
+	// Threads sharing a name, in one process or two, stay apart
+	named := &TraceData{TraceEvents: []TraceEvent{
+		{Ph: "M", Name: "thread_name", Pid: float64(1), Tid: float64(1), Args: map[string]interface{}{"name": "worker"}},
+		{Ph: "M", Name: "thread_name", Pid: float64(1), Tid: float64(2), Args: map[string]interface{}{"name": "worker"}},
+		span("a", 0, 10),
+		threadSpan("b", "", 2, 0, 20),
+	}}
+	stats := AnalyzeTraceBy(named, GroupByThread).OperationStats
+	want := map[string]OperationStats{
+		"1/1": {Label: "process (pid 1, tid worker)", Count: 1, TimeNs: 10000, MinNs: 10000, MaxNs: 10000, MeanNs: 10000, P50Ns: 10000, P95Ns: 10000, P99Ns: 10000},
+		"1/2": {Label: "process (pid 1, tid worker)", Count: 1, TimeNs: 20000, MinNs: 20000, MaxNs: 20000, MeanNs: 20000, P50Ns: 20000, P95Ns: 20000, P99Ns: 20000},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("Expected %+v, got %+v", want, stats)
+	}
+
+	if _, err := ParseGroupBy("shape"); err == nil {
+		t.Error("Expected error for unknown group-by")
+	}
+}
@@ -0,0 +1,64 @@
+package converter
+
+import "slices"
+
+// usedArgs are the event args read by DefaultEventMapper, the analyses and
+// the trace metadata
+var usedArgs = []string{
+	"correlation", "device", "stream", "bytes", // GPU events
+	"grid", "block", "registers per thread", "shared memory", "est. achieved occupancy %", // Kernel launches
+	"Input Dims", "flops", // Operators recorded with shapes
+	"Device Type", "Device Id", "Bytes", "Total Allocated", "Total Reserved", // [memory] events
+	"name", "labels", // Process and thread metadata
+}
+
+// UsedArgs returns the event args that conversion with the default event
+// mappers and analysis read, to load traces with LoadOptions.Args when
+// nothing else reads their args
+func UsedArgs() []string {
+	return slices.Clone(usedArgs)
+}
+
+// ArgString returns the string argument key of the event
+func (e TraceEvent) ArgString(key string) (string, bool) {
+	s, ok := e.Args[key].(string)
+	return s, ok
+}
+
+// ArgNumber returns the numeric argument key of the event
+func (e TraceEvent) ArgNumber(key string) (float64, bool) {
+	return numberArg(e.Args[key])
+}
+
+// ArgInt returns the numeric argument key of the event truncated to an
+// integer, e.g. a correlation id, stream or byte count
+func (e TraceEvent) ArgInt(key string) (int64, bool) {
+	v, ok := numberArg(e.Args[key])
+	return int64(v), ok
+}
+
+// InputDims returns the shapes of the operator's inputs from the
+// "Input Dims" argument recorded with record_shapes=True. Inputs that are
+// not tensors have empty shapes.
+func (e TraceEvent) InputDims() ([][]int64, bool) {
+	inputs, ok := e.Args["Input Dims"].([]interface{})
+	if !ok {
+		return nil, false
+	}
+	shapes := make([][]int64, len(inputs))
+	for i, input := range inputs {
+		dims, ok := input.([]interface{})
+		if !ok {
+			return nil, false
+		}
+		shapes[i] = make([]int64, len(dims))
+		for j, d := range dims {
+			n, ok := numberArg(d)
+			if !ok {
+				return nil, false
+			}
+			shapes[i][j] = int64(n)
+		}
+	}
+	return shapes, true
+}
@@ -0,0 +1,122 @@
+package converter
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RegressionCheck compares one time of a candidate run with its baseline.
+// Change is relative to the baseline, 0.05 for 5% slower, and +Inf for a
+// time missing from the baseline. The check fails when Change exceeds
+// MaxChange or, for operations with a time budget, when CandidateNs
+// exceeds MaxNs.
+type RegressionCheck struct {
+	Name        string
+	BaselineNs  int64
+	CandidateNs int64
+	Change      float64
+	MaxChange   float64
+	MaxNs       int64
+	Regressed   bool
+}
+
+// OpBudget limits the time of one operation, by OperationStats key: to at
+// most MaxNs when it is set, otherwise to a MaxChange slowdown relative to
+// the baseline
+type OpBudget struct {
+	Name      string
+	MaxNs     int64
+	MaxChange float64
+}
+
+// ParseOpBudget parses a budget such as "aten::mm=10ms" for at most 10ms
+// in aten::mm, or "aten::mm=10%" for at most 10% slower than the baseline
+func ParseOpBudget(s string) (OpBudget, error) {
+	i := strings.LastIndex(s, "=")
+	if i <= 0 {
+		return OpBudget{}, fmt.Errorf("invalid budget %q (want OP=DURATION or OP=PERCENT, such as aten::mm=10ms)", s)
+	}
+	budget := OpBudget{Name: s[:i]}
+	if d, err := time.ParseDuration(s[i+1:]); err == nil && d > 0 {
+		budget.MaxNs = d.Nanoseconds()
+		return budget, nil
+	}
+	change, err := ParseRegression(s[i+1:])
+	if err != nil {
+		return OpBudget{}, fmt.Errorf("invalid budget %q: want a positive duration or a regression such as 10%%", s)
+	}
+	budget.MaxChange = change
+	return budget, nil
+}
+
+// ParseRegression parses a relative regression such as "5%" or "0.05"
+func ParseRegression(s string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || v < 0 || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("invalid regression %q (want a percentage such as 5%% or a fraction such as 0.05)", s)
+	}
+	if strings.HasSuffix(s, "%") {
+		v /= 100
+	}
+	return v, nil
+}
+
+// CheckRegressions compares candidate with baseline: wall time (the span
+// of the trace), mean ProfilerStep time when both record steps, and the
+// time of each operation of either that ops matches, unless ops is nil.
+// Each may regress by at most maxRegression. Operations with a budget are
+// checked against it instead, whether ops matches them or not. Operations
+// are compared by OperationStats key, so both analyses should group the
+// same way.
+func CheckRegressions(baseline, candidate *TraceAnalysis, maxRegression float64, ops *regexp.Regexp, budgets []OpBudget) []RegressionCheck {
+	check := func(name string, base, cand int64, budget OpBudget) RegressionCheck {
+		c := RegressionCheck{Name: name, BaselineNs: base, CandidateNs: cand, MaxChange: budget.MaxChange, MaxNs: budget.MaxNs}
+		switch {
+		case base > 0:
+			c.Change = float64(cand-base) / float64(base)
+		case cand > 0:
+			c.Change = math.Inf(1)
+		}
+		if c.MaxNs > 0 {
+			c.Regressed = cand > c.MaxNs
+		} else {
+			c.Regressed = c.Change > c.MaxChange
+		}
+		return c
+	}
+	limit := OpBudget{MaxChange: maxRegression}
+
+	checks := []RegressionCheck{check("wall time", baseline.SpanNs, candidate.SpanNs, limit)}
+	if baseline.Steps > 0 && candidate.Steps > 0 {
+		checks = append(checks, check("step time", baseline.StepNs, candidate.StepNs, limit))
+	}
+	names := make(map[string]OpBudget)
+	if ops != nil {
+		for _, stats := range []map[string]OperationStats{baseline.OperationStats, candidate.OperationStats} {
+			for name := range stats {
+				if ops.MatchString(name) {
+					names[name] = limit
+				}
+			}
+		}
+	}
+	for _, b := range budgets {
+		names[b.Name] = b
+	}
+	var opChecks []RegressionCheck
+	for name, budget := range names {
+		opChecks = append(opChecks, check(name, baseline.OperationStats[name].TimeNs, candidate.OperationStats[name].TimeNs, budget))
+	}
+	sort.Slice(opChecks, func(i, j int) bool {
+		if opChecks[i].Change != opChecks[j].Change {
+			return opChecks[i].Change > opChecks[j].Change
+		}
+		return opChecks[i].Name < opChecks[j].Name
+	})
+	return append(checks, opChecks...)
+}
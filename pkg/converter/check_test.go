@@ -0,0 +1,81 @@
+package converter
+
+import (
+	"math"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestCheckRegressions(t *testing.T) {
+	run := func(stepDur, mmDur float64, extra ...TraceEvent) *TraceAnalysis {
+		events := []TraceEvent{
+			span("ProfilerStep#1", 0, stepDur),
+			span("aten::mm", 0, mmDur),
+		}
+		return AnalyzeTrace(&TraceData{TraceEvents: append(events, extra...)})
+	}
+	baseline := run(100, 50)
+	if baseline.Steps != 1 || baseline.StepNs != 100000 {
+		t.Errorf("Expected 1 step of 100us, got %d of %dns", baseline.Steps, baseline.StepNs)
+	}
+
+	// A new nested op makes no difference to wall time
+	candidate := run(102, 60, span("aten::add", 70, 1))
+	checks := CheckRegressions(baseline, candidate, 0.05, regexp.MustCompile(`^aten::`), nil)
+	want := []RegressionCheck{
+		{Name: "wall time", BaselineNs: 100000, CandidateNs: 102000, Change: 0.02, MaxChange: 0.05},
+		{Name: "step time", BaselineNs: 100000, CandidateNs: 102000, Change: 0.02, MaxChange: 0.05},
+		{Name: "aten::add", CandidateNs: 1000, Change: math.Inf(1), MaxChange: 0.05, Regressed: true},
+		{Name: "aten::mm", BaselineNs: 50000, CandidateNs: 60000, Change: 0.2, MaxChange: 0.05, Regressed: true},
+	}
+	if !reflect.DeepEqual(checks, want) {
+		t.Errorf("Expected %+v, got %+v", want, checks)
+	}
+	if checks := CheckRegressions(baseline, run(100, 50), 0.05, nil, nil); len(checks) != 2 || checks[0].Regressed || checks[1].Regressed {
+		t.Errorf("Expected no regression against an identical run, got %+v", checks)
+	}
+
+	// Budgets replace the global limit for their operation
+	budgets := []OpBudget{{Name: "aten::mm", MaxChange: 0.25}, {Name: "aten::add", MaxNs: 2000}}
+	checks = CheckRegressions(baseline, candidate, 0.05, regexp.MustCompile(`^aten::`), budgets)
+	want = []RegressionCheck{
+		{Name: "wall time", BaselineNs: 100000, CandidateNs: 102000, Change: 0.02, MaxChange: 0.05},
+		{Name: "step time", BaselineNs: 100000, CandidateNs: 102000, Change: 0.02, MaxChange: 0.05},
+		{Name: "aten::add", CandidateNs: 1000, Change: math.Inf(1), MaxNs: 2000},
+		{Name: "aten::mm", BaselineNs: 50000, CandidateNs: 60000, Change: 0.2, MaxChange: 0.25},
+	}
+	if !reflect.DeepEqual(checks, want) {
+		t.Errorf("Expected %+v, got %+v", want, checks)
+	}
+	if checks := CheckRegressions(baseline, candidate, 0.05, nil, []OpBudget{{Name: "aten::mm", MaxNs: 55000}}); len(checks) != 3 || !checks[2].Regressed {
+		t.Errorf("Expected aten::mm over its 55us budget, got %+v", checks)
+	}
+
+	for s, want := range map[string]OpBudget{
+		"aten::mm=10ms":       {Name: "aten::mm", MaxNs: 10_000_000},
+		"aten::mm=10%":        {Name: "aten::mm", MaxChange: 0.1},
+		"a=b=1.5us":           {Name: "a=b", MaxNs: 1500},
+		"nccl:all_reduce=0.2": {Name: "nccl:all_reduce", MaxChange: 0.2},
+	} {
+		if got, err := ParseOpBudget(s); err != nil || got != want {
+			t.Errorf("%q: expected %+v, got %+v, %v", s, want, got, err)
+		}
+	}
+	for _, s := range []string{"aten::mm", "=10ms", "aten::mm=", "aten::mm=-1ms", "aten::mm=fast"} {
+		if _, err := ParseOpBudget(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+
+	for s, want := range map[string]float64{"5%": 0.05, "0.05": 0.05, "0": 0, "12.5%": 0.125} {
+		if got, err := ParseRegression(s); err != nil || math.Abs(got-want) > 1e-12 {
+			t.Errorf("%q: expected %v, got %v, %v", s, want, got, err)
+		}
+	}
+	for _, s := range []string{"", "-5%", "five", "%"} {
+		if _, err := ParseRegression(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package converter
+
+import (
+	"sort"
+	"strings"
+)
+
+// allocatorCalls lists the CUDA runtime calls of the caching allocator
+// reserving, releasing or sizing device memory, which a warmed-up
+// allocator serves from its cache instead
+var allocatorCalls = map[string]bool{
+	"cudaMalloc":      true,
+	"cudaFree":        true,
+	"cudaMallocAsync": true,
+	"cudaFreeAsync":   true,
+	"cudaMemGetInfo":  true,
+}
+
+// minAllocatorCalls is the number of allocator calls per step from which
+// the caching allocator is thrashing
+const minAllocatorCalls = 10
+
+// maxAllocatorSites is the number of Sites reported
+const maxAllocatorSites = 10
+
+// AllocatorStats counts the cudaMalloc, cudaFree and cudaMemGetInfo calls
+// of a trace. The caching allocator normally makes them only while warming
+// up, so many of them per step mean it is thrashing: freeing cached blocks
+// and reserving them again, as after torch.cuda.empty_cache() or with
+// fragmented memory. Without ProfilerSteps, CallsPerStep counts the whole
+// trace, which warming up alone can fill, so Thrashing is never set. Sites
+// are the CPU stacks making the most calls.
+type AllocatorStats struct {
+	Count        int                  `json:"count"`
+	TimeNs       int64                `json:"time_ns"`
+	CallsPerStep float64              `json:"calls_per_step"`
+	Thrashing    bool                 `json:"thrashing"`
+	Calls        []RuntimeCallStats   `json:"calls"`
+	Sites        []AllocatorCallSites `json:"sites"`
+}
+
+// RuntimeCallStats is the count and time of one CUDA runtime call
+type RuntimeCallStats struct {
+	Name   string `json:"name"`
+	Count  int    `json:"count"`
+	TimeNs int64  `json:"time_ns"`
+}
+
+// AllocatorCallSites are the allocator calls made under one stack of CPU
+// events, outermost first
+type AllocatorCallSites struct {
+	Stack  []string `json:"stack"`
+	Count  int      `json:"count"`
+	TimeNs int64    `json:"time_ns"`
+}
+
+// allocatorCall is a call to the CUDA allocator on a CPU thread
+type allocatorCall struct {
+	ts     float64
+	name   string
+	thread threadID
+	durNs  int64
+}
+
+// allocatorStats returns the allocator calls of the trace, or nil if it
+// has none, given the CPU events of each thread
+func (a *traceAnalyzer) allocatorStats(cpu map[threadID][]cpuEvent) *AllocatorStats {
+	if len(a.allocator) == 0 {
+		return nil
+	}
+	stats := &AllocatorStats{Count: len(a.allocator)}
+	calls := make(map[string]*RuntimeCallStats)
+	byThread := make(map[threadID][]allocatorCall)
+	for _, c := range a.allocator {
+		stats.TimeNs += c.durNs
+		call := calls[c.name]
+		if call == nil {
+			call = &RuntimeCallStats{Name: c.name}
+			calls[c.name] = call
+		}
+		call.Count++
+		call.TimeNs += c.durNs
+		byThread[c.thread] = append(byThread[c.thread], c)
+	}
+	stats.CallsPerStep = float64(stats.Count) / float64(max(len(a.steps), 1))
+	stats.Thrashing = len(a.steps) > 0 && stats.CallsPerStep >= minAllocatorCalls
+	for _, call := range calls {
+		stats.Calls = append(stats.Calls, *call)
+	}
+	sort.Slice(stats.Calls, func(i, j int) bool {
+		if stats.Calls[i].Count != stats.Calls[j].Count {
+			return stats.Calls[i].Count > stats.Calls[j].Count
+		}
+		return stats.Calls[i].Name < stats.Calls[j].Name
+	})
+
+	sites := make(map[string]*AllocatorCallSites)
+	for thread, threadCalls := range byThread {
+		sort.SliceStable(threadCalls, func(i, j int) bool { return threadCalls[i].ts < threadCalls[j].ts })
+		eachStack(cpu[thread], len(threadCalls), func(i int) float64 { return threadCalls[i].ts }, func(i int, stack []cpuEvent) {
+			var names []string
+			for _, e := range stack {
+				if !allocatorCalls[e.name] { // The call itself
+					names = append(names, e.name)
+				}
+			}
+			key := strings.Join(names, "\x00")
+			site := sites[key]
+			if site == nil {
+				site = &AllocatorCallSites{Stack: names}
+				sites[key] = site
+			}
+			site.Count++
+			site.TimeNs += threadCalls[i].durNs
+		})
+	}
+	for _, site := range sites {
+		stats.Sites = append(stats.Sites, *site)
+	}
+	sort.Slice(stats.Sites, func(i, j int) bool {
+		if stats.Sites[i].Count != stats.Sites[j].Count {
+			return stats.Sites[i].Count > stats.Sites[j].Count
+		}
+		return strings.Join(stats.Sites[i].Stack, "\x00") < strings.Join(stats.Sites[j].Stack, "\x00")
+	})
+	stats.Sites = stats.Sites[:min(len(stats.Sites), maxAllocatorSites)]
+	return stats
+}
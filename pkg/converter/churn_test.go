@@ -0,0 +1,53 @@
+package converter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeTraceAllocator(t *testing.T) {
+	events := []TraceEvent{
+		span("ProfilerStep#1", 0, 1000),
+		cpuOp("aten::empty", 100, 100),
+		runtimeCall("cudaMalloc", 120, 10),
+		runtimeCall("cudaMemGetInfo", 150, 10),
+		cpuOp("aten::mm", 300, 100),
+		runtimeCall("cudaMalloc", 320, 10),
+		runtimeCall("cudaFree", 600, 10),
+		runtimeCall("cudaLaunchKernel", 700, 10),
+	}
+	for i := range 20 {
+		events = append(events, runtimeCall("cudaFree", 1100+float64(20*i), 10))
+	}
+	analysis := AnalyzeTrace(&TraceData{TraceEvents: events})
+
+	c := analysis.Allocator
+	if c == nil || c.Count != 24 || c.TimeNs != 240000 || c.CallsPerStep != 24 || !c.Thrashing {
+		t.Fatalf("Unexpected allocator stats %+v", c)
+	}
+	wantCalls := []RuntimeCallStats{
+		{Name: "cudaFree", Count: 21, TimeNs: 210000},
+		{Name: "cudaMalloc", Count: 2, TimeNs: 20000},
+		{Name: "cudaMemGetInfo", Count: 1, TimeNs: 10000},
+	}
+	if !reflect.DeepEqual(c.Calls, wantCalls) {
+		t.Errorf("Expected calls %+v, got %+v", wantCalls, c.Calls)
+	}
+	wantSites := []AllocatorCallSites{
+		{Stack: nil, Count: 20, TimeNs: 200000},
+		{Stack: []string{"ProfilerStep#1", "aten::empty"}, Count: 2, TimeNs: 20000},
+		{Stack: []string{"ProfilerStep#1"}, Count: 1, TimeNs: 10000},
+		{Stack: []string{"ProfilerStep#1", "aten::mm"}, Count: 1, TimeNs: 10000},
+	}
+	if !reflect.DeepEqual(c.Sites, wantSites) {
+		t.Errorf("Expected sites %+v, got %+v", wantSites, c.Sites)
+	}
+
+	if a := AnalyzeTrace(&TraceData{TraceEvents: events[:4]}); a.Allocator == nil || a.Allocator.Thrashing {
+		t.Errorf("Expected allocator calls without thrashing, got %+v", a.Allocator)
+	}
+	// Without steps, the calls may all come from warming up
+	if a := AnalyzeTrace(&TraceData{TraceEvents: events[1:]}); a.Allocator == nil || a.Allocator.CallsPerStep != 24 || a.Allocator.Thrashing {
+		t.Errorf("Expected no thrashing without steps, got %+v", a.Allocator)
+	}
+}
@@ -0,0 +1,69 @@
+package converter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Clock selects which duration of an event becomes its sample value
+type Clock string
+
+const (
+	// ClockWall uses wall-clock duration (dur)
+	ClockWall Clock = "wall"
+
+	// ClockThread uses thread CPU time (tdur), which excludes time the
+	// thread spent blocked or descheduled. Events without tdur keep their
+	// place in the stacks but contribute no samples.
+	ClockThread Clock = "thread"
+)
+
+// ParseClock parses a clock name
+func ParseClock(s string) (Clock, error) {
+	switch c := Clock(s); c {
+	case ClockWall, ClockThread:
+		return c, nil
+	default:
+		return "", fmt.Errorf("unknown clock %q (want wall or thread)", s)
+	}
+}
+
+// sampleDurationNs returns the event's sample value in nanoseconds for the
+// clock, and whether the event has a duration on that clock
+func (c Clock) sampleDurationNs(e TraceEvent) (float64, bool) {
+	if c == ClockThread {
+		return e.Tdur * 1000, e.Tdur > 0
+	}
+	return e.Dur * 1000, true
+}
+
+// SampleType selects a value column of converted profiles
+type SampleType string
+
+const (
+	// SampleCount is the "samples" column: the number of events
+	SampleCount SampleType = "samples"
+
+	// SampleTime is the "time" column, or "cpu_time" with ClockThread
+	SampleTime SampleType = "time"
+)
+
+// ParseSampleTypes parses a comma-separated list of sample types. An empty
+// list selects all of them.
+func ParseSampleTypes(s string) ([]SampleType, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var types []SampleType
+	for _, name := range strings.Split(s, ",") {
+		switch t := SampleType(strings.TrimSpace(name)); t {
+		case SampleCount, SampleTime:
+			types = append(types, t)
+		case "cpu_time":
+			types = append(types, SampleTime)
+		default:
+			return nil, fmt.Errorf("unknown sample type %q (want samples or time)", name)
+		}
+	}
+	return types, nil
+}
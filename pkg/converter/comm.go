@@ -0,0 +1,116 @@
+package converter
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+const (
+	// communicationCategory replaces the original category of collective
+	// communication ops so they form their own group in the profile
+	communicationCategory = "communication"
+
+	// communicationRoot is the synthetic root frame used when
+	// ConvertOptions.CommunicationRoot is set
+	communicationRoot = "Communication"
+)
+
+// communicationPrefixes lists name prefixes of NCCL/c10d collective ops,
+// covering both the CPU-side operators and the GPU kernels
+var communicationPrefixes = []string{
+	"nccl:",
+	"ncclKernel",
+	"ncclDevKernel",
+	"c10d::",
+	"record_param_comms",
+}
+
+// isCommunicationOp reports whether the event name is a collective
+// communication operator or kernel
+func isCommunicationOp(name string) bool {
+	for _, p := range communicationPrefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasCommunicationFrame reports whether any frame of the stack is a
+// communication op
+func hasCommunicationFrame(frames []frame) bool {
+	for _, f := range frames {
+		if f.cat == communicationCategory {
+			return true
+		}
+	}
+	return false
+}
+
+// CommOverlapStats is how much of the collective communication of one GPU
+// ran alongside compute kernels on the same device, in one training step
+// or over the whole trace. Exposed communication, with no kernel to hide
+// behind, adds directly to the step time. Rank is the rank recorded in
+// the trace's distributedInfo, or -1. Steps are the time windows of the
+// ProfilerStep spans; Step is -1 for the whole trace.
+type CommOverlapStats struct {
+	Rank         int     `json:"rank"`
+	Device       string  `json:"device"`
+	Step         int     `json:"step"`
+	CommNs       int64   `json:"comm_ns"`
+	OverlappedNs int64   `json:"overlapped_ns"`
+	ExposedNs    int64   `json:"exposed_ns"`
+	Overlap      float64 `json:"overlap"` // OverlappedNs over CommNs
+}
+
+// commOverlap returns the overlap of the communication intervals comm with
+// the compute intervals compute within window, both disjoint and sorted
+func commOverlap(comm, compute intervals, window TimeWindow) (commUs, overlappedUs float64) {
+	comm, compute = comm.clip(window), compute.clip(window)
+	j := 0
+	for _, c := range comm {
+		commUs += c[1] - c[0]
+		for j < len(compute) && compute[j][1] <= c[0] {
+			j++
+		}
+		for k := j; k < len(compute) && compute[k][0] < c[1]; k++ {
+			overlappedUs += min(c[1], compute[k][1]) - max(c[0], compute[k][0])
+		}
+	}
+	return commUs, overlappedUs
+}
+
+// commOverlapStats returns the overlap of communication with compute on
+// each device with communication kernels, per step and for the whole trace
+func (a *traceAnalyzer) commOverlapStats() []CommOverlapStats {
+	steps := slices.Sorted(maps.Keys(a.steps))
+	var result []CommOverlapStats
+	for _, device := range a.deviceOrder {
+		d := a.devices[device]
+		if len(d.comm) == 0 {
+			continue
+		}
+		comm, compute := d.comm.merge(), d.compute.merge()
+		add := func(step int, window TimeWindow) {
+			commUs, overlappedUs := commOverlap(comm, compute, window)
+			stats := CommOverlapStats{
+				Rank:         a.rank,
+				Device:       device,
+				Step:         step,
+				CommNs:       usToNs(commUs),
+				OverlappedNs: usToNs(overlappedUs),
+			}
+			stats.ExposedNs = stats.CommNs - stats.OverlappedNs
+			if stats.CommNs > 0 {
+				stats.Overlap = float64(stats.OverlappedNs) / float64(stats.CommNs)
+			}
+			result = append(result, stats)
+		}
+		add(-1, TimeWindow{})
+		for _, step := range steps {
+			add(step, a.steps[step])
+		}
+	}
+	return result
+}
@@ -0,0 +1,37 @@
+package converter
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeTraceCommOverlap(t *testing.T) {
+	kernel := func(name string, ts, dur float64) TraceEvent {
+		return withArgs(gpuKernel(name, ts, dur), map[string]interface{}{"device": float64(0)})
+	}
+	traceData := &TraceData{
+		TraceEvents: []TraceEvent{
+			annotation("ProfilerStep#1", 0, 100),
+			annotation("ProfilerStep#2", 100, 100),
+			kernel("gemm", 10, 40),
+			kernel("ncclKernel_AllReduce", 40, 40), // 10us overlapped, 30us exposed
+			kernel("gemm", 120, 20),
+			kernel("ncclDevKernel_AllGather", 130, 50), // 10us overlapped, 40us exposed
+		},
+		Metadata: map[string]json.RawMessage{"distributedInfo": json.RawMessage(`{"rank": 3}`)},
+	}
+	got := AnalyzeTrace(traceData).CommOverlap
+
+	want := []CommOverlapStats{
+		{Rank: 3, Device: "0", Step: -1, CommNs: 90000, OverlappedNs: 20000, ExposedNs: 70000, Overlap: 20.0 / 90},
+		{Rank: 3, Device: "0", Step: 1, CommNs: 40000, OverlappedNs: 10000, ExposedNs: 30000, Overlap: 0.25},
+		{Rank: 3, Device: "0", Step: 2, CommNs: 50000, OverlappedNs: 10000, ExposedNs: 40000, Overlap: 0.2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected overlap %+v, got %+v", want, got)
+	}
+	if got := AnalyzeTrace(&TraceData{TraceEvents: traceData.TraceEvents[:3]}).CommOverlap; got == nil || len(got) != 0 {
+		t.Errorf("Expected empty overlap statistics without communication kernels, got %+v", got)
+	}
+}
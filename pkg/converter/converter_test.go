@@ -0,0 +1,2589 @@
+package converter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+	"unsafe"
+
+	"github.com/olka/torch2pprof/pkg/profile"
+)
+
+func TestGetTid(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    interface{}
+		expected int64
+	}{
+		{"float64", float64(123.0), 123},
+		{"int", 456, 456},
+		{"int64", int64(789), 789},
+		{"string", "test", int64('t')*31*31*31 + int64('e')*31*31 + int64('s')*31 + int64('t')},
+		{"nil", nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := getTid(tt.input)
+			if result != tt.expected {
+				t.Errorf("getTid(%v): expected %d, got %d", tt.input, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestLoadTraceFile_PlainJSON(t *testing.T) {
+	// Create temporary test file
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.json")
+
+	testData := TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "test", Cat: "test_cat", Ts: 100, Dur: 50},
+		},
+	}
+
+	data, err := json.Marshal(testData)
+	if err != nil {
+		t.Fatalf("Failed to marshal test data: %v", err)
+	}
+
+	if err := os.WriteFile(testFile, data, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	// Test loading
+	loaded, err := LoadTraceFile(testFile)
+	if err != nil {
+		t.Fatalf("LoadTraceFile failed: %v", err)
+	}
+
+	if len(loaded.TraceEvents) != 1 {
+		t.Errorf("Expected 1 event, got %d", len(loaded.TraceEvents))
+	}
+
+	event := loaded.TraceEvents[0]
+	if event.Name != "test" {
+		t.Errorf("Expected name 'test', got '%s'", event.Name)
+	}
+	if event.Ts != 100 {
+		t.Errorf("Expected Ts 100, got %f", event.Ts)
+	}
+	if event.Dur != 50 {
+		t.Errorf("Expected Dur 50, got %f", event.Dur)
+	}
+}
+
+func TestLoadTraceFile_GzipJSON(t *testing.T) {
+	// Create temporary test file
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.json.gz")
+
+	testData := TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "test", Cat: "test_cat", Ts: 100, Dur: 50},
+		},
+	}
+
+	data, err := json.Marshal(testData)
+	if err != nil {
+		t.Fatalf("Failed to marshal test data: %v", err)
+	}
+
+	// Write gzipped file
+	f, err := os.Create(testFile)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	gz := gzip.NewWriter(f)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatalf("Failed to write gzip data: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("Failed to close gzip writer: %v", err)
+	}
+
+	// Test loading
+	loaded, err := LoadTraceFile(testFile)
+	if err != nil {
+		t.Fatalf("LoadTraceFile failed: %v", err)
+	}
+
+	if len(loaded.TraceEvents) != 1 {
+		t.Errorf("Expected 1 event, got %d", len(loaded.TraceEvents))
+	}
+
+	event := loaded.TraceEvents[0]
+	if event.Name != "test" {
+		t.Errorf("Expected name 'test', got '%s'", event.Name)
+	}
+}
+
+func TestLoadTraceFile_GzipWithoutExtension(t *testing.T) {
+	// Create temporary gzipped file without .gz extension
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test_no_ext")
+
+	testData := TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "test", Cat: "test_cat", Ts: 100, Dur: 50},
+		},
+	}
+
+	data, err := json.Marshal(testData)
+	if err != nil {
+		t.Fatalf("Failed to marshal test data: %v", err)
+	}
+
+	// Write gzipped file
+	f, err := os.Create(testFile)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	gz := gzip.NewWriter(f)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatalf("Failed to write gzip data: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("Failed to close gzip writer: %v", err)
+	}
+
+	// Test loading (should detect gzip by magic number)
+	loaded, err := LoadTraceFile(testFile)
+	if err != nil {
+		t.Fatalf("LoadTraceFile failed: %v", err)
+	}
+
+	if len(loaded.TraceEvents) != 1 {
+		t.Errorf("Expected 1 event, got %d", len(loaded.TraceEvents))
+	}
+}
+
+func TestLoadTraceFile_NonexistentFile(t *testing.T) {
+	_, err := LoadTraceFile("/nonexistent/file.json")
+	if err == nil {
+		t.Error("Expected error for nonexistent file")
+	}
+}
+
+func TestEvents(t *testing.T) {
+	content := `{"schemaVersion": 1, "traceEvents": [
+		{"ph": "X", "name": "a", "ts": 0, "dur": 1},
+		{"ph": "X", "name": "b", "ts": 1, "dur": 1},
+		{"ph": "i", "name": "c", "ts": 2}
+	]}`
+	var gz strings.Builder
+	zw := gzip.NewWriter(&gz)
+	if _, err := zw.Write([]byte(content)); err != nil {
+		t.Fatalf("Failed to compress: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to compress: %v", err)
+	}
+
+	for name, input := range map[string]string{"plain": content, "gzip": gz.String()} {
+		var names []string
+		for e, err := range Events(strings.NewReader(input)) {
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", name, err)
+			}
+			names = append(names, e.Name)
+		}
+		if want := []string{"a", "b", "c"}; !slices.Equal(names, want) {
+			t.Errorf("%s: expected events %v, got %v", name, want, names)
+		}
+	}
+
+	// Breaking out of the loop stops decoding
+	count := 0
+	for range Events(strings.NewReader(content)) {
+		count++
+		break
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 event before break, got %d", count)
+	}
+
+	// Events parsed before a syntax error are yielded, then the error
+	var names []string
+	var lastErr error
+	for e, err := range Events(strings.NewReader(`{"traceEvents": [{"name": "a"}, {"name": }]}`)) {
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		names = append(names, e.Name)
+	}
+	var parseErr *ParseError
+	if !slices.Equal(names, []string{"a"}) || !errors.As(lastErr, &parseErr) {
+		t.Errorf("Expected event a then a ParseError, got %v and %v", names, lastErr)
+	}
+}
+
+func TestDecodeEvents_MatchesEncodingJSON(t *testing.T) {
+	events := []string{
+		`{"ph": "X", "cat": "cpu_op", "name": "aten::mm", "pid": 1319, "tid": 1319, "ts": 4556988687013.137, "dur": 25.466, "args": {"External id": 2, "Input Dims": [[64, 128], []], "Ev Idx": 1}}`,
+		`{"ph":"X","name":"escapes \"\\\/\b\f\n\r\té 😀 \ud800 x","pid":"Spans","tid":"stream 7"}`,
+		"{\"name\": \"invalid \xff utf-8 \xe2\x82\", \"ts\": 1e3, \"dur\": -0, \"tts\": 12345678901234567890, \"tdur\": 0.1e-2}",
+		`{"PH": "B", "Name": "folded keys", "unknown": {"a": [true, false, null]}, "args": {"a": 1}, "args": {"b": -2.5E+2}}`,
+		`{"name": null, "ts": null, "args": null, "pid": null, "tid": -0}`,
+		`{}`,
+		`null`,
+	}
+	input := `{"schemaVersion": 1, "traceEvents": [` + strings.Join(events, ",") + `], "deviceProperties": [{"id": 0}]}`
+
+	var want TraceData
+	if err := json.Unmarshal([]byte(input), &want); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	var got []TraceEvent
+	meta := make(map[string]string)
+	err := decodeEvents(strings.NewReader(input), nil, slog.New(slog.DiscardHandler), func(key string, raw json.RawMessage) {
+		meta[key] = string(raw)
+	}, func(e TraceEvent) bool {
+		got = append(got, e)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("decodeEvents: %v", err)
+	}
+	if !reflect.DeepEqual(got, want.TraceEvents) {
+		t.Errorf("Events differ from encoding/json:\n got %#v\nwant %#v", got, want.TraceEvents)
+	}
+	if tid, ok := got[4].Tid.(float64); !ok || !math.Signbit(tid) {
+		t.Errorf("Expected tid -0, got %v", got[4].Tid)
+	}
+	if want := map[string]string{"schemaVersion": "1", "deviceProperties": `[{"id": 0}]`}; !maps.Equal(meta, want) {
+		t.Errorf("Expected metadata %v, got %v", want, meta)
+	}
+
+	// Invalid JSON is rejected like encoding/json does
+	for _, bad := range []string{`{"ph": "X",}`, `{"ts": 01}`, `{"ts": 1.}`, `{"name": "\x"}`, `{"name": "\u12"}`, "{\"name\": \"a\tb\"}", `{"ts": tru}`, `{"ts": 1e999}`, `{"args": [1]}`, `[]`} {
+		in := `{"traceEvents": [` + bad + `]}`
+		err := decodeEvents(strings.NewReader(in), nil, slog.New(slog.DiscardHandler), func(string, json.RawMessage) {}, func(TraceEvent) bool { return true })
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || json.Unmarshal([]byte(in), &want) == nil {
+			t.Errorf("%s: expected a ParseError as encoding/json fails, got %v", bad, err)
+		}
+	}
+}
+
+func TestScanEventsParallel(t *testing.T) {
+	kernel := "void at::native::elementwise_kernel<128, 4>(" + strings.Repeat("int, ", 40) + "float)"
+	var events []string
+	for i := range 200 {
+		switch i % 4 {
+		case 0:
+			events = append(events, fmt.Sprintf(`{"ph": "X", "name": "op%d", "pid": 1, "tid": %d, "ts": %d, "dur": 1}`, i, i%3, i))
+		case 1:
+			// Looks like an event boundary inside a string
+			events = append(events, fmt.Sprintf(`{"name": "}, {\"ph\": \"X\"}, {", "ts": %d}`, i))
+		case 2:
+			events = append(events, fmt.Sprintf(`{"name": %q, "args": {"shapes": [{"a": 1},  {"b": %d}]}}`, kernel, i))
+		default:
+			events = append(events, "null")
+		}
+	}
+	input := `{"traceEvents": [` + strings.Join(events, ",\n") + `], "schemaVersion": 1}`
+	var want TraceData
+	if err := json.Unmarshal([]byte(input), &want); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	start := strings.Index(input, "[")
+	end := strings.LastIndex(input, "]") + 1
+	logger := slog.New(slog.DiscardHandler)
+	for _, n := range []int{1, 2, 7, 64, 1000} {
+		got, next, err := scanEventsParallel(context.Background(), []byte(input), start, n, nil, &byteProgress{}, logger)
+		if err != nil {
+			t.Fatalf("%d chunks: %v", n, err)
+		}
+		if !reflect.DeepEqual(got, want.TraceEvents) || next != end {
+			t.Errorf("%d chunks: got %d events ending at %d, want %d ending at %d", n, len(got), next, len(want.TraceEvents), end)
+		}
+		// Long names are stored once, whichever chunk they are in
+		if unsafe.StringData(got[2].Name) != unsafe.StringData(got[len(got)-2].Name) {
+			t.Errorf("%d chunks: kernel name stored more than once", n)
+		}
+	}
+
+	// Errors are those of a sequential scan
+	bad := strings.Replace(input, `"ts": 149}`, `"ts": 1.}`, 1)
+	_, wantErr := LoadTrace(context.Background(), strings.NewReader(bad), LoadOptions{})
+	_, _, err := scanEventsParallel(context.Background(), []byte(bad), start, 7, nil, &byteProgress{}, logger)
+	var parseErr, wantParseErr *ParseError
+	if !errors.As(err, &parseErr) || !errors.As(wantErr, &wantParseErr) || parseErr.Offset != wantParseErr.Offset {
+		t.Errorf("Expected the error %v, got %v", wantErr, err)
+	}
+	if _, _, err := scanEventsParallel(context.Background(), []byte(`{"traceEvents": []}`), 16, 4, nil, &byteProgress{}, logger); err != nil {
+		t.Errorf("Empty array: %v", err)
+	}
+}
+
+func TestTraceEventArgs(t *testing.T) {
+	var e TraceEvent
+	data := `{"ph": "X", "name": "aten::mm", "args": {"Input Dims": [[64, 128], [128, 256], []], "correlation": 42, "External id": "a", "flops": 4194304}}`
+	if err := json.Unmarshal([]byte(data), &e); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	if id, ok := e.ArgInt("correlation"); !ok || id != 42 {
+		t.Errorf("ArgInt(correlation) = %d, %v", id, ok)
+	}
+	if flops, ok := e.ArgNumber("flops"); !ok || flops != 4194304 {
+		t.Errorf("ArgNumber(flops) = %v, %v", flops, ok)
+	}
+	if s, ok := e.ArgString("External id"); !ok || s != "a" {
+		t.Errorf("ArgString(External id) = %q, %v", s, ok)
+	}
+	if _, ok := e.ArgString("correlation"); ok {
+		t.Error("ArgString should not convert numbers")
+	}
+	if _, ok := e.ArgNumber("missing"); ok {
+		t.Error("ArgNumber should report missing args")
+	}
+
+	dims, ok := e.InputDims()
+	want := [][]int64{{64, 128}, {128, 256}, {}}
+	if !ok || !slices.EqualFunc(dims, want, slices.Equal) {
+		t.Errorf("InputDims() = %v, %v, want %v", dims, ok, want)
+	}
+	if _, ok := (TraceEvent{}).InputDims(); ok {
+		t.Error("InputDims should report events without shapes")
+	}
+}
+
+func TestLoadTraceNullEvents(t *testing.T) {
+	const content = `{"schemaVersion": 1, "traceEvents": null}`
+	path := filepath.Join(t.TempDir(), "trace.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	fromFile, err := LoadTraceFile(path)
+	if err != nil || len(fromFile.TraceEvents) != 0 {
+		t.Errorf("Expected an empty trace from the file, got %+v, %v", fromFile, err)
+	}
+	fromReader, err := LoadTrace(context.Background(), strings.NewReader(content), LoadOptions{})
+	if err != nil || len(fromReader.TraceEvents) != 0 {
+		t.Errorf("Expected an empty trace from the reader, got %+v, %v", fromReader, err)
+	}
+}
+
+func TestLoadTraceArgs(t *testing.T) {
+	const content = `{"traceEvents": [
+		{"ph": "X", "name": "gemm", "args": {"correlation": 7, "Python id": 1, "grid": [2, 1, 1]}},
+		{"ph": "X", "name": "step", "args": {"Python id": 2, "Ev Idx": 3}},
+		{"ph": "X", "name": "empty", "args": {}}
+	]}`
+	path := filepath.Join(t.TempDir(), "trace.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	opts := LoadOptions{Args: UsedArgs()}
+	fromFile, err := LoadTraceFileWithOptions(context.Background(), path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromReader, err := LoadTrace(context.Background(), strings.NewReader(content), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]interface{}{{"correlation": float64(7), "grid": []interface{}{float64(2), float64(1), float64(1)}}, nil, nil}
+	for _, traceData := range []*TraceData{fromFile, fromReader} {
+		for i, e := range traceData.TraceEvents {
+			if !reflect.DeepEqual(e.Args, want[i]) {
+				t.Errorf("%s: expected args %v, got %v", e.Name, want[i], e.Args)
+			}
+		}
+	}
+
+	all, err := LoadTrace(context.Background(), strings.NewReader(content), LoadOptions{})
+	if err != nil || len(all.TraceEvents[1].Args) != 2 || all.TraceEvents[2].Args == nil {
+		t.Errorf("Expected every arg without Args, got %+v, %v", all, err)
+	}
+}
+
+func TestLoadTraceFile_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    error
+		offset  int64
+		snippet string
+	}{
+		{name: "empty", content: "", want: ErrNotATrace},
+		{name: "text", content: "hello", want: ErrNotATrace},
+		{name: "no traceEvents", content: `{"schemaVersion": 1}`, want: ErrNotATrace},
+		{name: "array", content: `[{"ph": "X"}]`, want: ErrUnsupportedFormat},
+		{name: "protobuf", content: "\x0a\x05\x08\x01\x10\x00", want: ErrUnsupportedFormat},
+		{name: "syntax", content: `{"traceEvents": [{"ph": "X" "name": "a"}]}`, offset: 28, snippet: `"X" "name"`},
+		{name: "type", content: `{"traceEvents": [{"ph": "X", "ts": "soon"}]}`, snippet: `"soon"`},
+		{name: "truncated", content: `{"traceEvents": [{"ph": "X", "na`, offset: 32},
+		{name: "not an array", content: `{"traceEvents": nil}`, offset: 17, snippet: "nil"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "trace.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Failed to write: %v", err)
+			}
+			_, err := LoadTraceFile(path)
+			if tt.want != nil {
+				if !errors.Is(err, tt.want) {
+					t.Errorf("Expected %v, got %v", tt.want, err)
+				}
+				return
+			}
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("Expected a ParseError, got %v", err)
+			}
+			if tt.offset != 0 && parseErr.Offset != tt.offset {
+				t.Errorf("Expected offset %d, got %d", tt.offset, parseErr.Offset)
+			}
+			if !strings.Contains(parseErr.Snippet, tt.snippet) {
+				t.Errorf("Expected snippet containing %q, got %q", tt.snippet, parseErr.Snippet)
+			}
+		})
+	}
+}
+
+func TestAnalyzeTrace(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "op1", Cat: "cat1", Ts: 100, Dur: 50},
+			{Ph: "X", Name: "op2", Cat: "cat1", Ts: 200, Dur: 30},
+			{Ph: "X", Name: "op1", Cat: "cat2", Ts: 300, Dur: 20},
+			{Ph: "B", Name: "op3", Cat: "cat1", Ts: 400, Dur: 10}, // Not "X", should be skipped
+			{Ph: "X", Name: "op4", Cat: "cat1", Ts: 500, Dur: 0},  // Zero duration, should be skipped
+		},
+	}
+
+	analysis := AnalyzeTrace(testData)
+
+	// Check total events
+	if analysis.TotalEvents != 5 {
+		t.Errorf("Expected 5 total events, got %d", analysis.TotalEvents)
+	}
+
+	// Check complete events (Ph == "X")
+	if analysis.CompleteEvents != 4 {
+		t.Errorf("Expected 4 complete events, got %d", analysis.CompleteEvents)
+	}
+
+	// Check skipped zero duration
+	if analysis.SkippedZeroDuration != 1 {
+		t.Errorf("Expected 1 skipped zero duration, got %d", analysis.SkippedZeroDuration)
+	}
+
+	// Check converted events
+	if analysis.ConvertedEvents != 3 {
+		t.Errorf("Expected 3 converted events, got %d", analysis.ConvertedEvents)
+	}
+
+	// Check unique operations
+	if analysis.UniqueOperations != 2 { // op1 (appears twice with different cats), op2
+		t.Errorf("Expected 2 unique operations, got %d", analysis.UniqueOperations)
+	}
+
+	// Check total time (50 + 30 + 20 = 100 microseconds = 100000 nanoseconds)
+	expectedTimeNs := int64(100000)
+	if analysis.TotalTimeNs != expectedTimeNs {
+		t.Errorf("Expected total time %d ns, got %d ns", expectedTimeNs, analysis.TotalTimeNs)
+	}
+
+	// Check category stats
+	if len(analysis.CategoryStats) != 2 {
+		t.Errorf("Expected 2 categories, got %d", len(analysis.CategoryStats))
+	}
+
+	cat1Stats := analysis.CategoryStats["cat1"]
+	if cat1Stats.Count != 2 {
+		t.Errorf("Expected cat1 count 2, got %d", cat1Stats.Count)
+	}
+	expectedCat1Time := int64(80000) // 50 + 30 microseconds
+	if cat1Stats.TimeNs != expectedCat1Time {
+		t.Errorf("Expected cat1 time %d ns, got %d ns", expectedCat1Time, cat1Stats.TimeNs)
+	}
+}
+
+func TestGetSortedCategories(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "op1", Cat: "cat1", Ts: 100, Dur: 10},
+			{Ph: "X", Name: "op2", Cat: "cat2", Ts: 200, Dur: 50},
+			{Ph: "X", Name: "op3", Cat: "cat3", Ts: 300, Dur: 30},
+		},
+	}
+
+	analysis := AnalyzeTrace(testData)
+	sorted := analysis.GetSortedCategories()
+
+	if len(sorted) != 3 {
+		t.Errorf("Expected 3 categories, got %d", len(sorted))
+	}
+
+	// Should be sorted by time descending
+	if sorted[0].Name != "cat2" { // 50 microseconds
+		t.Errorf("Expected first category 'cat2', got '%s'", sorted[0].Name)
+	}
+	if sorted[1].Name != "cat3" { // 30 microseconds
+		t.Errorf("Expected second category 'cat3', got '%s'", sorted[1].Name)
+	}
+	if sorted[2].Name != "cat1" { // 10 microseconds
+		t.Errorf("Expected third category 'cat1', got '%s'", sorted[2].Name)
+	}
+}
+
+func TestGetSortedOperations(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "fast", Cat: "cat1", Ts: 100, Dur: 10},
+			{Ph: "X", Name: "slow", Cat: "cat1", Ts: 200, Dur: 50},
+			{Ph: "X", Name: "medium", Cat: "cat1", Ts: 300, Dur: 30},
+		},
+	}
+
+	analysis := AnalyzeTrace(testData)
+	sorted := analysis.GetSortedOperations()
+
+	if len(sorted) != 3 {
+		t.Errorf("Expected 3 operations, got %d", len(sorted))
+	}
+
+	// Should be sorted by time descending
+	if sorted[0].Name != "slow" {
+		t.Errorf("Expected first operation 'slow', got '%s'", sorted[0].Name)
+	}
+	if sorted[1].Name != "medium" {
+		t.Errorf("Expected second operation 'medium', got '%s'", sorted[1].Name)
+	}
+	if sorted[2].Name != "fast" {
+		t.Errorf("Expected third operation 'fast', got '%s'", sorted[2].Name)
+	}
+}
+
+func TestConvertTrace(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "op1", Cat: "cat1", Tid: 1, Ts: 100, Dur: 50},
+			{Ph: "X", Name: "op2", Cat: "cat1", Tid: 1, Ts: 110, Dur: 30}, // Nested
+			{Ph: "X", Name: "op3", Cat: "cat2", Tid: 2, Ts: 200, Dur: 20},
+		},
+	}
+
+	profile := ConvertTrace(testData, ConvertOptions{NumWorkers: 2})
+
+	if profile == nil {
+		t.Fatal("ConvertTrace returned nil")
+	}
+
+	if len(profile.Sample) == 0 {
+		t.Error("Expected samples to be created")
+	}
+
+	if len(profile.Location) == 0 {
+		t.Error("Expected locations to be created")
+	}
+
+	if len(profile.Function) == 0 {
+		t.Error("Expected functions to be created")
+	}
+
+	if len(profile.StringTable) == 0 {
+		t.Error("Expected strings to be created")
+	}
+
+	// Verify sample types were set
+	if len(profile.SampleType) != 2 {
+		t.Errorf("Expected 2 sample types, got %d", len(profile.SampleType))
+	}
+}
+
+func TestConvertTrace_EmptyEvents(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{},
+	}
+
+	profile := ConvertTrace(testData, ConvertOptions{NumWorkers: 1})
+
+	if profile == nil {
+		t.Fatal("ConvertTrace returned nil")
+	}
+
+	if len(profile.Sample) != 0 {
+		t.Errorf("Expected 0 samples for empty trace, got %d", len(profile.Sample))
+	}
+}
+
+func TestConvertTrace_FilteredEvents(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "B", Name: "op1", Cat: "cat1", Tid: 1, Ts: 100, Dur: 50}, // Wrong phase
+			{Ph: "X", Name: "op2", Cat: "cat1", Tid: 1, Ts: 200, Dur: 0},  // Zero duration
+			{Ph: "X", Name: "op3", Cat: "cat1", Tid: 1, Ts: 300, Dur: -1}, // Negative duration
+		},
+	}
+
+	profile := ConvertTrace(testData, ConvertOptions{NumWorkers: 1})
+
+	if profile == nil {
+		t.Fatal("ConvertTrace returned nil")
+	}
+
+	// All events should be filtered out
+	if len(profile.Sample) != 0 {
+		t.Errorf("Expected 0 samples (all filtered), got %d", len(profile.Sample))
+	}
+}
+
+func TestKernelLabels(t *testing.T) {
+	event := TraceEvent{
+		Ph: "X", Name: "gemm_kernel", Cat: "kernel", Ts: 100, Dur: 10,
+		Args: map[string]interface{}{
+			"grid":                      []interface{}{float64(128), float64(1), float64(1)},
+			"block":                     []interface{}{float64(256), float64(1), float64(1)},
+			"registers per thread":      float64(64),
+			"shared memory":             float64(49152),
+			"est. achieved occupancy %": float64(37.6),
+		},
+	}
+
+	labels := kernelLabels(event)
+	got := make(map[string]string)
+	for _, l := range labels {
+		got[l.key] = l.keyString()
+	}
+
+	expected := map[string]string{
+		"grid":                 "grid=128x1x1",
+		"block":                "block=256x1x1",
+		"registers_per_thread": "registers_per_thread=64",
+		"shared_memory":        "shared_memory=49152bytes",
+		"occupancy_pct":        "occupancy_pct=38",
+	}
+	for k, v := range expected {
+		if got[k] != v {
+			t.Errorf("label %s: expected %q, got %q", k, v, got[k])
+		}
+	}
+
+	// CPU ops never get kernel labels
+	event.Cat = "cpu_op"
+	if labels := kernelLabels(event); labels != nil {
+		t.Errorf("Expected no labels for cpu_op, got %v", labels)
+	}
+}
+
+func TestConvertTrace_KernelLabels(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "k", Cat: "kernel", Tid: 7, Ts: 100, Dur: 10,
+				Args: map[string]interface{}{"grid": []interface{}{float64(2), float64(1), float64(1)}}},
+			{Ph: "X", Name: "k", Cat: "kernel", Tid: 7, Ts: 200, Dur: 10,
+				Args: map[string]interface{}{"grid": []interface{}{float64(4), float64(1), float64(1)}}},
+		},
+	}
+
+	profile := ConvertTrace(testData, ConvertOptions{NumWorkers: 1})
+
+	// Same stack but different launch configs must stay separate samples
+	if len(profile.Sample) != 2 {
+		t.Fatalf("Expected 2 samples, got %d", len(profile.Sample))
+	}
+	grids := make(map[string]bool)
+	for _, s := range profile.Sample {
+		grids[sampleLabels(profile, s)["grid"]] = true
+	}
+	if !grids["2x1x1"] || !grids["4x1x1"] {
+		t.Errorf("Expected grid labels 2x1x1 and 4x1x1, got %v", grids)
+	}
+
+	// Collective kernels move to the communication category but keep
+	// their launch labels
+	profile = ConvertTrace(&TraceData{TraceEvents: []TraceEvent{
+		{Ph: "X", Name: "ncclDevKernel_AllReduce", Cat: "kernel", Tid: 7, Ts: 100, Dur: 10,
+			Args: map[string]interface{}{"grid": []interface{}{float64(8), float64(1), float64(1)}}},
+	}}, ConvertOptions{NumWorkers: 1})
+	if len(profile.Sample) != 1 || sampleLabels(profile, profile.Sample[0])["grid"] != "8x1x1" {
+		t.Errorf("Expected the grid label on the NCCL kernel, got %v", sampleStacks(profile))
+	}
+	if stacks := sampleStacks(profile); len(stacks) != 1 || stacks[0][len(stacks[0])-1] != "ncclDevKernel_AllReduce|"+communicationCategory {
+		t.Errorf("Expected the NCCL kernel in the communication category, got %v", stacks)
+	}
+}
+
+func TestIsCommunicationOp(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"nccl:all_reduce", true},
+		{"c10d::allreduce_", true},
+		{"ncclDevKernel_AllReduce_Sum_f32_RING_LL(ncclDevComm*, unsigned long, ncclWork*)", true},
+		{"record_param_comms", true},
+		{"aten::mm", false},
+		{"cudaLaunchKernel", false},
+	}
+
+	for _, tt := range tests {
+		if got := isCommunicationOp(tt.name); got != tt.expected {
+			t.Errorf("isCommunicationOp(%q): expected %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+// sampleStacks returns each sample's stack as root-first "name|category" frames
+func sampleStacks(p *profile.Profile) [][]string {
+	funcs := make(map[uint64]string)
+	for _, fn := range p.Function {
+		funcs[fn.Id] = p.StringTable[fn.Name] + "|" + p.StringTable[fn.Filename]
+	}
+	locs := make(map[uint64]string)
+	for _, loc := range p.Location {
+		locs[loc.Id] = funcs[loc.Line[0].FunctionId]
+	}
+
+	stacks := make([][]string, 0, len(p.Sample))
+	for _, s := range p.Sample {
+		stack := make([]string, len(s.LocationId))
+		for i, id := range s.LocationId {
+			stack[len(s.LocationId)-1-i] = locs[id]
+		}
+		stacks = append(stacks, stack)
+	}
+	return stacks
+}
+
+// sampleLabels returns the string labels of a sample keyed by label name
+func sampleLabels(p *profile.Profile, s *profile.Sample) map[string]string {
+	labels := make(map[string]string)
+	for _, l := range s.Label {
+		labels[p.StringTable[l.Key]] = p.StringTable[l.Str]
+	}
+	return labels
+}
+
+func TestConvertTrace_CommunicationRoot(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "step", Cat: "user_annotation", Tid: 1, Ts: 100, Dur: 100},
+			{Ph: "X", Name: "nccl:all_reduce", Cat: "cpu_op", Tid: 1, Ts: 110, Dur: 30},
+			{Ph: "X", Name: "aten::mm", Cat: "cpu_op", Tid: 1, Ts: 150, Dur: 30},
+		},
+	}
+
+	profile := ConvertTrace(testData, ConvertOptions{NumWorkers: 1, CommunicationRoot: true})
+
+	commSamples := 0
+	for _, stack := range sampleStacks(profile) {
+		root, leaf := stack[0], stack[len(stack)-1]
+		if strings.HasPrefix(leaf, "nccl:all_reduce|") {
+			commSamples++
+			if leaf != "nccl:all_reduce|"+communicationCategory {
+				t.Errorf("Expected category %q, got %q", communicationCategory, leaf)
+			}
+			if root != communicationRoot+"|"+communicationCategory {
+				t.Errorf("Expected root %q, got %q", communicationRoot, root)
+			}
+		} else if strings.HasPrefix(root, communicationRoot+"|") {
+			t.Errorf("Compute stack %v must not be under %q", stack, communicationRoot)
+		}
+	}
+	if commSamples != 1 {
+		t.Errorf("Expected 1 communication sample, got %d", commSamples)
+	}
+}
+
+func TestConvertTrace_ThreadRoots(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "M", Name: "process_name", Pid: float64(1234), Args: map[string]interface{}{"name": "python"}},
+			{Ph: "M", Name: "thread_name", Pid: float64(1234), Tid: float64(1), Args: map[string]interface{}{"name": "main"}},
+			{Ph: "M", Name: "process_labels", Pid: float64(0), Args: map[string]interface{}{"labels": "GPU 0"}},
+			{Ph: "M", Name: "thread_name", Pid: float64(0), Tid: float64(7), Args: map[string]interface{}{"name": "stream 7 "}},
+			{Ph: "X", Name: "aten::mm", Cat: "cpu_op", Pid: float64(1234), Tid: float64(1), Ts: 100, Dur: 10},
+			{Ph: "X", Name: "gemm", Cat: "kernel", Pid: float64(0), Tid: float64(7), Ts: 105, Dur: 5},
+			{Ph: "X", Name: "aten::add", Cat: "cpu_op", Pid: float64(1234), Tid: float64(2), Ts: 100, Dur: 10},
+		},
+	}
+
+	profile := ConvertTrace(testData, ConvertOptions{NumWorkers: 1, ThreadRoots: true})
+
+	roots := make(map[string]bool)
+	for _, stack := range sampleStacks(profile) {
+		if len(stack) != 2 {
+			t.Errorf("Expected root + leaf, got %v", stack)
+			continue
+		}
+		roots[stack[0]] = true
+	}
+
+	for _, expected := range []string{
+		"python (pid 1234, tid main)|thread",
+		"GPU 0 stream 7|thread",
+		"python (pid 1234, tid 2)|thread",
+	} {
+		if !roots[expected] {
+			t.Errorf("Expected root frame %q, got %v", expected, roots)
+		}
+	}
+}
+
+func TestConvertTrace_MultiProcess(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "M", Name: "process_name", Pid: float64(100), Args: map[string]interface{}{"name": "trainer"}},
+			{Ph: "M", Name: "process_name", Pid: float64(200), Args: map[string]interface{}{"name": "dataloader"}},
+			// Same tid in two processes: the worker op overlaps the trainer op
+			// and must not be nested under it
+			{Ph: "X", Name: "train_step", Cat: "cpu_op", Pid: float64(100), Tid: float64(1), Ts: 100, Dur: 100},
+			{Ph: "X", Name: "load_batch", Cat: "cpu_op", Pid: float64(200), Tid: float64(1), Ts: 110, Dur: 20},
+		},
+	}
+
+	profile := ConvertTrace(testData, ConvertOptions{NumWorkers: 1})
+
+	if len(profile.Sample) != 2 {
+		t.Fatalf("Expected 2 samples, got %d", len(profile.Sample))
+	}
+	for i, stack := range sampleStacks(profile) {
+		if len(stack) != 1 {
+			t.Errorf("Expected single-frame stacks, got %v", stack)
+		}
+		labels := sampleLabels(profile, profile.Sample[i])
+		switch stack[len(stack)-1] {
+		case "train_step|cpu_op":
+			if labels["pid"] != "100" || labels["process"] != "trainer" {
+				t.Errorf("Unexpected trainer labels: %v", labels)
+			}
+		case "load_batch|cpu_op":
+			if labels["pid"] != "200" || labels["process"] != "dataloader" {
+				t.Errorf("Unexpected dataloader labels: %v", labels)
+			}
+		}
+	}
+}
+
+func TestFilterTimeWindow(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "M", Name: "process_name", Pid: float64(1)},
+			{Ph: "X", Name: "parent", Tid: 1, Ts: 0, Dur: 1000},  // Clipped on both sides
+			{Ph: "X", Name: "before", Tid: 1, Ts: 50, Dur: 40},   // Ends before window
+			{Ph: "X", Name: "inside", Tid: 1, Ts: 200, Dur: 100}, // Fully inside
+			{Ph: "X", Name: "after", Tid: 1, Ts: 600, Dur: 10},   // Starts after window
+			{Ph: "i", Name: "marker", Tid: 1, Ts: 250},
+		},
+	}
+
+	filtered := FilterTimeWindow(testData, TimeWindow{Start: 100, End: 500})
+
+	byName := make(map[string]TraceEvent)
+	for _, e := range filtered.TraceEvents {
+		byName[e.Name] = e
+	}
+	if len(filtered.TraceEvents) != 4 {
+		t.Errorf("Expected 4 events, got %d", len(filtered.TraceEvents))
+	}
+	if p := byName["parent"]; p.Ts != 100 || p.Dur != 400 {
+		t.Errorf("Expected parent clipped to [100, 500), got ts=%v dur=%v", p.Ts, p.Dur)
+	}
+	if i := byName["inside"]; i.Ts != 200 || i.Dur != 100 {
+		t.Errorf("Expected inside event unchanged, got ts=%v dur=%v", i.Ts, i.Dur)
+	}
+	for _, name := range []string{"process_name", "marker"} {
+		if _, ok := byName[name]; !ok {
+			t.Errorf("Expected %q to be kept", name)
+		}
+	}
+
+	// The original trace must not be modified
+	if testData.TraceEvents[1].Ts != 0 {
+		t.Error("FilterTimeWindow modified the input trace")
+	}
+}
+
+func TestStepWindow(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "ProfilerStep#1", Ts: 100, Dur: 100},
+			{Ph: "X", Name: "ProfilerStep#2", Ts: 200, Dur: 100},
+			{Ph: "X", Name: "ProfilerStep#3", Ts: 300, Dur: 150},
+		},
+	}
+
+	window, err := StepWindow(testData, 2, 3)
+	if err != nil {
+		t.Fatalf("StepWindow failed: %v", err)
+	}
+	if window.Start != 200 || window.End != 450 {
+		t.Errorf("Expected window [200, 450), got [%v, %v)", window.Start, window.End)
+	}
+
+	window, err = StepWindow(testData, 2, -1)
+	if err != nil {
+		t.Fatalf("StepWindow failed: %v", err)
+	}
+	if window.Start != 200 || window.End != 0 {
+		t.Errorf("Expected window [200, inf), got [%v, %v)", window.Start, window.End)
+	}
+
+	if _, err := StepWindow(testData, 7, -1); err == nil {
+		t.Error("Expected error for missing step")
+	}
+}
+
+func TestSkipSteps(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "ProfilerStep#3", Ts: 1000, Dur: 100},
+			{Ph: "X", Name: "ProfilerStep#1", Ts: 0, Dur: 800}, // Out of order, still first
+			{Ph: "X", Name: "ProfilerStep#2", Ts: 800, Dur: 200},
+			{Ph: "X", Name: "ProfilerStep#4", Ts: 1100, Dur: 110},
+			{Ph: "X", Name: "ProfilerStep#5", Ts: 1210, Dur: 90},
+		},
+	}
+
+	window, err := SkipStepsWindow(testData, 2)
+	if err != nil {
+		t.Fatalf("SkipStepsWindow failed: %v", err)
+	}
+	if window != (TimeWindow{Start: 1000}) {
+		t.Errorf("Expected window [1000, inf), got %+v", window)
+	}
+	if _, err := SkipStepsWindow(testData, 5); err == nil {
+		t.Error("Expected error when skipping every step")
+	}
+
+	// Steps 1 and 2 take over 1.5x the median of 110us
+	if n := WarmupSteps(testData); n != 2 {
+		t.Errorf("Expected 2 warmup steps, got %d", n)
+	}
+	if n := WarmupSteps(&TraceData{TraceEvents: testData.TraceEvents[3:]}); n != 0 {
+		t.Errorf("Expected no warmup steps, got %d", n)
+	}
+}
+
+func TestConvertTrace_FocusIgnore(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "forward", Cat: "python_function", Tid: 1, Ts: 100, Dur: 100},
+			{Ph: "X", Name: "aten::conv2d", Cat: "cpu_op", Tid: 1, Ts: 110, Dur: 30},
+			{Ph: "X", Name: "aten::relu", Cat: "cpu_op", Tid: 1, Ts: 150, Dur: 30},
+			{Ph: "X", Name: "optimizer_step", Cat: "python_function", Tid: 1, Ts: 300, Dur: 10},
+		},
+	}
+
+	leaves := func(opts ConvertOptions) map[string]bool {
+		result := make(map[string]bool)
+		for _, stack := range sampleStacks(ConvertTrace(testData, opts)) {
+			result[stack[len(stack)-1]] = true
+		}
+		return result
+	}
+
+	focused := leaves(ConvertOptions{NumWorkers: 1, Focus: regexp.MustCompile(`aten::conv.*`)})
+	if len(focused) != 1 || !focused["aten::conv2d|cpu_op"] {
+		t.Errorf("Expected only the conv2d stack, got %v", focused)
+	}
+
+	ignored := leaves(ConvertOptions{NumWorkers: 1, Ignore: regexp.MustCompile(`^forward$`)})
+	if len(ignored) != 1 || !ignored["optimizer_step|python_function"] {
+		t.Errorf("Expected only the optimizer stack, got %v", ignored)
+	}
+}
+
+func TestConvertTrace_MinDuration(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "long", Cat: "cpu_op", Tid: 1, Ts: 100, Dur: 10},
+			{Ph: "X", Name: "short", Cat: "cpu_op", Tid: 1, Ts: 101, Dur: 0.5},
+			{Ph: "X", Name: "exact", Cat: "cpu_op", Tid: 1, Ts: 102, Dur: 5},
+		},
+	}
+
+	profile := ConvertTrace(testData, ConvertOptions{NumWorkers: 1, MinDuration: 5 * time.Microsecond})
+
+	leaves := make(map[string]bool)
+	for _, stack := range sampleStacks(profile) {
+		leaves[stack[len(stack)-1]] = true
+	}
+	if leaves["short|cpu_op"] {
+		t.Error("Expected short event to be dropped")
+	}
+	if !leaves["long|cpu_op"] || !leaves["exact|cpu_op"] {
+		t.Errorf("Expected long and exact events to be kept, got %v", leaves)
+	}
+}
+
+func TestCollapseRecursion(t *testing.T) {
+	frames := []frame{
+		{"step", "user_annotation"},
+		{"forward", "python_function"},
+		{"forward", "python_function"},
+		{"forward", "python_function"},
+		{"aten::mm", "cpu_op"},
+		{"aten::mm", "cpu_op"},
+		{"forward", "cpu_op"}, // Same name, different category
+	}
+
+	collapsed := collapseRecursion(frames)
+	expected := []frame{
+		{"step", "user_annotation"},
+		{"forward (x3)", "python_function"},
+		{"aten::mm (x2)", "cpu_op"},
+		{"forward", "cpu_op"},
+	}
+	if len(collapsed) != len(expected) {
+		t.Fatalf("Expected %d frames, got %d: %v", len(expected), len(collapsed), collapsed)
+	}
+	for i := range expected {
+		if collapsed[i] != expected[i] {
+			t.Errorf("Frame %d: expected %v, got %v", i, expected[i], collapsed[i])
+		}
+	}
+}
+
+func TestNormalizeKernelName(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"void at::native::unrolled_elementwise_kernel<at::native::CUDAFunctorOnSelf_add<int>, std::array<char*, 2ul>, 4>(int, at::native::CUDAFunctorOnSelf_add<int>, std::array<char*, 2ul>)",
+			"at::native::unrolled_elementwise_kernel",
+		},
+		{
+			"std::enable_if<!(false), void>::type internal::gemvx::kernel<int, int, __half, float, false, 7>(cublasGemvParamsEx<int, __half const>)",
+			"internal::gemvx::kernel",
+		},
+		{
+			"void vllm::reshape_and_cache_flash_kernel<unsigned short, unsigned short, (vllm::Fp8KVCacheDataType)0>(unsigned short const*, long const*)",
+			"vllm::reshape_and_cache_flash_kernel",
+		},
+		{
+			"void at::native::(anonymous namespace)::softmax_warp_forward<float, float, float, 8, false>(float*, float const*, int)",
+			"at::native::(anonymous namespace)::softmax_warp_forward",
+		},
+		{"triton_poi_fused_addmm_relu_1", "triton_poi_fused_addmm_relu"},
+		{"ampere_fp16_s16816gemm_fp16_64x64_sliced1x2_ldg8_relu_f2f_stages_64x6_tn", "ampere_fp16_s16816gemm_fp16_64x64_sliced1x2_ldg8_relu_f2f_stages_64x6_tn"},
+		{"_ZN2at6native29vectorized_elementwise_kernelILi4ENS0_11FillFunctorIfEENS_6detail5ArrayIPcLi1EEEEEviT0_T1_", "at::native::vectorized_elementwise_kernel"},
+		{"_Z13simple_kernelPfi", "simple_kernel"},
+		{"_Zgarbage", "_Zgarbage"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeKernelName(tt.input); got != tt.expected {
+			t.Errorf("normalizeKernelName(%q):\n  expected %q\n  got      %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestConvertTrace_NormalizeKernelNames(t *testing.T) {
+	raw := "void foo::kernel<float, 4>(float*, int)"
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: raw, Cat: "kernel", Tid: 7, Ts: 100, Dur: 10},
+			{Ph: "X", Name: "void foo::kernel<double, 8>(double*, int)", Cat: "kernel", Tid: 7, Ts: 200, Dur: 10},
+		},
+	}
+
+	profile := ConvertTrace(testData, ConvertOptions{NumWorkers: 1, NormalizeKernelNames: true, RawKernelNameLabel: true})
+
+	if len(profile.Function) != 1 {
+		t.Errorf("Expected both instantiations to share one function, got %d", len(profile.Function))
+	}
+	rawNames := make(map[string]bool)
+	for i, stack := range sampleStacks(profile) {
+		if stack[0] != "foo::kernel|kernel" {
+			t.Errorf("Expected normalized frame, got %v", stack)
+		}
+		rawNames[sampleLabels(profile, profile.Sample[i])["raw_name"]] = true
+	}
+	if !rawNames[raw] {
+		t.Errorf("Expected raw_name label %q, got %v", raw, rawNames)
+	}
+}
+
+func TestDetectOverlaps(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "a", Pid: 1, Tid: 1, Ts: 100, Dur: 50},
+			{Ph: "X", Name: "b", Pid: 1, Tid: 1, Ts: 120, Dur: 50}, // Ends after a
+			{Ph: "X", Name: "c", Pid: 1, Tid: 2, Ts: 100, Dur: 50},
+			{Ph: "X", Name: "d", Pid: 1, Tid: 2, Ts: 110, Dur: 10}, // Properly nested
+		},
+	}
+
+	overlaps := DetectOverlaps(testData, 0)
+	if len(overlaps) != 1 {
+		t.Fatalf("Expected 1 thread with overlaps, got %d", len(overlaps))
+	}
+	if overlaps[0].Tid != 1 || overlaps[0].Overlaps != 1 || overlaps[0].Events != 2 {
+		t.Errorf("Unexpected overlap report: %+v", overlaps[0])
+	}
+}
+
+func TestConvertTrace_OverlapPolicies(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "a", Cat: "c", Tid: 1, Ts: 100, Dur: 50},
+			{Ph: "X", Name: "b", Cat: "c", Tid: 1, Ts: 120, Dur: 50}, // 30us inside a, 20us after
+		},
+	}
+
+	timeByStack := func(policy OverlapPolicy) map[string]int64 {
+		profile := ConvertTrace(testData, ConvertOptions{NumWorkers: 1, Overlap: policy})
+		result := make(map[string]int64)
+		for i, stack := range sampleStacks(profile) {
+			result[strings.Join(stack, ";")] += profile.Sample[i].Value[1]
+		}
+		return result
+	}
+
+	split := timeByStack(OverlapSplit)
+	if split["a|c;b|c"] != 30000 || split["b|c"] != 20000 {
+		t.Errorf("Unexpected split attribution: %v", split)
+	}
+	// A split event counts once, in the part inside the overlapping event
+	var samples int64
+	for _, s := range ConvertTrace(testData, ConvertOptions{NumWorkers: 1}).Sample {
+		samples += s.Value[0]
+	}
+	if samples != 2 {
+		t.Errorf("Expected 2 samples for 2 events, got %d", samples)
+	}
+
+	parent := timeByStack(OverlapParent)
+	if parent["a|c;b|c"] != 50000 || parent["b|c"] != 0 {
+		t.Errorf("Unexpected parent attribution: %v", parent)
+	}
+
+	drop := timeByStack(OverlapDrop)
+	if len(drop) != 1 || drop["a|c"] != 50000 {
+		t.Errorf("Unexpected drop attribution: %v", drop)
+	}
+
+	// Dropped events still count as processed
+	opts := ConvertOptions{Overlap: OverlapDrop}
+	agg := newShardedAggregator(1, opts, slog.New(slog.DiscardHandler), func(stackSample) *sampleData { return &sampleData{} })
+	defer agg.close()
+	w := newStackWorker(opts, newFrameTable(), agg.sink())
+	var processed int64
+	group := &threadGroup{events: []eventWithEnd{
+		{Ts: 100, Dur: 50, End: 150, frames: []frame{{name: "a", cat: "c"}}, valueNs: 50000, timed: true},
+		{Ts: 120, Dur: 50, End: 170, frames: []frame{{name: "b", cat: "c"}}, valueNs: 50000, timed: true},
+	}}
+	if overlaps := w.processThreadEvents(context.Background(), group, &processed); overlaps != 1 || processed != 2 {
+		t.Errorf("Expected 1 overlap and 2 processed events with drop, got %d and %d", overlaps, processed)
+	}
+	w.done()
+
+	// The overlaps are found while building the stacks
+	_, diag, err := ConvertTraceWithDiagnostics(context.Background(), testData, ConvertOptions{NumWorkers: 1, Overlap: OverlapDrop})
+	if err != nil {
+		t.Fatalf("ConvertTraceWithDiagnostics: %v", err)
+	}
+	if len(diag.Overlaps) != 1 || diag.Overlaps[0].Tid != 1 || diag.Overlaps[0].Overlaps != 1 || diag.Overlaps[0].Events != 2 {
+		t.Errorf("Unexpected overlap report: %+v", diag.Overlaps)
+	}
+
+	// Default policy is split
+	if def := timeByStack(""); def["b|c"] != 20000 {
+		t.Errorf("Expected split as default policy, got %v", def)
+	}
+}
+
+func TestParseOverlapPolicy(t *testing.T) {
+	for _, s := range []string{"split", "parent", "drop"} {
+		if p, err := ParseOverlapPolicy(s); err != nil || string(p) != s {
+			t.Errorf("ParseOverlapPolicy(%q) = %q, %v", s, p, err)
+		}
+	}
+	if _, err := ParseOverlapPolicy("bogus"); err == nil {
+		t.Error("Expected error for unknown policy")
+	}
+}
+
+func TestConvertTrace_Epsilon(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "parent", Cat: "c", Tid: 1, Ts: 100, Dur: 50},
+			// Child ends 0.4us after its parent due to timestamp rounding
+			{Ph: "X", Name: "child", Cat: "c", Tid: 1, Ts: 140, Dur: 10.4},
+			// Sibling starting exactly when the parent ends
+			{Ph: "X", Name: "sibling", Cat: "c", Tid: 1, Ts: 150, Dur: 10},
+		},
+	}
+
+	stacks := func(epsilon time.Duration) map[string]bool {
+		result := make(map[string]bool)
+		for _, stack := range sampleStacks(ConvertTrace(testData, ConvertOptions{NumWorkers: 1, Epsilon: epsilon})) {
+			result[strings.Join(stack, ";")] = true
+		}
+		return result
+	}
+
+	// Touching events are never nested, even without tolerance
+	exact := stacks(0)
+	if !exact["sibling|c"] {
+		t.Errorf("Expected sibling at top level, got %v", exact)
+	}
+
+	tolerant := stacks(time.Microsecond)
+	if !tolerant["parent|c;child|c"] || !tolerant["sibling|c"] || len(tolerant) != 3 {
+		t.Errorf("Expected child nested and sibling at top level, got %v", tolerant)
+	}
+	if overlaps := DetectOverlaps(testData, time.Microsecond); len(overlaps) != 0 {
+		t.Errorf("Expected no overlaps within epsilon, got %v", overlaps)
+	}
+}
+
+func TestConvertTrace_LaunchLatency(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "aten::mm", Cat: "cpu_op", Pid: 1, Tid: 1, Ts: 100, Dur: 20},
+			{Ph: "X", Name: "cudaLaunchKernel", Cat: "cuda_runtime", Pid: 1, Tid: 1, Ts: 105, Dur: 5,
+				Args: map[string]interface{}{"correlation": float64(42)}},
+			{Ph: "X", Name: "gemm", Cat: "kernel", Pid: 0, Tid: 7, Ts: 130, Dur: 10,
+				Args: map[string]interface{}{"correlation": float64(42)}},
+			// Launch without a matching kernel gets no latency frame
+			{Ph: "X", Name: "cudaLaunchKernel", Cat: "cuda_runtime", Pid: 1, Tid: 1, Ts: 200, Dur: 5,
+				Args: map[string]interface{}{"correlation": float64(43)}},
+		},
+	}
+
+	profile := ConvertTrace(testData, ConvertOptions{NumWorkers: 1, LaunchLatency: true})
+
+	times := make(map[string]int64)
+	for i, stack := range sampleStacks(profile) {
+		times[strings.Join(stack, ";")] += profile.Sample[i].Value[1]
+	}
+	expected := "launch latency|launch_latency;cudaLaunchKernel|cuda_runtime"
+	if times[expected] != 25000 {
+		t.Errorf("Expected 25us launch latency as %q, got %v", expected, times)
+	}
+	// The CPU stack keeps the durations of its events
+	if times["aten::mm|cpu_op"] != 20000 || times["aten::mm|cpu_op;cudaLaunchKernel|cuda_runtime"] != 5000 {
+		t.Errorf("Expected launch latency outside the CPU stack, got %v", times)
+	}
+
+	hasLatency := func(opts ConvertOptions) bool {
+		for _, stack := range sampleStacks(ConvertTrace(testData, opts)) {
+			if stack[0] == launchLatencyFrame+"|"+launchLatencyCategory {
+				return true
+			}
+		}
+		return false
+	}
+	if hasLatency(ConvertOptions{NumWorkers: 1}) {
+		t.Error("Expected no launch latency frames by default")
+	}
+	// The delay is not thread CPU time
+	if hasLatency(ConvertOptions{NumWorkers: 1, LaunchLatency: true, Clock: ClockThread}) {
+		t.Error("Expected no launch latency frames with the thread clock")
+	}
+}
+
+func TestConvertTrace_IdleFrames(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "k1", Cat: "kernel", Tid: 7, Ts: 100, Dur: 10},
+			{Ph: "X", Name: "k2", Cat: "kernel", Tid: 7, Ts: 105, Dur: 20}, // Overlaps k1, no gap
+			{Ph: "X", Name: "k3", Cat: "kernel", Tid: 7, Ts: 150, Dur: 10}, // 25us gap
+			{Ph: "X", Name: "k4", Cat: "kernel", Tid: 7, Ts: 160, Dur: 10}, // Touching, no gap
+		},
+	}
+
+	idleNs := func(opts ConvertOptions) int64 {
+		profile := ConvertTrace(testData, opts)
+		var ns int64
+		for i, stack := range sampleStacks(profile) {
+			if stack[len(stack)-1] == idleFrame+"|"+idleCategory {
+				ns += profile.Sample[i].Value[1]
+			}
+		}
+		return ns
+	}
+	if ns := idleNs(ConvertOptions{NumWorkers: 1, IdleFrames: true}); ns != 25000 {
+		t.Errorf("Expected 25us of idle time, got %d ns", ns)
+	}
+	// Gaps are not thread CPU time
+	if ns := idleNs(ConvertOptions{NumWorkers: 1, IdleFrames: true, Clock: ClockThread}); ns != 0 {
+		t.Errorf("Expected no idle time with the thread clock, got %d ns", ns)
+	}
+}
+
+func TestConvertTrace_SampleRate(t *testing.T) {
+	var events []TraceEvent
+	events = append(events, TraceEvent{Ph: "X", Name: "parent", Cat: "c", Tid: 1, Ts: 0, Dur: 100000})
+	for i := 0; i < 10000; i++ {
+		events = append(events, TraceEvent{Ph: "X", Name: "leaf", Cat: "c", Tid: 1, Ts: float64(i * 10), Dur: 5})
+	}
+	testData := &TraceData{TraceEvents: events}
+
+	profile := ConvertTrace(testData, ConvertOptions{NumWorkers: 1, SampleRate: 0.1, SampleSeed: 1})
+
+	var leafCount, leafTime, parentCount int64
+	for i, stack := range sampleStacks(profile) {
+		switch strings.Join(stack, ";") {
+		case "parent|c;leaf|c":
+			leafCount += profile.Sample[i].Value[0]
+			leafTime += profile.Sample[i].Value[1]
+		case "parent|c":
+			parentCount += profile.Sample[i].Value[0]
+		}
+	}
+
+	// Parents are never dropped
+	if parentCount != 1 {
+		t.Errorf("Expected parent to be kept, got count %d", parentCount)
+	}
+	// Scaled leaf values should approximate the full totals
+	if leafCount < 9000 || leafCount > 11000 {
+		t.Errorf("Expected scaled leaf count near 10000, got %d", leafCount)
+	}
+	if leafTime < 45000000 || leafTime > 55000000 {
+		t.Errorf("Expected scaled leaf time near 50ms, got %d ns", leafTime)
+	}
+}
+
+func TestSubMicrosecondPrecision(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			// 1.005us * 1000 is 1004.999... in floating point
+			{Ph: "X", Name: "op", Cat: "c", Tid: 1, Ts: 100, Dur: 1.005},
+			{Ph: "X", Name: "tiny", Cat: "c", Tid: 1, Ts: 200, Dur: 0.0005},
+			{Ph: "X", Name: "tiny", Cat: "c", Tid: 1, Ts: 201, Dur: 0.0005},
+			{Ph: "X", Name: "tiny", Cat: "c", Tid: 1, Ts: 202, Dur: 0.0005},
+		},
+	}
+
+	analysis := AnalyzeTrace(testData)
+	if op := analysis.OperationStats["op"]; op.TimeNs != 1005 {
+		t.Errorf("Expected analyzer op time 1005 ns, got %d", op.TimeNs)
+	}
+
+	profile := ConvertTrace(testData, ConvertOptions{NumWorkers: 1})
+	times := make(map[string]int64)
+	for i, stack := range sampleStacks(profile) {
+		times[stack[0]] = profile.Sample[i].Value[1]
+	}
+	if times["op|c"] != 1005 {
+		t.Errorf("Expected op time 1005 ns, got %d", times["op|c"])
+	}
+	// Three 0.5ns events accumulate to 1.5ns before rounding
+	if times["tiny|c"] != 2 {
+		t.Errorf("Expected accumulated tiny time 2 ns, got %d", times["tiny|c"])
+	}
+}
+
+func TestConvertTrace_ThreadClock(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "wait", Cat: "c", Tid: 1, Ts: 100, Dur: 100, Tts: 10, Tdur: 5},
+			{Ph: "X", Name: "compute", Cat: "c", Tid: 1, Ts: 110, Dur: 50, Tts: 12, Tdur: 48},
+			{Ph: "X", Name: "kernel", Cat: "kernel", Tid: 7, Ts: 110, Dur: 50}, // No thread time
+		},
+	}
+
+	profile := ConvertTrace(testData, ConvertOptions{NumWorkers: 1, Clock: ClockThread})
+
+	if name := profile.StringTable[profile.SampleType[1].Type]; name != "cpu_time" {
+		t.Errorf("Expected cpu_time sample type, got %q", name)
+	}
+	times := make(map[string]int64)
+	for i, stack := range sampleStacks(profile) {
+		times[strings.Join(stack, ";")] = profile.Sample[i].Value[1]
+	}
+	expected := map[string]int64{"wait|c": 5000, "wait|c;compute|c": 48000}
+	if len(times) != len(expected) {
+		t.Errorf("Expected %d samples, got %v", len(expected), times)
+	}
+	for stack, ns := range expected {
+		if times[stack] != ns {
+			t.Errorf("Stack %q: expected %d ns, got %d", stack, ns, times[stack])
+		}
+	}
+}
+
+func TestConvertTrace_SampleTypes(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "op", Cat: "c", Tid: 1, Ts: 100, Dur: 2},
+		},
+	}
+
+	types, err := ParseSampleTypes("time")
+	if err != nil {
+		t.Fatalf("ParseSampleTypes failed: %v", err)
+	}
+	profile := ConvertTrace(testData, ConvertOptions{NumWorkers: 1, SampleTypes: types})
+	if len(profile.SampleType) != 1 || profile.StringTable[profile.SampleType[0].Type] != "time" {
+		t.Fatalf("Expected only the time sample type, got %+v", profile.SampleType)
+	}
+	if len(profile.Sample) != 1 || len(profile.Sample[0].Value) != 1 || profile.Sample[0].Value[0] != 2000 {
+		t.Errorf("Expected a single 2000 ns value, got %+v", profile.Sample)
+	}
+	if errs := profile.Validate(); len(errs) > 0 {
+		t.Errorf("Profile is invalid: %v", errs)
+	}
+
+	types, _ = ParseSampleTypes("cpu_time,samples")
+	profile = ConvertTrace(testData, ConvertOptions{NumWorkers: 1, SampleTypes: types})
+	if v := profile.Sample[0].Value; len(v) != 2 || v[0] != 2000 || v[1] != 1 {
+		t.Errorf("Expected values in the requested order, got %v", v)
+	}
+	if _, err := ParseSampleTypes("bytes"); err == nil {
+		t.Error("Expected error for unknown sample type")
+	}
+}
+
+func TestConvertTrace_Labels(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "op", Cat: "c", Pid: 1, Tid: 1, Ts: 100, Dur: 2},
+			{Ph: "X", Name: "op", Cat: "c", Pid: 2, Tid: 1, Ts: 100, Dur: 2},
+		},
+	}
+
+	profile := ConvertTrace(testData, ConvertOptions{
+		NumWorkers: 1,
+		Labels:     map[string]string{"run": "1234", "experiment": "bert-large"},
+	})
+	if len(profile.Sample) != 2 {
+		t.Fatalf("Expected 2 samples, got %d", len(profile.Sample))
+	}
+	for _, s := range profile.Sample {
+		labels := make(map[string]string)
+		for _, l := range s.Label {
+			labels[profile.StringTable[l.Key]] = profile.StringTable[l.Str]
+		}
+		if labels["experiment"] != "bert-large" || labels["run"] != "1234" || labels["pid"] == "" {
+			t.Errorf("Unexpected labels: %v", labels)
+		}
+	}
+	var comments []string
+	for _, c := range profile.Comment {
+		comments = append(comments, profile.StringTable[c])
+	}
+	if want := []string{"experiment=bert-large", "run=1234"}; !slices.Equal(comments, want) {
+		t.Errorf("Comments = %v, want %v", comments, want)
+	}
+}
+
+func TestConvertTrace_MaxMemory(t *testing.T) {
+	var events []TraceEvent
+	for i := range 50 {
+		// Stacks repeat across threads, so spilled runs share keys
+		events = append(events,
+			TraceEvent{Ph: "X", Name: "step", Cat: "c", Pid: 1, Tid: i % 5, Ts: float64(i * 100), Dur: 90},
+			TraceEvent{Ph: "X", Name: fmt.Sprintf("op%d", i%7), Cat: "c", Pid: 1, Tid: i % 5, Ts: float64(i*100 + 10), Dur: 20, Args: map[string]interface{}{"External id": float64(i % 3)}},
+		)
+	}
+	testData := &TraceData{TraceEvents: events}
+
+	values := func(p *profile.Profile) map[string][]int64 {
+		m := make(map[string][]int64)
+		for i, stack := range sampleStacks(p) {
+			key := strings.Join(stack, ";")
+			for _, l := range p.Sample[i].Label {
+				key += " " + p.StringTable[l.Key] + "=" + p.StringTable[l.Str]
+			}
+			m[key] = p.Sample[i].Value
+		}
+		return m
+	}
+	unbounded := ConvertTrace(testData, ConvertOptions{NumWorkers: 1})
+	want := values(unbounded)
+
+	// A budget the profile just fits in is too small for the aggregated
+	// samples, which take more memory each
+	var budget int64
+	for _, s := range unbounded.Sample {
+		budget += profileSampleBytes(len(s.LocationId), len(s.Value), len(s.Label))
+	}
+	var log bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	dir := t.TempDir()
+	spilled, err := ConvertTraceContext(context.Background(), testData, ConvertOptions{NumWorkers: 1, MaxMemory: budget, SpillDir: dir, Logger: logger})
+	if err != nil {
+		t.Fatalf("ConvertTraceContext: %v", err)
+	}
+	if !strings.Contains(log.String(), "Spilled samples to disk") {
+		t.Errorf("Expected samples to be spilled within %d bytes", budget)
+	}
+	if got := values(spilled); !maps.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("Spilled conversion differs:\n got %v\nwant %v", got, want)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Expected spill files to be removed, found %d", len(files))
+	}
+
+	// A profile that does not fit fails instead of outgrowing the budget
+	_, err = ConvertTraceContext(context.Background(), testData, ConvertOptions{NumWorkers: 1, MaxMemory: budget - 1, SpillDir: dir})
+	if !errors.Is(err, ErrMemoryBudget) {
+		t.Errorf("Expected ErrMemoryBudget, got %v", err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Expected spill files to be removed after failing, found %d", len(files))
+	}
+	if p := ConvertTrace(testData, ConvertOptions{NumWorkers: 1, MaxMemory: 1, SpillDir: dir}); !maps.EqualFunc(values(p), want, slices.Equal) {
+		t.Error("Expected ConvertTrace to convert without a budget the profile does not fit")
+	}
+
+	_, err = ConvertTraceContext(context.Background(), testData, ConvertOptions{MaxMemory: 1, SpillDir: filepath.Join(dir, "missing")})
+	if err == nil {
+		t.Error("Expected an error for an unusable spill directory")
+	}
+}
+
+func TestAggregator_HashCollision(t *testing.T) {
+	agg := newAggregator(ConvertOptions{}, slog.New(slog.DiscardHandler))
+	a := newStackSample([]uint32{1}, nil, 10)
+	b := newStackSample([]uint32{2}, nil, 20)
+	b.hash = a.hash // Force a collision
+
+	created := 0
+	create := func(stackSample) *sampleData {
+		created++
+		return &sampleData{}
+	}
+	for _, s := range []stackSample{a, b, a} {
+		if err := agg.add(s, create); err != nil {
+			t.Fatalf("add: %v", err)
+		}
+	}
+	if created != 2 || agg.len() != 2 {
+		t.Fatalf("Expected 2 samples, created %d, len %d", created, agg.len())
+	}
+
+	got := make(map[uint32]float64)
+	_ = agg.each(func(s *sampleData) { got[s.ids[0]] = s.timeNs })
+	if got[1] != 20 || got[2] != 20 {
+		t.Errorf("Expected 1=20 2=20, got %v", got)
+	}
+}
+
+func TestAggregator_HeapLimit(t *testing.T) {
+	agg := newAggregator(ConvertOptions{MaxMemory: 1 << 40}, slog.New(slog.DiscardHandler))
+	agg.heapLimit = 1 // Always exceeded
+	defer agg.close()
+
+	create := func(stackSample) *sampleData { return &sampleData{} }
+	for i := range heapCheckInterval {
+		if err := agg.add(newStackSample([]uint32{uint32(i)}, nil, 1), create); err != nil {
+			t.Fatalf("add: %v", err)
+		}
+	}
+	if len(agg.runs) != 0 {
+		t.Fatal("Expected no spill for the heap while holding little of the budget")
+	}
+
+	agg.maxBytes = 4 << 20 // Over a quarter held, still under budget
+	runtime.GC()           // So the live heap is known
+	for i := range heapCheckInterval {
+		if err := agg.add(newStackSample([]uint32{uint32(heapCheckInterval + i)}, nil, 1), create); err != nil {
+			t.Fatalf("add: %v", err)
+		}
+	}
+	if len(agg.runs) != 1 || agg.len() != 2*heapCheckInterval {
+		t.Errorf("Expected one run over the heap limit and %d samples, got %d runs and %d samples", 2*heapCheckInterval, len(agg.runs), agg.len())
+	}
+}
+
+func TestShardedAggregator(t *testing.T) {
+	agg := newShardedAggregator(4, ConvertOptions{}, slog.New(slog.DiscardHandler), func(stackSample) *sampleData {
+		return &sampleData{}
+	})
+	defer agg.close()
+
+	// Two workers emitting the same 1000 stacks
+	for range 2 {
+		sink := agg.sink()
+		for i := range 1000 {
+			sink.add(newStackSample([]uint32{uint32(i)}, nil, 1))
+		}
+		sink.flush()
+	}
+	if err := agg.wait(); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+
+	if agg.len() != 1000 {
+		t.Errorf("Expected 1000 samples, got %d", agg.len())
+	}
+	seen := make(map[uint32]bool)
+	_ = agg.each(func(s *sampleData) {
+		id := s.ids[0]
+		if seen[id] || s.count != 2 {
+			t.Errorf("Expected frame %d once with count 2, got count %v", id, s.count)
+		}
+		seen[id] = true
+	})
+}
+
+func TestStackWorker(t *testing.T) {
+	agg := newShardedAggregator(2, ConvertOptions{}, slog.New(slog.DiscardHandler), func(stackSample) *sampleData {
+		return &sampleData{}
+	})
+	defer agg.close()
+	frames := newFrameTable()
+	w := newStackWorker(ConvertOptions{}, frames, agg.sink())
+
+	// Two threads repeating a parent with two children, one of them with
+	// a label
+	grid := []sampleLabel{{key: "grid", str: "[1, 1, 1]"}}
+	for range 2 {
+		var events []eventWithEnd
+		for i := range 3 {
+			ts := float64(i * 100)
+			events = append(events,
+				eventWithEnd{Ts: ts, Dur: 50, End: ts + 50, frames: []frame{{name: "step", cat: "c"}}, valueNs: 10000, timed: true},
+				eventWithEnd{Ts: ts + 10, Dur: 10, End: ts + 20, frames: []frame{{name: "mm", cat: "c"}}, valueNs: 10000, timed: true},
+				eventWithEnd{Ts: ts + 30, Dur: 10, End: ts + 40, frames: []frame{{name: "mm", cat: "c"}}, labels: grid, valueNs: 10000, timed: true})
+		}
+		var processed int64
+		w.processThreadEvents(context.Background(), &threadGroup{events: events}, &processed)
+		if processed != 9 {
+			t.Fatalf("Expected 9 processed events, got %d", processed)
+		}
+	}
+	if len(w.samples) != 3 {
+		t.Fatalf("Expected 3 pending stacks, got %d", len(w.samples))
+	}
+	w.done()
+	if err := agg.wait(); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+
+	got := make(map[string]float64)
+	_ = agg.each(func(s *sampleData) {
+		var names []string
+		for _, id := range s.ids {
+			names = append(names, frames.frame(id).name)
+		}
+		key := strings.Join(names, ";")
+		if len(s.sampleLabels) > 0 {
+			key += " " + s.sampleLabels[0].str
+		}
+		got[key] = s.count
+	})
+	want := map[string]float64{"step": 6, "step;mm": 6, "step;mm [1, 1, 1]": 6}
+	if !maps.Equal(got, want) {
+		t.Errorf("Samples = %v, want %v", got, want)
+	}
+}
+
+func TestReadResources(t *testing.T) {
+	meminfo := &fstest.MapFile{Data: []byte("MemTotal:       16384000 kB\nMemAvailable:    8192000 kB\n")}
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+		want Resources
+	}{
+		{"nothing known", fstest.MapFS{}, Resources{CPUs: 8}},
+		{"meminfo", fstest.MapFS{"proc/meminfo": meminfo}, Resources{CPUs: 8, Memory: 8192000 << 10}},
+		{"cgroup v2", fstest.MapFS{
+			"proc/meminfo":                 meminfo,
+			"sys/fs/cgroup/cpu.max":        {Data: []byte("250000 100000\n")},
+			"sys/fs/cgroup/memory.max":     {Data: []byte("4294967296\n")},
+			"sys/fs/cgroup/memory.current": {Data: []byte("1073741824\n")},
+		}, Resources{CPUs: 3, Memory: 3 << 30}},
+		{"cgroup v2 without limits", fstest.MapFS{
+			"sys/fs/cgroup/cpu.max":    {Data: []byte("max 100000\n")},
+			"sys/fs/cgroup/memory.max": {Data: []byte("max\n")},
+		}, Resources{CPUs: 8}},
+		{"cgroup v1", fstest.MapFS{
+			"sys/fs/cgroup/cpu/cpu.cfs_quota_us":         {Data: []byte("50000\n")},
+			"sys/fs/cgroup/cpu/cpu.cfs_period_us":        {Data: []byte("100000\n")},
+			"sys/fs/cgroup/memory/memory.limit_in_bytes": {Data: []byte("9223372036854771712\n")},
+		}, Resources{CPUs: 1}},
+	}
+	for _, tt := range tests {
+		if got := readResources(tt.fsys, 8); got != tt.want {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestAutoTune(t *testing.T) {
+	small := &TraceData{TraceEvents: make([]TraceEvent, 1000)}
+	large := &TraceData{TraceEvents: make([]TraceEvent, 10*workerEvents)}
+	res := Resources{CPUs: 96, Memory: 1 << 30}
+
+	if opts := AutoTune(ConvertOptions{}, small, res); opts.NumWorkers != 1 || opts.MaxMemory != 0 {
+		t.Errorf("Small trace: expected 1 worker and no memory limit, got %d and %d", opts.NumWorkers, opts.MaxMemory)
+	}
+	if opts := AutoTune(ConvertOptions{}, large, res); opts.NumWorkers != 10 || opts.MaxMemory != 0 {
+		t.Errorf("Large trace: expected 10 workers and no memory limit, got %d and %d", opts.NumWorkers, opts.MaxMemory)
+	}
+	if opts := AutoTune(ConvertOptions{}, large, Resources{CPUs: 4, Memory: 64 << 20}); opts.NumWorkers != 4 || opts.MaxMemory != 32<<20 {
+		t.Errorf("Little memory: expected 4 workers spilling beyond 32 MiB, got %d and %d", opts.NumWorkers, opts.MaxMemory)
+	}
+	if opts := AutoTune(ConvertOptions{NumWorkers: 3, MaxMemory: 1 << 40}, large, Resources{CPUs: 4, Memory: 64 << 20}); opts.NumWorkers != 3 || opts.MaxMemory != 1<<40 {
+		t.Errorf("Expected set options to be kept, got %d and %d", opts.NumWorkers, opts.MaxMemory)
+	}
+	if opts := AutoTune(ConvertOptions{}, large, Resources{CPUs: 4}); opts.MaxMemory != 0 {
+		t.Errorf("Expected no memory limit when memory is unknown, got %d", opts.MaxMemory)
+	}
+
+	// Shard queues shrink to fit a tenth of the memory budget
+	if n := shardQueueLen(ConvertOptions{NumWorkers: 8}, 4); n != 16 {
+		t.Errorf("Expected 16 batches per shard, got %d", n)
+	}
+	if n := shardQueueLen(ConvertOptions{NumWorkers: 8, MaxMemory: 40 * sampleBatchSize * queuedSampleBytes}, 4); n != 1 {
+		t.Errorf("Expected 1 batch per shard, got %d", n)
+	}
+}
+
+func TestGroupByThread_Chunks(t *testing.T) {
+	var events []TraceEvent
+	for i := range 300 {
+		e := TraceEvent{Ph: "X", Name: fmt.Sprintf("op%d", i%7), Pid: float64(i % 2), Tid: float64(i % 3), Ts: float64(300 - i), Dur: float64(i % 5)}
+		if i%11 == 0 {
+			e.Ph = "i"
+		}
+		events = append(events, e)
+	}
+	md := collectMetadata(events)
+
+	// Runs grouped separately and merged match a single run
+	whole := &Diagnostics{SkippedPhases: make(map[string]int)}
+	want := mergeGroupChunks([]groupChunk{groupEvents(events, ConvertOptions{}, md, nil)}, whole)
+	sortThreadEvents(want, 1)
+	split := &Diagnostics{SkippedPhases: make(map[string]int)}
+	got := mergeGroupChunks([]groupChunk{
+		groupEvents(events[:1], ConvertOptions{}, md, nil),
+		groupEvents(events[1:120], ConvertOptions{}, md, nil),
+		groupEvents(events[120:], ConvertOptions{}, md, nil),
+	}, split)
+	sortThreadEvents(got, 4)
+
+	if !reflect.DeepEqual(split, whole) {
+		t.Errorf("Expected counts %+v, got %+v", whole, split)
+	}
+	byRoot := func(groups []*threadGroup) map[string]*threadGroup {
+		m := make(map[string]*threadGroup)
+		for _, g := range groups {
+			m[fmt.Sprint(g.labels, len(g.events), g.events[0].Ts)] = g
+		}
+		return m
+	}
+	if len(got) != 6 || !reflect.DeepEqual(byRoot(got), byRoot(want)) {
+		t.Errorf("Grouping in runs differs from a single run")
+	}
+}
+
+func TestConvertTrace_WorkerPool(t *testing.T) {
+	var events []TraceEvent
+	for tid := range 100 {
+		for i := range tid%5 + 1 {
+			events = append(events, TraceEvent{Ph: "X", Name: fmt.Sprintf("op%d", i), Cat: "c", Pid: 1, Tid: tid, Ts: float64(i * 10), Dur: 5})
+		}
+	}
+	testData := &TraceData{TraceEvents: events}
+
+	totals := func(p *profile.Profile) map[string]int64 {
+		m := make(map[string]int64)
+		for i, stack := range sampleStacks(p) {
+			m[strings.Join(stack, ";")] += p.Sample[i].Value[1]
+		}
+		return m
+	}
+	want := totals(ConvertTrace(testData, ConvertOptions{NumWorkers: 1}))
+	for _, workers := range []int{0, 3, 200} {
+		if got := totals(ConvertTrace(testData, ConvertOptions{NumWorkers: workers})); !maps.Equal(got, want) {
+			t.Errorf("NumWorkers %d: got %v, want %v", workers, got, want)
+		}
+	}
+}
+
+func TestConvertTrace_EventMappers(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "step", Cat: "user_annotation", Tid: 1, Ts: 100, Dur: 100},
+			{Ph: "X", Name: "block", Cat: "inhouse", Tid: 1, Ts: 110, Dur: 50, Args: map[string]interface{}{"layer": "encoder"}},
+			{Ph: "X", Name: "aten::mm", Cat: "cpu_op", Tid: 1, Ts: 120, Dur: 20},
+			{Ph: "X", Name: "heartbeat", Cat: "noise", Tid: 1, Ts: 170, Dur: 10},
+		},
+	}
+
+	opts := ConvertOptions{NumWorkers: 1, SampleTypes: []SampleType{SampleTime}}
+	opts.EventMappers = map[string]EventMapper{
+		"inhouse": EventMapperFunc(func(e TraceEvent) (MappedEvent, bool) {
+			m, _ := DefaultEventMapper(opts).MapEvent(e)
+			layer, _ := e.Args["layer"].(string)
+			m.Frames = append([]Frame{{Name: layer, Category: "layer"}}, m.Frames...)
+			m.Labels = append(m.Labels, Label{Key: "layer", Str: layer})
+			return m, true
+		}),
+		"noise": EventMapperFunc(func(TraceEvent) (MappedEvent, bool) {
+			return MappedEvent{}, false
+		}),
+	}
+	profile := ConvertTrace(testData, opts)
+
+	got := make(map[string]int64)
+	for i, stack := range sampleStacks(profile) {
+		s := profile.Sample[i]
+		key := strings.Join(stack, ";")
+		if strings.Contains(key, "heartbeat") {
+			t.Errorf("Dropped event in stack %q", key)
+		}
+		if strings.HasSuffix(key, "block|inhouse") && sampleLabels(profile, s)["layer"] != "encoder" {
+			t.Errorf("Missing layer label on %q", key)
+		}
+		got[key] += s.Value[0]
+	}
+	want := map[string]int64{
+		"step|user_annotation":                                             100000,
+		"step|user_annotation;encoder|layer;block|inhouse":                 50000,
+		"step|user_annotation;encoder|layer;block|inhouse;aten::mm|cpu_op": 20000,
+	}
+	for key, v := range want {
+		if got[key] != v {
+			t.Errorf("Stack %q: expected %d, got %d (all: %v)", key, v, got[key], got)
+		}
+	}
+}
+
+func TestConvertTrace_Rewrite(t *testing.T) {
+	rules, err := ParseRewriteRules(strings.NewReader(`
+# Fold autograd nodes
+- match: '^autograd::engine::evaluate_function: .*'
+  rename: autograd::engine::evaluate_function
+  merge: true
+- match: "^(ProfilerStep)#\\d+$"  # strip step numbers
+  rename: $1
+-
+  match: ^cudaStreamSynchronize$
+  drop: true
+`))
+	if err != nil {
+		t.Fatalf("ParseRewriteRules: %v", err)
+	}
+
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "ProfilerStep#1", Tid: 1, Ts: 0, Dur: 100},
+			{Ph: "X", Name: "autograd::engine::evaluate_function: MmBackward0", Tid: 1, Ts: 10, Dur: 50},
+			{Ph: "X", Name: "autograd::engine::evaluate_function: AddBackward0", Tid: 1, Ts: 20, Dur: 30},
+			{Ph: "X", Name: "cudaStreamSynchronize", Tid: 1, Ts: 25, Dur: 20},
+			{Ph: "X", Name: "aten::mm", Tid: 1, Ts: 30, Dur: 10},
+			{Ph: "X", Name: "ProfilerStep#2", Tid: 1, Ts: 100, Dur: 100},
+		},
+	}
+	profile := ConvertTrace(testData, ConvertOptions{
+		NumWorkers:  1,
+		SampleTypes: []SampleType{SampleTime},
+		Rewrite:     rules,
+	})
+
+	got := make(map[string]int64)
+	for i, stack := range sampleStacks(profile) {
+		names := make([]string, len(stack))
+		for j, f := range stack {
+			names[j], _, _ = strings.Cut(f, "|")
+		}
+		got[strings.Join(names, ";")] += profile.Sample[i].Value[0]
+	}
+	want := map[string]int64{
+		"ProfilerStep": 200000,
+		"ProfilerStep;autograd::engine::evaluate_function":          100000,
+		"ProfilerStep;autograd::engine::evaluate_function;aten::mm": 10000,
+	}
+	if len(got) != len(want) {
+		t.Errorf("Expected stacks %v, got %v", want, got)
+	}
+	for key, v := range want {
+		if got[key] != v {
+			t.Errorf("Stack %q: expected %d, got %d", key, v, got[key])
+		}
+	}
+}
+
+func TestParseRewriteRules_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"no match", "- rename: x", "line 1: rule without match"},
+		{"bad regexp", "- match: '('", "line 1: invalid match"},
+		{"unknown key", "- match: x\n  replace: y", `line 2: unknown key "replace"`},
+		{"bad bool", "- match: x\n  drop: yes please", "line 2: drop must be true or false"},
+		{"drop and rename", "- match: x\n  drop: true\n  rename: y", "line 1: drop cannot be combined"},
+		{"not a list", "match: x", "line 1: expected a list item"},
+		{"unterminated", "- match: 'x", "line 1: unterminated string"},
+	}
+	for _, tt := range tests {
+		_, err := ParseRewriteRules(strings.NewReader(tt.input))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestParseScalar(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  string
+	}{
+		{in: "", want: ""},
+		{in: "plain", want: "plain"},
+		{in: "plain # comment", want: "plain"},
+		{in: "plain\t# comment", want: "plain"},
+		{in: "# only a comment", want: ""},
+		{in: "a#b", want: "a#b"},
+		{in: `"quoted"`, want: "quoted"},
+		{in: `"a \"b\" \\d+"`, want: `a "b" \d+`},
+		{in: `"a" # don't "quote" this`, want: "a"},
+		{in: `"a # b"`, want: "a # b"},
+		{in: `'single'`, want: "single"},
+		{in: `'it''s' # it's "quoted"`, want: "it's"},
+		{in: `'a' # 'b'`, want: "a"},
+		{in: `'a # b'`, want: "a # b"},
+		{in: `'a'' b'`, want: "a' b"},
+		{in: `"a`, err: "unterminated string"},
+		{in: `"a\"`, err: "unterminated string"},
+		{in: `'a''`, err: "unterminated string"},
+		{in: `"a" b`, err: `unexpected "b" after string "a"`},
+		{in: `'a' 'b'`, err: `unexpected "'b'" after string 'a'`},
+	}
+	for _, tt := range tests {
+		got, err := parseScalar(tt.in)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseScalar(%s): expected error containing %q, got %q, %v", tt.in, tt.err, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseScalar(%s) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+
+	// A quote in the comment after a quoted match does not end the match
+	rw, err := ParseRewriteRules(strings.NewReader("- match: '^a$' # don't merge 'b'\n  rename: \"c\" # was \"a\"\n"))
+	if err != nil {
+		t.Fatalf("ParseRewriteRules: %v", err)
+	}
+	if got := rw.RewriteFrames([]Frame{{Name: "a"}}); len(got) != 1 || got[0].Name != "c" {
+		t.Errorf("Expected a renamed to c, got %+v", got)
+	}
+}
+
+func TestNewConvertOptions(t *testing.T) {
+	opts, err := NewConvertOptions(
+		WithThreadRoots(),
+		WithClock(ClockThread),
+		WithSampleTypes(SampleTime),
+		WithLabel("run", "1"),
+		WithLabel("experiment", "a"),
+		WithWorkers(2),
+	)
+	if err != nil {
+		t.Fatalf("NewConvertOptions: %v", err)
+	}
+	if !opts.ThreadRoots || opts.Clock != ClockThread || opts.NumWorkers != 2 || len(opts.Labels) != 2 {
+		t.Errorf("Unexpected options: %+v", opts)
+	}
+
+	_, err = NewConvertOptions(
+		WithSampleRate(1.5, 0),
+		WithOverlap("merge"),
+		WithSampleTypes(SampleTime, SampleTime),
+	)
+	if err == nil {
+		t.Fatal("Expected an error for invalid options")
+	}
+	for _, want := range []string{"sample rate 1.5", `unknown overlap policy "merge"`, `duplicate sample type "time"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got %v", want, err)
+		}
+	}
+
+	testData := &TraceData{TraceEvents: []TraceEvent{{Ph: "X", Name: "op", Tid: 1, Ts: 0, Dur: 10}}}
+	if _, err := ConvertTraceContext(context.Background(), testData, ConvertOptions{Clock: "cpu"}); err == nil {
+		t.Error("Expected ConvertTraceContext to reject an unknown clock")
+	}
+	// ConvertTrace falls back to the defaults instead
+	invalid := ConvertOptions{Clock: "cpu", NumWorkers: -1, SampleTypes: []SampleType{"bytes"}, Labels: map[string]string{"": "x"}}
+	if p := ConvertTrace(testData, invalid); p == nil || len(p.Sample) != 1 || len(p.SampleType) != 2 {
+		t.Errorf("Expected ConvertTrace to convert with default options, got %+v", p)
+	}
+	if err := invalid.orDefaults().Validate(); err != nil {
+		t.Errorf("Expected valid options after orDefaults, got %v", err)
+	}
+	p, err := Convert(context.Background(), testData, WithSampleTypes(SampleCount))
+	if err != nil {
+		t.Fatalf("Convert: %v", err)
+	}
+	if len(p.SampleType) != 1 || len(p.Sample) != 1 {
+		t.Errorf("Expected 1 sample type and 1 sample, got %d and %d", len(p.SampleType), len(p.Sample))
+	}
+}
+
+func TestConvertTrace_Logger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.json")
+	content := `{"traceEvents": [
+		{"ph": "X", "name": "parent", "tid": 1, "ts": 0, "dur": 10},
+		{"ph": "X", "name": "straddle", "tid": 1, "ts": 5, "dur": 10},
+		{"ph": "B", "name": "open", "tid": 2, "ts": 0},
+		{"ph": "E", "tid": 2, "ts": 5},
+		{"ph": "Q", "name": "odd", "tid": 2, "ts": 0},
+		{"ph": "M", "name": "thread_name", "tid": 2}
+	]} trailing`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+
+	var logs strings.Builder
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	if _, err := ConvertTraceFile(context.Background(), path, ConvertOptions{Logger: logger}); err != nil {
+		t.Fatalf("ConvertTraceFile: %v", err)
+	}
+	for _, want := range []string{
+		`msg="Ignoring data after the trace JSON object"`,
+		`msg="Skipping events with unknown phase" phase=Q events=1`,
+		`begin=1 end=1`,
+		`msg="Partially overlapping events found" threads=1`,
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("Expected log containing %q, got:\n%s", want, logs.String())
+		}
+	}
+	if strings.Contains(logs.String(), "phase=M") {
+		t.Errorf("Metadata events should only be logged at debug level, got:\n%s", logs.String())
+	}
+}
+
+func TestConvertTraceWithDiagnostics(t *testing.T) {
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "parent", Tid: 1, Ts: 0, Dur: 10},
+			{Ph: "X", Name: "straddle", Tid: 1, Ts: 5, Dur: 10},
+			{Ph: "X", Name: "instant", Tid: 1, Ts: 20, Dur: 0},
+			{Ph: "X", Name: "no tid", Ts: 30, Dur: 5},
+			{Ph: "B", Name: "open", Tid: 2, Ts: 0},
+			{Ph: "E", Tid: 2, Ts: 5},
+			{Ph: "M", Name: "thread_name", Tid: 2},
+		},
+	}
+
+	_, diag, err := ConvertTraceWithDiagnostics(context.Background(), testData, ConvertOptions{})
+	if err != nil {
+		t.Fatalf("ConvertTraceWithDiagnostics: %v", err)
+	}
+	if diag.Events != 7 || diag.Converted != 3 || diag.Skipped() != 4 {
+		t.Errorf("Expected 7 events, 3 converted and 4 skipped, got %d, %d and %d", diag.Events, diag.Converted, diag.Skipped())
+	}
+	if want := map[string]int{"B": 1, "E": 1, "M": 1}; !maps.Equal(diag.SkippedPhases, want) {
+		t.Errorf("SkippedPhases = %v, want %v", diag.SkippedPhases, want)
+	}
+	if diag.ZeroDuration != 1 || diag.UnknownTids != 1 {
+		t.Errorf("Expected 1 zero-duration event and 1 unknown tid, got %d and %d", diag.ZeroDuration, diag.UnknownTids)
+	}
+	if len(diag.Overlaps) != 1 || diag.Overlaps[0].Overlaps != 1 {
+		t.Errorf("Expected 1 overlapping event, got %+v", diag.Overlaps)
+	}
+	if diag.TimeUnit != "us" {
+		t.Errorf("Expected time unit us, got %q", diag.TimeUnit)
+	}
+	var stages []string
+	for _, phase := range diag.Memory {
+		stages = append(stages, phase.Stage)
+		if phase.HeapBytes == 0 {
+			t.Errorf("Expected heap bytes for stage %s", phase.Stage)
+		}
+	}
+	if want := []string{stageGroup, StageBuild, StageAggregate}; !slices.Equal(stages, want) {
+		t.Errorf("Memory stages = %v, want %v", stages, want)
+	}
+
+	nanos := &TraceData{TraceEvents: []TraceEvent{{Ph: "X", Name: "op", Tid: 1, Ts: 1.7e18, Dur: 1000}}}
+	if _, diag, _ := ConvertTraceWithDiagnostics(context.Background(), nanos, ConvertOptions{}); diag.TimeUnit != "ns" {
+		t.Errorf("Expected time unit ns for epoch nanoseconds, got %q", diag.TimeUnit)
+	}
+}
+
+func TestConverter(t *testing.T) {
+	c, err := NewConverter(ConvertOptions{SampleTypes: []SampleType{SampleTime}})
+	if err != nil {
+		t.Fatalf("NewConverter: %v", err)
+	}
+
+	// The child arrives before its parent
+	c.AddEvents([]TraceEvent{{Ph: "X", Name: "matmul", Tid: 1, Ts: 20, Dur: 30}})
+	c.AddEvents([]TraceEvent{{Ph: "X", Name: "forward", Tid: 1, Ts: 0, Dur: 100}})
+	p, err := c.Snapshot(context.Background())
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	stacks := make(map[string]int64)
+	for i, stack := range sampleStacks(p) {
+		stacks[strings.Join(stack, ";")] = p.Sample[i].Value[0]
+	}
+	if stacks["forward|;matmul|"] != 30000 || stacks["forward|"] != 100000 {
+		t.Errorf("Unexpected snapshot stacks: %v", stacks)
+	}
+
+	// Snapshots keep the events, Finish starts over
+	c.AddEvents([]TraceEvent{{Ph: "X", Name: "backward", Tid: 1, Ts: 100, Dur: 50}})
+	if p := c.Finish(); len(p.Sample) != 3 {
+		t.Errorf("Expected 3 samples at finish, got %d", len(p.Sample))
+	}
+	if c.Len() != 0 {
+		t.Errorf("Expected an empty session after Finish, got %d events", c.Len())
+	}
+
+	if _, err := NewConverter(ConvertOptions{SampleRate: 2}); err == nil {
+		t.Error("Expected NewConverter to reject invalid options")
+	}
+}
+
+func TestTraceSpans(t *testing.T) {
+	traceData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "ProfilerStep#3", Cat: "user_annotation", Pid: 1, Tid: 1, Ts: 0, Dur: 100},
+			{Ph: "X", Name: "forward", Cat: "user_annotation", Pid: 1, Tid: 1, Ts: 10, Dur: 50},
+			{Ph: "X", Name: "aten::linear", Cat: "cpu_op", Pid: 1, Tid: 1, Ts: 20, Dur: 30},
+			{Ph: "X", Name: "aten::matmul", Cat: "cpu_op", Pid: 1, Tid: 1, Ts: 25, Dur: 10},
+			{Ph: "X", Name: "python_fn", Cat: "python_function", Pid: 1, Tid: 1, Ts: 60, Dur: 10},
+		},
+		Metadata: map[string]json.RawMessage{"baseTimeNanoseconds": json.RawMessage("1700000000000000000")},
+	}
+
+	spans := TraceSpans(traceData, SpanOptions{Ops: true})
+	var names []string
+	for _, s := range spans {
+		parent := ""
+		if s.Parent >= 0 {
+			parent = spans[s.Parent].Name
+		}
+		names = append(names, parent+">"+s.Name)
+	}
+	// Nested ops and other categories are left out
+	if want := []string{">ProfilerStep#3", "ProfilerStep#3>forward", "forward>aten::linear"}; !slices.Equal(names, want) {
+		t.Errorf("Expected spans %v, got %v", want, names)
+	}
+	if spans[0].Step != 3 || spans[1].Step != -1 {
+		t.Errorf("Expected step 3 and -1, got %d and %d", spans[0].Step, spans[1].Step)
+	}
+	if want := time.Unix(0, 1700000000000010000); !spans[1].Start.Equal(want) || spans[1].End.Sub(spans[1].Start) != 50*time.Microsecond {
+		t.Errorf("Expected forward at %v for 50us, got %v to %v", want, spans[1].Start, spans[1].End)
+	}
+
+	if spans := TraceSpans(traceData, SpanOptions{}); len(spans) != 2 {
+		t.Errorf("Expected 2 spans without ops, got %d", len(spans))
+	}
+}
+
+func TestOperationTimes(t *testing.T) {
+	traceData := &TraceData{TraceEvents: []TraceEvent{
+		{Ph: "X", Name: "forward", Pid: 1, Tid: 1, Ts: 0, Dur: 100},
+		{Ph: "X", Name: "forward", Pid: 1, Tid: 1, Ts: 10, Dur: 50},
+		{Ph: "X", Name: "matmul", Pid: 1, Tid: 1, Ts: 20, Dur: 30},
+		{Ph: "X", Name: "matmul", Pid: 1, Tid: 2, Ts: 20, Dur: 30},
+	}}
+
+	got := map[string]OperationTime{}
+	for _, o := range OperationTimes(traceData, 0) {
+		got[o.Name] = o
+	}
+
+	// forward: outer 100-50 + inner 50-30 self; total counts the recursion once
+	if f := got["forward"]; f.SelfNs != 70000 || f.TotalNs != 100000 || f.Count != 2 {
+		t.Errorf("Unexpected forward: %+v", f)
+	}
+	if m := got["matmul"]; m.SelfNs != 60000 || m.TotalNs != 60000 || m.Count != 2 {
+		t.Errorf("Unexpected matmul: %+v", m)
+	}
+}
+
+func TestTraceAnalysisJSON(t *testing.T) {
+	analysis := AnalyzeTrace(&TraceData{TraceEvents: []TraceEvent{
+		{Ph: "X", Name: "matmul", Cat: "cpu_op", Ts: 0, Dur: 10},
+	}})
+
+	data, err := json.Marshal(analysis)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var decoded struct {
+		TotalTimeNs int64 `json:"total_time_ns"`
+		Operations  map[string]struct {
+			Count  int   `json:"count"`
+			TimeNs int64 `json:"time_ns"`
+		} `json:"operations"`
+		Categories map[string]json.RawMessage `json:"categories"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if decoded.TotalTimeNs != 10000 || decoded.Operations["matmul"].TimeNs != 10000 || decoded.Categories["cpu_op"] == nil {
+		t.Errorf("Unexpected JSON: %s", data)
+	}
+}
+
+func TestConvertProgress(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "trace.json")
+	var events []TraceEvent
+	for i := 0; i < 2*progressInterval+5; i++ {
+		events = append(events, TraceEvent{Ph: "X", Name: "op", Pid: 1, Tid: 1, Ts: float64(i * 10), Dur: 5})
+	}
+	data, err := json.Marshal(map[string]interface{}{"schemaVersion": 1, "traceEvents": events})
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+
+	type report struct {
+		stage       string
+		done, total int64
+	}
+	var reports []report
+	progress := func(stage string, done, total int64) {
+		reports = append(reports, report{stage, done, total})
+	}
+	traceData, err := LoadTraceFileWithProgress(path, progress)
+	if err != nil {
+		t.Fatalf("LoadTraceFileWithProgress failed: %v", err)
+	}
+	if len(traceData.TraceEvents) != len(events) {
+		t.Fatalf("Expected %d events, got %d", len(events), len(traceData.TraceEvents))
+	}
+	size := int64(len(data))
+	if len(reports) != 3 || reports[2] != (report{StageParse, size, size}) {
+		t.Errorf("Unexpected load progress: %v", reports)
+	}
+
+	reports = nil
+	if _, err := ConvertTraceFile(context.Background(), path, ConvertOptions{NumWorkers: 1, Progress: progress}); err != nil {
+		t.Fatalf("ConvertTraceFile failed: %v", err)
+	}
+	final := make(map[string]report)
+	var stages []string
+	for _, r := range reports {
+		if len(stages) == 0 || stages[len(stages)-1] != r.stage {
+			stages = append(stages, r.stage)
+		}
+		final[r.stage] = r
+	}
+	if want := []string{StageParse, StageBuild, StageAggregate}; !slices.Equal(stages, want) {
+		t.Errorf("Stages = %v, want %v", stages, want)
+	}
+	if r := final[StageBuild]; r.done != int64(len(events)) || r.total != int64(len(events)) {
+		t.Errorf("Expected final build progress %d/%d, got %d/%d", len(events), len(events), r.done, r.total)
+	}
+	if r := final[StageAggregate]; r.done != 1 || r.total != 1 {
+		t.Errorf("Expected final aggregate progress 1/1, got %d/%d", r.done, r.total)
+	}
+}
+
+func TestGenerateTrace(t *testing.T) {
+	opts := GenerateOptions{Events: 5000, Threads: 3, Depth: 6, Seed: 7}
+	var buf, again bytes.Buffer
+	if err := GenerateTrace(&buf, opts); err != nil {
+		t.Fatalf("GenerateTrace failed: %v", err)
+	}
+	if err := GenerateTrace(&again, opts); err != nil {
+		t.Fatalf("GenerateTrace failed: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), again.Bytes()) {
+		t.Error("Expected the same trace for the same seed")
+	}
+
+	traceData, err := LoadTrace(context.Background(), &buf, LoadOptions{})
+	if err != nil {
+		t.Fatalf("LoadTrace failed: %v", err)
+	}
+	complete := 0
+	launches := make(map[int64]bool)
+	for _, e := range traceData.TraceEvents {
+		if e.Ph != "X" {
+			continue
+		}
+		complete++
+		if e.Cat == "cuda_runtime" {
+			id, _ := correlationID(e)
+			launches[id] = true
+		}
+	}
+	if complete != opts.Events {
+		t.Errorf("Expected %d complete events, got %d", opts.Events, complete)
+	}
+	gpu := 0
+	for _, e := range traceData.TraceEvents {
+		if isGPUEvent(e) {
+			gpu++
+			if id, ok := correlationID(e); !ok || !launches[id] {
+				t.Fatalf("GPU event without launch: %+v", e)
+			}
+		}
+	}
+	if gpu == 0 || gpu != len(launches) {
+		t.Errorf("Expected one GPU event per launch, got %d for %d launches", gpu, len(launches))
+	}
+
+	p, diag, err := ConvertTraceWithDiagnostics(context.Background(), traceData, ConvertOptions{})
+	if err != nil {
+		t.Fatalf("ConvertTraceWithDiagnostics failed: %v", err)
+	}
+	if len(diag.Overlaps) > 0 || len(p.Sample) == 0 {
+		t.Errorf("Expected cleanly nested events, got %d overlapping threads and %d samples", len(diag.Overlaps), len(p.Sample))
+	}
+
+	if err := GenerateTrace(io.Discard, GenerateOptions{Threads: -1}); err == nil {
+		t.Error("Expected an error for negative options")
+	}
+}
+
+func TestWriteTraceFile(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "slice.json.gz")
+	testData := &TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "M", Name: "thread_name", Pid: float64(1), Tid: float64(1), Args: map[string]interface{}{"name": "main"}},
+			{Ph: "X", Name: "matmul", Cat: "cpu_op", Pid: float64(1), Tid: float64(1), Ts: 100, Dur: 50},
+		},
+		Metadata: map[string]json.RawMessage{"distributedInfo": json.RawMessage(`{"rank": 3}`)},
+	}
+
+	if err := WriteTraceFile(testFile, testData); err != nil {
+		t.Fatalf("WriteTraceFile failed: %v", err)
+	}
+	loaded, err := LoadTraceFile(testFile)
+	if err != nil {
+		t.Fatalf("LoadTraceFile failed: %v", err)
+	}
+
+	if len(loaded.TraceEvents) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(loaded.TraceEvents))
+	}
+	if e := loaded.TraceEvents[1]; e.Name != "matmul" || e.Ts != 100 || e.Dur != 50 || e.Tid != float64(1) {
+		t.Errorf("Unexpected event: %+v", e)
+	}
+	if loaded.TraceEvents[0].Args["name"] != "main" {
+		t.Errorf("Expected metadata args to survive, got %+v", loaded.TraceEvents[0].Args)
+	}
+	if string(loaded.Metadata["distributedInfo"]) != `{"rank": 3}` {
+		t.Errorf("Expected distributedInfo to survive, got %q", loaded.Metadata["distributedInfo"])
+	}
+}
+
+func TestSplitTrace(t *testing.T) {
+	traceData := &TraceData{TraceEvents: []TraceEvent{
+		{Ph: "M", Name: "process_name", Pid: float64(10), Args: map[string]interface{}{"name": "rank0"}},
+		{Ph: "X", Name: "ProfilerStep#1", Cat: "user_annotation", Pid: float64(10), Tid: float64(1), Ts: 0, Dur: 100},
+		{Ph: "X", Name: "ProfilerStep#2", Cat: "user_annotation", Pid: float64(10), Tid: float64(1), Ts: 100, Dur: 100},
+		{Ph: "X", Name: "cudaLaunchKernel", Cat: "cuda_runtime", Pid: float64(10), Tid: float64(1), Ts: 10, Dur: 5,
+			Args: map[string]interface{}{"correlation": float64(1)}},
+		{Ph: "X", Name: "cudaLaunchKernel", Cat: "cuda_runtime", Pid: float64(20), Tid: float64(1), Ts: 110, Dur: 5,
+			Args: map[string]interface{}{"correlation": float64(2)}},
+		{Ph: "X", Name: "gemm", Cat: "kernel", Pid: float64(0), Tid: float64(7), Ts: 20, Dur: 30,
+			Args: map[string]interface{}{"correlation": float64(1), "device": float64(0)}},
+		{Ph: "X", Name: "gemm", Cat: "kernel", Pid: float64(1), Tid: float64(7), Ts: 120, Dur: 30,
+			Args: map[string]interface{}{"correlation": float64(2), "device": float64(1)}},
+		{Ph: "X", Name: "annotation", Cat: "gpu_user_annotation", Pid: float64(0), Tid: float64(7), Ts: 20, Dur: 30},
+		{Ph: "f", Name: "ac2g", Cat: "ac2g", Pid: float64(0), Tid: float64(7), Ts: 20},
+	}}
+
+	names := func(groups []TraceGroup) []string {
+		var result []string
+		for _, g := range groups {
+			result = append(result, g.Name+":"+strconv.Itoa(len(g.Trace.TraceEvents)))
+		}
+		return result
+	}
+	tests := []struct {
+		by   SplitKey
+		want string
+	}{
+		// Every group keeps the process_name metadata event
+		{SplitStep, "step1:6 step2:4"},
+		{SplitRank, "pid10:6 pid20:3"},
+		{SplitGPU, "gpu0:2 gpu1:2"},
+	}
+	for _, tt := range tests {
+		groups, err := SplitTrace(traceData, tt.by)
+		if err != nil {
+			t.Fatalf("SplitTrace(%s) failed: %v", tt.by, err)
+		}
+		if got := strings.Join(names(groups), " "); got != tt.want {
+			t.Errorf("SplitTrace(%s): expected %q, got %q", tt.by, tt.want, got)
+		}
+	}
+
+	traceData.Metadata = map[string]json.RawMessage{"distributedInfo": json.RawMessage(`{"rank": 3}`)}
+	groups, err := SplitTrace(traceData, SplitRank)
+	if err != nil || len(groups) != 1 || groups[0].Name != "rank3" {
+		t.Errorf("Expected a single rank3 group, got %v (%v)", names(groups), err)
+	}
+
+	if _, err := ParseSplitKey("thread"); err == nil {
+		t.Error("Expected error for unknown split key")
+	}
+}
+
+func TestConvertWithAnalysis(t *testing.T) {
+	traceData := &TraceData{TraceEvents: []TraceEvent{
+		{Ph: "M", Name: "thread_name", Pid: float64(1), Tid: float64(1), Args: map[string]interface{}{"name": "main"}},
+		{Ph: "X", Name: "forward", Cat: "python_function", Pid: float64(1), Tid: float64(1), Ts: 0, Dur: 100},
+		{Ph: "X", Name: "aten::mm", Cat: "cpu_op", Pid: float64(1), Tid: float64(1), Ts: 10, Dur: 30},
+		{Ph: "X", Name: "aten::mm", Cat: "cpu_op", Pid: float64(1), Tid: float64(1), Ts: 50, Dur: 0},
+		{Ph: "X", Name: "gemm", Cat: "kernel", Pid: float64(0), Tid: float64(7), Ts: 20, Dur: 15,
+			Args: map[string]interface{}{"device": float64(0), "stream": float64(7)}},
+	}}
+
+	for _, groupBy := range []GroupBy{GroupByName, GroupByThread} {
+		opts, err := NewConvertOptions(WithAnalysis(groupBy), WithAnalysisReports(AllReports), WithMinDuration(20*time.Microsecond))
+		if err != nil {
+			t.Fatalf("NewConvertOptions failed: %v", err)
+		}
+		_, diag, err := ConvertTraceWithDiagnostics(context.Background(), traceData, opts)
+		if err != nil {
+			t.Fatalf("ConvertTraceWithDiagnostics failed: %v", err)
+		}
+		// Conversion options do not change the analysis of the trace
+		if want := AnalyzeTraceBy(traceData, groupBy); !reflect.DeepEqual(diag.Analysis, want) {
+			t.Errorf("%s: analysis differs from AnalyzeTraceBy:\n got %+v\nwant %+v", groupBy, diag.Analysis, want)
+		}
+	}
+
+	_, diag, err := ConvertTraceWithDiagnostics(context.Background(), traceData, ConvertOptions{})
+	if err != nil || diag.Analysis != nil {
+		t.Errorf("Expected no analysis by default, got %v, %v", diag.Analysis, err)
+	}
+	if err := (ConvertOptions{AnalyzeBy: "shape"}).Validate(); err == nil {
+		t.Error("Expected error for unknown AnalyzeBy")
+	}
+}
+
+func TestInfo(t *testing.T) {
+	info := Info(&TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "M", Name: "process_name", Pid: float64(10), Args: map[string]interface{}{"name": "trainer"}},
+			{Ph: "X", Name: "ProfilerStep#3", Cat: "user_annotation", Pid: float64(10), Tid: float64(1), Ts: 100, Dur: 50},
+			{Ph: "X", Name: "aten::mm", Cat: "cpu_op", Pid: float64(10), Tid: float64(1), Ts: 110, Dur: 10,
+				Args: map[string]interface{}{"Input Dims": []interface{}{}, "flops": float64(10)}},
+			{Ph: "X", Name: "gemm", Cat: "kernel", Pid: float64(0), Tid: float64(7), Ts: 120, Dur: 40,
+				Args: map[string]interface{}{"device": float64(0)}},
+			{Ph: "i", Name: "[memory]", Pid: float64(10), Tid: float64(1), Ts: 115},
+		},
+		Metadata: map[string]json.RawMessage{
+			"schemaVersion":    json.RawMessage(`1`),
+			"deviceProperties": json.RawMessage(`[{"id": 0, "name": "A100"}]`),
+			"distributedInfo":  json.RawMessage(`{"backend": "nccl", "rank": 1, "world_size": 8}`),
+		},
+	})
+
+	if info.Events != 5 || info.EventsByPhase["X"] != 3 || info.EventsByPhase["M"] != 1 {
+		t.Errorf("Unexpected event counts: %d %v", info.Events, info.EventsByPhase)
+	}
+	if info.SpanNs != 60000 || string(info.SchemaVersion) != "1" || info.ProfilerSteps != 1 {
+		t.Errorf("Unexpected span %d, schema %s or steps %d", info.SpanNs, info.SchemaVersion, info.ProfilerSteps)
+	}
+	if len(info.Processes) != 2 || info.Processes[1] != (ProcessInfo{Pid: "10", Name: "trainer", Events: 3}) {
+		t.Errorf("Unexpected processes: %+v", info.Processes)
+	}
+	if len(info.Devices) != 1 || info.Devices[0] != (DeviceInfo{ID: "0", Name: "A100"}) {
+		t.Errorf("Unexpected devices: %+v", info.Devices)
+	}
+	if info.DistributedInfo == nil || info.DistributedInfo.Rank != 1 || info.DistributedInfo.WorldSize != 8 {
+		t.Errorf("Unexpected distributed info: %+v", info.DistributedInfo)
+	}
+	if info.Stacks || !info.Shapes || !info.Memory || !info.Flops {
+		t.Errorf("Unexpected recorded data: stacks %t shapes %t memory %t flops %t",
+			info.Stacks, info.Shapes, info.Memory, info.Flops)
+	}
+}
+
+func TestBuildTimeline(t *testing.T) {
+	timeline := BuildTimeline(&TraceData{
+		TraceEvents: []TraceEvent{
+			{Ph: "X", Name: "forward", Pid: float64(10), Tid: float64(1), Ts: 0, Dur: 100},
+			{Ph: "X", Name: "aten::mm", Pid: float64(10), Tid: float64(1), Ts: 10, Dur: 20},
+			{Ph: "X", Name: "gemm", Cat: "kernel", Pid: float64(0), Tid: float64(7), Ts: 50, Dur: 25},
+			{Ph: "X", Name: "gemm", Cat: "kernel", Pid: float64(0), Tid: float64(7), Ts: 150, Dur: 50},
+			{Ph: "i", Name: "marker", Pid: float64(10), Tid: float64(1), Ts: 60},
+		},
+	}, 4)
+
+	if timeline.StartUs != 0 || timeline.EndUs != 200 || timeline.BucketUs != 50 {
+		t.Fatalf("Unexpected bounds: %+v", timeline)
+	}
+	if len(timeline.Lanes) != 2 {
+		t.Fatalf("Expected 2 lanes, got %d", len(timeline.Lanes))
+	}
+	gpu, cpu := timeline.Lanes[0], timeline.Lanes[1]
+	if !gpu.GPU || gpu.Pid != "0" || gpu.BusyNs != 75000 {
+		t.Errorf("Unexpected GPU lane: %+v", gpu)
+	}
+	if want := []float64{0, 0.5, 0, 1}; !slices.Equal(gpu.Utilization, want) {
+		t.Errorf("GPU utilization = %v, want %v", gpu.Utilization, want)
+	}
+	// Nested events count once
+	if cpu.GPU || cpu.BusyNs != 100000 {
+		t.Errorf("Unexpected CPU lane: %+v", cpu)
+	}
+	if want := []float64{1, 1, 0, 0}; !slices.Equal(cpu.Utilization, want) {
+		t.Errorf("CPU utilization = %v, want %v", cpu.Utilization, want)
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	traceData := &TraceData{}
+	for i := 0; i < 2*progressInterval; i++ {
+		traceData.TraceEvents = append(traceData.TraceEvents,
+			TraceEvent{Ph: "X", Name: "op", Pid: 1, Tid: 1, Ts: float64(i), Dur: 1})
+	}
+	testFile := filepath.Join(t.TempDir(), "trace.json")
+	if err := WriteTraceFile(testFile, traceData); err != nil {
+		t.Fatalf("WriteTraceFile failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := LoadTraceFileContext(ctx, testFile, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("LoadTraceFileContext: expected context.Canceled, got %v", err)
+	}
+	if _, err := ConvertTraceContext(ctx, traceData, ConvertOptions{NumWorkers: 1}); !errors.Is(err, context.Canceled) {
+		t.Errorf("ConvertTraceContext: expected context.Canceled, got %v", err)
+	}
+	if _, err := AnalyzeTraceContext(ctx, traceData, GroupByName); !errors.Is(err, context.Canceled) {
+		t.Errorf("AnalyzeTraceContext: expected context.Canceled, got %v", err)
+	}
+
+	if _, err := ConvertTraceContext(context.Background(), traceData, ConvertOptions{NumWorkers: 1}); err != nil {
+		t.Errorf("ConvertTraceContext without cancellation failed: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package converter
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// dataStallFraction is the share of a step spent waiting for data above
+// which the input pipeline is the bottleneck of the step
+const dataStallFraction = 0.5
+
+// DataLoaderStats is the time a training step spent waiting for its input
+// batch, in the DataLoader events on the CPU, versus the rest of the step.
+// Steps are the time windows of the ProfilerStep spans; Step is -1 for the
+// whole trace. Stalled steps spent at least half their time waiting for
+// data, so a faster input pipeline would speed them up the most.
+type DataLoaderStats struct {
+	Step         int     `json:"step"`
+	StepNs       int64   `json:"step_ns"`
+	DataNs       int64   `json:"data_ns"`
+	ComputeNs    int64   `json:"compute_ns"`
+	DataFraction float64 `json:"data_fraction"`
+	Stalled      bool    `json:"stalled"`
+}
+
+// isDataLoaderEvent reports whether e fetches a batch from a DataLoader:
+// the enumerate(DataLoader) spans torch.profiler records around each
+// fetch, or the iterator's __next__ in Python stacks
+func isDataLoaderEvent(e TraceEvent) bool {
+	return strings.HasPrefix(e.Name, "enumerate(DataLoader)") ||
+		strings.Contains(e.Name, "DataLoaderIter.__next__") ||
+		strings.Contains(e.Name, "dataloader.py(") && strings.HasSuffix(e.Name, "__next__")
+}
+
+// dataLoaderStats returns the data loading time of the whole trace and of
+// each step, or nil if the trace has no DataLoader events
+func (a *traceAnalyzer) dataLoaderStats() []DataLoaderStats {
+	if len(a.dataLoader) == 0 {
+		return nil
+	}
+	data := a.dataLoader.merge()
+	add := func(result []DataLoaderStats, step int, window TimeWindow, spanUs float64) []DataLoaderStats {
+		stats := DataLoaderStats{
+			Step:   step,
+			StepNs: usToNs(spanUs),
+			DataNs: usToNs(data.clip(window).total()),
+		}
+		stats.ComputeNs = stats.StepNs - stats.DataNs
+		if stats.StepNs > 0 {
+			stats.DataFraction = float64(stats.DataNs) / float64(stats.StepNs)
+		}
+		stats.Stalled = stats.DataFraction >= dataStallFraction
+		return append(result, stats)
+	}
+	result := add(nil, -1, TimeWindow{}, a.end-a.start)
+	for _, step := range slices.Sorted(maps.Keys(a.steps)) {
+		w := a.steps[step]
+		result = add(result, step, w, w.End-w.Start)
+	}
+	return result
+}
@@ -0,0 +1,31 @@
+package converter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeTraceDataLoader(t *testing.T) {
+	const next = "enumerate(DataLoader)#_MultiProcessingDataLoaderIter.__next__"
+	analysis := AnalyzeTrace(&TraceData{TraceEvents: []TraceEvent{
+		annotation("ProfilerStep#1", 0, 100),
+		annotation(next, 0, 10),
+		annotation("forward", 10, 90),
+		annotation("ProfilerStep#2", 100, 100),
+		annotation(next, 100, 70),
+		annotation("torch/utils/data/dataloader.py(628): __next__", 110, 20), // Nested, counts once
+		annotation("forward", 170, 30),
+	}})
+
+	want := []DataLoaderStats{
+		{Step: -1, StepNs: 200000, DataNs: 80000, ComputeNs: 120000, DataFraction: 0.4},
+		{Step: 1, StepNs: 100000, DataNs: 10000, ComputeNs: 90000, DataFraction: 0.1},
+		{Step: 2, StepNs: 100000, DataNs: 70000, ComputeNs: 30000, DataFraction: 0.7, Stalled: true},
+	}
+	if !reflect.DeepEqual(analysis.DataLoader, want) {
+		t.Errorf("Expected %+v, got %+v", want, analysis.DataLoader)
+	}
+	if got := AnalyzeTrace(&TraceData{TraceEvents: []TraceEvent{annotation("forward", 0, 1)}}).DataLoader; got == nil || len(got) != 0 {
+		t.Errorf("Expected empty data loading statistics without DataLoader events, got %+v", got)
+	}
+}
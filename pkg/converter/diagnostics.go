@@ -0,0 +1,151 @@
+package converter
+
+import (
+	"log/slog"
+	"sort"
+)
+
+// Diagnostics reports which events a conversion left out and what it had
+// to guess, so that data loss is visible rather than silent
+type Diagnostics struct {
+	// Events is the number of events in the trace and Converted the
+	// number of complete events placed on call stacks
+	Events    int
+	Converted int
+
+	// SkippedPhases counts the events other than complete events (ph=X),
+	// by phase
+	SkippedPhases map[string]int
+
+	// ZeroDuration counts complete events without a positive duration
+	ZeroDuration int
+
+	// ShorterThanMin counts events dropped by ConvertOptions.MinDuration
+	ShorterThanMin int
+
+	// DroppedByMapper counts events an EventMapper dropped
+	DroppedByMapper int
+
+	// UnknownTids counts converted events whose tid is missing or neither
+	// a number nor a string; they are all converted as thread 0
+	UnknownTids int
+
+	// Overlaps lists the threads with partially overlapping events, as
+	// found while building their stacks, most overlaps first
+	Overlaps []ThreadOverlap
+
+	// TimeUnit is the guessed unit of ts and dur: "us" as the trace format
+	// specifies, or "ns" when timestamps look like nanoseconds since the
+	// epoch, which makes every duration 1000 times too long
+	TimeUnit string
+
+	// Analysis holds the trace statistics when ConvertOptions.AnalyzeBy
+	// is set
+	Analysis *TraceAnalysis
+
+	// Memory records the heap use of each phase of the conversion, in
+	// order
+	Memory []MemoryPhase
+}
+
+// addCounts adds the skipped and converted event counts of o to d
+func (d *Diagnostics) addCounts(o *Diagnostics) {
+	d.Converted += o.Converted
+	for ph, n := range o.SkippedPhases {
+		d.SkippedPhases[ph] += n
+	}
+	d.ZeroDuration += o.ZeroDuration
+	d.ShorterThanMin += o.ShorterThanMin
+	d.DroppedByMapper += o.DroppedByMapper
+	d.UnknownTids += o.UnknownTids
+}
+
+// Skipped is the number of events that were not converted
+func (d *Diagnostics) Skipped() int {
+	return d.Events - d.Converted
+}
+
+// nanosecondEpoch is the smallest timestamp read as nanoseconds since the
+// epoch: 1e17 is 1973 in nanoseconds but far in the future in microseconds
+const nanosecondEpoch = 1e17
+
+// guessTimeUnit guesses the unit of the trace's timestamps
+func guessTimeUnit(traceData *TraceData) string {
+	for _, e := range traceData.TraceEvents {
+		if e.Ts > nanosecondEpoch {
+			return "ns"
+		}
+	}
+	return "us"
+}
+
+// isKnownID reports whether a pid or tid field has a type getTid converts
+func isKnownID(id interface{}) bool {
+	switch id.(type) {
+	case float64, int, int64, string:
+		return true
+	default:
+		return false
+	}
+}
+
+// tracePhases are the event phases of the Chrome trace event format
+var tracePhases = map[string]bool{
+	"B": true, "E": true, "X": true, "i": true, "I": true, "C": true,
+	"b": true, "n": true, "e": true, "S": true, "T": true, "p": true, "F": true,
+	"s": true, "t": true, "f": true, "P": true, "N": true, "O": true, "D": true,
+	"M": true, "V": true, "v": true, "R": true, "c": true, "(": true, ")": true,
+}
+
+// logSkipped reports the skipped events: unknown phases, begin/end events,
+// whose time is lost, and a nanosecond time unit as warnings, and the rest
+// as debug details
+func (d *Diagnostics) logSkipped(logger *slog.Logger) {
+	phases := make([]string, 0, len(d.SkippedPhases))
+	for ph := range d.SkippedPhases {
+		phases = append(phases, ph)
+	}
+	sort.Strings(phases)
+	for _, ph := range phases {
+		if !tracePhases[ph] {
+			logger.Warn("Skipping events with unknown phase", "phase", ph, "events", d.SkippedPhases[ph])
+		}
+	}
+	if begin, end := d.SkippedPhases["B"], d.SkippedPhases["E"]; begin+end > 0 {
+		logger.Warn("Skipping begin/end events, only complete events (ph=X) are converted", "begin", begin, "end", end)
+	}
+	if d.TimeUnit == "ns" {
+		logger.Warn("Timestamps look like nanoseconds, but trace times are read as microseconds")
+	}
+	if d.UnknownTids > 0 {
+		logger.Warn("Events without a usable tid are converted as thread 0", "events", d.UnknownTids)
+	}
+	for _, ph := range phases {
+		if tracePhases[ph] && ph != "B" && ph != "E" {
+			logger.Debug("Skipping non-complete events", "phase", ph, "events", d.SkippedPhases[ph])
+		}
+	}
+	if d.ZeroDuration > 0 {
+		logger.Debug("Skipping complete events with zero duration", "events", d.ZeroDuration)
+	}
+	if d.ShorterThanMin > 0 {
+		logger.Debug("Dropped events shorter than the minimum duration", "events", d.ShorterThanMin)
+	}
+	if d.DroppedByMapper > 0 {
+		logger.Debug("Dropped events by event mappers", "events", d.DroppedByMapper)
+	}
+}
+
+// logOverlaps reports the threads with partially overlapping events
+func (d *Diagnostics) logOverlaps(logger *slog.Logger, policy OverlapPolicy) {
+	if len(d.Overlaps) == 0 {
+		return
+	}
+	if policy == "" {
+		policy = OverlapSplit
+	}
+	logger.Warn("Partially overlapping events found", "threads", len(d.Overlaps), "policy", policy)
+	for _, o := range d.Overlaps {
+		logger.Debug("Overlapping events", "pid", o.Pid, "tid", o.Tid, "overlaps", o.Overlaps, "events", o.Events)
+	}
+}
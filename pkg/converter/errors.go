@@ -0,0 +1,73 @@
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotATrace is returned for inputs that are not trace JSON, such as
+	// text files or JSON objects without traceEvents
+	ErrNotATrace = errors.New("not a PyTorch trace")
+
+	// ErrUnsupportedFormat is returned for trace formats that can be
+	// recognized but not loaded, such as binary protobuf traces
+	ErrUnsupportedFormat = errors.New("unsupported trace format")
+
+	// ErrMemoryBudget is returned when the profile needs more memory than
+	// ConvertOptions.MaxMemory, even with its samples spilled to disk
+	ErrMemoryBudget = errors.New("memory budget exceeded")
+)
+
+// ParseError is malformed JSON in a trace. Offset is the byte offset in the
+// uncompressed trace and Snippet the input around it, when still buffered.
+type ParseError struct {
+	Offset  int64
+	Snippet string
+	Err     error
+}
+
+func (e *ParseError) Error() string {
+	if e.Snippet == "" {
+		return fmt.Sprintf("invalid trace JSON at byte %d: %v", e.Offset, e.Err)
+	}
+	return fmt.Sprintf("invalid trace JSON at byte %d near %q: %v", e.Offset, e.Snippet, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// sniffSize is how many bytes sniffFormat looks at
+const sniffSize = 512
+
+// sniffFormat checks that the input starts like a trace JSON object,
+// telling other formats apart so the error says what the input is
+func sniffFormat(br *bufio.Reader) error {
+	head, _ := br.Peek(sniffSize)
+	return sniffHead(head)
+}
+
+// sniffHead is sniffFormat for the first sniffSize bytes of the input
+func sniffHead(head []byte) error {
+	trimmed := bytes.TrimLeft(head, " \t\r\n\ufeff")
+	if len(trimmed) == 0 {
+		return fmt.Errorf("%w: the input is empty", ErrNotATrace)
+	}
+	switch trimmed[0] {
+	case '{':
+		return nil
+	case '[':
+		return fmt.Errorf(`%w: JSON array traces are not supported, wrap the events as {"traceEvents": [...]}`, ErrUnsupportedFormat)
+	}
+	for _, b := range head {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' {
+			return fmt.Errorf("%w: binary data rather than JSON, such as a Perfetto protobuf trace or a pprof profile", ErrUnsupportedFormat)
+		}
+	}
+	return fmt.Errorf("%w: the input does not start with a JSON object", ErrNotATrace)
+}
+
+// snippetContext is how many bytes of input a ParseError quotes on each
+// side of the offset
+const snippetContext = 40
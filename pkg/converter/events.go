@@ -0,0 +1,63 @@
+package converter
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"iter"
+	"log/slog"
+)
+
+// Events returns an iterator over the events of the trace read from r,
+// plain or gzip-compressed, yielding each event as soon as it is parsed so
+// single-pass analyses need not hold the whole trace in memory. Top-level
+// fields other than traceEvents are skipped. A loading error, with the same
+// types as LoadTraceFile, is yielded last with a zero event.
+func Events(r io.Reader) iter.Seq2[TraceEvent, error] {
+	return func(yield func(TraceEvent, error) bool) {
+		br := bufio.NewReader(r)
+		var input io.Reader = br
+		if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+			gzReader, err := gzip.NewReader(br)
+			if err != nil {
+				yield(TraceEvent{}, err)
+				return
+			}
+			defer func() { _ = gzReader.Close() }()
+			input = gzReader
+		}
+
+		stopped := false
+		err := decodeEvents(input, nil, slog.New(slog.DiscardHandler), func(string, json.RawMessage) {}, func(e TraceEvent) bool {
+			if !yield(e, nil) {
+				stopped = true
+				return false
+			}
+			return true
+		})
+		if err != nil && !stopped {
+			yield(TraceEvent{}, err)
+		}
+	}
+}
+
+// decodeEvents parses a trace JSON object, calling meta for each top-level
+// field other than traceEvents and event for each event, in input order.
+// It stops without error when event returns false. Only the event args in
+// keepArgs are read, unless it is nil.
+func decodeEvents(r io.Reader, keepArgs map[string]bool, logger *slog.Logger, meta func(key string, raw json.RawMessage), event func(e TraceEvent) bool) error {
+	br := bufio.NewReader(r)
+	if err := sniffFormat(br); err != nil {
+		return err
+	}
+	s := newScanner(br)
+	s.keepArgs = keepArgs
+	err := s.scanTrace(logger, meta, func() error { return s.readEvents(event) })
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) && parseErr.Snippet == "" {
+		parseErr.Snippet = s.snippet(parseErr.Offset)
+	}
+	return err
+}
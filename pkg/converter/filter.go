@@ -0,0 +1,192 @@
+package converter
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// TimeWindow selects a slice of a trace by timestamp, in trace units
+// (microseconds). A zero Start or End leaves that side unbounded.
+type TimeWindow struct {
+	Start float64
+	End   float64
+}
+
+// IsZero reports whether the window is unbounded on both sides
+func (w TimeWindow) IsZero() bool {
+	return w.Start == 0 && w.End == 0
+}
+
+// contains reports whether ts falls inside the window
+func (w TimeWindow) contains(ts float64) bool {
+	return (w.Start == 0 || ts >= w.Start) && (w.End == 0 || ts < w.End)
+}
+
+// FilterTimeWindow returns a copy of the trace restricted to the window.
+// Complete events overlapping the window are clipped to it, so enclosing
+// parents keep their place in the stacks; other timed events are kept when
+// their timestamp falls inside the window. Metadata events are always kept.
+func FilterTimeWindow(traceData *TraceData, window TimeWindow) *TraceData {
+	if window.IsZero() {
+		return traceData
+	}
+
+	start := window.Start
+	end := window.End
+	if end == 0 {
+		end = math.Inf(1)
+	}
+
+	filtered := &TraceData{
+		TraceEvents: make([]TraceEvent, 0, len(traceData.TraceEvents)),
+		Metadata:    traceData.Metadata,
+	}
+	for _, e := range traceData.TraceEvents {
+		switch e.Ph {
+		case "M":
+			filtered.TraceEvents = append(filtered.TraceEvents, e)
+		case "X":
+			eventEnd := e.Ts + e.Dur
+			if eventEnd <= start || e.Ts >= end {
+				continue
+			}
+			if e.Ts < start {
+				e.Ts = start
+			}
+			if eventEnd > end {
+				eventEnd = end
+			}
+			e.Dur = eventEnd - e.Ts
+			filtered.TraceEvents = append(filtered.TraceEvents, e)
+		default:
+			if window.contains(e.Ts) {
+				filtered.TraceEvents = append(filtered.TraceEvents, e)
+			}
+		}
+	}
+	return filtered
+}
+
+// profilerStepPrefix is the name prefix of the spans torch.profiler
+// records around each training step
+const profilerStepPrefix = "ProfilerStep#"
+
+// profilerStepNumber parses the step number from a ProfilerStep span name
+func profilerStepNumber(e TraceEvent) (int, bool) {
+	if e.Ph != "X" || !strings.HasPrefix(e.Name, profilerStepPrefix) {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(e.Name, profilerStepPrefix))
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// StepWindow returns the time window covering ProfilerStep spans first
+// through last (inclusive). A negative bound leaves that side unbounded.
+func StepWindow(traceData *TraceData, first, last int) (TimeWindow, error) {
+	var window TimeWindow
+	foundStart, foundEnd := first < 0, last < 0
+
+	for _, e := range traceData.TraceEvents {
+		step, ok := profilerStepNumber(e)
+		if !ok {
+			continue
+		}
+		if step == first && (!foundStart || e.Ts < window.Start) {
+			window.Start = e.Ts
+			foundStart = true
+		}
+		if step == last && (!foundEnd || e.Ts+e.Dur > window.End) {
+			window.End = e.Ts + e.Dur
+			foundEnd = true
+		}
+	}
+
+	if !foundStart {
+		return TimeWindow{}, fmt.Errorf("profiler step %d not found in trace", first)
+	}
+	if !foundEnd {
+		return TimeWindow{}, fmt.Errorf("profiler step %d not found in trace", last)
+	}
+	return window, nil
+}
+
+// stepSpans returns the windows of the ProfilerStep spans, by step number
+func stepSpans(traceData *TraceData) (steps []int, windows map[int]TimeWindow) {
+	windows = make(map[int]TimeWindow)
+	for _, e := range traceData.TraceEvents {
+		step, ok := profilerStepNumber(e)
+		if !ok {
+			continue
+		}
+		w, seen := windows[step]
+		if !seen {
+			steps = append(steps, step)
+			w = TimeWindow{Start: e.Ts, End: e.Ts + e.Dur}
+		}
+		w.Start, w.End = min(w.Start, e.Ts), max(w.End, e.Ts+e.Dur)
+		windows[step] = w
+	}
+	slices.Sort(steps)
+	return steps, windows
+}
+
+// SkipStepsWindow returns the time window starting with the ProfilerStep
+// after the first n, leaving the end unbounded, to drop warmup steps
+func SkipStepsWindow(traceData *TraceData, n int) (TimeWindow, error) {
+	steps, windows := stepSpans(traceData)
+	if n >= len(steps) {
+		return TimeWindow{}, fmt.Errorf("cannot skip %d profiler steps of a trace with %d", n, len(steps))
+	}
+	return TimeWindow{Start: windows[steps[n]].Start}, nil
+}
+
+// warmupFactor is how much slower than the median step a leading step is
+// to count as warmup
+const warmupFactor = 1.5
+
+// WarmupSteps returns the number of leading ProfilerSteps taking more than
+// 1.5 times the median step, as the first steps do while cuDNN benchmarks
+// algorithms and torch.compile compiles. The last step is never counted.
+func WarmupSteps(traceData *TraceData) int {
+	steps, windows := stepSpans(traceData)
+	if len(steps) < 2 {
+		return 0
+	}
+	durations := make([]float64, len(steps))
+	for i, step := range steps {
+		durations[i] = windows[step].End - windows[step].Start
+	}
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+	median := sorted[len(sorted)/2]
+
+	n := 0
+	for n < len(durations)-1 && durations[n] > warmupFactor*median {
+		n++
+	}
+	return n
+}
+
+// keepStack applies the Focus and Ignore filters to a stack, mirroring
+// pprof's -focus/-ignore semantics
+func (opts ConvertOptions) keepStack(frames []frame) bool {
+	if opts.Focus == nil && opts.Ignore == nil {
+		return true
+	}
+	focused := opts.Focus == nil
+	for _, f := range frames {
+		if opts.Ignore != nil && opts.Ignore.MatchString(f.name) {
+			return false
+		}
+		if !focused && opts.Focus.MatchString(f.name) {
+			focused = true
+		}
+	}
+	return focused
+}
@@ -0,0 +1,22 @@
+package converter
+
+import "fmt"
+
+// collapseRecursion merges runs of consecutive identical frames into a
+// single frame annotated with the repetition count, e.g. "forward (x3)"
+func collapseRecursion(frames []frame) []frame {
+	collapsed := make([]frame, 0, len(frames))
+	for i := 0; i < len(frames); {
+		j := i + 1
+		for j < len(frames) && frames[j] == frames[i] {
+			j++
+		}
+		f := frames[i]
+		if n := j - i; n > 1 {
+			f.name = fmt.Sprintf("%s (x%d)", f.name, n)
+		}
+		collapsed = append(collapsed, f)
+		i = j
+	}
+	return collapsed
+}
@@ -0,0 +1,371 @@
+package converter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+)
+
+// GenerateOptions shapes a synthetic trace written by GenerateTrace. Zero
+// fields take the defaults noted.
+type GenerateOptions struct {
+	// Events is the number of complete events to write, across all
+	// threads and GPU streams (default: 100000)
+	Events int
+
+	// Threads is the number of CPU threads running operators (default: 4)
+	Threads int
+
+	// Depth is the deepest nesting of operators under a ProfilerStep span
+	// (default: 10)
+	Depth int
+
+	// GPUs is the number of devices the threads launch kernels on, each
+	// thread on its own stream (default: 1); NoGPU writes a CPU-only trace
+	GPUs  int
+	NoGPU bool
+
+	// Seed makes the trace reproducible: the same options write the same
+	// trace
+	Seed int64
+}
+
+// Default GenerateOptions
+const (
+	defaultGenerateEvents  = 100000
+	defaultGenerateThreads = 4
+	defaultGenerateDepth   = 10
+)
+
+// withDefaults fills in the zero fields of o
+func (o GenerateOptions) withDefaults() (GenerateOptions, error) {
+	if o.Events < 0 || o.Threads < 0 || o.Depth < 0 || o.GPUs < 0 {
+		return o, fmt.Errorf("generate options must not be negative")
+	}
+	if o.Events == 0 {
+		o.Events = defaultGenerateEvents
+	}
+	if o.Threads == 0 {
+		o.Threads = defaultGenerateThreads
+	}
+	if o.Depth == 0 {
+		o.Depth = defaultGenerateDepth
+	}
+	if o.GPUs == 0 {
+		o.GPUs = 1
+	}
+	return o, nil
+}
+
+// GenerateTraceFile writes a synthetic trace to path, gzip-compressed if
+// path ends in .gz
+func GenerateTraceFile(path string, opts GenerateOptions) error {
+	return createTraceFile(path, func(w io.Writer) error {
+		return GenerateTrace(w, opts)
+	})
+}
+
+// GenerateTrace writes a synthetic PyTorch profiler trace as Chrome trace
+// JSON, for testing and benchmarking without real traces. Each thread runs
+// ProfilerStep spans of nested Python functions and aten operators, whose
+// innermost calls launch kernels and copies on the thread's GPU stream,
+// linked by correlation ids as CUPTI records them. Events are written as
+// they are generated, so traces of any size take little memory.
+func GenerateTrace(w io.Writer, opts GenerateOptions) error {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return err
+	}
+	g := &traceGenerator{
+		opts: opts,
+		rng:  rand.New(rand.NewSource(opts.Seed)),
+		w:    bufio.NewWriterSize(w, 1<<16),
+	}
+	g.header()
+	for t := range opts.Threads {
+		// Spread the events evenly, the remainder over the first threads
+		budget := opts.Events / opts.Threads
+		if t < opts.Events%opts.Threads {
+			budget++
+		}
+		g.thread(t, budget)
+	}
+	g.footer()
+	if g.err != nil {
+		return g.err
+	}
+	return g.w.Flush()
+}
+
+// Names of generated events, by category
+var (
+	genPythonNames = []string{
+		"train.py(212): train_step",
+		"torch/nn/modules/module.py(1747): _call_impl",
+		"nn.Module: TransformerBlock",
+		"nn.Module: Attention",
+		"nn.Module: MLP",
+		"nn.Module: Linear",
+		"nn.Module: LayerNorm",
+		"torch/nn/functional.py(2900): layer_norm",
+		"torch/nn/functional.py(1695): gelu",
+		"torch/_tensor.py(626): backward",
+		"torch/optim/adam.py(213): step",
+	}
+	genOpNames = []string{
+		"aten::linear", "aten::addmm", "aten::matmul", "aten::mm", "aten::bmm",
+		"aten::softmax", "aten::_softmax", "aten::layer_norm", "aten::native_layer_norm",
+		"aten::add", "aten::add_", "aten::mul", "aten::gelu", "aten::dropout",
+		"aten::copy_", "aten::to", "aten::_to_copy", "aten::empty", "aten::view",
+		"aten::transpose", "aten::contiguous", "aten::scaled_dot_product_attention",
+	}
+	genKernelNames = []string{
+		"ampere_sgemm_128x64_tn",
+		"sm80_xmma_gemm_f16f16_f16f32_f32_tn_n_tilesize128x128x32_stage4_warpsize2x2x1_tensor16x8x16_kernel",
+		"void cutlass::Kernel<cutlass_80_tensorop_s1688gemm_128x128_32x3_nn_align4>(cutlass_80_tensorop_s1688gemm_128x128_32x3_nn_align4::Params)",
+		"void at::native::vectorized_elementwise_kernel<4, at::native::CUDAFunctor_add<float>, std::array<char*, 3ul> >(int, at::native::CUDAFunctor_add<float>, std::array<char*, 3ul>)",
+		"void at::native::vectorized_elementwise_kernel<4, at::native::GeluCUDAKernelImpl(at::TensorIteratorBase&, at::native::GeluType)::{lambda()#2}::operator()() const::{lambda()#2}::operator()() const::{lambda(float)#1}, std::array<char*, 2ul> >(int, at::native::GeluCUDAKernelImpl(at::TensorIteratorBase&, at::native::GeluType)::{lambda()#2}::operator()() const::{lambda()#2}::operator()() const::{lambda(float)#1}, std::array<char*, 2ul>)",
+		"void at::native::(anonymous namespace)::vectorized_layer_norm_kernel<float, float>(int, float, float const*, float const*, float const*, float*, float*, float*)",
+		"void (anonymous namespace)::softmax_warp_forward<float, float, float, 10, false, false>(float*, float const*, int, int, int, bool const*, int, bool)",
+		"void at::native::unrolled_elementwise_kernel<at::native::direct_copy_kernel_cuda(at::TensorIteratorBase&)::{lambda()#3}::operator()() const::{lambda()#7}::operator()() const::{lambda(float)#1}, std::array<char*, 2ul>, 4, TrivialOffsetCalculator<1, unsigned int>, TrivialOffsetCalculator<1, unsigned int>, at::native::memory::LoadWithCast<1>, at::native::memory::StoreWithCast<1> >(int, at::native::direct_copy_kernel_cuda(at::TensorIteratorBase&)::{lambda()#3}::operator()() const::{lambda()#7}::operator()() const::{lambda(float)#1}, std::array<char*, 2ul>, TrivialOffsetCalculator<1, unsigned int>, TrivialOffsetCalculator<1, unsigned int>, at::native::memory::LoadWithCast<1>, at::native::memory::StoreWithCast<1>)",
+		"void at::native::(anonymous namespace)::fused_dropout_kernel_vec<float, float, unsigned int, 1, 4, bool>(at::cuda::detail::TensorInfo<float const, unsigned int>, at::cuda::detail::TensorInfo<float, unsigned int>, at::cuda::detail::TensorInfo<bool, unsigned int>, unsigned int, float, at::PhiloxCudaState)",
+	}
+	genMemcpyNames = []string{
+		"Memcpy HtoD (Pinned -> Device)",
+		"Memcpy DtoH (Device -> Pageable)",
+		"Memcpy DtoD (Device -> Device)",
+	}
+	genInputDims = []string{
+		"[[32, 512, 1024], [4096, 1024], [4096]]",
+		"[[32, 512, 4096]]",
+		"[[32, 16, 512, 64], [32, 16, 64, 512]]",
+		"[[32, 512, 1024], [], []]",
+		"[[16384, 1024], [1024, 1024]]",
+		"[[]]",
+	}
+)
+
+// genQuoted holds the JSON strings of generated names, quoted once. The
+// names are plain ASCII, which Go and JSON quote alike.
+var genQuoted = func() map[string][]byte {
+	quoted := make(map[string][]byte)
+	for _, names := range [][]string{genPythonNames, genOpNames, genKernelNames, genMemcpyNames} {
+		for _, name := range names {
+			quoted[name] = []byte(strconv.Quote(name))
+		}
+	}
+	return quoted
+}()
+
+// genStartTs is the first timestamp of generated traces, in microseconds
+const genStartTs = 1.7e12
+
+// genProcessID is the pid of the generated CPU threads
+const genProcessID = 4242
+
+// traceGenerator writes the events of GenerateTrace
+type traceGenerator struct {
+	opts GenerateOptions
+	rng  *rand.Rand
+	w    *bufio.Writer
+	buf  []byte
+	err  error
+
+	// events counts the events written, metadata included, to separate
+	// them with commas
+	events int
+
+	// correlation and externalID number launches and operators, as
+	// CUPTI and the profiler do
+	correlation int64
+	externalID  int64
+}
+
+// header writes the top-level fields and metadata events naming the
+// process, threads and GPUs
+func (g *traceGenerator) header() {
+	g.write(`{"schemaVersion": 1, "displayTimeUnit": "ms", "deviceProperties": [`)
+	if !g.opts.NoGPU {
+		for d := range g.opts.GPUs {
+			if d > 0 {
+				g.write(", ")
+			}
+			g.write(`{"id": ` + strconv.Itoa(d) + `, "name": "NVIDIA A100-SXM4-80GB", "totalGlobalMem": 85031714816, "computeMajor": 8, "computeMinor": 0, "numSms": 108}`)
+		}
+	}
+	g.write("],\n\"traceEvents\": [\n")
+	g.metadata("process_name", genProcessID, 0, "python")
+	for t := range g.opts.Threads {
+		g.metadata("thread_name", genProcessID, genProcessID+t, "thread "+strconv.Itoa(t))
+	}
+	if !g.opts.NoGPU {
+		for d := range g.opts.GPUs {
+			g.metadata("process_name", d, 0, "GPU "+strconv.Itoa(d))
+		}
+	}
+}
+
+func (g *traceGenerator) footer() {
+	g.write("\n]}\n")
+}
+
+// metadata writes an "M" event, which does not count as a generated event
+func (g *traceGenerator) metadata(name string, pid, tid int, value string) {
+	g.separate()
+	g.write(`{"ph": "M", "name": "` + name + `", "pid": ` + strconv.Itoa(pid) + `, "tid": ` + strconv.Itoa(tid) + `, "ts": 0, "args": {"name": ` + strconv.Quote(value) + `}}`)
+}
+
+// thread writes budget events for thread t: ProfilerStep spans of
+// operator trees until the budget is spent
+func (g *traceGenerator) thread(t, budget int) {
+	tid := genProcessID + t
+	stream := streamOf(t)
+	ts := genStartTs + g.rng.Float64()*100
+	streamFree := ts
+	for step := 0; budget > 0 && g.err == nil; step++ {
+		budget-- // The step span
+		start := ts
+		ts += g.gap()
+		for range 1 + g.rng.Intn(8) {
+			if budget == 0 {
+				break
+			}
+			ts = g.op(t, tid, stream, 1, ts, &streamFree, &budget)
+		}
+		ts += g.gap()
+		g.complete("user_annotation", []byte(strconv.Quote("ProfilerStep#"+strconv.Itoa(step))), genProcessID, tid, start, ts-start, nil)
+		ts += g.gap()
+	}
+}
+
+// streamOf returns the GPU stream of thread t
+func streamOf(t int) int {
+	return 7 + t
+}
+
+// op writes an operator starting at ts and its children, and returns its
+// end. Shallow operators are Python functions, deeper ones aten operators;
+// leaves launch GPU work when the budget allows.
+func (g *traceGenerator) op(t, tid, stream, level int, ts float64, streamFree *float64, budget *int) float64 {
+	*budget--
+	start := ts + g.gap() // Apart from the previous sibling
+	g.externalID++
+	externalID := g.externalID
+	python := level*2 <= g.opts.Depth
+	ts = start + g.gap()
+
+	// Descend less often the deeper the operator, so trees stay bounded
+	descend := level < g.opts.Depth && g.rng.Float64() < 0.95-0.9*float64(level)/float64(g.opts.Depth)
+	switch {
+	case descend:
+		for range 1 + g.rng.Intn(3) {
+			if *budget == 0 {
+				break
+			}
+			ts = g.op(t, tid, stream, level+1, ts, streamFree, budget)
+		}
+	case !python && !g.opts.NoGPU && *budget >= 2:
+		ts = g.launch(t, tid, stream, externalID, ts, streamFree)
+		*budget -= 2
+	default:
+		ts += 1 + g.rng.ExpFloat64()*10 // Self time
+	}
+	ts += g.gap()
+
+	var name []byte
+	var args []byte
+	cat := "cpu_op"
+	if python {
+		cat = "python_function"
+		name = genQuoted[genPythonNames[g.rng.Intn(len(genPythonNames))]]
+	} else {
+		name = genQuoted[genOpNames[g.rng.Intn(len(genOpNames))]]
+		args = fmt.Appendf(args, `"External id": %d, "Input Dims": %s`, externalID, genInputDims[g.rng.Intn(len(genInputDims))])
+	}
+	g.complete(cat, name, genProcessID, tid, start, ts-start, args)
+	return ts
+}
+
+// launch writes a runtime call at ts launching a kernel or copy on the
+// stream of thread t, queued after the stream's previous work, and returns
+// the end of the call
+func (g *traceGenerator) launch(t, tid, stream int, externalID int64, ts float64, streamFree *float64) float64 {
+	g.correlation++
+	device := t % g.opts.GPUs
+	launchDur := 2 + g.rng.ExpFloat64()*4
+	gpuStart := math.Max(ts+launchDur+1+g.rng.Float64()*5, *streamFree+0.5+g.rng.Float64())
+	// Kernel durations are heavy-tailed: mostly a few microseconds, with
+	// long GEMMs
+	gpuDur := math.Min(2*math.Exp(g.rng.NormFloat64()*1.5+1), 5000)
+	*streamFree = gpuStart + gpuDur
+
+	callName := `"cudaLaunchKernel"`
+	cat := "kernel"
+	var name []byte
+	gpuArgs := fmt.Appendf(nil, `"External id": %d, "correlation": %d, "device": %d, "stream": %d`, externalID, g.correlation, device, stream)
+	if g.rng.Intn(10) == 0 {
+		callName = `"cudaMemcpyAsync"`
+		cat = "gpu_memcpy"
+		name = genQuoted[genMemcpyNames[g.rng.Intn(len(genMemcpyNames))]]
+		gpuArgs = fmt.Appendf(gpuArgs, `, "bytes": %d`, 1<<(10+g.rng.Intn(16)))
+	} else {
+		name = genQuoted[genKernelNames[g.rng.Intn(len(genKernelNames))]]
+		gpuArgs = fmt.Appendf(gpuArgs, `, "grid": [%d, 1, 1], "block": [128, 1, 1]`, 1<<g.rng.Intn(12))
+	}
+	g.complete("cuda_runtime", []byte(callName), genProcessID, tid, ts, launchDur,
+		fmt.Appendf(nil, `"External id": %d, "correlation": %d`, externalID, g.correlation))
+	g.complete(cat, name, device, stream, gpuStart, gpuDur, gpuArgs)
+	return ts + launchDur
+}
+
+// gap returns a short random delay between events, in microseconds
+func (g *traceGenerator) gap() float64 {
+	return 0.1 + g.rng.Float64()
+}
+
+// complete writes an "X" event with a JSON-quoted name and args, the
+// contents of its args object
+func (g *traceGenerator) complete(cat string, name []byte, pid, tid int, ts, dur float64, args []byte) {
+	// Round to nanoseconds the way nested events still nest
+	start, end := math.Round(ts*1e3)/1e3, math.Round((ts+dur)*1e3)/1e3
+	g.separate()
+	b := append(g.buf[:0], `{"ph": "X", "cat": "`...)
+	b = append(b, cat...)
+	b = append(b, `", "name": `...)
+	b = append(b, name...)
+	b = append(b, `, "pid": `...)
+	b = strconv.AppendInt(b, int64(pid), 10)
+	b = append(b, `, "tid": `...)
+	b = strconv.AppendInt(b, int64(tid), 10)
+	b = append(b, `, "ts": `...)
+	b = strconv.AppendFloat(b, start, 'f', 3, 64)
+	b = append(b, `, "dur": `...)
+	b = strconv.AppendFloat(b, end-start, 'f', 3, 64)
+	if len(args) > 0 {
+		b = append(b, `, "args": {`...)
+		b = append(b, args...)
+		b = append(b, '}')
+	}
+	b = append(b, '}')
+	g.buf = b
+	if g.err == nil {
+		_, g.err = g.w.Write(b)
+	}
+}
+
+// separate writes the comma before every event but the first
+func (g *traceGenerator) separate() {
+	if g.events > 0 {
+		g.write(",\n")
+	}
+	g.events++
+}
+
+func (g *traceGenerator) write(s string) {
+	if g.err == nil {
+		_, g.err = g.w.WriteString(s)
+	}
+}
@@ -0,0 +1,148 @@
+package converter
+
+import (
+	"cmp"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// minGroupChunk is the fewest events a worker groups by thread
+const minGroupChunk = 1 << 16
+
+// groupChunk holds the events of a run of the trace grouped by thread
+type groupChunk struct {
+	threads map[threadID]*threadGroup
+	diag    Diagnostics // Only the skipped and converted counts
+}
+
+// groupByThread groups the events to convert by (pid, tid), so processes
+// sharing thread ids stay separate, and sorts each thread's events by start
+// time, counting the events skipped in diag. Runs of the trace are grouped
+// in parallel, except with custom mappers, which are promised the events
+// one at a time in trace order. The groups are returned longest first.
+func groupByThread(events []TraceEvent, opts ConvertOptions, md *traceMetadata, gpuStarts map[int64]float64, diag *Diagnostics) []*threadGroup {
+	n := 1
+	if len(opts.EventMappers) == 0 {
+		n = max(min(opts.workers(), len(events)/minGroupChunk), 1)
+	}
+	chunks := make([]groupChunk, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			chunks[i] = groupEvents(events[len(events)*i/n:len(events)*(i+1)/n], opts, md, gpuStarts)
+		}()
+	}
+	wg.Wait()
+
+	groups := mergeGroupChunks(chunks, diag)
+	sort.Slice(groups, func(i, j int) bool { return len(groups[i].events) > len(groups[j].events) })
+	sortThreadEvents(groups, opts.workers())
+	return groups
+}
+
+// groupEvents maps and groups a run of events
+func groupEvents(events []TraceEvent, opts ConvertOptions, md *traceMetadata, gpuStarts map[int64]float64) groupChunk {
+	chunk := groupChunk{
+		threads: make(map[threadID]*threadGroup),
+		diag:    Diagnostics{SkippedPhases: make(map[string]int)},
+	}
+	diag := &chunk.diag
+	defaultMapper := DefaultEventMapper(opts)
+	for _, e := range events {
+		if e.Ph != "X" {
+			diag.SkippedPhases[e.Ph]++
+			continue
+		}
+		if e.Dur <= 0 {
+			diag.ZeroDuration++
+			continue
+		}
+		if opts.MinDuration > 0 && e.Dur*1000 < float64(opts.MinDuration.Nanoseconds()) {
+			diag.ShorterThanMin++
+			continue
+		}
+		event, ok := opts.mapEvent(e, defaultMapper)
+		if !ok {
+			diag.DroppedByMapper++
+			continue
+		}
+		if !isKnownID(e.Tid) {
+			diag.UnknownTids++
+		}
+		diag.Converted++
+		id := threadID{pid: getTid(e.Pid), tid: getTid(e.Tid)}
+		group, ok := chunk.threads[id]
+		if !ok {
+			group = &threadGroup{labels: md.processLabelsFor(e.Pid), pid: e.Pid, tid: e.Tid}
+			if opts.ThreadRoots {
+				group.root = []frame{md.rootFrame(e.Pid, e.Tid)}
+			}
+			chunk.threads[id] = group
+		}
+		if gpuStarts != nil {
+			event.LaunchLatency = launchLatency(e, gpuStarts)
+		}
+		group.events = append(group.events, event)
+	}
+	return chunk
+}
+
+// mergeGroupChunks joins the groups of consecutive runs of the trace, with
+// each thread's events in trace order and the root and labels of its first
+// event, and adds up their counts in diag
+func mergeGroupChunks(chunks []groupChunk, diag *Diagnostics) []*threadGroup {
+	threads := make(map[threadID]*threadGroup)
+	var groups []*threadGroup
+	sizes := make(map[threadID]int)
+	for _, c := range chunks {
+		diag.addCounts(&c.diag)
+		for id, g := range c.threads {
+			if threads[id] == nil {
+				threads[id] = &threadGroup{root: g.root, labels: g.labels, pid: g.pid, tid: g.tid}
+				groups = append(groups, threads[id])
+			}
+			sizes[id] += len(g.events)
+		}
+	}
+	if len(chunks) == 1 {
+		for id, g := range chunks[0].threads {
+			threads[id].events = g.events
+		}
+		return groups
+	}
+	for id, g := range threads {
+		g.events = make([]eventWithEnd, 0, sizes[id])
+	}
+	for _, c := range chunks {
+		for id, g := range c.threads {
+			threads[id].events = append(threads[id].events, g.events...)
+		}
+	}
+	return groups
+}
+
+// sortThreadEvents sorts the events of each group by start time, on up to
+// workers goroutines
+func sortThreadEvents(groups []*threadGroup, workers int) {
+	queue := make(chan *threadGroup)
+	var wg sync.WaitGroup
+	for range min(workers, len(groups)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range queue {
+				slices.SortFunc(group.events, func(a, b eventWithEnd) int {
+					return cmp.Compare(a.Ts, b.Ts)
+				})
+			}
+		}()
+	}
+	for _, group := range groups {
+		queue <- group
+	}
+	close(queue)
+	wg.Wait()
+}
@@ -0,0 +1,51 @@
+package converter
+
+// Events shared by the tests. CPU events run on pid 1, tid 1 unless given a
+// thread, and GPU events on pid 0, stream 7 unless given a stream.
+
+// span returns a complete CPU event without a category
+func span(name string, ts, dur float64) TraceEvent {
+	return threadSpan(name, "", 1, ts, dur)
+}
+
+// threadSpan returns a complete CPU event of category cat on thread tid
+func threadSpan(name, cat string, tid, ts, dur float64) TraceEvent {
+	return TraceEvent{Ph: "X", Name: name, Cat: cat, Pid: float64(1), Tid: tid, Ts: ts, Dur: dur}
+}
+
+// cpuOp returns a complete cpu_op event
+func cpuOp(name string, ts, dur float64) TraceEvent {
+	return threadSpan(name, "cpu_op", 1, ts, dur)
+}
+
+// annotation returns a complete user_annotation event
+func annotation(name string, ts, dur float64) TraceEvent {
+	return threadSpan(name, "user_annotation", 1, ts, dur)
+}
+
+// runtimeCall returns a complete cuda_runtime event
+func runtimeCall(name string, ts, dur float64) TraceEvent {
+	return threadSpan(name, "cuda_runtime", 1, ts, dur)
+}
+
+// gpuEvent returns a complete GPU event of category cat on stream
+func gpuEvent(name, cat string, stream, ts, dur float64) TraceEvent {
+	return TraceEvent{Ph: "X", Name: name, Cat: cat, Pid: float64(0), Tid: stream, Ts: ts, Dur: dur}
+}
+
+// gpuKernel returns a complete kernel event
+func gpuKernel(name string, ts, dur float64) TraceEvent {
+	return gpuEvent(name, "kernel", 7, ts, dur)
+}
+
+// withArgs returns e with args
+func withArgs(e TraceEvent, args map[string]interface{}) TraceEvent {
+	e.Args = args
+	return e
+}
+
+// correlated returns e with the correlation id linking a launch to its
+// GPU event
+func correlated(e TraceEvent, id float64) TraceEvent {
+	return withArgs(e, map[string]interface{}{"correlation": id})
+}
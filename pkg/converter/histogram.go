@@ -0,0 +1,85 @@
+package converter
+
+import "regexp"
+
+// DurationHistogram counts the durations of the complete events matching a
+// pattern in log-scale buckets, whose bounds follow the 1-2-5 series (1us,
+// 2us, 5us, 10us...), so that operations with several typical durations,
+// such as the occasional recompilation or cache miss, show several peaks.
+// Buckets run from the shortest to the longest matching event, empty
+// buckets between them included.
+type DurationHistogram struct {
+	Events  int               `json:"events"`
+	TimeNs  int64             `json:"time_ns"`
+	Buckets []HistogramBucket `json:"buckets"`
+}
+
+// HistogramBucket counts the events lasting at least LowNs and less than
+// HighNs
+type HistogramBucket struct {
+	LowNs  int64 `json:"low_ns"`
+	HighNs int64 `json:"high_ns"`
+	Count  int   `json:"count"`
+	TimeNs int64 `json:"time_ns"`
+}
+
+// histogramBuckets is the number of 1-2-5 buckets from 1ns up to the
+// longest int64 duration
+const histogramBuckets = 3 * 19
+
+// histogramBounds returns the bounds of bucket i: bucket 0 is [1ns, 2ns),
+// 1 is [2ns, 5ns), 2 is [5ns, 10ns), 3 is [10ns, 20ns)...
+func histogramBounds(i int) (low, high int64) {
+	decade := int64(1)
+	for range i / 3 {
+		decade *= 10
+	}
+	switch i % 3 {
+	case 0:
+		return decade, 2 * decade
+	case 1:
+		return 2 * decade, 5 * decade
+	default:
+		if i == histogramBuckets-1 {
+			return 5 * decade, 1<<63 - 1
+		}
+		return 5 * decade, 10 * decade
+	}
+}
+
+// histogramBucket returns the bucket of a duration of at least 1ns
+func histogramBucket(ns int64) int {
+	i := 0
+	for {
+		if _, high := histogramBounds(i); ns < high || i == histogramBuckets-1 {
+			return i
+		}
+		i++
+	}
+}
+
+// DurationHistogramOf returns the histogram of the durations of the
+// complete events with a name matching re. Events without a duration are
+// skipped, as in AnalyzeTrace.
+func DurationHistogramOf(traceData *TraceData, re *regexp.Regexp) *DurationHistogram {
+	var buckets [histogramBuckets]HistogramBucket
+	h := &DurationHistogram{}
+	first, last := histogramBuckets, -1
+	for _, e := range traceData.TraceEvents {
+		if e.Ph != "X" || e.Dur <= 0 || !re.MatchString(e.Name) {
+			continue
+		}
+		ns := max(usToNs(e.Dur), 1)
+		i := histogramBucket(ns)
+		buckets[i].Count++
+		buckets[i].TimeNs += ns
+		first, last = min(first, i), max(last, i)
+		h.Events++
+		h.TimeNs += ns
+	}
+	for i := first; i <= last; i++ {
+		buckets[i].LowNs, buckets[i].HighNs = histogramBounds(i)
+		h.Buckets = append(h.Buckets, buckets[i])
+	}
+	return h
+}
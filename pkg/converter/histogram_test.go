@@ -0,0 +1,33 @@
+package converter
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestDurationHistogram(t *testing.T) {
+	h := DurationHistogramOf(&TraceData{TraceEvents: []TraceEvent{
+		span("aten::copy_", 0, 3),
+		span("aten::copy_", 0, 4),
+		span("aten::copy_", 0, 30), // A slow outlier, two buckets apart
+		span("aten::copy_", 0, 0),  // Skipped
+		span("aten::mm", 0, 1000),
+	}}, regexp.MustCompile(`copy`))
+
+	want := &DurationHistogram{Events: 3, TimeNs: 37000, Buckets: []HistogramBucket{
+		{LowNs: 2000, HighNs: 5000, Count: 2, TimeNs: 7000},
+		{LowNs: 5000, HighNs: 10000},
+		{LowNs: 10000, HighNs: 20000},
+		{LowNs: 20000, HighNs: 50000, Count: 1, TimeNs: 30000},
+	}}
+	if !reflect.DeepEqual(h, want) {
+		t.Errorf("Expected %+v, got %+v", want, h)
+	}
+	if h := DurationHistogramOf(&TraceData{TraceEvents: []TraceEvent{span("aten::mm", 0, 1)}}, regexp.MustCompile(`copy`)); h.Events != 0 || h.Buckets != nil {
+		t.Errorf("Expected an empty histogram, got %+v", h)
+	}
+	if i := histogramBucket(1<<63 - 1); i != histogramBuckets-1 {
+		t.Errorf("Expected the longest duration in the last bucket, got bucket %d", i)
+	}
+}
@@ -0,0 +1,134 @@
+package converter
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+)
+
+// TraceInfo describes what a trace contains, as reported by the info command
+type TraceInfo struct {
+	SchemaVersion   json.RawMessage  `json:"schema_version,omitempty"`
+	StartUs         float64          `json:"start_us"`
+	EndUs           float64          `json:"end_us"`
+	SpanNs          int64            `json:"span_ns"`
+	Events          int              `json:"events"`
+	EventsByPhase   map[string]int   `json:"events_by_phase"`
+	Processes       []ProcessInfo    `json:"processes"`
+	Threads         int              `json:"threads"`
+	Devices         []DeviceInfo     `json:"devices"`
+	DistributedInfo *DistributedInfo `json:"distributed_info,omitempty"`
+	ProfilerSteps   int              `json:"profiler_steps"`
+
+	// What the profiler recorded, detected from the events
+	Stacks bool `json:"stacks"` // with_stack: python_function events
+	Shapes bool `json:"shapes"` // record_shapes: "Input Dims" args
+	Memory bool `json:"memory"` // profile_memory: [memory] events
+	Flops  bool `json:"flops"`  // with_flops: "flops" args
+}
+
+// ProcessInfo is a process with timed events in the trace
+type ProcessInfo struct {
+	Pid    string `json:"pid"`
+	Name   string `json:"name,omitempty"`
+	Events int    `json:"events"`
+}
+
+// DeviceInfo is a GPU with events in the trace. Name comes from the
+// deviceProperties field when present.
+type DeviceInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+// DistributedInfo is the distributedInfo field of a distributed job's trace
+type DistributedInfo struct {
+	Backend   string `json:"backend"`
+	Rank      int    `json:"rank"`
+	WorldSize int    `json:"world_size"`
+}
+
+// Info summarizes the metadata and contents of a trace
+func Info(traceData *TraceData) *TraceInfo {
+	info := &TraceInfo{
+		SchemaVersion: traceData.Metadata["schemaVersion"],
+		Events:        len(traceData.TraceEvents),
+		EventsByPhase: make(map[string]int),
+	}
+	if raw, ok := traceData.Metadata["distributedInfo"]; ok {
+		var di DistributedInfo
+		if err := json.Unmarshal(raw, &di); err == nil {
+			info.DistributedInfo = &di
+		}
+	}
+
+	md := collectMetadata(traceData.TraceEvents)
+	processes := make(map[string]*ProcessInfo)
+	threads := make(map[string]bool)
+	devices := make(map[string]bool)
+	steps := make(map[int]bool)
+	start, end := math.Inf(1), math.Inf(-1)
+
+	for _, e := range traceData.TraceEvents {
+		info.EventsByPhase[e.Ph]++
+		if e.Ph == "M" {
+			continue
+		}
+
+		start = min(start, e.Ts)
+		end = max(end, e.Ts+e.Dur)
+
+		pid := idString(e.Pid)
+		p := processes[pid]
+		if p == nil {
+			p = &ProcessInfo{Pid: pid, Name: md.processNames[pid]}
+			processes[pid] = p
+		}
+		p.Events++
+		threads[threadKey(e.Pid, e.Tid)] = true
+
+		if isGPUEvent(e) {
+			devices[gpuDevice(e)] = true
+		}
+		if step, ok := profilerStepNumber(e); ok {
+			steps[step] = true
+		}
+		if e.Cat == "python_function" {
+			info.Stacks = true
+		}
+		if e.Name == "[memory]" {
+			info.Memory = true
+		}
+		if _, ok := e.Args["Input Dims"]; ok {
+			info.Shapes = true
+		}
+		if _, ok := e.Args["flops"]; ok {
+			info.Flops = true
+		}
+	}
+
+	if !math.IsInf(start, 1) {
+		info.StartUs, info.EndUs = start, end
+		info.SpanNs = usToNs(end - start)
+	}
+	for _, p := range processes {
+		info.Processes = append(info.Processes, *p)
+	}
+	sort.Slice(info.Processes, func(i, j int) bool { return lessID(info.Processes[i].Pid, info.Processes[j].Pid) })
+	info.Threads = len(threads)
+	var properties []struct {
+		ID   json.Number `json:"id"`
+		Name string      `json:"name"`
+	}
+	_ = json.Unmarshal(traceData.Metadata["deviceProperties"], &properties)
+	names := make(map[string]string)
+	for _, p := range properties {
+		names[p.ID.String()] = p.Name
+	}
+	for device := range devices {
+		info.Devices = append(info.Devices, DeviceInfo{ID: device, Name: names[device]})
+	}
+	sort.Slice(info.Devices, func(i, j int) bool { return lessID(info.Devices[i].ID, info.Devices[j].ID) })
+	info.ProfilerSteps = len(steps)
+	return info
+}
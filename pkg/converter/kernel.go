@@ -0,0 +1,290 @@
+package converter
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// sampleLabel is a label attached to a stack sample before it is interned
+// into the profile. Either str or num is meaningful, depending on isNum.
+type sampleLabel struct {
+	key   string
+	str   string
+	num   int64
+	unit  string
+	isNum bool
+}
+
+// keyString returns a stable string representation of the label
+func (l sampleLabel) keyString() string {
+	if l.isNum {
+		return fmt.Sprintf("%s=%d%s", l.key, l.num, l.unit)
+	}
+	return l.key + "=" + l.str
+}
+
+// isKernelEvent reports whether the event is a GPU kernel execution
+func isKernelEvent(e TraceEvent) bool {
+	return e.Cat == "kernel"
+}
+
+// kernelLabels extracts the launch configuration of a GPU kernel from its
+// args (grid/block dimensions, registers, shared memory, occupancy).
+// Returns nil for non-kernel events or kernels without launch info.
+func kernelLabels(e TraceEvent) []sampleLabel {
+	if !isKernelEvent(e) || len(e.Args) == 0 {
+		return nil
+	}
+
+	var labels []sampleLabel
+	if dims, ok := formatDims(e.Args["grid"]); ok {
+		labels = append(labels, sampleLabel{key: "grid", str: dims})
+	}
+	if dims, ok := formatDims(e.Args["block"]); ok {
+		labels = append(labels, sampleLabel{key: "block", str: dims})
+	}
+	if v, ok := e.ArgNumber("registers per thread"); ok {
+		labels = append(labels, sampleLabel{key: "registers_per_thread", num: int64(v), isNum: true})
+	}
+	if v, ok := e.ArgNumber("shared memory"); ok {
+		labels = append(labels, sampleLabel{key: "shared_memory", num: int64(v), unit: "bytes", isNum: true})
+	}
+	if v, ok := e.ArgNumber("est. achieved occupancy %"); ok {
+		labels = append(labels, sampleLabel{key: "occupancy_pct", num: int64(math.Round(v)), isNum: true})
+	}
+	return labels
+}
+
+// formatDims renders a [x, y, z] dimension array as "XxYxZ"
+func formatDims(v interface{}) (string, bool) {
+	arr, ok := v.([]interface{})
+	if !ok || len(arr) == 0 {
+		return "", false
+	}
+	parts := make([]string, 0, len(arr))
+	for _, d := range arr {
+		n, ok := numberArg(d)
+		if !ok {
+			return "", false
+		}
+		parts = append(parts, fmt.Sprintf("%d", int64(n)))
+	}
+	return strings.Join(parts, "x"), true
+}
+
+// numberArg converts a decoded JSON number to float64
+func numberArg(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// tritonSuffix matches the numeric suffix Triton appends to generated
+// kernel names (triton_poi_fused_addmm_relu_1)
+var tritonSuffix = regexp.MustCompile(`^(triton_\w+?)_\d+$`)
+
+// normalizeKernelName shortens a GPU kernel name to its qualified function
+// name: mangled names are demangled, template arguments, parameter lists and
+// return types are stripped, and autogenerated Triton suffixes are removed.
+// Names that cannot be normalized are returned unchanged.
+func normalizeKernelName(name string) string {
+	normalized := name
+	if strings.HasPrefix(name, "_Z") {
+		if demangled, ok := demangleFunctionName(name); ok {
+			normalized = demangled
+		}
+	}
+
+	normalized = stripTemplateArgs(normalized)
+	normalized = stripParams(normalized)
+	normalized = stripReturnType(normalized)
+	if m := tritonSuffix.FindStringSubmatch(normalized); m != nil {
+		normalized = m[1]
+	}
+
+	if normalized == "" {
+		return name
+	}
+	return normalized
+}
+
+// stripTemplateArgs removes all (possibly nested) <...> groups
+func stripTemplateArgs(name string) string {
+	if !strings.Contains(name, "<") {
+		return name
+	}
+	var b strings.Builder
+	depth := 0
+	for _, c := range name {
+		switch {
+		case c == '<':
+			depth++
+		case c == '>' && depth > 0:
+			depth--
+		case depth == 0:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// stripParams removes a trailing parameter list, keeping parenthesized
+// scopes such as "(anonymous namespace)" inside the name
+func stripParams(name string) string {
+	name = strings.TrimSpace(name)
+	name = strings.TrimSuffix(name, " const")
+	if !strings.HasSuffix(name, ")") {
+		return name
+	}
+	depth := 0
+	for i := len(name) - 1; i >= 0; i-- {
+		switch name[i] {
+		case ')':
+			depth++
+		case '(':
+			depth--
+			if depth == 0 {
+				return strings.TrimSpace(name[:i])
+			}
+		}
+	}
+	return name
+}
+
+// stripReturnType drops everything before the last space outside of
+// parentheses, e.g. the "void " in "void foo::bar"
+func stripReturnType(name string) string {
+	depth := 0
+	for i := len(name) - 1; i >= 0; i-- {
+		switch name[i] {
+		case ')':
+			depth++
+		case '(':
+			depth--
+		case ' ':
+			if depth == 0 {
+				return name[i+1:]
+			}
+		}
+	}
+	return name
+}
+
+// demangleFunctionName extracts the qualified function name from an Itanium
+// C++ ABI mangled symbol (_ZN2at6native6kernelIfEEvT_ -> at::native::kernel).
+// It is a best-effort decoder for the common <nested-name> and
+// <unqualified-name> forms used by CUDA kernels; template arguments and
+// parameter types are skipped rather than decoded.
+func demangleFunctionName(mangled string) (string, bool) {
+	s := strings.TrimPrefix(mangled, "_Z")
+	s = strings.TrimPrefix(s, "L")
+	if s == "" {
+		return "", false
+	}
+
+	if s[0] != 'N' {
+		name, _, ok := readSourceName(s)
+		return name, ok
+	}
+
+	// <nested-name> ::= N [<CV-qualifiers>] <prefix> <unqualified-name> E
+	s = strings.TrimLeft(s[1:], "rVKRO")
+	var parts []string
+	for len(s) > 0 && s[0] != 'E' {
+		switch {
+		case s[0] >= '0' && s[0] <= '9':
+			name, rest, ok := readSourceName(s)
+			if !ok {
+				return "", false
+			}
+			parts = append(parts, name)
+			s = rest
+		case s[0] == 'I':
+			rest, ok := skipTemplateArgs(s)
+			if !ok {
+				return "", false
+			}
+			s = rest
+		case strings.HasPrefix(s, "St"):
+			parts = append(parts, "std")
+			s = s[2:]
+		case s[0] == 'S':
+			// Substitutions refer back to earlier components, which are
+			// already part of the name
+			end := strings.IndexByte(s, '_')
+			if end < 0 {
+				return "", false
+			}
+			s = s[end+1:]
+		default:
+			return "", false
+		}
+	}
+	if len(parts) == 0 {
+		return "", false
+	}
+	return strings.Join(parts, "::"), true
+}
+
+// readSourceName reads a length-prefixed identifier
+func readSourceName(s string) (name, rest string, ok bool) {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	if i == 0 {
+		return "", s, false
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil || i+n > len(s) {
+		return "", s, false
+	}
+	name = s[i : i+n]
+	if strings.HasPrefix(name, "_GLOBAL__N") {
+		name = "(anonymous namespace)"
+	}
+	return name, s[i+n:], true
+}
+
+// skipTemplateArgs skips a balanced I...E template argument list
+func skipTemplateArgs(s string) (string, bool) {
+	depth := 0
+	for len(s) > 0 {
+		switch c := s[0]; {
+		case c >= '0' && c <= '9':
+			_, rest, ok := readSourceName(s)
+			if !ok {
+				return s, false
+			}
+			s = rest
+			continue
+		case c == 'L':
+			// Literal: L <type> <value> E
+			end := strings.IndexByte(s, 'E')
+			if end < 0 {
+				return s, false
+			}
+			s = s[end+1:]
+			continue
+		case c == 'I' || c == 'N' || c == 'X' || c == 'J':
+			depth++
+		case c == 'E':
+			depth--
+			if depth == 0 {
+				return s[1:], true
+			}
+		}
+		s = s[1:]
+	}
+	return s, false
+}
@@ -0,0 +1,88 @@
+package converter
+
+import "strconv"
+
+const (
+	// launchLatencyFrame is the synthetic frame attributing the delay
+	// between a kernel launch call and the kernel starting on the GPU
+	launchLatencyFrame = "launch latency"
+
+	// launchLatencyCategory is the category of launch latency frames
+	launchLatencyCategory = "launch_latency"
+)
+
+// launchCalls lists CUDA runtime/driver calls that launch GPU work
+var launchCalls = map[string]bool{
+	"cudaLaunchKernel":            true,
+	"cudaLaunchKernelExC":         true,
+	"cuLaunchKernel":              true,
+	"cuLaunchKernelEx":            true,
+	"cudaLaunchCooperativeKernel": true,
+	"cudaGraphLaunch":             true,
+}
+
+// isLaunchCall reports whether the event is a CPU-side kernel launch
+func isLaunchCall(e TraceEvent) bool {
+	return (e.Cat == "cuda_runtime" || e.Cat == "cuda_driver") && launchCalls[e.Name]
+}
+
+// isGPUEvent reports whether the event executed on a GPU stream
+func isGPUEvent(e TraceEvent) bool {
+	switch e.Cat {
+	case "kernel", "gpu_memcpy", "gpu_memset":
+		return true
+	default:
+		return false
+	}
+}
+
+// gpuDevice returns the device id of a GPU event, falling back to its pid,
+// which PyTorch sets to the device index
+func gpuDevice(e TraceEvent) string {
+	if v, ok := e.ArgInt("device"); ok {
+		return strconv.FormatInt(v, 10)
+	}
+	return idString(e.Pid)
+}
+
+// correlationID returns the CUPTI correlation id linking a runtime call to
+// the GPU activity it launched
+func correlationID(e TraceEvent) (int64, bool) {
+	return e.ArgInt("correlation")
+}
+
+// gpuStartTimes maps correlation ids to the start time of the first GPU
+// activity they launched
+func gpuStartTimes(events []TraceEvent) map[int64]float64 {
+	starts := make(map[int64]float64)
+	for _, e := range events {
+		if e.Ph != "X" || !isGPUEvent(e) {
+			continue
+		}
+		id, ok := correlationID(e)
+		if !ok {
+			continue
+		}
+		if ts, seen := starts[id]; !seen || e.Ts < ts {
+			starts[id] = e.Ts
+		}
+	}
+	return starts
+}
+
+// launchLatency returns the delay in microseconds between a launch call and
+// the start of the GPU activity it launched, or 0 if it cannot be matched
+func launchLatency(e TraceEvent, gpuStarts map[int64]float64) float64 {
+	if !isLaunchCall(e) {
+		return 0
+	}
+	id, ok := correlationID(e)
+	if !ok {
+		return 0
+	}
+	start, ok := gpuStarts[id]
+	if !ok || start <= e.Ts {
+		return 0
+	}
+	return start - e.Ts
+}
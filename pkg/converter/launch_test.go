@@ -0,0 +1,34 @@
+package converter
+
+import "testing"
+
+func TestAnalyzeTraceLaunchLatency(t *testing.T) {
+	launch := func(id, ts float64) TraceEvent {
+		return correlated(runtimeCall("cudaLaunchKernel", ts, 1), id)
+	}
+	kernel := func(name string, id, ts float64) TraceEvent {
+		return correlated(gpuKernel(name, ts, 5), id)
+	}
+	analysis := AnalyzeTrace(&TraceData{TraceEvents: []TraceEvent{
+		launch(1, 0), kernel("gemm", 1, 10),
+		launch(2, 20), kernel("relu", 2, 22),
+		kernel("softmax", 3, 25), launch(3, 24), // GPU event first
+		launch(4, 30), // Never ran
+	}})
+
+	l := analysis.LaunchLatency
+	if l == nil {
+		t.Fatal("Expected launch latency statistics")
+	}
+	if l.Launches != 3 || l.TotalNs != 13000 || l.MinNs != 1000 || l.MaxNs != 10000 || l.P50Ns != 2000 {
+		t.Errorf("Unexpected launch latency statistics: %+v", l)
+	}
+	want := LaunchLatency{Call: "cudaLaunchKernel", Kernel: "gemm", Thread: l.Worst[0].Thread, StartNs: 0, LatencyNs: 10000}
+	if len(l.Worst) != 3 || l.Worst[0] != want || l.Worst[2].Kernel != "softmax" {
+		t.Errorf("Expected the slowest launch %+v first, got %+v", want, l.Worst)
+	}
+
+	if AnalyzeTrace(&TraceData{TraceEvents: []TraceEvent{launch(1, 0)}}).LaunchLatency != nil {
+		t.Error("Expected no launch latency statistics without GPU events")
+	}
+}
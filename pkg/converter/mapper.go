@@ -0,0 +1,131 @@
+package converter
+
+// Frame is a function frame of a profile stack
+type Frame struct {
+	Name     string
+	Category string
+}
+
+// Label is a sample label. It is a string label when Str is set and a
+// numeric label with Num and Unit otherwise.
+type Label struct {
+	Key  string
+	Str  string
+	Num  int64
+	Unit string
+}
+
+// MappedEvent is how a trace event appears in the profile
+type MappedEvent struct {
+	// Frames are pushed on the stack for the event, outermost first.
+	// Events nested inside it are stacked under the last frame.
+	Frames []Frame
+
+	// Labels are attached to the samples of the event
+	Labels []Label
+
+	// ValueNs is the sample value of the event in nanoseconds
+	ValueNs float64
+
+	// Untimed events keep their place in the stacks but contribute no
+	// samples, like events without tdur with ClockThread
+	Untimed bool
+}
+
+// EventMapper classifies complete trace events into frames, labels and
+// sample values. MapEvent returns false to drop the event, as if it was not
+// in the trace. Events are mapped one at a time, in trace order.
+type EventMapper interface {
+	MapEvent(e TraceEvent) (MappedEvent, bool)
+}
+
+// EventMapperFunc adapts a function to the EventMapper interface
+type EventMapperFunc func(e TraceEvent) (MappedEvent, bool)
+
+// MapEvent calls f(e)
+func (f EventMapperFunc) MapEvent(e TraceEvent) (MappedEvent, bool) {
+	return f(e)
+}
+
+// DefaultEventMapper returns the mapper used for events without a custom
+// mapper: one frame named after the event, communication ops moved to the
+// "communication" category, GPU kernel launch labels, kernel name
+// normalization and the duration on opts.Clock. Custom mappers can fall
+// back to it for events they do not handle.
+func DefaultEventMapper(opts ConvertOptions) EventMapper {
+	return &defaultMapper{
+		normalize:   opts.NormalizeKernelNames,
+		rawLabel:    opts.RawKernelNameLabel,
+		clock:       opts.Clock,
+		kernelNames: make(map[string]string),
+	}
+}
+
+// defaultMapper implements DefaultEventMapper. It caches normalized kernel
+// names, so it is not safe for concurrent use.
+type defaultMapper struct {
+	normalize   bool
+	rawLabel    bool
+	clock       Clock
+	kernelNames map[string]string
+}
+
+func (m *defaultMapper) MapEvent(e TraceEvent) (MappedEvent, bool) {
+	var labels []Label
+	for _, l := range kernelLabels(e) {
+		labels = append(labels, Label{Key: l.key, Str: l.str, Num: l.num, Unit: l.unit})
+	}
+	name := e.Name
+	if m.normalize && isKernelEvent(e) {
+		normalized, ok := m.kernelNames[e.Name]
+		if !ok {
+			normalized = normalizeKernelName(e.Name)
+			m.kernelNames[e.Name] = normalized
+		}
+		if m.rawLabel && normalized != e.Name {
+			labels = append(labels, Label{Key: "raw_name", Str: e.Name})
+		}
+		name = normalized
+	}
+	// Collective kernels keep their launch labels and normalized names,
+	// so they are moved to their category last
+	category := e.Cat
+	if isCommunicationOp(e.Name) {
+		category = communicationCategory
+	}
+	valueNs, timed := m.clock.sampleDurationNs(e)
+	return MappedEvent{
+		Frames:  []Frame{{Name: name, Category: category}},
+		Labels:  labels,
+		ValueNs: valueNs,
+		Untimed: !timed,
+	}, true
+}
+
+// mapEvent maps e with the custom mapper registered for its category, or
+// with the default mapper, into the frames and labels of a stack entry
+func (opts ConvertOptions) mapEvent(e TraceEvent, defaultMapper EventMapper) (eventWithEnd, bool) {
+	mapper := opts.EventMappers[e.Cat]
+	if mapper == nil {
+		mapper = defaultMapper
+	}
+	m, ok := mapper.MapEvent(e)
+	if !ok || len(m.Frames) == 0 {
+		return eventWithEnd{}, false
+	}
+	event := eventWithEnd{
+		Ts:      e.Ts,
+		Dur:     e.Dur,
+		End:     e.Ts + e.Dur,
+		frames:  make([]frame, len(m.Frames)),
+		valueNs: m.ValueNs,
+		timed:   !m.Untimed,
+	}
+	for i, f := range m.Frames {
+		event.frames[i] = frame{name: f.Name, cat: f.Category}
+	}
+	for _, l := range m.Labels {
+		event.labels = append(event.labels, sampleLabel{key: l.Key, str: l.Str, num: l.Num, unit: l.Unit, isNum: l.Str == ""})
+	}
+	return event, true
+}
@@ -0,0 +1,62 @@
+package converter
+
+import "runtime/metrics"
+
+// MemoryPhase is the Go heap use of a phase of conversion
+type MemoryPhase struct {
+	// Stage is "group" for grouping events by thread, StageBuild or
+	// StageAggregate
+	Stage string
+
+	// HeapBytes is the heap in use at the end of the phase, garbage not
+	// yet collected included, and AllocatedBytes the bytes allocated
+	// during the phase
+	HeapBytes      uint64
+	AllocatedBytes uint64
+}
+
+// stageGroup is the MemoryPhase of grouping events by thread, which is
+// not reported to a ProgressFunc
+const stageGroup = "group"
+
+// Runtime metrics read for memory accounting
+const (
+	metricHeapObjects = "/memory/classes/heap/objects:bytes"
+	metricHeapAllocs  = "/gc/heap/allocs:bytes"
+	metricHeapLive    = "/gc/heap/live:bytes"
+)
+
+// memoryTracker records the MemoryPhases of a conversion
+type memoryTracker struct {
+	phases    []MemoryPhase
+	allocated uint64
+}
+
+func newMemoryTracker() *memoryTracker {
+	_, allocated := readHeap()
+	return &memoryTracker{allocated: allocated}
+}
+
+// end records the phase stage as ending now
+func (t *memoryTracker) end(stage string) {
+	heap, allocated := readHeap()
+	t.phases = append(t.phases, MemoryPhase{Stage: stage, HeapBytes: heap, AllocatedBytes: allocated - t.allocated})
+	t.allocated = allocated
+}
+
+// readHeap returns the bytes of heap objects and the bytes allocated so
+// far. Unlike runtime.ReadMemStats, it does not stop the world.
+func readHeap() (heap, allocated uint64) {
+	samples := []metrics.Sample{{Name: metricHeapObjects}, {Name: metricHeapAllocs}}
+	metrics.Read(samples)
+	return samples[0].Value.Uint64(), samples[1].Value.Uint64()
+}
+
+// liveHeap returns the bytes of heap objects that were live at the end of
+// the last garbage collection, which unlike the heap in use does not
+// count garbage
+func liveHeap() uint64 {
+	samples := []metrics.Sample{{Name: metricHeapLive}}
+	metrics.Read(samples)
+	return samples[0].Value.Uint64()
+}
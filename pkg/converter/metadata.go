@@ -0,0 +1,109 @@
+package converter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// traceMetadata holds process and thread names collected from metadata
+// events (ph=M) such as process_name, process_labels and thread_name
+type traceMetadata struct {
+	processNames  map[string]string
+	processLabels map[string]string
+	threadNames   map[string]string
+}
+
+// collectMetadata scans the trace for metadata events
+func collectMetadata(events []TraceEvent) *traceMetadata {
+	md := &traceMetadata{
+		processNames:  make(map[string]string),
+		processLabels: make(map[string]string),
+		threadNames:   make(map[string]string),
+	}
+	for _, e := range events {
+		if e.Ph != "M" {
+			continue
+		}
+		name, _ := e.ArgString("name")
+		switch e.Name {
+		case "process_name":
+			md.processNames[idString(e.Pid)] = strings.TrimSpace(name)
+		case "process_labels":
+			labels, _ := e.ArgString("labels")
+			md.processLabels[idString(e.Pid)] = strings.TrimSpace(labels)
+		case "thread_name":
+			md.threadNames[threadKey(e.Pid, e.Tid)] = strings.TrimSpace(name)
+		}
+	}
+	return md
+}
+
+// rootFrame returns the synthetic frame naming the execution context of a
+// (pid, tid) pair, e.g. "python (pid 1234, tid main)" or "GPU 0 stream 7"
+func (md *traceMetadata) rootFrame(pid, tid interface{}) frame {
+	pidStr := idString(pid)
+	tidStr := idString(tid)
+
+	thread := md.threadNames[threadKey(pid, tid)]
+	if label := md.processLabels[pidStr]; strings.HasPrefix(label, "GPU") {
+		if thread == "" {
+			thread = "stream " + tidStr
+		}
+		return frame{name: label + " " + thread, cat: threadCategory}
+	}
+
+	process := md.processNames[pidStr]
+	if process == "" {
+		process = "process"
+	}
+	if thread == "" {
+		thread = tidStr
+	}
+	return frame{name: fmt.Sprintf("%s (pid %s, tid %s)", process, pidStr, thread), cat: threadCategory}
+}
+
+// processLabelsFor returns the labels identifying the process of a sample:
+// its pid and, when known from metadata, the process name
+func (md *traceMetadata) processLabelsFor(pid interface{}) []sampleLabel {
+	pidStr := idString(pid)
+	labels := []sampleLabel{{key: "pid", str: pidStr}}
+	if name := md.processNames[pidStr]; name != "" {
+		labels = append(labels, sampleLabel{key: "process", str: name})
+	}
+	return labels
+}
+
+// threadCategory is the category of synthetic thread root frames
+const threadCategory = "thread"
+
+// threadKey builds a map key for a (pid, tid) pair
+func threadKey(pid, tid interface{}) string {
+	return idString(pid) + "/" + idString(tid)
+}
+
+// idString renders a pid or tid, which may be a number or a string
+func idString(id interface{}) string {
+	switch v := id.(type) {
+	case float64:
+		return strconv.FormatInt(int64(v), 10)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case string:
+		return v
+	default:
+		return "0"
+	}
+}
+
+// lessID orders ids rendered by idString numerically when both are numbers
+func lessID(a, b string) bool {
+	x, errA := strconv.ParseInt(a, 10, 64)
+	y, errB := strconv.ParseInt(b, 10, 64)
+	if errA == nil && errB == nil {
+		return x < y
+	}
+	return a < b
+}
@@ -0,0 +1,242 @@
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"runtime"
+	"slices"
+	"sync"
+
+	"github.com/olka/torch2pprof/pkg/profile"
+)
+
+// minChunkSize is the least input a parallel scan of the traceEvents array
+// gives each worker
+const minChunkSize = 16 << 20
+
+// decodeMapped is decodeTrace for a whole uncompressed trace held in
+// memory, such as a mapped file, parsed in place. The traceEvents array is
+// split into chunks scanned in parallel; progress may be nil.
+func decodeMapped(ctx context.Context, data []byte, keepArgs map[string]bool, progress ProgressFunc, logger *slog.Logger) (*TraceData, error) {
+	if err := sniffHead(data[:min(len(data), sniffSize)]); err != nil {
+		return nil, err
+	}
+	report := &byteProgress{progress: progress, total: int64(len(data))}
+
+	var traceData TraceData
+	s := newBytesScanner(data)
+	err := s.scanTrace(logger, func(key string, raw json.RawMessage) {
+		if traceData.Metadata == nil {
+			traceData.Metadata = make(map[string]json.RawMessage)
+		}
+		traceData.Metadata[key] = raw
+	}, func() error {
+		n := max(min(runtime.GOMAXPROCS(0), (len(data)-s.pos)/minChunkSize), 1)
+		events, end, err := scanEventsParallel(ctx, data, s.pos, n, keepArgs, report, logger)
+		if err != nil {
+			return err
+		}
+		traceData.TraceEvents = append(traceData.TraceEvents, events...)
+		s.pos = end
+		return nil
+	})
+	if err != nil {
+		var parseErr *ParseError
+		if errors.As(err, &parseErr) && parseErr.Snippet == "" {
+			parseErr.Snippet = s.snippet(parseErr.Offset)
+		}
+		return nil, err
+	}
+	logger.Debug("Decoded trace", "events", len(traceData.TraceEvents), "metadata_fields", len(traceData.Metadata))
+	if progress != nil {
+		progress(StageParse, report.total, report.total)
+	}
+	return &traceData, nil
+}
+
+// byteProgress reports the bytes parsed by concurrent chunk scans, one
+// call at a time
+type byteProgress struct {
+	mu       sync.Mutex
+	progress ProgressFunc
+	done     int64
+	total    int64
+}
+
+// add reports n more bytes parsed
+func (p *byteProgress) add(n int) {
+	if p.progress == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Chunks that turn out to start inside a string count twice
+	p.done = min(p.done+int64(n), p.total)
+	p.progress(StageParse, p.done, p.total)
+}
+
+// eventChunk is the result of scanning part of a traceEvents array
+type eventChunk struct {
+	events []TraceEvent
+	next   int  // Offset of the first element not read
+	end    bool // The closing bracket was read, next is just past it
+	err    error
+}
+
+// scanEventsParallel reads the traceEvents array of data opening at start
+// in up to n chunks, returning its events and the offset just past it. Chunk boundaries are
+// guessed from the raw bytes and may lie inside a string, so a chunk is
+// only used if the one before it stopped exactly at its start; otherwise
+// the rest of the array is scanned sequentially. The result is always the
+// same as for a single scan.
+func scanEventsParallel(ctx context.Context, data []byte, start, n int, keepArgs map[string]bool, report *byteProgress, logger *slog.Logger) ([]TraceEvent, int, error) {
+	start++ // [
+	bounds := []int{start}
+	for i := 1; i < n; i++ {
+		b := eventBoundary(data, start+(len(data)-start)/n*i)
+		if b < 0 {
+			break
+		}
+		if b > bounds[len(bounds)-1] {
+			bounds = append(bounds, b)
+		}
+	}
+
+	// The chunks share the table of event names, so a name is stored once
+	// whichever chunks it appears in
+	names := profile.NewBuilder()
+	chunks := make([]eventChunk, len(bounds))
+	var wg sync.WaitGroup
+	for i := range bounds {
+		limit := len(data)
+		if i+1 < len(bounds) {
+			limit = bounds[i+1]
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			chunks[i] = scanEventChunk(ctx, data, bounds[i], limit, i == 0, names, keepArgs, report)
+		}()
+	}
+	wg.Wait()
+
+	var parts [][]TraceEvent
+	for i, c := range chunks {
+		if c.err != nil {
+			return nil, 0, c.err
+		}
+		parts = append(parts, c.events)
+		if c.end {
+			logger.Debug("Scanned traceEvents in parallel", "chunks", i+1)
+			return slices.Concat(parts...), c.next, nil
+		}
+		if i+1 < len(chunks) && c.next == bounds[i+1] {
+			continue
+		}
+		logger.Debug("Scanning the rest of traceEvents sequentially", "offset", c.next, "chunks", i+1)
+		rest := scanEventChunk(ctx, data, c.next, len(data), false, names, keepArgs, report)
+		if rest.err != nil {
+			return nil, 0, rest.err
+		}
+		return slices.Concat(append(parts, rest.events)...), rest.next, nil
+	}
+	panic("unreachable") // The last chunk has no limit, so it reads the closing bracket or fails
+}
+
+// scanEventChunk reads the events of a traceEvents array from start, the
+// offset of an element or, if first, just past the opening bracket, until
+// the next element starts at or after limit or the array ends, interning
+// event names in names and keeping the args in keepArgs, unless nil
+func scanEventChunk(ctx context.Context, data []byte, start, limit int, first bool, names *profile.Builder, keepArgs map[string]bool, report *byteProgress) eventChunk {
+	s := newBytesScanner(data)
+	s.pos = start
+	s.names = names
+	s.keepArgs = keepArgs
+	var chunk eventChunk
+	reported := start
+
+	if first {
+		c, err := s.nonSpace()
+		if err != nil {
+			chunk.err = err
+			return chunk
+		}
+		if c == ']' {
+			chunk.next, chunk.end = s.pos+1, true
+			return chunk
+		}
+	}
+	for {
+		var e TraceEvent
+		if err := s.readArrayEvent(&e); err != nil {
+			chunk.err = err
+			return chunk
+		}
+		chunk.events = append(chunk.events, e)
+		if len(chunk.events)%progressInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				chunk.err = err
+				return chunk
+			}
+			report.add(s.pos - reported)
+			reported = s.pos
+		}
+
+		c, err := s.nonSpace()
+		if err != nil {
+			chunk.err = err
+			return chunk
+		}
+		switch c {
+		case ',':
+			s.pos++
+		case ']':
+			chunk.next, chunk.end = s.pos+1, true
+			return chunk
+		default:
+			chunk.err = s.syntaxError(c, "after array element")
+			return chunk
+		}
+		if _, err := s.nonSpace(); err != nil {
+			chunk.err = err
+			return chunk
+		}
+		if s.pos >= limit {
+			chunk.next = s.pos
+			return chunk
+		}
+	}
+}
+
+// eventBoundary returns the offset of the first { at or after from that
+// follows a }, a comma and optional whitespace, as between two events, or
+// -1 if there is none
+func eventBoundary(data []byte, from int) int {
+	for i := from; i < len(data); i++ {
+		if data[i] != '{' {
+			continue
+		}
+		j := lastNonSpace(data, i)
+		if j >= 0 && data[j] == ',' {
+			if k := lastNonSpace(data, j); k >= 0 && data[k] == '}' {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// lastNonSpace returns the offset of the last non-whitespace byte before
+// i, or -1
+func lastNonSpace(data []byte, i int) int {
+	for i--; i >= 0; i-- {
+		switch data[i] {
+		case ' ', '\t', '\n', '\r':
+		default:
+			return i
+		}
+	}
+	return -1
+}
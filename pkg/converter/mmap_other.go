@@ -0,0 +1,13 @@
+//go:build !unix
+
+package converter
+
+import (
+	"errors"
+	"os"
+)
+
+// mapFile is not supported on this platform, so files are streamed
+func mapFile(f *os.File, size int64) ([]byte, func() error, error) {
+	return nil, nil, errors.ErrUnsupported
+}
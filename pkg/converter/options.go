@@ -0,0 +1,276 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"regexp"
+	"runtime"
+	"time"
+
+	"github.com/olka/torch2pprof/pkg/profile"
+)
+
+// Option sets a conversion option. Options are applied in order, so later
+// options override earlier ones.
+type Option func(*ConvertOptions)
+
+// NewConvertOptions returns the conversion options with one worker per CPU
+// and the given options applied, or an error if they are invalid
+func NewConvertOptions(options ...Option) (ConvertOptions, error) {
+	opts := ConvertOptions{NumWorkers: runtime.NumCPU()}
+	for _, o := range options {
+		o(&opts)
+	}
+	if err := opts.Validate(); err != nil {
+		return ConvertOptions{}, err
+	}
+	return opts, nil
+}
+
+// Convert converts trace data with the given options, see NewConvertOptions
+func Convert(ctx context.Context, traceData *TraceData, options ...Option) (*profile.Profile, error) {
+	opts, err := NewConvertOptions(options...)
+	if err != nil {
+		return nil, err
+	}
+	return ConvertTraceContext(ctx, traceData, opts)
+}
+
+// Validate reports all invalid options at once
+func (opts ConvertOptions) Validate() error {
+	var errs []error
+	if opts.NumWorkers < 0 {
+		errs = append(errs, fmt.Errorf("negative number of workers %d", opts.NumWorkers))
+	}
+	if opts.MinDuration < 0 {
+		errs = append(errs, fmt.Errorf("negative minimum duration %v", opts.MinDuration))
+	}
+	if opts.MaxMemory < 0 {
+		errs = append(errs, fmt.Errorf("negative memory budget %d", opts.MaxMemory))
+	}
+	if opts.Epsilon < 0 {
+		errs = append(errs, fmt.Errorf("negative epsilon %v", opts.Epsilon))
+	}
+	if opts.SampleRate < 0 || opts.SampleRate > 1 {
+		errs = append(errs, fmt.Errorf("sample rate %v is not in [0, 1]", opts.SampleRate))
+	}
+	if opts.Clock != "" {
+		if _, err := ParseClock(string(opts.Clock)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if opts.Overlap != "" {
+		if _, err := ParseOverlapPolicy(string(opts.Overlap)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if opts.AnalyzeBy != "" {
+		if _, err := ParseGroupBy(string(opts.AnalyzeBy)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	seen := make(map[SampleType]bool)
+	for _, t := range opts.SampleTypes {
+		if t != SampleCount && t != SampleTime {
+			errs = append(errs, fmt.Errorf("unknown sample type %q (want samples or time)", t))
+		} else if seen[t] {
+			errs = append(errs, fmt.Errorf("duplicate sample type %q", t))
+		}
+		seen[t] = true
+	}
+	for key := range opts.Labels {
+		if key == "" {
+			errs = append(errs, errors.New("empty label key"))
+		}
+	}
+	for category, m := range opts.EventMappers {
+		if m == nil {
+			errs = append(errs, fmt.Errorf("nil event mapper for category %q", category))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid conversion options: %w", err)
+	}
+	return nil
+}
+
+// orDefaults returns opts with the options Validate rejects reset to their
+// defaults and spilling disabled, so that converting with them cannot fail
+func (opts ConvertOptions) orDefaults() ConvertOptions {
+	opts.NumWorkers = max(opts.NumWorkers, 0)
+	opts.MinDuration = max(opts.MinDuration, 0)
+	opts.Epsilon = max(opts.Epsilon, 0)
+	opts.MaxMemory, opts.SpillDir = 0, ""
+	if opts.SampleRate < 0 || opts.SampleRate > 1 {
+		opts.SampleRate = 0
+	}
+	if _, err := ParseClock(string(opts.Clock)); err != nil {
+		opts.Clock = ""
+	}
+	if _, err := ParseOverlapPolicy(string(opts.Overlap)); err != nil {
+		opts.Overlap = ""
+	}
+	if _, err := ParseGroupBy(string(opts.AnalyzeBy)); err != nil {
+		opts.AnalyzeBy = ""
+	}
+	if (ConvertOptions{SampleTypes: opts.SampleTypes}).Validate() != nil {
+		opts.SampleTypes = nil
+	}
+	opts.Labels = maps.Clone(opts.Labels)
+	delete(opts.Labels, "")
+	opts.EventMappers = maps.Clone(opts.EventMappers)
+	maps.DeleteFunc(opts.EventMappers, func(_ string, m EventMapper) bool { return m == nil })
+	return opts
+}
+
+// WithWorkers sets how many threads are converted in parallel
+func WithWorkers(n int) Option {
+	return func(o *ConvertOptions) { o.NumWorkers = n }
+}
+
+// workers returns the size of the worker pool
+func (opts ConvertOptions) workers() int {
+	if opts.NumWorkers > 0 {
+		return opts.NumWorkers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// WithThreadRoots prefixes each stack with a frame naming its process and
+// thread or GPU stream
+func WithThreadRoots() Option {
+	return func(o *ConvertOptions) { o.ThreadRoots = true }
+}
+
+// WithCommunicationRoot groups collective communication stacks under a
+// "Communication" root frame
+func WithCommunicationRoot() Option {
+	return func(o *ConvertOptions) { o.CommunicationRoot = true }
+}
+
+// WithFocus keeps only stacks with a frame matching re
+func WithFocus(re *regexp.Regexp) Option {
+	return func(o *ConvertOptions) { o.Focus = re }
+}
+
+// WithIgnore drops stacks with a frame matching re
+func WithIgnore(re *regexp.Regexp) Option {
+	return func(o *ConvertOptions) { o.Ignore = re }
+}
+
+// WithCollapseRecursion merges consecutive identical frames
+func WithCollapseRecursion() Option {
+	return func(o *ConvertOptions) { o.CollapseRecursion = true }
+}
+
+// WithNormalizedKernelNames shortens GPU kernel names, keeping the
+// original names as "raw_name" labels if rawLabel is set
+func WithNormalizedKernelNames(rawLabel bool) Option {
+	return func(o *ConvertOptions) {
+		o.NormalizeKernelNames = true
+		o.RawKernelNameLabel = rawLabel
+	}
+}
+
+// WithMinDuration drops events shorter than d
+func WithMinDuration(d time.Duration) Option {
+	return func(o *ConvertOptions) { o.MinDuration = d }
+}
+
+// WithLaunchLatency adds "launch latency" frames under kernel launches
+func WithLaunchLatency() Option {
+	return func(o *ConvertOptions) { o.LaunchLatency = true }
+}
+
+// WithIdleFrames adds "<idle>" samples for gaps between events
+func WithIdleFrames() Option {
+	return func(o *ConvertOptions) { o.IdleFrames = true }
+}
+
+// WithSampleRate keeps each leaf event with probability rate, seeding the
+// random generator with seed
+func WithSampleRate(rate float64, seed int64) Option {
+	return func(o *ConvertOptions) {
+		o.SampleRate = rate
+		o.SampleSeed = seed
+	}
+}
+
+// WithClock selects the clock of sample values
+func WithClock(c Clock) Option {
+	return func(o *ConvertOptions) { o.Clock = c }
+}
+
+// WithEpsilon sets the tolerance when comparing event boundaries
+func WithEpsilon(d time.Duration) Option {
+	return func(o *ConvertOptions) { o.Epsilon = d }
+}
+
+// WithOverlap selects how partially overlapping events are attributed
+func WithOverlap(p OverlapPolicy) Option {
+	return func(o *ConvertOptions) { o.Overlap = p }
+}
+
+// WithSampleTypes selects the value columns of the profile, in order
+func WithSampleTypes(types ...SampleType) Option {
+	return func(o *ConvertOptions) { o.SampleTypes = types }
+}
+
+// WithLabel attaches key=value to every sample and as a profile comment
+func WithLabel(key, value string) Option {
+	return func(o *ConvertOptions) {
+		if o.Labels == nil {
+			o.Labels = make(map[string]string)
+		}
+		o.Labels[key] = value
+	}
+}
+
+// WithProgress reports conversion progress to fn
+func WithProgress(fn ProgressFunc) Option {
+	return func(o *ConvertOptions) { o.Progress = fn }
+}
+
+// WithEventMapper maps the events of category with m
+func WithEventMapper(category string, m EventMapper) Option {
+	return func(o *ConvertOptions) {
+		if o.EventMappers == nil {
+			o.EventMappers = make(map[string]EventMapper)
+		}
+		o.EventMappers[category] = m
+	}
+}
+
+// WithRewrite rewrites the frames of each stack with r
+func WithRewrite(r FrameRewriter) Option {
+	return func(o *ConvertOptions) { o.Rewrite = r }
+}
+
+// WithLogger logs conversion warnings and debug details to logger
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *ConvertOptions) { o.Logger = logger }
+}
+
+// WithAnalysis also analyzes the trace while converting it, with
+// operations aggregated by groupBy, into Diagnostics.Analysis
+func WithAnalysis(groupBy GroupBy) Option {
+	return func(o *ConvertOptions) { o.AnalyzeBy = groupBy }
+}
+
+// WithAnalysisReports selects the optional reports of the analysis made
+// with WithAnalysis
+func WithAnalysisReports(reports Reports) Option {
+	return func(o *ConvertOptions) { o.AnalyzeReports = reports }
+}
+
+// WithMaxMemory spills aggregated samples to temporary files in dir
+// (os.TempDir() if empty) beyond an estimated maxBytes
+func WithMaxMemory(maxBytes int64, dir string) Option {
+	return func(o *ConvertOptions) {
+		o.MaxMemory = maxBytes
+		o.SpillDir = dir
+	}
+}
@@ -0,0 +1,181 @@
+package converter
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OutlierThreshold is the duration above which an event is an outlier of
+// its operation: StdDevs standard deviations above the mean, or Multiple
+// times the Percentile-th percentile when StdDevs is zero
+type OutlierThreshold struct {
+	StdDevs    float64
+	Percentile int
+	Multiple   float64
+}
+
+// ParseOutlierThreshold parses a threshold such as "3" or "3sigma" for
+// three standard deviations above the mean, or "2xp99" for twice the p99
+func ParseOutlierThreshold(s string) (OutlierThreshold, error) {
+	if multiple, p, ok := strings.Cut(s, "xp"); ok {
+		m, err := strconv.ParseFloat(multiple, 64)
+		if err != nil || m <= 0 {
+			return OutlierThreshold{}, fmt.Errorf("invalid outlier threshold %q: bad multiple %q", s, multiple)
+		}
+		percentile, err := strconv.Atoi(p)
+		if err != nil || percentile < 1 || percentile > 100 {
+			return OutlierThreshold{}, fmt.Errorf("invalid outlier threshold %q: percentile must be 1 to 100", s)
+		}
+		return OutlierThreshold{Percentile: percentile, Multiple: m}, nil
+	}
+	n, err := strconv.ParseFloat(strings.TrimSuffix(s, "sigma"), 64)
+	if err != nil || n <= 0 {
+		return OutlierThreshold{}, fmt.Errorf("invalid outlier threshold %q (want N, Nsigma or MxpP such as 3 or 2xp99)", s)
+	}
+	return OutlierThreshold{StdDevs: n}, nil
+}
+
+// String returns the threshold as parsed by ParseOutlierThreshold
+func (t OutlierThreshold) String() string {
+	if t.StdDevs > 0 {
+		return strconv.FormatFloat(t.StdDevs, 'g', -1, 64) + "sigma"
+	}
+	return strconv.FormatFloat(t.Multiple, 'g', -1, 64) + "xp" + strconv.Itoa(t.Percentile)
+}
+
+// limit returns the outlier duration for the sorted durations of an
+// operation
+func (t OutlierThreshold) limit(sorted []int64) int64 {
+	if t.StdDevs > 0 {
+		var sum float64
+		for _, v := range sorted {
+			sum += float64(v)
+		}
+		mean := sum / float64(len(sorted))
+		return int64(math.Round(mean + t.StdDevs*float64(stdDev(sorted))))
+	}
+	return int64(math.Round(t.Multiple * float64(percentile(sorted, t.Percentile))))
+}
+
+// minOutlierSamples is the number of events an operation needs for its
+// outliers to be reported, below which its distribution is mostly noise
+const minOutlierSamples = 10
+
+// Outlier is an event much longer than the other events of its operation.
+// Stack holds the events enclosing it on its thread, outermost first, and
+// Step the ProfilerStep it started in, -1 for none.
+type Outlier struct {
+	Name     string   `json:"name"`
+	Thread   string   `json:"thread"`
+	Step     int      `json:"step"`
+	StartNs  int64    `json:"start_ns"` // Since the start of the trace
+	DurNs    int64    `json:"dur_ns"`
+	MedianNs int64    `json:"median_ns"` // Of the operation
+	LimitNs  int64    `json:"limit_ns"`
+	Stack    []string `json:"stack"`
+}
+
+// FindOutliers returns the complete events lasting longer than threshold
+// allows for their operation, by name, most slowed down relative to the
+// median of the operation first. At most limit outliers are returned,
+// unless limit is 0.
+func FindOutliers(traceData *TraceData, threshold OutlierThreshold, limit int) []Outlier {
+	durations := make(map[string][]int64)
+	start := math.Inf(1)
+	steps := make(map[int]TimeWindow)
+	for _, e := range traceData.TraceEvents {
+		if e.Ph != "X" || e.Dur <= 0 {
+			continue
+		}
+		durations[e.Name] = append(durations[e.Name], usToNs(e.Dur))
+		start = min(start, e.Ts)
+		if step, ok := profilerStepNumber(e); ok {
+			steps[step] = TimeWindow{Start: e.Ts, End: e.Ts + e.Dur}
+		}
+	}
+
+	type operation struct{ median, limit int64 }
+	operations := make(map[string]operation)
+	for name, ds := range durations {
+		if len(ds) < minOutlierSamples {
+			continue
+		}
+		slices.Sort(ds)
+		operations[name] = operation{median: percentile(ds, 50), limit: threshold.limit(ds)}
+	}
+
+	var found []int // Indexes of the outlier events
+	for i, e := range traceData.TraceEvents {
+		if e.Ph != "X" || e.Dur <= 0 {
+			continue
+		}
+		if op, ok := operations[e.Name]; ok && usToNs(e.Dur) > op.limit {
+			found = append(found, i)
+		}
+	}
+	slowdown := func(i int) float64 {
+		e := traceData.TraceEvents[i]
+		return float64(usToNs(e.Dur)) / float64(max(operations[e.Name].median, 1))
+	}
+	sort.SliceStable(found, func(i, j int) bool { return slowdown(found[i]) > slowdown(found[j]) })
+	if limit > 0 && len(found) > limit {
+		found = found[:limit]
+	}
+	if len(found) == 0 {
+		return nil
+	}
+
+	md := collectMetadata(traceData.TraceEvents)
+	outliers := make([]Outlier, 0, len(found))
+	for _, i := range found {
+		e := traceData.TraceEvents[i]
+		o := Outlier{
+			Name:     e.Name,
+			Thread:   md.rootFrame(e.Pid, e.Tid).name,
+			Step:     -1,
+			StartNs:  usToNs(e.Ts - start),
+			DurNs:    usToNs(e.Dur),
+			MedianNs: operations[e.Name].median,
+			LimitNs:  operations[e.Name].limit,
+			Stack:    enclosingEvents(traceData.TraceEvents, i),
+		}
+		for step, w := range steps {
+			if w.Start <= e.Ts && e.Ts < w.End && (o.Step < 0 || step < o.Step) {
+				o.Step = step
+			}
+		}
+		outliers = append(outliers, o)
+	}
+	return outliers
+}
+
+// enclosingEvents returns the names of the complete events on the thread
+// of events[i] that enclose it, outermost first
+func enclosingEvents(events []TraceEvent, i int) []string {
+	target := events[i]
+	key := threadKey(target.Pid, target.Tid)
+	var enclosing []TraceEvent
+	for j, e := range events {
+		if j == i || e.Ph != "X" || e.Dur <= 0 || e.Ts > target.Ts || e.Ts+e.Dur < target.Ts+target.Dur {
+			continue
+		}
+		if threadKey(e.Pid, e.Tid) == key {
+			enclosing = append(enclosing, e)
+		}
+	}
+	sort.SliceStable(enclosing, func(a, b int) bool {
+		if enclosing[a].Ts != enclosing[b].Ts {
+			return enclosing[a].Ts < enclosing[b].Ts
+		}
+		return enclosing[a].Dur > enclosing[b].Dur
+	})
+	names := make([]string, len(enclosing))
+	for j, e := range enclosing {
+		names[j] = e.Name
+	}
+	return names
+}
@@ -0,0 +1,58 @@
+package converter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindOutliers(t *testing.T) {
+	events := []TraceEvent{
+		span("ProfilerStep#1", 0, 500),
+		span("ProfilerStep#2", 500, 500),
+		span("forward", 600, 300),
+		span("aten::mm", 650, 100), // The outlier
+		threadSpan("other thread", "", 2, 600, 300),
+		span("rare", 0, 5), // Too few events to have outliers
+		span("rare", 10, 500),
+	}
+	for i := range 10 {
+		events = append(events, span("aten::mm", float64(20*i), 10))
+	}
+
+	want := []Outlier{{
+		Name:     "aten::mm",
+		Thread:   "process (pid 1, tid 1)",
+		Step:     2,
+		StartNs:  650000,
+		DurNs:    100000,
+		MedianNs: 10000,
+		LimitNs:  95801,
+		Stack:    []string{"ProfilerStep#2", "forward"},
+	}}
+	for _, spec := range []string{"3", "3sigma"} {
+		threshold, err := ParseOutlierThreshold(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := FindOutliers(&TraceData{TraceEvents: events}, threshold, 0); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %+v, got %+v", spec, want, got)
+		}
+	}
+
+	threshold, err := ParseOutlierThreshold("2xp99")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if threshold != (OutlierThreshold{Percentile: 99, Multiple: 2}) || threshold.String() != "2xp99" {
+		t.Errorf("Unexpected threshold %+v", threshold)
+	}
+	// The p99 of 11 events is the outlier itself
+	if got := FindOutliers(&TraceData{TraceEvents: events}, threshold, 0); got != nil {
+		t.Errorf("Expected no outliers beyond twice the p99, got %+v", got)
+	}
+	for _, spec := range []string{"", "-1", "xp99", "2xp0", "2xq99", "three"} {
+		if _, err := ParseOutlierThreshold(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package converter
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// OverlapPolicy selects how an event that starts inside another event on the
+// same thread but ends after it is attributed. Such partial overlaps are
+// common with async annotations and cannot be represented as a call stack.
+type OverlapPolicy string
+
+const (
+	// OverlapSplit nests the part of the event inside the overlapping event
+	// under it and attributes the remainder to the enclosing stack
+	OverlapSplit OverlapPolicy = "split"
+
+	// OverlapParent nests the whole event under the overlapping event
+	OverlapParent OverlapPolicy = "parent"
+
+	// OverlapDrop drops partially overlapping events
+	OverlapDrop OverlapPolicy = "drop"
+)
+
+// ParseOverlapPolicy parses an overlap policy name
+func ParseOverlapPolicy(s string) (OverlapPolicy, error) {
+	switch p := OverlapPolicy(s); p {
+	case OverlapSplit, OverlapParent, OverlapDrop:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown overlap policy %q (want split, parent or drop)", s)
+	}
+}
+
+// ThreadOverlap reports partially overlapping events on one thread
+type ThreadOverlap struct {
+	Pid      interface{}
+	Tid      interface{}
+	Events   int
+	Overlaps int
+}
+
+// DetectOverlaps finds complete events that partially overlap an enclosing
+// event on the same (pid, tid) and returns per-thread counts, sorted by
+// overlap count descending. Threads without overlaps are omitted.
+// Boundaries closer than epsilon are considered equal, as in ConvertTrace.
+func DetectOverlaps(traceData *TraceData, epsilon time.Duration) []ThreadOverlap {
+	eps := float64(epsilon.Nanoseconds()) / 1000
+
+	threads := make(map[threadID][]TraceEvent)
+	for _, e := range traceData.TraceEvents {
+		if e.Ph != "X" || e.Dur <= 0 {
+			continue
+		}
+		id := threadID{pid: getTid(e.Pid), tid: getTid(e.Tid)}
+		threads[id] = append(threads[id], e)
+	}
+
+	var result []ThreadOverlap
+	for _, events := range threads {
+		sort.Slice(events, func(i, j int) bool {
+			return events[i].Ts < events[j].Ts
+		})
+
+		overlaps := 0
+		var open []float64 // End times of events still open
+		for _, e := range events {
+			end := e.Ts + e.Dur
+			live := open[:0]
+			partial := false
+			for _, openEnd := range open {
+				if openEnd <= e.Ts+eps {
+					continue
+				}
+				if openEnd < end-eps {
+					partial = true
+				}
+				live = append(live, openEnd)
+			}
+			open = append(live, end)
+			if partial {
+				overlaps++
+			}
+		}
+
+		if overlaps > 0 {
+			result = append(result, ThreadOverlap{
+				Pid:      events[0].Pid,
+				Tid:      events[0].Tid,
+				Events:   len(events),
+				Overlaps: overlaps,
+			})
+		}
+	}
+
+	sortOverlaps(result)
+	return result
+}
+
+// sortOverlaps sorts threads by overlap count descending, then by pid and
+// tid so that the order does not depend on the order threads were scanned
+func sortOverlaps(overlaps []ThreadOverlap) {
+	sort.Slice(overlaps, func(i, j int) bool {
+		a, b := overlaps[i], overlaps[j]
+		if a.Overlaps != b.Overlaps {
+			return a.Overlaps > b.Overlaps
+		}
+		if pa, pb := getTid(a.Pid), getTid(b.Pid); pa != pb {
+			return pa < pb
+		}
+		return getTid(a.Tid) < getTid(b.Tid)
+	})
+}
@@ -0,0 +1,143 @@
+package converter
+
+import (
+	"sort"
+	"strings"
+)
+
+// PrecisionStats classifies the GPU kernel time of a trace by the numeric
+// precision and Tensor Core use guessed from kernel names. MatrixNs is the
+// time of matrix kernels (GEMMs, convolutions, attention); TensorCoreNs
+// the time of kernels running on Tensor Cores, either by name (hmma, xmma,
+// tensorop, s16816...) or as matrix kernels in a reduced precision. A
+// large gap between the two means matrix math that AMP does not cover.
+type PrecisionStats struct {
+	KernelNs           int64           `json:"kernel_ns"`
+	MatrixNs           int64           `json:"matrix_ns"`
+	TensorCoreNs       int64           `json:"tensor_core_ns"`
+	TensorCoreFraction float64         `json:"tensor_core_fraction"` // Of KernelNs
+	Precisions         []PrecisionTime `json:"precisions"`
+}
+
+// PrecisionTime is the kernel time of one precision: fp8, int8, bf16,
+// fp16, tf32, fp32, fp64 or unknown
+type PrecisionTime struct {
+	Precision string `json:"precision"`
+	Kernels   int    `json:"kernels"`
+	TimeNs    int64  `json:"time_ns"`
+}
+
+// precisionMarkers maps lowercase kernel name substrings to precisions,
+// checked in order so that e.g. bf16 is not taken for f16. fp8 needs a
+// separator, as template arguments such as Fp8KVCacheDataType name fp8
+// support rather than an fp8 kernel.
+var precisionMarkers = []struct {
+	marker, precision string
+}{
+	{"e4m3", "fp8"}, {"e5m2", "fp8"}, {"float8", "fp8"}, {"fp8_", "fp8"}, {"_fp8", "fp8"},
+	{"int8", "int8"}, {"imma", "int8"}, {"i8i8", "int8"},
+	{"bf16", "bf16"}, {"bfloat16", "bf16"},
+	{"fp16", "fp16"}, {"f16", "fp16"}, {"half", "fp16"}, {"hmma", "fp16"}, {"h884", "fp16"}, {"h1688", "fp16"}, {"h16816", "fp16"},
+	{"tf32", "tf32"},
+	{"fp64", "fp64"}, {"double", "fp64"}, {"dgemm", "fp64"},
+	{"fp32", "fp32"}, {"f32", "fp32"}, {"float", "fp32"}, {"sgemm", "fp32"},
+}
+
+// tensorCoreMarkers are lowercase kernel name substrings of kernels built
+// on Tensor Core instructions
+var tensorCoreMarkers = []string{"hmma", "imma", "xmma", "gmma", "tensorop", "s16816", "s1688", "h884", "h1688", "h16816", "tensor16x8"}
+
+// matrixMarkers are lowercase kernel name substrings of matrix kernels,
+// which can run on Tensor Cores in a reduced precision
+var matrixMarkers = []string{"gemm", "mma", "tensorop", "cutlass", "conv2d", "conv3d", "convolution", "fprop", "dgrad", "wgrad", "flash::", "fmha"}
+
+// reducedPrecisions are the precisions Tensor Cores compute in
+var reducedPrecisions = map[string]bool{"fp8": true, "int8": true, "bf16": true, "fp16": true, "tf32": true}
+
+// kernelPrecision guesses the precision of a kernel from its name, and
+// whether it is a matrix kernel and runs on Tensor Cores
+func kernelPrecision(name string) (precision string, matrix, tensorCore bool) {
+	name = strings.ToLower(name)
+	precision = "unknown"
+	for _, m := range precisionMarkers {
+		if strings.Contains(name, m.marker) {
+			precision = m.precision
+			break
+		}
+	}
+	for _, m := range tensorCoreMarkers {
+		if strings.Contains(name, m) {
+			return precision, true, true
+		}
+	}
+	for _, m := range matrixMarkers {
+		if strings.Contains(name, m) {
+			return precision, true, reducedPrecisions[precision]
+		}
+	}
+	return precision, false, false
+}
+
+// precisionCounter accumulates PrecisionStats, caching the classification
+// of kernel names, which repeat
+type precisionCounter struct {
+	stats   PrecisionStats
+	byName  map[string]kernelClass
+	byPrec  map[string]*PrecisionTime
+	kernels int
+}
+
+// kernelClass is the classification of a kernel name
+type kernelClass struct {
+	precision          string
+	matrix, tensorCore bool
+}
+
+// add accounts for a kernel
+func (c *precisionCounter) add(name string, durNs int64) {
+	if c.byName == nil {
+		c.byName = make(map[string]kernelClass)
+		c.byPrec = make(map[string]*PrecisionTime)
+	}
+	class, ok := c.byName[name]
+	if !ok {
+		class.precision, class.matrix, class.tensorCore = kernelPrecision(name)
+		c.byName[name] = class
+	}
+	c.kernels++
+	c.stats.KernelNs += durNs
+	if class.matrix {
+		c.stats.MatrixNs += durNs
+	}
+	if class.tensorCore {
+		c.stats.TensorCoreNs += durNs
+	}
+	p := c.byPrec[class.precision]
+	if p == nil {
+		p = &PrecisionTime{Precision: class.precision}
+		c.byPrec[class.precision] = p
+	}
+	p.Kernels++
+	p.TimeNs += durNs
+}
+
+// finish returns the statistics, or nil if no kernel was added
+func (c *precisionCounter) finish() *PrecisionStats {
+	if c.kernels == 0 {
+		return nil
+	}
+	stats := c.stats
+	if stats.KernelNs > 0 {
+		stats.TensorCoreFraction = float64(stats.TensorCoreNs) / float64(stats.KernelNs)
+	}
+	for _, p := range c.byPrec {
+		stats.Precisions = append(stats.Precisions, *p)
+	}
+	sort.Slice(stats.Precisions, func(i, j int) bool {
+		if stats.Precisions[i].TimeNs != stats.Precisions[j].TimeNs {
+			return stats.Precisions[i].TimeNs > stats.Precisions[j].TimeNs
+		}
+		return stats.Precisions[i].Precision < stats.Precisions[j].Precision
+	})
+	return &stats
+}
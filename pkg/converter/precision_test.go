@@ -0,0 +1,41 @@
+package converter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKernelPrecision(t *testing.T) {
+	tests := []struct {
+		name               string
+		precision          string
+		matrix, tensorCore bool
+	}{
+		{"ampere_fp16_s16816gemm_fp16_128x64_ldg8_f2f_stages_64x4_tn", "fp16", true, true},
+		{"sm80_xmma_gemm_bf16bf16_bf16f32_f32_tn_n_tilesize128x128x32", "bf16", true, true},
+		{"void cutlass::Kernel2<cutlass_80_tensorop_s16816gemm_f16_64x64_64x6_tn_align8>(Params)", "fp16", true, true},
+		{"sm90_xmma_gemm_e4m3e4m3_e4m3f32_f32_tn_n_tilesize128x128x64", "fp8", true, true},
+		{"volta_sgemm_128x64_tn", "fp32", true, false},
+		{"void flash::flash_fwd_kernel<Flash_fwd_kernel_traits<64, 64, 256, 4, cutlass::half_t>>()", "fp16", true, true},
+		{"void at::native::vectorized_elementwise_kernel<4, at::native::FillFunctor<float>>()", "fp32", false, false},
+		{"void vllm::reshape_and_cache_flash_kernel<unsigned short, (vllm::Fp8KVCacheDataType)0>()", "unknown", false, false},
+	}
+	for _, tt := range tests {
+		precision, matrix, tensorCore := kernelPrecision(tt.name)
+		if precision != tt.precision || matrix != tt.matrix || tensorCore != tt.tensorCore {
+			t.Errorf("kernelPrecision(%q) = %s, %v, %v; want %s, %v, %v",
+				tt.name, precision, matrix, tensorCore, tt.precision, tt.matrix, tt.tensorCore)
+		}
+	}
+
+	p := AnalyzeTrace(&TraceData{TraceEvents: []TraceEvent{
+		gpuKernel(tests[0].name, 0, 60), gpuKernel(tests[4].name, 0, 30), gpuKernel(tests[6].name, 0, 10),
+	}}).Precision
+	if p == nil || p.KernelNs != 100000 || p.MatrixNs != 90000 || p.TensorCoreNs != 60000 || p.TensorCoreFraction != 0.6 {
+		t.Fatalf("Unexpected precision statistics: %+v", p)
+	}
+	want := []PrecisionTime{{Precision: "fp16", Kernels: 1, TimeNs: 60000}, {Precision: "fp32", Kernels: 2, TimeNs: 40000}}
+	if !reflect.DeepEqual(p.Precisions, want) {
+		t.Errorf("Expected %+v, got %+v", want, p.Precisions)
+	}
+}
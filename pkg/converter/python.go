@@ -0,0 +1,72 @@
+package converter
+
+import (
+	"maps"
+	"slices"
+)
+
+// PythonOverheadStats splits the CPU time of a trace recorded with
+// with_stack=True between Python and the framework. PythonNs is the time
+// threads spent in python_function frames, PurePythonNs the part of it
+// outside any operator (cpu_op events), interpreting the model's code, and
+// OperatorNs the time threads spent in operators. GPUNs is the time GPUs
+// were busy, summed over GPUs. Each is summed over threads; Step is -1 for
+// the whole trace. Overhead is the share of pure Python in the time spent
+// in pure Python or operators: high values mean the interpreter, not the
+// operators, limits how fast work reaches the GPU.
+type PythonOverheadStats struct {
+	Step         int     `json:"step"`
+	StepNs       int64   `json:"step_ns"`
+	PythonNs     int64   `json:"python_ns"`
+	PurePythonNs int64   `json:"pure_python_ns"`
+	OperatorNs   int64   `json:"operator_ns"`
+	GPUNs        int64   `json:"gpu_ns"`
+	Overhead     float64 `json:"overhead"`
+}
+
+// pythonOverhead returns the Python overhead of the whole trace and of
+// each step, or nil if the trace has no python_function events
+func (a *traceAnalyzer) pythonOverhead() []PythonOverheadStats {
+	if len(a.pythonFrames) == 0 {
+		return nil
+	}
+	operators := a.cpuOps.covered
+	type thread struct{ python, pure, operators intervals }
+	var threads []thread
+	for key := range operators {
+		if _, ok := a.pythonFrames[key]; !ok {
+			threads = append(threads, thread{operators: operators[key].merge()})
+		}
+	}
+	for key, frames := range a.pythonFrames {
+		t := thread{python: frames.merge(), operators: operators[key].merge()}
+		t.pure = t.python.subtract(t.operators)
+		threads = append(threads, t)
+	}
+	gpus := make([]intervals, 0, len(a.deviceOrder))
+	for _, device := range a.deviceOrder {
+		gpus = append(gpus, a.devices[device].intervals.merge())
+	}
+
+	add := func(result []PythonOverheadStats, step int, window TimeWindow, spanUs float64) []PythonOverheadStats {
+		stats := PythonOverheadStats{Step: step, StepNs: usToNs(spanUs)}
+		for _, t := range threads {
+			stats.PythonNs += usToNs(t.python.clip(window).total())
+			stats.PurePythonNs += usToNs(t.pure.clip(window).total())
+			stats.OperatorNs += usToNs(t.operators.clip(window).total())
+		}
+		for _, gpu := range gpus {
+			stats.GPUNs += usToNs(gpu.clip(window).total())
+		}
+		if cpu := stats.PurePythonNs + stats.OperatorNs; cpu > 0 {
+			stats.Overhead = float64(stats.PurePythonNs) / float64(cpu)
+		}
+		return append(result, stats)
+	}
+	result := add(nil, -1, TimeWindow{}, a.end-a.start)
+	for _, step := range slices.Sorted(maps.Keys(a.steps)) {
+		w := a.steps[step]
+		result = add(result, step, w, w.End-w.Start)
+	}
+	return result
+}
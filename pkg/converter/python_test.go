@@ -0,0 +1,39 @@
+package converter
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeTracePythonOverhead(t *testing.T) {
+	events := []TraceEvent{
+		threadSpan("ProfilerStep#1", "user_annotation", 1, 0, 100),
+		threadSpan("ProfilerStep#2", "user_annotation", 1, 100, 100),
+		threadSpan("train.py(10): step", "python_function", 1, 0, 200),
+		threadSpan("torch/nn/modules/module.py(1): forward", "python_function", 1, 10, 80),
+		threadSpan("aten::mm", "cpu_op", 1, 20, 30),
+		threadSpan("aten::add", "cpu_op", 1, 40, 20), // Nested in aten::mm's span once merged
+		threadSpan("aten::relu", "cpu_op", 1, 150, 20),
+		threadSpan("autograd::engine::evaluate_function", "cpu_op", 2, 120, 40), // Thread without Python
+		gpuKernel("volta_sgemm", 50, 100),
+	}
+	analysis := AnalyzeTrace(&TraceData{TraceEvents: events})
+
+	want := []PythonOverheadStats{
+		{Step: -1, StepNs: 200000, PythonNs: 200000, PurePythonNs: 140000, OperatorNs: 100000, GPUNs: 100000, Overhead: 140.0 / 240},
+		{Step: 1, StepNs: 100000, PythonNs: 100000, PurePythonNs: 60000, OperatorNs: 40000, GPUNs: 50000, Overhead: 0.6},
+		{Step: 2, StepNs: 100000, PythonNs: 100000, PurePythonNs: 80000, OperatorNs: 60000, GPUNs: 50000, Overhead: 80.0 / 140},
+	}
+	if !reflect.DeepEqual(analysis.PythonOverhead, want) {
+		t.Errorf("Expected Python overhead %+v, got %+v", want, analysis.PythonOverhead)
+	}
+
+	if a := AnalyzeTrace(&TraceData{TraceEvents: events[4:]}); a.PythonOverhead == nil || len(a.PythonOverhead) != 0 {
+		t.Errorf("Expected empty Python overhead without python_function events, got %+v", a.PythonOverhead)
+	}
+	a, err := AnalyzeTraceReports(context.Background(), &TraceData{TraceEvents: events}, GroupByName, ReportStepTrend)
+	if err != nil || a.PythonOverhead != nil || len(a.StepCategories) != 2 || a.StepCategories[0].PythonNs != 100000 {
+		t.Errorf("Expected step categories without Python overhead, got %+v, %+v, %v", a.StepCategories, a.PythonOverhead, err)
+	}
+}
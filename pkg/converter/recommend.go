@@ -0,0 +1,125 @@
+package converter
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Recommendation is a suggestion derived from a TraceAnalysis by a rule of
+// thumb. Evidence holds the numbers that triggered it and ImpactNs the
+// time at stake, such as exposed communication or time in cudaMalloc,
+// which recommendations are sorted by. It bounds what the suggestion can
+// save rather than predicting it.
+type Recommendation struct {
+	Rule       string `json:"rule"`
+	Suggestion string `json:"suggestion"`
+	Evidence   string `json:"evidence"`
+	ImpactNs   int64  `json:"impact_ns"`
+}
+
+// Thresholds of the recommendation rules
+const (
+	// A trace is launch-bound when its GPUs idle for at least
+	// minLaunchIdleFraction of it between kernels shorter on average than
+	// maxKernelPerLaunch launch calls, which the CPU cannot launch as fast
+	// as they run. Launches must not be queued: a median launch delay of
+	// maxLaunchQueueNs or more means the GPU, not the CPU, is behind.
+	minLaunchIdleFraction = 0.2
+	maxKernelPerLaunch    = 2
+	maxLaunchQueueNs      = 50_000
+
+	// tinyGEMMNs is the mean duration below which a matrix kernel is tiny,
+	// and minTinyGEMMs the launches of tiny GEMMs worth batching
+	tinyGEMMNs   = 10_000
+	minTinyGEMMs = 100
+
+	// maxCommOverlap is the share of communication hidden behind compute
+	// below which bucket sizes are worth tuning, and minExposedFraction the
+	// share of the trace span exposed communication must take
+	maxCommOverlap     = 0.5
+	minExposedFraction = 0.05
+
+	// maxTensorCoreFraction is the share of matrix time on Tensor Cores
+	// below which mixed precision is suggested
+	maxTensorCoreFraction = 0.5
+)
+
+// recommend applies the recommendation rules to analysis, most time at
+// stake first
+func recommend(analysis *TraceAnalysis) []Recommendation {
+	var result []Recommendation
+	add := func(rule string, impactNs int64, suggestion, evidence string, args ...any) {
+		result = append(result, Recommendation{Rule: rule, Suggestion: suggestion, Evidence: fmt.Sprintf(evidence, args...), ImpactNs: impactNs})
+	}
+	us := func(ns int64) float64 { return float64(ns) / 1e3 }
+	ms := func(ns int64) float64 { return float64(ns) / 1e6 }
+
+	var kernels int
+	var kernelNs, idleNs int64
+	for _, d := range analysis.Devices {
+		kernels += d.Kernels
+		kernelNs += d.KernelNs
+		idleNs += d.IdleNs
+	}
+	if l := analysis.LaunchLatency; l != nil && kernels > 0 && analysis.SpanNs > 0 {
+		meanKernelNs, meanCallNs := kernelNs/int64(kernels), l.CallNs/int64(l.Launches)
+		idle := float64(idleNs) / float64(analysis.SpanNs*int64(len(analysis.Devices)))
+		if l.P50Ns < maxLaunchQueueNs && idle >= minLaunchIdleFraction && meanKernelNs < maxKernelPerLaunch*meanCallNs {
+			add("cuda-graphs", idleNs,
+				"GPUs idle between kernels shorter than the CPU time to launch them: capture the steady-state step in CUDA Graphs (torch.cuda.graphs, torch.compile mode=\"reduce-overhead\") to replay it with a single launch",
+				"GPUs idle %.0f%% of the trace, mean kernel %.1f us against %.1f us per launch call, median launch latency %.1f us over %d launches",
+				idle*100, us(meanKernelNs), us(meanCallNs), us(l.P50Ns), l.Launches)
+		}
+	}
+
+	var tiny int
+	var tinyNs int64
+	for _, k := range analysis.Kernels {
+		if _, matrix, _ := kernelPrecision(k.Name); matrix && k.SelfNs < int64(k.Count)*tinyGEMMNs {
+			tiny += k.Count
+			tinyNs += k.SelfNs
+		}
+	}
+	if tiny >= minTinyGEMMs {
+		add("batch-gemms", tinyNs,
+			"Many small matrix kernels leave the GPU underused: batch them (torch.bmm, grouped GEMMs, larger batch sizes) or fuse them with torch.compile",
+			"%d matrix kernels under %.0f us on average, %.3f ms in total", tiny, us(tinyGEMMNs), ms(tinyNs))
+	}
+
+	for _, c := range analysis.CommOverlap {
+		if c.Step == -1 && c.Overlap < maxCommOverlap && c.ExposedNs > int64(minExposedFraction*float64(analysis.SpanNs)) {
+			add("comm-overlap", c.ExposedNs,
+				"Collective communication mostly waits for compute to finish: tune DDP bucket_cap_mb or FSDP prefetching so gradients are reduced while backward runs",
+				"GPU %s: %.0f%% of %.3f ms of communication overlapped, %.3f ms exposed", c.Device, c.Overlap*100, ms(c.CommNs), ms(c.ExposedNs))
+		}
+	}
+
+	if c := analysis.Allocator; c != nil && c.Thrashing {
+		add("allocator", c.TimeNs,
+			"The caching allocator keeps reserving and releasing device memory: set PYTORCH_CUDA_ALLOC_CONF=expandable_segments:True, avoid torch.cuda.empty_cache() in the loop and keep tensor shapes stable",
+			"%d cudaMalloc/cudaFree/cudaMemGetInfo calls taking %.3f ms, %.1f per step", c.Count, ms(c.TimeNs), c.CallsPerStep)
+	}
+
+	if len(analysis.DataLoader) > 0 {
+		stalled := 0
+		for _, d := range analysis.DataLoader[1:] {
+			if d.Stalled {
+				stalled++
+			}
+		}
+		if whole := analysis.DataLoader[0]; stalled > 0 || whole.Stalled {
+			add("dataloader", whole.DataNs,
+				"Steps wait for input batches: raise DataLoader num_workers, enable pin_memory and persistent_workers, or move preprocessing off the training process",
+				"%d of %d steps stalled, %.0f%% of the time spent waiting for data", stalled, len(analysis.DataLoader)-1, whole.DataFraction*100)
+		}
+	}
+
+	if p := analysis.Precision; p != nil && p.MatrixNs > 0 && float64(p.TensorCoreNs) < maxTensorCoreFraction*float64(p.MatrixNs) {
+		add("mixed-precision", p.MatrixNs-p.TensorCoreNs,
+			"Matrix math runs mostly off Tensor Cores: enable autocast to bf16 or fp16, or torch.backends.cuda.matmul.allow_tf32 for fp32 models",
+			"%.3f ms of %.3f ms of matrix kernels not on Tensor Cores", ms(p.MatrixNs-p.TensorCoreNs), ms(p.MatrixNs))
+	}
+
+	sort.SliceStable(result, func(i, j int) bool { return result[i].ImpactNs > result[j].ImpactNs })
+	return result
+}
@@ -0,0 +1,75 @@
+package converter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRecommend(t *testing.T) {
+	analysis := &TraceAnalysis{
+		SpanNs:        100_000_000,
+		Steps:         2,
+		Devices:       []DeviceStats{{Device: "0", Kernels: 10, KernelNs: 50_000, IdleNs: 60_000_000}},
+		LaunchLatency: &LaunchLatencyStats{Launches: 10, CallNs: 50_000, TotalNs: 200_000, P50Ns: 20_000},
+		Kernels:       []OperationTime{{Name: "volta_sgemm_32x32_nn", Count: 200, SelfNs: 1_000_000, TotalNs: 1_000_000}},
+		CommOverlap: []CommOverlapStats{
+			{Device: "0", Step: -1, CommNs: 50_000_000, OverlappedNs: 10_000_000, ExposedNs: 40_000_000, Overlap: 0.2},
+			{Device: "0", Step: 1, CommNs: 25_000_000, OverlappedNs: 5_000_000, ExposedNs: 20_000_000, Overlap: 0.2},
+		},
+		Precision: &PrecisionStats{MatrixNs: 10_000_000, TensorCoreNs: 2_000_000},
+		Allocator: &AllocatorStats{Count: 30, TimeNs: 3_000_000, CallsPerStep: 15, Thrashing: true},
+	}
+
+	var rules []string
+	for _, r := range recommend(analysis) {
+		rules = append(rules, r.Rule)
+		if r.Suggestion == "" || r.Evidence == "" {
+			t.Errorf("Expected a suggestion and evidence, got %+v", r)
+		}
+	}
+	// Most time at stake first, once per device for the whole trace
+	want := []string{"cuda-graphs", "comm-overlap", "mixed-precision", "allocator", "batch-gemms"}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("Expected rules %v, got %v", want, rules)
+	}
+
+	if r := recommend(&TraceAnalysis{Steps: 2, Allocator: &AllocatorStats{Count: 19, CallsPerStep: 9.5}}); r != nil {
+		t.Errorf("Expected no recommendations, got %+v", r)
+	}
+}
+
+func TestRecommendCUDAGraphs(t *testing.T) {
+	// trace launches 20 kernels of kernelDur, one every 10us, each issued
+	// by a 5us launch call; start returns when kernel i starts
+	trace := func(kernelDur float64, start func(i int) float64) *TraceData {
+		var events []TraceEvent
+		for i := range 20 {
+			events = append(events,
+				correlated(runtimeCall("cudaLaunchKernel", float64(10*i), 5), float64(i)),
+				correlated(gpuKernel("elementwise_kernel", start(i), kernelDur), float64(i)))
+		}
+		return &TraceData{TraceEvents: events}
+	}
+	hasRule := func(analysis *TraceAnalysis) bool {
+		for _, r := range analysis.Recommendations {
+			if r.Rule == "cuda-graphs" {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Launch-bound: 2us kernels start soon after their launch, the GPU
+	// idles between them
+	if a := AnalyzeTrace(trace(2, func(i int) float64 { return float64(10*i + 6) })); !hasRule(a) {
+		t.Errorf("Expected CUDA Graphs for a launch-bound trace, got %+v", a.Recommendations)
+	}
+	// Deep queue: 100us kernels run back to back long after their launch
+	if a := AnalyzeTrace(trace(100, func(i int) float64 { return float64(100*i + 10) })); hasRule(a) {
+		t.Errorf("Expected no CUDA Graphs for a GPU-bound trace, got %+v", a.Recommendations)
+	}
+	// Short kernels queued behind long ones are not launch-bound either
+	if a := AnalyzeTrace(trace(2, func(i int) float64 { return float64(100*i + 1000) })); hasRule(a) {
+		t.Errorf("Expected no CUDA Graphs with queued launches, got %+v", a.Recommendations)
+	}
+}
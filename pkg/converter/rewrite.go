@@ -0,0 +1,292 @@
+package converter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FrameRewriter renames, merges or drops the frames of each stack, root
+// first, before the stack is filtered and aggregated. Threads are converted
+// in parallel, so it must be safe for concurrent use. It may reuse the
+// frames slice.
+type FrameRewriter interface {
+	RewriteFrames(frames []Frame) []Frame
+}
+
+// FrameRewriterFunc adapts a function to the FrameRewriter interface
+type FrameRewriterFunc func(frames []Frame) []Frame
+
+// RewriteFrames calls f(frames)
+func (f FrameRewriterFunc) RewriteFrames(frames []Frame) []Frame {
+	return f(frames)
+}
+
+// RewriteRule rewrites the frames whose name matches Match
+type RewriteRule struct {
+	Match *regexp.Regexp
+
+	// Rename replaces the matched text, with $1-style references to
+	// submatches. Names are kept when it is empty.
+	Rename string
+
+	// Drop removes the frame; the frames nested in it take its place
+	Drop bool
+
+	// Merge folds runs of nested frames matched by this rule into the
+	// outermost one
+	Merge bool
+}
+
+// Rewriter is a FrameRewriter applying the first rule matching each frame.
+// Rewrites are cached by frame name.
+type Rewriter struct {
+	rules []RewriteRule
+	cache sync.Map // frame name -> frameRewrite
+}
+
+// frameRewrite is the cached outcome of the rules for a frame name
+type frameRewrite struct {
+	rule int // index of the matching rule, -1 if none
+	name string
+}
+
+// NewRewriter returns a Rewriter for rules
+func NewRewriter(rules []RewriteRule) *Rewriter {
+	return &Rewriter{rules: rules}
+}
+
+// RewriteFrames applies the rules to a root-first stack
+func (r *Rewriter) RewriteFrames(frames []Frame) []Frame {
+	out := frames[:0]
+	merging := -1 // merge rule of the last kept frame
+	for _, f := range frames {
+		rw := r.rewrite(f.Name)
+		if rw.rule < 0 {
+			out = append(out, f)
+			merging = -1
+			continue
+		}
+		rule := r.rules[rw.rule]
+		if rule.Drop || (rule.Merge && merging == rw.rule) {
+			continue
+		}
+		f.Name = rw.name
+		out = append(out, f)
+		merging = -1
+		if rule.Merge {
+			merging = rw.rule
+		}
+	}
+	return out
+}
+
+// rewrite finds the first rule matching name
+func (r *Rewriter) rewrite(name string) frameRewrite {
+	if v, ok := r.cache.Load(name); ok {
+		return v.(frameRewrite)
+	}
+	rw := frameRewrite{rule: -1, name: name}
+	for i, rule := range r.rules {
+		if !rule.Match.MatchString(name) {
+			continue
+		}
+		rw.rule = i
+		if rule.Rename != "" {
+			rw.name = rule.Match.ReplaceAllString(name, rule.Rename)
+		}
+		break
+	}
+	r.cache.Store(name, rw)
+	return rw
+}
+
+// rewriteFrames applies opts.Rewrite to a stack
+func (opts ConvertOptions) rewriteFrames(frames []frame) []frame {
+	public := make([]Frame, len(frames))
+	for i, f := range frames {
+		public[i] = Frame{Name: f.name, Category: f.cat}
+	}
+	public = opts.Rewrite.RewriteFrames(public)
+	rewritten := make([]frame, len(public))
+	for i, f := range public {
+		rewritten[i] = frame{name: f.Name, cat: f.Category}
+	}
+	return rewritten
+}
+
+// LoadRewriteRules reads rewrite rules from a file, see ParseRewriteRules
+func LoadRewriteRules(path string) (*Rewriter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rw, err := ParseRewriteRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rw, nil
+}
+
+// ParseRewriteRules parses rewrite rules written as a YAML list of
+// mappings with the keys match (a regexp), rename, drop and merge:
+//
+//	# Fold all autograd nodes into one frame
+//	- match: '^autograd::engine::evaluate_function: .*'
+//	  rename: autograd::engine::evaluate_function
+//	  merge: true
+//	- match: ^cudaStreamSynchronize$
+//	  drop: true
+//
+// Only this subset of YAML is supported:
+//   - a list of mappings, each item starting with "- " and its other keys
+//     indented, with one key: value per line
+//   - plain values, which run to the end of the line or to a " #" comment
+//   - single-quoted values, where two quotes in a row stand for one
+//   - double-quoted values, with the escape sequences of Go string
+//     literals (\", \\, \n, \t, \x41, \u00e9...)
+//   - comments on their own line or after a value, outside its quotes
+//
+// Multi-line values, flow collections, anchors and tags are not.
+func ParseRewriteRules(r io.Reader) (*Rewriter, error) {
+	var rules []RewriteRule
+	var current *RewriteRule
+	finish := func(line int) error {
+		if current == nil {
+			return nil
+		}
+		if current.Match == nil {
+			return fmt.Errorf("line %d: rule without match", line)
+		}
+		if current.Drop && (current.Rename != "" || current.Merge) {
+			return fmt.Errorf("line %d: drop cannot be combined with rename or merge", line)
+		}
+		rules = append(rules, *current)
+		current = nil
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	lineNum, start := 0, 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimRight(scanner.Text(), " \t")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if item, ok := strings.CutPrefix(trimmed, "-"); ok && (item == "" || item[0] == ' ') {
+			if err := finish(start); err != nil {
+				return nil, err
+			}
+			current, start = &RewriteRule{}, lineNum
+			trimmed = strings.TrimSpace(item)
+			if trimmed == "" {
+				continue
+			}
+		} else if current == nil || line[0] != ' ' && line[0] != '\t' {
+			return nil, fmt.Errorf("line %d: expected a list item starting with '- '", lineNum)
+		}
+
+		key, raw, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", lineNum)
+		}
+		value, err := parseScalar(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		switch key = strings.TrimSpace(key); key {
+		case "match":
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid match: %w", lineNum, err)
+			}
+			current.Match = re
+		case "rename":
+			current.Rename = value
+		case "drop", "merge":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s must be true or false, got %q", lineNum, key, value)
+			}
+			if key == "drop" {
+				current.Drop = b
+			} else {
+				current.Merge = b
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unknown key %q (want match, rename, drop or merge)", lineNum, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := finish(start); err != nil {
+		return nil, err
+	}
+	return NewRewriter(rules), nil
+}
+
+// parseScalar unquotes a YAML scalar and strips the comment following it.
+// Quoted scalars end at their first closing quote, so quotes in the comment
+// are not mistaken for it.
+func parseScalar(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	switch s[0] {
+	case '"':
+		end := 1
+		for ; end < len(s) && s[end] != '"'; end++ {
+			if s[end] == '\\' {
+				end++ // Skip the escaped character
+			}
+		}
+		if end >= len(s) {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		if !isComment(s[end+1:]) {
+			return "", fmt.Errorf("unexpected %q after string %s", strings.TrimSpace(s[end+1:]), s[:end+1])
+		}
+		return strconv.Unquote(s[:end+1])
+	case '\'':
+		end := 1
+		for ; end < len(s); end++ {
+			if s[end] != '\'' {
+				continue
+			}
+			if end+1 < len(s) && s[end+1] == '\'' {
+				end++ // '' stands for a quote
+				continue
+			}
+			break
+		}
+		if end >= len(s) {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		if !isComment(s[end+1:]) {
+			return "", fmt.Errorf("unexpected %q after string %s", strings.TrimSpace(s[end+1:]), s[:end+1])
+		}
+		return strings.ReplaceAll(s[1:end], "''", "'"), nil
+	}
+	// Plain scalars end at a # starting the value or following a blank
+	for i := range len(s) {
+		if s[i] == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t') {
+			return strings.TrimSpace(s[:i]), nil
+		}
+	}
+	return s, nil
+}
+
+// isComment reports whether s is blank or a trailing comment
+func isComment(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "" || strings.HasPrefix(s, "#")
+}
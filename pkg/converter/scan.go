@@ -0,0 +1,891 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/olka/torch2pprof/pkg/profile"
+)
+
+// scanBufferSize is the initial size of the scanner's input buffer. It
+// grows to hold the largest single value captured raw, such as a big
+// top-level metadata field.
+const scanBufferSize = 256 << 10
+
+// maxInternLength and maxInterned bound the strings the scanner interns:
+// phases, categories, names and argument keys repeat across millions of
+// events, unlike long strings such as Python stack frames.
+const (
+	maxInternLength = 128
+	maxInterned     = 1 << 16
+)
+
+// errStopScan stops scanning when the event callback returns false
+var errStopScan = errors.New("stop scanning")
+
+// scanner is a JSON parser specialized for trace files. It decodes events
+// straight into TraceEvent fields without reflection, converts the usual
+// short decimal numbers without strconv and interns repeated strings, which
+// makes it several times faster than encoding/json on large traces. It
+// accepts the same input as encoding/json and produces the same values.
+type scanner struct {
+	r    io.Reader
+	buf  []byte
+	pos  int   // next unread byte of buf
+	base int64 // input offset of buf[0]
+	err  error // read error, reported once buf is consumed
+
+	// tok is the start of the string or number being read and mark the
+	// start of a value captured raw; fill keeps buf from there on. They
+	// are -1 when unused.
+	tok, mark int
+
+	key     []byte // key of the object field being read
+	scratch []byte // unescaped string contents
+	strings map[string]string
+
+	// names interns event names, shared by the scanners of the chunks of
+	// a trace
+	names *profile.Builder
+
+	// pid and tid are the IDs of the previous event
+	pid, tid interface{}
+
+	// keepArgs, if not nil, holds the event args to keep, others are
+	// skipped
+	keepArgs map[string]bool
+}
+
+func newScanner(r io.Reader) *scanner {
+	return &scanner{
+		r:       r,
+		buf:     make([]byte, 0, scanBufferSize),
+		tok:     -1,
+		mark:    -1,
+		strings: make(map[string]string),
+		names:   profile.NewBuilder(),
+	}
+}
+
+// newBytesScanner returns a scanner reading data in place, which is never
+// written to
+func newBytesScanner(data []byte) *scanner {
+	return &scanner{
+		buf:     data,
+		err:     io.EOF,
+		tok:     -1,
+		mark:    -1,
+		strings: make(map[string]string),
+		names:   profile.NewBuilder(),
+	}
+}
+
+// offset returns the input offset of the next unread byte
+func (s *scanner) offset() int64 {
+	return s.base + int64(s.pos)
+}
+
+// fill reads more input into buf, dropping the bytes before tok, mark or a
+// snippet's worth before pos. It returns false at the end of the input.
+func (s *scanner) fill() bool {
+	for s.err == nil {
+		keep := s.pos - snippetContext
+		if s.tok >= 0 {
+			keep = min(keep, s.tok)
+		}
+		if s.mark >= 0 {
+			keep = min(keep, s.mark)
+		}
+		if keep > 0 {
+			n := copy(s.buf, s.buf[keep:])
+			s.buf = s.buf[:n]
+			s.base += int64(keep)
+			s.pos -= keep
+			if s.tok >= 0 {
+				s.tok -= keep
+			}
+			if s.mark >= 0 {
+				s.mark -= keep
+			}
+		}
+		if len(s.buf) == cap(s.buf) {
+			s.buf = append(s.buf, make([]byte, cap(s.buf))...)[:len(s.buf)]
+		}
+		n, err := s.r.Read(s.buf[len(s.buf):cap(s.buf)])
+		s.buf = s.buf[:len(s.buf)+n]
+		s.err = err
+		if n > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// eof returns the error for input ending before the trace does: the read
+// error, or a ParseError if the input is just truncated
+func (s *scanner) eof() error {
+	if s.err == nil || errors.Is(s.err, io.EOF) || errors.Is(s.err, io.ErrUnexpectedEOF) {
+		return &ParseError{Offset: s.offset(), Err: errors.New("unexpected end of input, the trace may be truncated")}
+	}
+	return s.err
+}
+
+// snippet returns the buffered input around offset
+func (s *scanner) snippet(offset int64) string {
+	i := int(offset - s.base)
+	if i < 0 || i > len(s.buf) {
+		return ""
+	}
+	return string(s.buf[max(i-snippetContext, 0):min(i+snippetContext, len(s.buf))])
+}
+
+// syntaxError reports the unexpected byte c at the current offset, worded
+// like encoding/json
+func (s *scanner) syntaxError(c byte, context string) error {
+	return s.syntaxErrorAt(s.offset(), c, context)
+}
+
+func (s *scanner) syntaxErrorAt(offset int64, c byte, context string) error {
+	var quoted string
+	switch c {
+	case '\'':
+		quoted = `'\''`
+	case '"':
+		quoted = `'"'`
+	default:
+		q := strconv.Quote(string(rune(c)))
+		quoted = "'" + q[1:len(q)-1] + "'"
+	}
+	return &ParseError{Offset: offset, Err: fmt.Errorf("invalid character %s %s", quoted, context)}
+}
+
+// typeError reports a value of the wrong JSON kind for a Go field
+func (s *scanner) typeError(c byte, field, goType string) error {
+	kind := "number"
+	switch c {
+	case '"':
+		kind = "string"
+	case '{':
+		kind = "object"
+	case '[':
+		kind = "array"
+	case 't', 'f':
+		kind = "bool"
+	}
+	return &ParseError{Offset: s.offset(), Err: fmt.Errorf("cannot unmarshal %s into Go %s of type %s", kind, field, goType)}
+}
+
+// nonSpace skips whitespace and returns the next byte without reading it
+func (s *scanner) nonSpace() (byte, error) {
+	for {
+		buf := s.buf
+		for i := s.pos; i < len(buf); i++ {
+			if c := buf[i]; c > ' ' || (c != ' ' && c != '\n' && c != '\t' && c != '\r') {
+				s.pos = i
+				return c, nil
+			}
+		}
+		s.pos = len(buf)
+		if !s.fill() {
+			return 0, s.eof()
+		}
+	}
+}
+
+// intern returns b as a string, shared with earlier equal short strings
+func (s *scanner) intern(b []byte) string {
+	if len(b) > maxInternLength {
+		return string(b)
+	}
+	if v, ok := s.strings[string(b)]; ok {
+		return v
+	}
+	v := string(b)
+	if len(s.strings) < maxInterned {
+		s.strings[v] = v
+	}
+	return v
+}
+
+// internName returns b as a string shared with every equal event name of
+// the trace, whatever its length: a kernel name of hundreds of bytes can
+// repeat on millions of events. Names are looked up in the strings of the
+// scanner first, without locking, then in the string table it shares with
+// the scanners of other chunks.
+func (s *scanner) internName(b []byte) string {
+	if v, ok := s.strings[string(b)]; ok {
+		return v
+	}
+	v := s.names.String(s.names.AddBytes(b))
+	if len(s.strings) < maxInterned {
+		s.strings[v] = v
+	}
+	return v
+}
+
+// stringSpecial marks the bytes that end the fast path of readString:
+// quotes, backslashes, control characters and non-ASCII bytes
+var stringSpecial = func() (t [256]bool) {
+	for c := range t {
+		t[c] = c == '"' || c == '\\' || c < ' ' || c >= utf8.RuneSelf
+	}
+	return t
+}()
+
+// readString reads a string starting at the opening quote. The result is
+// only valid until the next read.
+func (s *scanner) readString() ([]byte, error) {
+	s.tok = s.pos
+	i := s.pos + 1
+	escaped, nonASCII := false, false
+	for {
+		for i < len(s.buf) && !stringSpecial[s.buf[i]] {
+			i++
+		}
+		if i >= len(s.buf) {
+			s.pos = i
+			if !s.fill() {
+				s.tok = -1
+				return nil, s.eof()
+			}
+			i = s.pos
+			continue
+		}
+		switch c := s.buf[i]; {
+		case c == '"':
+			start := s.base + int64(s.tok) + 1
+			raw := s.buf[s.tok+1 : i]
+			s.pos, s.tok = i+1, -1
+			if !escaped && (!nonASCII || utf8.Valid(raw)) {
+				return raw, nil
+			}
+			return s.unquote(raw, start)
+		case c == '\\':
+			// Skip the escaped character, which unquote checks
+			escaped = true
+			i++
+			if i >= len(s.buf) {
+				s.pos = i
+				if !s.fill() {
+					s.tok = -1
+					return nil, s.eof()
+				}
+				i = s.pos
+			}
+		case c < ' ':
+			s.pos, s.tok = i, -1
+			return nil, s.syntaxError(c, "in string literal")
+		default:
+			nonASCII = true
+		}
+		i++
+	}
+}
+
+// unquote decodes the escapes of raw string contents starting at offset
+// and replaces invalid UTF-8 with U+FFFD, as encoding/json does
+func (s *scanner) unquote(raw []byte, offset int64) ([]byte, error) {
+	b := s.scratch[:0]
+	for i := 0; i < len(raw); {
+		c := raw[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRune(raw[i:])
+			b = utf8.AppendRune(b, r)
+			i += size
+			continue
+		}
+		if c != '\\' {
+			b = append(b, c)
+			i++
+			continue
+		}
+		// A backslash is always followed by a character, or the closing
+		// quote would have been escaped
+		switch e := raw[i+1]; e {
+		case '"', '\\', '/':
+			b = append(b, e)
+		case 'b':
+			b = append(b, '\b')
+		case 'f':
+			b = append(b, '\f')
+		case 'n':
+			b = append(b, '\n')
+		case 'r':
+			b = append(b, '\r')
+		case 't':
+			b = append(b, '\t')
+		case 'u':
+			r, err := s.unicodeEscape(raw, i, offset)
+			if err != nil {
+				return nil, err
+			}
+			if utf16.IsSurrogate(r) {
+				if r2, err := s.unicodeEscape(raw, i+6, offset); err == nil {
+					if dec := utf16.DecodeRune(r, r2); dec != utf8.RuneError {
+						b = utf8.AppendRune(b, dec)
+						i += 12
+						continue
+					}
+				}
+				r = utf8.RuneError
+			}
+			b = utf8.AppendRune(b, r)
+			i += 6
+			continue
+		default:
+			return nil, s.syntaxErrorAt(offset+int64(i)+1, e, "in string escape code")
+		}
+		i += 2
+	}
+	s.scratch = b
+	return b, nil
+}
+
+// unicodeEscape decodes the \uXXXX escape at raw[i:]
+func (s *scanner) unicodeEscape(raw []byte, i int, offset int64) (rune, error) {
+	if i+1 >= len(raw) || raw[i] != '\\' || raw[i+1] != 'u' {
+		return 0, errors.New("not a unicode escape")
+	}
+	var r rune
+	for j := i + 2; j < i+6; j++ {
+		if j >= len(raw) {
+			return 0, s.syntaxErrorAt(offset+int64(j), '"', "in \\u hexadecimal character escape")
+		}
+		c := raw[j]
+		switch {
+		case '0' <= c && c <= '9':
+			c -= '0'
+		case 'a' <= c && c <= 'f':
+			c = c - 'a' + 10
+		case 'A' <= c && c <= 'F':
+			c = c - 'A' + 10
+		default:
+			return 0, s.syntaxErrorAt(offset+int64(j), c, "in \\u hexadecimal character escape")
+		}
+		r = r*16 + rune(c)
+	}
+	return r, nil
+}
+
+// smallInts holds boxed small integers, common argument values, so that
+// decoding them does not allocate
+var smallInts = func() (t [256]interface{}) {
+	for i := range t {
+		t[i] = float64(i)
+	}
+	return t
+}()
+
+// float64pow10 holds the powers of ten exactly representable as float64
+var float64pow10 = [...]float64{
+	1e0, 1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9, 1e10,
+	1e11, 1e12, 1e13, 1e14, 1e15, 1e16, 1e17, 1e18, 1e19, 1e20, 1e21, 1e22,
+}
+
+// readNumber reads a number into a float64, the TraceEvent field named
+// field or, if field is empty, an interface{} value
+func (s *scanner) readNumber(field string) (float64, error) {
+	s.tok = s.pos
+	i := s.pos
+	for {
+		if i >= len(s.buf) {
+			s.pos = i
+			if !s.fill() {
+				break
+			}
+			i = s.pos
+			continue
+		}
+		if c := s.buf[i]; ('0' <= c && c <= '9') || c == '-' || c == '+' || c == '.' || c == 'e' || c == 'E' {
+			i++
+			continue
+		}
+		break
+	}
+	start := s.base + int64(s.tok)
+	b := s.buf[s.tok:i]
+	s.pos, s.tok = i, -1
+
+	// Validate the JSON number grammar while accumulating the digits
+	var mantissa uint64
+	digits, decimals, j := 0, 0, 0
+	neg, exp := false, false
+	bad := func(j int) error {
+		if j < len(b) {
+			return s.syntaxErrorAt(start+int64(j), b[j], "in numeric literal")
+		}
+		if s.pos < len(s.buf) {
+			return s.syntaxError(s.buf[s.pos], "in numeric literal")
+		}
+		return s.eof()
+	}
+	isDigit := func(j int) bool { return j < len(b) && '0' <= b[j] && b[j] <= '9' }
+	if j < len(b) && b[j] == '-' {
+		neg = true
+		j++
+	}
+	if !isDigit(j) {
+		return 0, bad(j)
+	}
+	if b[j] == '0' {
+		j++
+	} else {
+		for ; isDigit(j); j++ {
+			mantissa = mantissa*10 + uint64(b[j]-'0')
+			digits++
+		}
+	}
+	if j < len(b) && b[j] == '.' {
+		j++
+		if !isDigit(j) {
+			return 0, bad(j)
+		}
+		for ; isDigit(j); j++ {
+			mantissa = mantissa*10 + uint64(b[j]-'0')
+			digits++
+			decimals++
+		}
+	}
+	if j < len(b) && (b[j] == 'e' || b[j] == 'E') {
+		exp = true
+		j++
+		if j < len(b) && (b[j] == '+' || b[j] == '-') {
+			j++
+		}
+		if !isDigit(j) {
+			return 0, bad(j)
+		}
+		for isDigit(j) {
+			j++
+		}
+	}
+	if j < len(b) {
+		return 0, bad(j)
+	}
+
+	// An exact mantissa divided by an exact power of ten is correctly
+	// rounded, as strconv.ParseFloat is
+	if !exp && digits <= 19 && mantissa < 1<<53 && decimals < len(float64pow10) {
+		f := float64(mantissa) / float64pow10[decimals]
+		if neg {
+			f = -f
+		}
+		return f, nil
+	}
+	f, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		dest := "value"
+		if field != "" {
+			dest = "struct field TraceEvent." + field
+		}
+		return 0, &ParseError{Offset: start, Err: fmt.Errorf("cannot unmarshal number %s into Go %s of type float64", b, dest)}
+	}
+	return f, nil
+}
+
+// readLiteral reads true, false or null
+func (s *scanner) readLiteral(lit string) error {
+	for i := 0; i < len(lit); i++ {
+		if s.pos >= len(s.buf) && !s.fill() {
+			return s.eof()
+		}
+		if c := s.buf[s.pos]; c != lit[i] {
+			return s.syntaxError(c, fmt.Sprintf("in literal %s (expecting %q)", lit, lit[i]))
+		}
+		s.pos++
+	}
+	return nil
+}
+
+// readObject reads an object, calling field with each key and the scanner
+// at its value. The key is only valid until field reads the value.
+func (s *scanner) readObject(field func(key []byte) error) error {
+	s.pos++ // {
+	c, err := s.nonSpace()
+	if err != nil {
+		return err
+	}
+	if c == '}' {
+		s.pos++
+		return nil
+	}
+	for {
+		if c != '"' {
+			return s.syntaxError(c, "looking for beginning of object key string")
+		}
+		key, err := s.readString()
+		if err != nil {
+			return err
+		}
+		s.key = append(s.key[:0], key...)
+		if c, err = s.nonSpace(); err != nil {
+			return err
+		}
+		if c != ':' {
+			return s.syntaxError(c, "after object key")
+		}
+		s.pos++
+		if err := field(s.key); err != nil {
+			return err
+		}
+		if c, err = s.nonSpace(); err != nil {
+			return err
+		}
+		switch c {
+		case ',':
+			s.pos++
+			if c, err = s.nonSpace(); err != nil {
+				return err
+			}
+		case '}':
+			s.pos++
+			return nil
+		default:
+			return s.syntaxError(c, "after object key:value pair")
+		}
+	}
+}
+
+// readArray reads an array, calling elem with the scanner at each element
+func (s *scanner) readArray(elem func() error) error {
+	s.pos++ // [
+	c, err := s.nonSpace()
+	if err != nil {
+		return err
+	}
+	if c == ']' {
+		s.pos++
+		return nil
+	}
+	for {
+		if err := elem(); err != nil {
+			return err
+		}
+		if c, err = s.nonSpace(); err != nil {
+			return err
+		}
+		switch c {
+		case ',':
+			s.pos++
+		case ']':
+			s.pos++
+			return nil
+		default:
+			return s.syntaxError(c, "after array element")
+		}
+	}
+}
+
+// readValue reads any value as encoding/json decodes it into an interface{}
+func (s *scanner) readValue() (interface{}, error) {
+	c, err := s.nonSpace()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c == '"':
+		b, err := s.readString()
+		if err != nil {
+			return nil, err
+		}
+		return s.intern(b), nil
+	case c == '-' || ('0' <= c && c <= '9'):
+		f, err := s.readNumber("")
+		if i := int(f); float64(i) == f && 0 <= i && i < len(smallInts) && !math.Signbit(f) {
+			return smallInts[i], err
+		}
+		return f, err
+	case c == '{':
+		m := make(map[string]interface{})
+		err := s.readObject(func(key []byte) error {
+			k := s.intern(key)
+			v, err := s.readValue()
+			m[k] = v
+			return err
+		})
+		return m, err
+	case c == '[':
+		a := []interface{}{}
+		err := s.readArray(func() error {
+			v, err := s.readValue()
+			a = append(a, v)
+			return err
+		})
+		return a, err
+	case c == 't':
+		return true, s.readLiteral("true")
+	case c == 'f':
+		return false, s.readLiteral("false")
+	case c == 'n':
+		return nil, s.readLiteral("null")
+	}
+	return nil, s.syntaxError(c, "looking for beginning of value")
+}
+
+// skipValue reads and validates a value without keeping it
+func (s *scanner) skipValue() error {
+	c, err := s.nonSpace()
+	if err != nil {
+		return err
+	}
+	switch {
+	case c == '"':
+		_, err := s.readString()
+		return err
+	case c == '-' || ('0' <= c && c <= '9'):
+		_, err := s.readNumber("")
+		return err
+	case c == '{':
+		return s.readObject(func([]byte) error { return s.skipValue() })
+	case c == '[':
+		return s.readArray(s.skipValue)
+	case c == 't':
+		return s.readLiteral("true")
+	case c == 'f':
+		return s.readLiteral("false")
+	case c == 'n':
+		return s.readLiteral("null")
+	}
+	return s.syntaxError(c, "looking for beginning of value")
+}
+
+// readRaw reads a value and returns a copy of its JSON
+func (s *scanner) readRaw() (json.RawMessage, error) {
+	if _, err := s.nonSpace(); err != nil {
+		return nil, err
+	}
+	s.mark = s.pos
+	defer func() { s.mark = -1 }()
+	if err := s.skipValue(); err != nil {
+		return nil, err
+	}
+	return bytes.Clone(s.buf[s.mark:s.pos]), nil
+}
+
+// eventFields are the JSON names of the TraceEvent fields
+var eventFields = [...]string{"ph", "cat", "name", "pid", "tid", "ts", "dur", "tts", "tdur", "args"}
+
+// readEvent reads an event object into e
+func (s *scanner) readEvent(e *TraceEvent) error {
+	return s.readObject(func(key []byte) error {
+		return s.readEventField(e, key)
+	})
+}
+
+// readEventField reads the value of the field named key into e. Keys
+// matching no field exactly are matched case insensitively, as
+// encoding/json does, and skipped if no field matches.
+func (s *scanner) readEventField(e *TraceEvent, key []byte) error {
+	var err error
+	switch string(key) {
+	case "ph":
+		err = s.readStringField(&e.Ph, "ph")
+	case "cat":
+		err = s.readStringField(&e.Cat, "cat")
+	case "name":
+		err = s.readStringField(&e.Name, "name")
+	case "pid":
+		e.Pid, err = s.readID(&s.pid)
+	case "tid":
+		e.Tid, err = s.readID(&s.tid)
+	case "ts":
+		err = s.readFloatField(&e.Ts, "ts")
+	case "dur":
+		err = s.readFloatField(&e.Dur, "dur")
+	case "tts":
+		err = s.readFloatField(&e.Tts, "tts")
+	case "tdur":
+		err = s.readFloatField(&e.Tdur, "tdur")
+	case "args":
+		err = s.readArgs(e)
+	default:
+		for _, f := range eventFields {
+			if bytes.EqualFold(key, []byte(f)) {
+				return s.readEventField(e, []byte(f))
+			}
+		}
+		err = s.skipValue()
+	}
+	return err
+}
+
+// readID reads a pid or tid, reusing prev, the ID of the previous event,
+// when equal: consecutive events mostly run on the same thread, and
+// sharing the value saves an allocation per event
+func (s *scanner) readID(prev *interface{}) (interface{}, error) {
+	c, err := s.nonSpace()
+	if err != nil {
+		return nil, err
+	}
+	if c != '-' && (c < '0' || c > '9') {
+		return s.readValue()
+	}
+	f, err := s.readNumber("")
+	if p, ok := (*prev).(float64); !ok || p != f || math.Signbit(p) != math.Signbit(f) {
+		*prev = f
+	}
+	return *prev, err
+}
+
+// readStringField reads a string field; null leaves it unchanged
+func (s *scanner) readStringField(v *string, field string) error {
+	c, err := s.nonSpace()
+	if err != nil {
+		return err
+	}
+	switch c {
+	case '"':
+		b, err := s.readString()
+		if err != nil {
+			return err
+		}
+		if field == "name" {
+			*v = s.internName(b)
+		} else {
+			*v = s.intern(b)
+		}
+		return nil
+	case 'n':
+		return s.readLiteral("null")
+	}
+	return s.typeError(c, "struct field TraceEvent."+field, "string")
+}
+
+// readFloatField reads a number field; null leaves it unchanged
+func (s *scanner) readFloatField(v *float64, field string) error {
+	c, err := s.nonSpace()
+	if err != nil {
+		return err
+	}
+	switch {
+	case c == '-' || ('0' <= c && c <= '9'):
+		*v, err = s.readNumber(field)
+		return err
+	case c == 'n':
+		return s.readLiteral("null")
+	}
+	return s.typeError(c, "struct field TraceEvent."+field, "float64")
+}
+
+// readArgs reads the args object of e, adding to its args if the event has
+// several, as encoding/json does
+func (s *scanner) readArgs(e *TraceEvent) error {
+	c, err := s.nonSpace()
+	if err != nil {
+		return err
+	}
+	switch c {
+	case '{':
+		if e.Args == nil && s.keepArgs == nil {
+			e.Args = make(map[string]interface{})
+		}
+		return s.readObject(func(key []byte) error {
+			if s.keepArgs != nil {
+				if !s.keepArgs[string(key)] {
+					return s.skipValue()
+				}
+				if e.Args == nil {
+					e.Args = make(map[string]interface{})
+				}
+			}
+			k := s.intern(key)
+			v, err := s.readValue()
+			e.Args[k] = v
+			return err
+		})
+	case 'n':
+		e.Args = nil
+		return s.readLiteral("null")
+	}
+	return s.typeError(c, "struct field TraceEvent.args", "map[string]interface {}")
+}
+
+// scanTrace reads a trace JSON object, calling meta for each top-level
+// field other than traceEvents and events at the opening bracket of each
+// traceEvents array, which it must read up to the closing one. events may
+// return errStopScan to stop without error.
+func (s *scanner) scanTrace(logger *slog.Logger, meta func(key string, raw json.RawMessage), events func() error) error {
+	c, err := s.nonSpace()
+	if err != nil {
+		return err
+	}
+	if c != '{' {
+		return s.syntaxError(c, "looking for beginning of value")
+	}
+	hasEvents := false
+	err = s.readObject(func(key []byte) error {
+		if string(key) != "traceEvents" {
+			k := string(key)
+			raw, err := s.readRaw()
+			if err != nil {
+				return err
+			}
+			meta(k, raw)
+			return nil
+		}
+
+		hasEvents = true
+		c, err := s.nonSpace()
+		if err != nil {
+			return err
+		}
+		if c == 'n' { // No events, as encoding/json reads null
+			return s.readLiteral("null")
+		}
+		if c != '[' {
+			return &ParseError{Offset: s.offset(), Err: fmt.Errorf("expected [, found %q", c)}
+		}
+		return events()
+	})
+	if err == errStopScan {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !hasEvents {
+		return fmt.Errorf("%w: the JSON object has no traceEvents field", ErrNotATrace)
+	}
+	if _, err := s.nonSpace(); err == nil {
+		logger.Warn("Ignoring data after the trace JSON object", "offset", s.offset())
+	}
+	return nil
+}
+
+// readEvents reads a traceEvents array, calling event for each event. It
+// returns errStopScan when event returns false.
+func (s *scanner) readEvents(event func(e TraceEvent) bool) error {
+	return s.readArray(func() error {
+		var e TraceEvent
+		if err := s.readArrayEvent(&e); err != nil {
+			return err
+		}
+		if !event(e) {
+			return errStopScan
+		}
+		return nil
+	})
+}
+
+// readArrayEvent reads an element of a traceEvents array into e
+func (s *scanner) readArrayEvent(e *TraceEvent) error {
+	c, err := s.nonSpace()
+	if err != nil {
+		return err
+	}
+	switch c {
+	case '{':
+		return s.readEvent(e)
+	case 'n':
+		return s.readLiteral("null")
+	}
+	return s.typeError(c, "value", "converter.TraceEvent")
+}
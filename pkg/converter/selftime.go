@@ -0,0 +1,179 @@
+package converter
+
+import (
+	"sort"
+	"time"
+)
+
+// OperationTime holds the self and total time of one operation name
+type OperationTime struct {
+	Name    string `json:"name"`
+	Count   int    `json:"count"`
+	SelfNs  int64  `json:"self_ns"`
+	TotalNs int64  `json:"total_ns"`
+}
+
+// OperationTimes computes the self time of every operation: its duration
+// minus the time covered by events nested directly under it on the same
+// thread. Total time counts recursive invocations once, so a name nested
+// under itself is not double counted. Boundaries closer than epsilon are
+// considered equal, as in ConvertTrace. The result is sorted by self time.
+func OperationTimes(traceData *TraceData, epsilon time.Duration) []OperationTime {
+	threads := make(map[threadID][]TraceEvent)
+	for _, e := range traceData.TraceEvents {
+		if e.Ph != "X" || e.Dur <= 0 {
+			continue
+		}
+		id := threadID{pid: getTid(e.Pid), tid: getTid(e.Tid)}
+		threads[id] = append(threads[id], e)
+	}
+
+	times := newSelfTimes(epsilon)
+	for id, events := range threads {
+		sort.Slice(events, func(i, j int) bool {
+			if events[i].Ts != events[j].Ts {
+				return events[i].Ts < events[j].Ts
+			}
+			return events[i].Dur > events[j].Dur
+		})
+		for _, e := range events {
+			times.add(id, e.Name, e.Ts, e.Dur)
+		}
+	}
+	return times.finish()
+}
+
+// selfTimes accumulates the self and total time of operations from the
+// events of each thread, which must be added in start order, keeping only
+// the events still open on each thread
+type selfTimes struct {
+	eps     float64
+	threads map[threadID]*openOps
+	self    map[string]float64
+	total   map[string]float64
+	counts  map[string]int
+
+	// covered, if not nil, collects the time covered by the events of
+	// each thread, as intervals in time order
+	covered map[threadID]intervals
+}
+
+// openOps is the stack of events open on one thread, outermost first, and
+// how many of them each name has
+type openOps struct {
+	stack   []openOp
+	onStack map[string]int
+}
+
+// openOp is an event whose nested events are still being added
+type openOp struct {
+	name            string
+	start, end, dur float64
+	covered         float64
+}
+
+// newSelfTimes returns an empty accumulator considering boundaries closer
+// than epsilon equal
+func newSelfTimes(epsilon time.Duration) *selfTimes {
+	return &selfTimes{
+		eps:     float64(epsilon.Nanoseconds()) / 1000,
+		threads: make(map[threadID]*openOps),
+		self:    make(map[string]float64),
+		total:   make(map[string]float64),
+		counts:  make(map[string]int),
+	}
+}
+
+// add accounts for an event of thread id starting at ts
+func (t *selfTimes) add(id threadID, name string, ts, dur float64) {
+	ops := t.threads[id]
+	if ops == nil {
+		ops = &openOps{onStack: make(map[string]int)}
+		t.threads[id] = ops
+	}
+	for len(ops.stack) > 0 && ops.stack[len(ops.stack)-1].end <= ts+t.eps {
+		t.pop(ops, true)
+	}
+	t.counts[name]++
+
+	// An event starting with the one before it but ending later holds
+	// it, though listed after it: open it under that one
+	if n := len(ops.stack); n > 0 && ops.stack[n-1].start == ts && ts+dur > ops.stack[n-1].end {
+		child := t.pop(ops, false)
+		t.push(id, ops, openOp{name: name, start: ts, end: ts + dur, dur: dur})
+		t.push(id, ops, child)
+		return
+	}
+	t.push(id, ops, openOp{name: name, start: ts, end: ts + dur, dur: dur})
+}
+
+// push opens op on top of the stack of thread id, covering part of its
+// parent
+func (t *selfTimes) push(id threadID, ops *openOps, op openOp) {
+	if n := len(ops.stack); n > 0 {
+		parent := &ops.stack[n-1]
+		parent.covered += min(op.end, parent.end) - op.start
+	}
+	if t.covered != nil {
+		covered := t.covered[id]
+		if n := len(covered); n > 0 && op.start <= covered[n-1][1] {
+			covered[n-1][1] = max(covered[n-1][1], op.end)
+		} else {
+			t.covered[id] = append(covered, [2]float64{op.start, op.end})
+		}
+	}
+	if ops.onStack[op.name] == 0 {
+		t.total[op.name] += op.dur
+	}
+	ops.onStack[op.name]++
+	ops.stack = append(ops.stack, op)
+}
+
+// pop removes the innermost open event and returns it. Closing it adds
+// its self time; otherwise, it is undone as if it had not been pushed, so
+// it can be pushed again under another event, which covers it anyway.
+func (t *selfTimes) pop(ops *openOps, closing bool) openOp {
+	n := len(ops.stack)
+	top := ops.stack[n-1]
+	ops.stack = ops.stack[:n-1]
+	ops.onStack[top.name]--
+	if closing {
+		t.self[top.name] += max(top.dur-top.covered, 0)
+		return top
+	}
+	if ops.onStack[top.name] == 0 {
+		t.total[top.name] -= top.dur
+	}
+	if n > 1 {
+		parent := &ops.stack[n-2]
+		parent.covered -= min(top.end, parent.end) - top.start
+	}
+	return top
+}
+
+// finish closes the events still open and returns the times of every
+// operation, sorted by self time
+func (t *selfTimes) finish() []OperationTime {
+	for _, ops := range t.threads {
+		for len(ops.stack) > 0 {
+			t.pop(ops, true)
+		}
+	}
+
+	result := make([]OperationTime, 0, len(t.counts))
+	for name, count := range t.counts {
+		result = append(result, OperationTime{
+			Name:    name,
+			Count:   count,
+			SelfNs:  usToNs(t.self[name]),
+			TotalNs: usToNs(t.total[name]),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].SelfNs != result[j].SelfNs {
+			return result[i].SelfNs > result[j].SelfNs
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
@@ -0,0 +1,54 @@
+package converter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeTraceTopKernelsAndOperators(t *testing.T) {
+	analysis := AnalyzeTrace(&TraceData{TraceEvents: []TraceEvent{
+		cpuOp("aten::linear", 0, 100),
+		cpuOp("aten::mm", 10, 60),
+		annotation("ProfilerStep#1", 0, 200),
+		gpuKernel("gemm", 20, 30),
+		gpuKernel("gemm", 60, 30),
+		gpuKernel("fill", 100, 50),
+	}})
+
+	wantKernels := []OperationTime{
+		{Name: "gemm", Count: 2, SelfNs: 60000, TotalNs: 60000},
+		{Name: "fill", Count: 1, SelfNs: 50000, TotalNs: 50000},
+	}
+	if !reflect.DeepEqual(analysis.Kernels, wantKernels) {
+		t.Errorf("Expected kernels %+v, got %+v", wantKernels, analysis.Kernels)
+	}
+	// The annotation is neither a kernel nor a CPU operator
+	wantOps := []OperationTime{
+		{Name: "aten::mm", Count: 1, SelfNs: 60000, TotalNs: 60000},
+		{Name: "aten::linear", Count: 1, SelfNs: 40000, TotalNs: 100000},
+	}
+	if !reflect.DeepEqual(analysis.CPUOperators, wantOps) {
+		t.Errorf("Expected CPU operators %+v, got %+v", wantOps, analysis.CPUOperators)
+	}
+
+	// Self times are computed as events arrive, even when a child is
+	// listed before the parent starting with it
+	events := []TraceEvent{
+		cpuOp("aten::mm", 0, 60),
+		cpuOp("aten::linear", 0, 100),
+		threadSpan("aten::linear", "cpu_op", 2, 5, 10),
+		cpuOp("aten::linear", 70, 20),
+		cpuOp("aten::add", 80, 30),
+	}
+	wantOps = []OperationTime{
+		{Name: "aten::mm", Count: 1, SelfNs: 60000, TotalNs: 60000},
+		{Name: "aten::linear", Count: 3, SelfNs: 40000, TotalNs: 110000},
+		{Name: "aten::add", Count: 1, SelfNs: 30000, TotalNs: 30000},
+	}
+	if ops := AnalyzeTrace(&TraceData{TraceEvents: events}).CPUOperators; !reflect.DeepEqual(ops, wantOps) {
+		t.Errorf("Expected CPU operators %+v, got %+v", wantOps, ops)
+	}
+	if ops := OperationTimes(&TraceData{TraceEvents: events}, 0); !reflect.DeepEqual(ops, wantOps) {
+		t.Errorf("Expected the CPU operators of OperationTimes, got %+v", ops)
+	}
+}
@@ -0,0 +1,70 @@
+package converter
+
+import (
+	"context"
+	"sync"
+
+	"github.com/olka/torch2pprof/pkg/profile"
+)
+
+// Converter accumulates streamed events, e.g. from a collector, and
+// converts them to profiles on demand. Events may arrive in any order and
+// in batches of any size; stacks are rebuilt from all events added so far
+// on each snapshot, so an event whose parent arrives in a later batch is
+// nested correctly once it does. It is safe for concurrent use.
+type Converter struct {
+	opts ConvertOptions
+
+	mu     sync.Mutex
+	events []TraceEvent
+}
+
+// NewConverter returns a Converter using opts, or an error if they are
+// invalid
+func NewConverter(opts ConvertOptions) (*Converter, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return &Converter{opts: opts}, nil
+}
+
+// AddEvents adds a batch of events. The batch is copied, so the caller may
+// reuse it.
+func (c *Converter) AddEvents(batch []TraceEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, batch...)
+}
+
+// Len returns the number of events added since the session started or was
+// last finished
+func (c *Converter) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.events)
+}
+
+// Snapshot converts the events added so far, keeping them for later
+// snapshots. Events may be added while it runs; they are left for the next
+// snapshot.
+func (c *Converter) Snapshot(ctx context.Context) (*profile.Profile, error) {
+	c.mu.Lock()
+	events := c.events[:len(c.events):len(c.events)]
+	c.mu.Unlock()
+	return ConvertTraceContext(ctx, &TraceData{TraceEvents: events}, c.opts)
+}
+
+// Finish converts the events added so far and starts a new session, so
+// the next profile only covers events added afterwards. It returns nil if
+// samples cannot be spilled to disk with ConvertOptions.MaxMemory, or the
+// profile does not fit in it.
+func (c *Converter) Finish() *profile.Profile {
+	c.mu.Lock()
+	events := c.events
+	c.events = nil
+	c.mu.Unlock()
+	// The options were validated and the context is never cancelled, so
+	// conversion can only fail for the memory budget
+	p, _ := ConvertTraceContext(context.Background(), &TraceData{TraceEvents: events}, c.opts)
+	return p
+}
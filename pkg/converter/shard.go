@@ -0,0 +1,149 @@
+package converter
+
+import (
+	"errors"
+	"log/slog"
+	"runtime"
+	"sync"
+)
+
+// sampleBatchSize is the number of samples a worker buffers for a shard
+// before handing them to its aggregator
+const sampleBatchSize = 256
+
+// queuedSampleBytes approximates the memory of a sample waiting in a batch,
+// with its frames
+const queuedSampleBytes = 512
+
+// shardedAggregator spreads samples over aggregators by stack hash, each
+// fed by its own goroutine, so that workers are not serialized on a single
+// map. The same stack always lands in the same shard, so the shards hold
+// disjoint samples and need no merging.
+type shardedAggregator struct {
+	shards []*aggregator
+	inputs []chan []stackSample
+	errs   []error
+	wg     sync.WaitGroup
+}
+
+// newShardedAggregator starts n aggregators sharing the memory budget of
+// opts, calling create for the locations and labels of new samples. Each
+// also spills when the heap exceeds the whole budget.
+func newShardedAggregator(n int, opts ConvertOptions, logger *slog.Logger, create func(stackSample) *sampleData) *shardedAggregator {
+	queueLen := shardQueueLen(opts, n)
+	heapLimit := opts.MaxMemory
+	if opts.MaxMemory > 0 {
+		opts.MaxMemory = max(opts.MaxMemory/int64(n), 1)
+	}
+	s := &shardedAggregator{
+		shards: make([]*aggregator, n),
+		inputs: make([]chan []stackSample, n),
+		errs:   make([]error, n),
+	}
+	for i := range n {
+		s.shards[i] = newAggregator(opts, logger)
+		s.shards[i].heapLimit = heapLimit
+		s.inputs[i] = make(chan []stackSample, queueLen)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			for batch := range s.inputs[i] {
+				for _, sample := range batch {
+					if s.errs[i] != nil {
+						continue // Drain the workers
+					}
+					s.errs[i] = s.shards[i].add(sample, create)
+				}
+			}
+		}()
+	}
+	return s
+}
+
+// shardQueueLen returns how many batches each of n shards buffers: two per
+// worker, so workers rarely wait for a shard, but no more than a tenth of
+// the memory budget holds
+func shardQueueLen(opts ConvertOptions, n int) int {
+	queueLen := 2 * opts.workers()
+	if opts.MaxMemory > 0 {
+		queueLen = min(queueLen, int(opts.MaxMemory/10/(sampleBatchSize*queuedSampleBytes)/int64(n)))
+	}
+	return max(queueLen, 1)
+}
+
+// aggregationShards returns the number of shards for opts: one per worker
+// that can run at once
+func aggregationShards(opts ConvertOptions) int {
+	return min(runtime.GOMAXPROCS(0), opts.workers())
+}
+
+// sink returns a buffer for the samples of one worker
+func (s *shardedAggregator) sink() *sampleSink {
+	return &sampleSink{inputs: s.inputs, batches: make([][]stackSample, len(s.inputs))}
+}
+
+// wait stops accepting samples once all sinks are flushed, and returns the
+// first error of the shards
+func (s *shardedAggregator) wait() error {
+	for _, input := range s.inputs {
+		close(input)
+	}
+	s.wg.Wait()
+	return errors.Join(s.errs...)
+}
+
+// len returns the number of samples each will visit, or an upper bound
+// when samples were spilled
+func (s *shardedAggregator) len() int64 {
+	var n int64
+	for _, agg := range s.shards {
+		n += agg.len()
+	}
+	return n
+}
+
+// each calls fn for every aggregated sample, shard by shard
+func (s *shardedAggregator) each(fn func(s *sampleData)) error {
+	for _, agg := range s.shards {
+		if err := agg.each(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close removes the run files of all shards
+func (s *shardedAggregator) close() {
+	for _, agg := range s.shards {
+		agg.close()
+	}
+}
+
+// sampleSink batches the samples of a worker by shard
+type sampleSink struct {
+	inputs  []chan []stackSample
+	batches [][]stackSample
+}
+
+// add queues a sample for its shard
+func (s *sampleSink) add(sample stackSample) {
+	i := sample.hash % uint64(len(s.inputs))
+	if s.batches[i] == nil {
+		s.batches[i] = make([]stackSample, 0, sampleBatchSize)
+	}
+	s.batches[i] = append(s.batches[i], sample)
+	if len(s.batches[i]) == sampleBatchSize {
+		s.inputs[i] <- s.batches[i]
+		s.batches[i] = nil
+	}
+}
+
+// flush sends the partial batches
+func (s *sampleSink) flush() {
+	for i, batch := range s.batches {
+		if len(batch) > 0 {
+			s.inputs[i] <- batch
+			s.batches[i] = nil
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package converter
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// Span is a trace event exported as a distributed tracing span
+type Span struct {
+	Name     string
+	Category string
+
+	// Pid and Tid identify the thread or GPU stream of the event, named by
+	// Thread as in ConvertOptions.ThreadRoots
+	Pid, Tid string
+	Thread   string
+
+	// Step is the number of a ProfilerStep span, or -1
+	Step int
+
+	Start, End time.Time
+
+	// Parent is the index of the span enclosing this one on the same
+	// thread, or -1
+	Parent int
+}
+
+// SpanOptions selects the events exported by TraceSpans
+type SpanOptions struct {
+	// Ops also exports top-level CPU ops, those not nested in other ops
+	Ops bool
+
+	// BaseTime is the wall-clock time of trace timestamp 0. By default it
+	// is read from the baseTimeNanoseconds field PyTorch writes, or the
+	// Unix epoch if the trace has none.
+	BaseTime time.Time
+}
+
+// TraceSpans returns the ProfilerStep events, user annotations and, with
+// opts.Ops, top-level CPU ops of a trace as spans. Spans are nested by time
+// on each thread or GPU stream, and listed with parents before their
+// children.
+func TraceSpans(traceData *TraceData, opts SpanOptions) []Span {
+	base := opts.BaseTime
+	if base.IsZero() {
+		base = traceBaseTime(traceData)
+	}
+	md := collectMetadata(traceData.TraceEvents)
+
+	lanes := make(map[string][]TraceEvent)
+	for _, e := range traceData.TraceEvents {
+		if e.Ph != "X" || e.Dur <= 0 {
+			continue
+		}
+		if _, ok := profilerStepNumber(e); ok || isAnnotation(e) || opts.Ops && e.Cat == "cpu_op" {
+			key := threadKey(e.Pid, e.Tid)
+			lanes[key] = append(lanes[key], e)
+		}
+	}
+	keys := make([]string, 0, len(lanes))
+	for key := range lanes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	at := func(ts float64) time.Time { return base.Add(time.Duration(usToNs(ts))) }
+	var spans []Span
+	for _, key := range keys {
+		events := lanes[key]
+		sort.SliceStable(events, func(i, j int) bool {
+			if events[i].Ts != events[j].Ts {
+				return events[i].Ts < events[j].Ts
+			}
+			return events[i].Dur > events[j].Dur
+		})
+
+		// Ops nested in other ops are kept on the stack but not exported
+		type openEvent struct {
+			end  float64
+			span int // -1 if not exported
+			op   bool
+		}
+		var stack []openEvent
+		for _, e := range events {
+			for len(stack) > 0 && stack[len(stack)-1].end <= e.Ts {
+				stack = stack[:len(stack)-1]
+			}
+			parent, inOp := -1, false
+			for i := len(stack) - 1; i >= 0; i-- {
+				inOp = inOp || stack[i].op
+				if parent < 0 && stack[i].span >= 0 {
+					parent = stack[i].span
+				}
+			}
+
+			step, isStep := profilerStepNumber(e)
+			op := !isStep && !isAnnotation(e)
+			open := openEvent{end: e.Ts + e.Dur, span: -1, op: op}
+			if !op || !inOp {
+				if !isStep {
+					step = -1
+				}
+				open.span = len(spans)
+				spans = append(spans, Span{
+					Name:     e.Name,
+					Category: e.Cat,
+					Pid:      idString(e.Pid),
+					Tid:      idString(e.Tid),
+					Thread:   md.rootFrame(e.Pid, e.Tid).name,
+					Step:     step,
+					Start:    at(e.Ts),
+					End:      at(e.Ts + e.Dur),
+					Parent:   parent,
+				})
+			}
+			stack = append(stack, open)
+		}
+	}
+	return spans
+}
+
+// isAnnotation reports whether e is a record_function range on the CPU or
+// its GPU counterpart
+func isAnnotation(e TraceEvent) bool {
+	return e.Cat == "user_annotation" || e.Cat == "gpu_user_annotation"
+}
+
+// traceBaseTime returns the wall-clock time of trace timestamp 0 from the
+// baseTimeNanoseconds field, or the Unix epoch
+func traceBaseTime(traceData *TraceData) time.Time {
+	var ns int64
+	if raw, ok := traceData.Metadata["baseTimeNanoseconds"]; ok && json.Unmarshal(raw, &ns) == nil {
+		return time.Unix(0, ns)
+	}
+	return time.Unix(0, 0)
+}
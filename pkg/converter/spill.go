@@ -0,0 +1,304 @@
+package converter
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"slices"
+	"sort"
+
+	"github.com/olka/torch2pprof/pkg/profile"
+)
+
+// sampleEntryOverhead approximates the memory of an aggregated sample
+// besides its frames, location IDs and labels: the map entry, the
+// sampleData struct and slice headers
+const sampleEntryOverhead = 160
+
+// profileSampleOverhead approximates the memory of a profile sample besides
+// its location IDs, values and labels: the Sample struct and slice headers
+const profileSampleOverhead = 80
+
+// profileSampleBytes estimates the memory a sample with locations
+// locations, values values and labels labels takes in the profile. Labels
+// are shared among samples, so only the pointers count.
+func profileSampleBytes(locations, values, labels int) int64 {
+	return int64(8*(locations+values+labels)) + profileSampleOverhead
+}
+
+// aggregator sums the samples with the same stack and labels, keyed by
+// stack hash with colliding samples chained. With a memory budget, it
+// writes its samples sorted by key to a temporary run file whenever their
+// estimated size exceeds the budget, and merges the runs at the end, so
+// traces with more unique stacks than fit in memory can be converted.
+// Estimates can be off, so it also spills when the live heap of the whole
+// process exceeds heapLimit.
+type aggregator struct {
+	samples   map[uint64]*sampleData
+	n         int   // samples held, including chained ones
+	size      int64 // estimated bytes held by samples
+	maxBytes  int64 // 0 for no budget
+	heapLimit int64 // 0 for no limit
+	dir       string
+	runs      []*os.File
+	spilled   int64 // samples written to runs
+	logger    *slog.Logger
+}
+
+func newAggregator(opts ConvertOptions, logger *slog.Logger) *aggregator {
+	return &aggregator{
+		samples:  make(map[uint64]*sampleData),
+		maxBytes: opts.MaxMemory,
+		dir:      opts.SpillDir,
+		logger:   logger,
+	}
+}
+
+// add adds a sample's weight and time to the sample with the same frames
+// and labels, calling create for the locations and labels of samples not
+// held in memory
+func (a *aggregator) add(sample stackSample, create func(stackSample) *sampleData) error {
+	head := a.samples[sample.hash]
+	for existing := head; existing != nil; existing = existing.next {
+		if slices.Equal(existing.ids, sample.ids) && slices.Equal(existing.sampleLabels, sample.labels) {
+			existing.count += sample.weight
+			existing.timeNs += sample.timeNs
+			return nil
+		}
+	}
+	s := create(sample)
+	s.count, s.timeNs = sample.weight, sample.timeNs
+	s.ids, s.sampleLabels, s.next = sample.ids, sample.labels, head
+	a.samples[sample.hash] = s
+	a.n++
+	a.size += int64(4*len(s.ids)+8*len(s.locationIds)+80*len(s.labels)) + sampleEntryOverhead
+	if a.maxBytes > 0 && a.size > a.maxBytes {
+		return a.spill()
+	}
+	// Only aggregators holding a fair share of their budget spill for the
+	// heap, so that memory held by others does not make them spill runs
+	// of a few samples over and over
+	if a.heapLimit > 0 && a.n%heapCheckInterval == 0 && a.size > a.maxBytes/4 {
+		if live := liveHeap(); live > uint64(a.heapLimit) {
+			a.logger.Debug("Live heap over memory limit", "live_bytes", live, "limit", a.heapLimit)
+			return a.spill()
+		}
+	}
+	return nil
+}
+
+// heapCheckInterval is the number of new samples between checks of the
+// live heap against the memory limit
+const heapCheckInterval = 4096
+
+// len returns the number of samples each will visit, or an upper bound
+// when samples were spilled
+func (a *aggregator) len() int64 {
+	return a.spilled + int64(a.n)
+}
+
+// runKey returns the key ordering a sample in runs: its hash, then its
+// location IDs and labels, which identify it across runs as the profile
+// builder hands out the same IDs for the same frames
+func runKey(hash uint64, s *sampleData) string {
+	buf := binary.BigEndian.AppendUint64(nil, hash)
+	for _, id := range s.locationIds {
+		buf = binary.AppendUvarint(buf, id)
+	}
+	buf = append(buf, 0)
+	for _, l := range s.labels {
+		for _, v := range []int64{l.Key, l.Str, l.Num, l.NumUnit} {
+			buf = binary.AppendVarint(buf, v)
+		}
+	}
+	return string(buf)
+}
+
+// spill writes the samples held in memory to a new run, sorted by key
+func (a *aggregator) spill() error {
+	f, err := os.CreateTemp(a.dir, "torch2pprof-spill-*")
+	if err != nil {
+		return fmt.Errorf("spilling samples: %w", err)
+	}
+	a.runs = append(a.runs, f)
+
+	type entry struct {
+		key    string
+		sample *sampleData
+	}
+	entries := make([]entry, 0, a.n)
+	for hash, head := range a.samples {
+		for s := head; s != nil; s = s.next {
+			entries = append(entries, entry{runKey(hash, s), s})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	w := bufio.NewWriter(f)
+	for _, e := range entries {
+		writeRunEntry(w, e.key, e.sample)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("spilling samples: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("spilling samples: %w", err)
+	}
+
+	a.logger.Debug("Spilled samples to disk", "samples", len(entries), "estimated_bytes", a.size, "runs", len(a.runs))
+	a.spilled += int64(len(entries))
+	a.samples = make(map[uint64]*sampleData)
+	a.n, a.size = 0, 0
+	return nil
+}
+
+// each calls fn for every aggregated sample, merging spilled runs
+func (a *aggregator) each(fn func(s *sampleData)) error {
+	if len(a.runs) == 0 {
+		for _, head := range a.samples {
+			for s := head; s != nil; s = s.next {
+				fn(s)
+			}
+		}
+		return nil
+	}
+	if a.n > 0 {
+		if err := a.spill(); err != nil {
+			return err
+		}
+	}
+
+	var h runHeap
+	for _, f := range a.runs {
+		r := &runReader{r: bufio.NewReader(f)}
+		if err := r.next(); err != nil {
+			return err
+		}
+		if !r.done {
+			h = append(h, r)
+		}
+	}
+	heap.Init(&h)
+	for len(h) > 0 {
+		merged := h[0].sample
+		key := h[0].key
+		for len(h) > 0 && h[0].key == key {
+			if h[0].sample != merged {
+				merged.count += h[0].sample.count
+				merged.timeNs += h[0].sample.timeNs
+			}
+			if err := h[0].next(); err != nil {
+				return err
+			}
+			if h[0].done {
+				heap.Pop(&h)
+			} else {
+				heap.Fix(&h, 0)
+			}
+		}
+		fn(merged)
+	}
+	return nil
+}
+
+// close removes the run files
+func (a *aggregator) close() {
+	for _, f := range a.runs {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}
+	a.runs = nil
+}
+
+// writeRunEntry encodes a sample as its key, location IDs, labels as
+// string table indices, count and time. Write errors are reported by Flush.
+func writeRunEntry(w *bufio.Writer, key string, s *sampleData) {
+	var buf []byte
+	buf = binary.AppendUvarint(buf, uint64(len(key)))
+	buf = append(buf, key...)
+	buf = binary.AppendUvarint(buf, uint64(len(s.locationIds)))
+	for _, id := range s.locationIds {
+		buf = binary.AppendUvarint(buf, id)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(s.labels)))
+	for _, l := range s.labels {
+		for _, v := range []int64{l.Key, l.Str, l.Num, l.NumUnit} {
+			buf = binary.AppendVarint(buf, v)
+		}
+	}
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(s.count))
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(s.timeNs))
+	_, _ = w.Write(buf)
+}
+
+// runReader reads the entries of a run in key order
+type runReader struct {
+	r      *bufio.Reader
+	key    string
+	sample *sampleData
+	done   bool
+}
+
+// next reads the next entry, setting done at the end of the run
+func (rr *runReader) next() error {
+	n, err := binary.ReadUvarint(rr.r)
+	if err == io.EOF {
+		rr.done = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading spilled samples: %w", err)
+	}
+	key := make([]byte, n)
+	if _, err := io.ReadFull(rr.r, key); err != nil {
+		return fmt.Errorf("reading spilled samples: %w", err)
+	}
+	s := &sampleData{}
+	if n, err = binary.ReadUvarint(rr.r); err != nil {
+		return fmt.Errorf("reading spilled samples: %w", err)
+	}
+	s.locationIds = make([]uint64, n)
+	for i := range s.locationIds {
+		if s.locationIds[i], err = binary.ReadUvarint(rr.r); err != nil {
+			return fmt.Errorf("reading spilled samples: %w", err)
+		}
+	}
+	if n, err = binary.ReadUvarint(rr.r); err != nil {
+		return fmt.Errorf("reading spilled samples: %w", err)
+	}
+	for range n {
+		var l profile.Label
+		for _, v := range []*int64{&l.Key, &l.Str, &l.Num, &l.NumUnit} {
+			if *v, err = binary.ReadVarint(rr.r); err != nil {
+				return fmt.Errorf("reading spilled samples: %w", err)
+			}
+		}
+		s.labels = append(s.labels, &l)
+	}
+	var values [16]byte
+	if _, err := io.ReadFull(rr.r, values[:]); err != nil {
+		return fmt.Errorf("reading spilled samples: %w", err)
+	}
+	s.count = math.Float64frombits(binary.LittleEndian.Uint64(values[:8]))
+	s.timeNs = math.Float64frombits(binary.LittleEndian.Uint64(values[8:]))
+	rr.key, rr.sample = string(key), s
+	return nil
+}
+
+// runHeap orders runs by their current key, for the k-way merge
+type runHeap []*runReader
+
+func (h runHeap) Len() int           { return len(h) }
+func (h runHeap) Less(i, j int) bool { return h[i].key < h[j].key }
+func (h runHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x any)        { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
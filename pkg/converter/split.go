@@ -0,0 +1,221 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// SplitKey selects how SplitTrace groups the events of a trace
+type SplitKey string
+
+const (
+	// SplitStep makes one group per ProfilerStep span
+	SplitStep SplitKey = "step"
+
+	// SplitRank makes one group per rank of a distributed job: the whole
+	// trace if it records its rank, otherwise one group per process
+	SplitRank SplitKey = "rank"
+
+	// SplitGPU makes one group per GPU device, holding its kernels, memcpys
+	// and memsets
+	SplitGPU SplitKey = "gpu"
+)
+
+// ParseSplitKey parses a split key name
+func ParseSplitKey(s string) (SplitKey, error) {
+	switch k := SplitKey(s); k {
+	case SplitStep, SplitRank, SplitGPU:
+		return k, nil
+	default:
+		return "", fmt.Errorf("unknown split key %q (want step, rank or gpu)", s)
+	}
+}
+
+// TraceGroup is a named subset of a trace produced by SplitTrace
+type TraceGroup struct {
+	Name  string
+	Trace *TraceData
+}
+
+// SplitTrace divides the trace into groups, ordered by step, pid or device.
+// Every group keeps the metadata events and top-level fields of the trace.
+func SplitTrace(traceData *TraceData, by SplitKey) ([]TraceGroup, error) {
+	switch by {
+	case SplitStep:
+		return splitBySteps(traceData)
+	case SplitRank:
+		return splitByRank(traceData), nil
+	case SplitGPU:
+		return splitByGPU(traceData)
+	default:
+		return nil, fmt.Errorf("unknown split key %q", by)
+	}
+}
+
+// splitBySteps restricts the trace to the window of each ProfilerStep
+func splitBySteps(traceData *TraceData) ([]TraceGroup, error) {
+	windows := make(map[int]TimeWindow)
+	for _, e := range traceData.TraceEvents {
+		step, ok := profilerStepNumber(e)
+		if !ok {
+			continue
+		}
+		w, seen := windows[step]
+		if !seen || e.Ts < w.Start {
+			w.Start = e.Ts
+		}
+		if !seen || e.Ts+e.Dur > w.End {
+			w.End = e.Ts + e.Dur
+		}
+		windows[step] = w
+	}
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("no ProfilerStep spans found in trace")
+	}
+
+	steps := make([]int, 0, len(windows))
+	for step := range windows {
+		steps = append(steps, step)
+	}
+	sort.Ints(steps)
+
+	groups := make([]TraceGroup, len(steps))
+	for i, step := range steps {
+		groups[i] = TraceGroup{
+			Name:  "step" + strconv.Itoa(step),
+			Trace: FilterTimeWindow(traceData, windows[step]),
+		}
+	}
+	return groups, nil
+}
+
+// splitByRank groups events by process. A trace recording its rank in
+// distributedInfo is a single rank and forms one group. GPU activity joins
+// the process that launched it, matched by correlation id.
+func splitByRank(traceData *TraceData) []TraceGroup {
+	if rank, ok := distributedRank(traceData); ok {
+		return []TraceGroup{{Name: "rank" + strconv.Itoa(rank), Trace: traceData}}
+	}
+
+	launchers := make(map[int64]string)
+	for _, e := range traceData.TraceEvents {
+		if isGPUEvent(e) {
+			continue
+		}
+		if id, ok := correlationID(e); ok {
+			launchers[id] = idString(e.Pid)
+		}
+	}
+	// GPU pids follow the process that launched their first correlated
+	// event, so uncorrelated events such as GPU annotations join it too
+	devices := make(map[string]string)
+	for _, e := range traceData.TraceEvents {
+		if id, ok := correlationID(e); ok && isGPUEvent(e) {
+			pid := idString(e.Pid)
+			if _, seen := devices[pid]; !seen && launchers[id] != "" {
+				devices[pid] = launchers[id]
+			}
+		}
+	}
+
+	groups := newGroupSet(traceData)
+	for _, e := range traceData.TraceEvents {
+		switch e.Ph {
+		case "M", "s", "t", "f":
+			// Flow events link CPU and GPU processes and carry no timing of
+			// their own, so they are dropped
+			continue
+		}
+		pid := idString(e.Pid)
+		if id, ok := correlationID(e); ok && isGPUEvent(e) && launchers[id] != "" {
+			pid = launchers[id]
+		} else if launcher, ok := devices[pid]; ok {
+			pid = launcher
+		}
+		groups.add(pid, e)
+	}
+	return groups.build(func(pid string) string { return "pid" + pid })
+}
+
+// splitByGPU groups GPU events by their device
+func splitByGPU(traceData *TraceData) ([]TraceGroup, error) {
+	groups := newGroupSet(traceData)
+	for _, e := range traceData.TraceEvents {
+		if e.Ph == "M" || !isGPUEvent(e) {
+			continue
+		}
+		groups.add(gpuDevice(e), e)
+	}
+	if len(groups.keys) == 0 {
+		return nil, fmt.Errorf("no GPU events found in trace")
+	}
+	return groups.build(func(device string) string { return "gpu" + device }), nil
+}
+
+// traceRank returns the rank recorded in the distributedInfo field, or -1
+func traceRank(traceData *TraceData) int {
+	if rank, ok := distributedRank(traceData); ok {
+		return rank
+	}
+	return -1
+}
+
+// distributedRank returns the rank recorded in the distributedInfo field
+func distributedRank(traceData *TraceData) (int, bool) {
+	raw, ok := traceData.Metadata["distributedInfo"]
+	if !ok {
+		return 0, false
+	}
+	var info struct {
+		Rank *int `json:"rank"`
+	}
+	if err := json.Unmarshal(raw, &info); err != nil || info.Rank == nil {
+		return 0, false
+	}
+	return *info.Rank, true
+}
+
+// groupSet collects events into groups keyed by a numeric or string id
+type groupSet struct {
+	source *TraceData
+	keys   []string
+	events map[string][]TraceEvent
+}
+
+func newGroupSet(source *TraceData) *groupSet {
+	return &groupSet{source: source, events: make(map[string][]TraceEvent)}
+}
+
+func (g *groupSet) add(key string, e TraceEvent) {
+	if _, ok := g.events[key]; !ok {
+		g.keys = append(g.keys, key)
+	}
+	g.events[key] = append(g.events[key], e)
+}
+
+// build returns the groups ordered by key, each prefixed with the metadata
+// events of the source trace
+func (g *groupSet) build(name func(key string) string) []TraceGroup {
+	var metadata []TraceEvent
+	for _, e := range g.source.TraceEvents {
+		if e.Ph == "M" {
+			metadata = append(metadata, e)
+		}
+	}
+
+	sort.Slice(g.keys, func(i, j int) bool { return lessID(g.keys[i], g.keys[j]) })
+
+	groups := make([]TraceGroup, len(g.keys))
+	for i, key := range g.keys {
+		events := make([]TraceEvent, 0, len(metadata)+len(g.events[key]))
+		events = append(events, metadata...)
+		events = append(events, g.events[key]...)
+		groups[i] = TraceGroup{
+			Name:  name(key),
+			Trace: &TraceData{TraceEvents: events, Metadata: g.source.Metadata},
+		}
+	}
+	return groups
+}
@@ -0,0 +1,195 @@
+package converter
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"slices"
+	"sync"
+)
+
+// frameTable interns the frames of a conversion as small integer IDs, so
+// that stacks are hashed and compared as integers rather than as strings
+// such as long kernel names. Workers look frames up in a cache of their
+// own first, so the table is mostly read when a frame is first seen.
+type frameTable struct {
+	mu     sync.RWMutex
+	ids    map[frame]uint32
+	frames []frame
+}
+
+func newFrameTable() *frameTable {
+	return &frameTable{ids: make(map[frame]uint32)}
+}
+
+// intern returns the ID of f, adding it to the table if it is new
+func (t *frameTable) intern(f frame) uint32 {
+	t.mu.RLock()
+	id, ok := t.ids[f]
+	t.mu.RUnlock()
+	if ok {
+		return id
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if id, ok := t.ids[f]; ok {
+		return id
+	}
+	id = uint32(len(t.frames))
+	t.frames = append(t.frames, f)
+	t.ids[f] = id
+	return id
+}
+
+// frame returns the frame interned as id
+func (t *frameTable) frame(id uint32) frame {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.frames[id]
+}
+
+// stackSeed seeds stack hashes, which are only compared within a process
+var stackSeed = maphash.MakeSeed()
+
+// appendStackKey appends the aggregation key of a root-first stack of
+// frame IDs and its labels to buf: the number of frames, the IDs and the
+// labels, so that equal keys mean equal samples
+func appendStackKey(buf []byte, ids []uint32, labels ...[]sampleLabel) []byte {
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(ids)))
+	for _, id := range ids {
+		buf = binary.LittleEndian.AppendUint32(buf, id)
+	}
+	for _, ls := range labels {
+		for _, l := range ls {
+			buf = append(buf, l.key...)
+			buf = append(buf, 0)
+			if l.isNum {
+				buf = append(buf, 1)
+				buf = binary.LittleEndian.AppendUint64(buf, uint64(l.num))
+				buf = append(buf, l.unit...)
+			} else {
+				buf = append(buf, 2)
+				buf = append(buf, l.str...)
+			}
+			buf = append(buf, 0)
+		}
+	}
+	return buf
+}
+
+// newStackSample builds a stackSample from root-first frame IDs
+func newStackSample(ids []uint32, labels []sampleLabel, timeNs float64) stackSample {
+	return stackSample{
+		ids:    ids,
+		labels: labels,
+		hash:   maphash.Bytes(stackSeed, appendStackKey(nil, ids, labels)),
+		timeNs: timeNs,
+		weight: 1,
+	}
+}
+
+// localStackLimit is the number of distinct stacks a worker aggregates
+// before handing them to the shards, bounding its memory
+const localStackLimit = 1 << 14
+
+// stackWorker builds the samples of the threads given to one worker
+// goroutine. It aggregates them itself, keyed by frame IDs and labels, so
+// an event repeating a known stack costs no allocation, and hands each
+// distinct stack to the shards once.
+type stackWorker struct {
+	opts   ConvertOptions
+	frames *frameTable
+	known  map[frame]uint32 // Frames interned by this worker
+	sink   *sampleSink
+
+	// pending indexes samples, the stacks not yet handed to the shards,
+	// in the order they were first seen
+	pending map[string]int
+	samples []stackSample
+
+	// Buffers reused from one event or thread to the next. ids holds the
+	// frame IDs of the events of the current thread at the offsets
+	// recorded in eventWithEnd.idOffset.
+	stack    []*eventWithEnd
+	ids      []uint32
+	root     []uint32
+	stackIDs []uint32
+	body     []frame
+	key      []byte
+}
+
+func newStackWorker(opts ConvertOptions, frames *frameTable, sink *sampleSink) *stackWorker {
+	return &stackWorker{
+		opts:    opts,
+		frames:  frames,
+		known:   make(map[frame]uint32),
+		sink:    sink,
+		pending: make(map[string]int),
+	}
+}
+
+// frameID returns the ID of f
+func (w *stackWorker) frameID(f frame) uint32 {
+	id, ok := w.known[f]
+	if !ok {
+		id = w.frames.intern(f)
+		w.known[f] = id
+	}
+	return id
+}
+
+// intern records the frame IDs of event, which must precede pushing it
+func (w *stackWorker) intern(event *eventWithEnd) {
+	event.idOffset = int32(len(w.ids))
+	for _, f := range event.frames {
+		w.ids = append(w.ids, w.frameID(f))
+	}
+}
+
+// eventIDs returns the frame IDs of an interned event
+func (w *stackWorker) eventIDs(event *eventWithEnd) []uint32 {
+	return w.ids[event.idOffset : int(event.idOffset)+len(event.frames)]
+}
+
+// add aggregates time and weight into the sample for the root-first frame
+// IDs and the labels of group and event
+func (w *stackWorker) add(ids []uint32, groupLabels, eventLabels []sampleLabel, timeNs, weight float64) {
+	w.key = appendStackKey(w.key[:0], ids, groupLabels, eventLabels)
+	if i, ok := w.pending[string(w.key)]; ok {
+		w.samples[i].timeNs += timeNs
+		w.samples[i].weight += weight
+		return
+	}
+
+	labels := groupLabels
+	if len(eventLabels) > 0 {
+		labels = append(labels[:len(labels):len(labels)], eventLabels...)
+	}
+	w.pending[string(w.key)] = len(w.samples)
+	w.samples = append(w.samples, stackSample{
+		ids:    slices.Clone(ids),
+		labels: labels,
+		hash:   maphash.Bytes(stackSeed, w.key),
+		timeNs: timeNs,
+		weight: weight,
+	})
+	if len(w.samples) == localStackLimit {
+		w.flush()
+	}
+}
+
+// flush hands the pending samples to the shards
+func (w *stackWorker) flush() {
+	for _, sample := range w.samples {
+		w.sink.add(sample)
+	}
+	clear(w.pending)
+	clear(w.samples)
+	w.samples = w.samples[:0]
+}
+
+// done hands all samples of the worker to the shards
+func (w *stackWorker) done() {
+	w.flush()
+	w.sink.flush()
+}
@@ -0,0 +1,147 @@
+package converter
+
+import (
+	"maps"
+	"math"
+	"slices"
+)
+
+// Drift thresholds of StepTrends
+const (
+	// minTrendSteps is the number of steps needed to tell a trend
+	minTrendSteps = 3
+
+	// driftChange is the change of a category over the trace, relative to
+	// its mean per step, from which it is drifting
+	driftChange = 0.2
+
+	// minDriftShare is the share of the mean step time a category needs
+	// for its drift to matter, so that noise in a few microseconds of
+	// idle time is not flagged
+	minDriftShare = 0.01
+)
+
+// StepCategoryTimes breaks the time of one ProfilerStep down by what ran
+// in it. GPU times are the time covered by compute kernels, memory copies
+// and sets, and communication kernels, and GPUIdleNs the time none ran,
+// each summed over devices. PythonNs and DataLoaderNs are the time covered
+// by python_function events and by DataLoader fetches on any CPU thread.
+type StepCategoryTimes struct {
+	Step         int   `json:"step"`
+	StepNs       int64 `json:"step_ns"`
+	KernelNs     int64 `json:"kernel_ns"`
+	MemcpyNs     int64 `json:"memcpy_ns"`
+	CommNs       int64 `json:"comm_ns"`
+	GPUIdleNs    int64 `json:"gpu_idle_ns"`
+	PythonNs     int64 `json:"python_ns"`
+	DataLoaderNs int64 `json:"dataloader_ns"`
+}
+
+// CategoryTrend is the evolution of one category of StepCategoryTimes over
+// the steps: the least-squares slope of its time per step, and the change
+// that slope makes over the trace relative to the mean. A category
+// changing by a fifth or more of its mean, over at least three steps, is
+// drifting, as when data loading slows down epoch after epoch, unless it
+// takes under 1% of the mean step.
+type CategoryTrend struct {
+	Category string  `json:"category"`
+	FirstNs  int64   `json:"first_ns"`
+	LastNs   int64   `json:"last_ns"`
+	MeanNs   int64   `json:"mean_ns"`
+	SlopeNs  float64 `json:"slope_ns"` // Per step
+	Change   float64 `json:"change"`
+	Drifting bool    `json:"drifting"`
+}
+
+// stepCategoryTimes returns the category times of each step, in step order
+func (a *traceAnalyzer) stepCategoryTimes() []StepCategoryTimes {
+	if len(a.steps) == 0 {
+		return nil
+	}
+	type device struct{ all, compute, memcpy, comm intervals }
+	devices := make([]device, 0, len(a.deviceOrder))
+	for _, name := range a.deviceOrder {
+		d := a.devices[name]
+		devices = append(devices, device{d.intervals.merge(), d.compute.merge(), d.memcpy.merge(), d.comm.merge()})
+	}
+	var python intervals
+	for _, frames := range a.pythonFrames {
+		python = append(python, frames...)
+	}
+	python, data := python.merge(), a.dataLoader.merge()
+
+	var result []StepCategoryTimes
+	for _, step := range slices.Sorted(maps.Keys(a.steps)) {
+		w := a.steps[step]
+		times := StepCategoryTimes{
+			Step:         step,
+			StepNs:       usToNs(w.End - w.Start),
+			PythonNs:     usToNs(python.clip(w).total()),
+			DataLoaderNs: usToNs(data.clip(w).total()),
+		}
+		for _, d := range devices {
+			times.KernelNs += usToNs(d.compute.clip(w).total())
+			times.MemcpyNs += usToNs(d.memcpy.clip(w).total())
+			times.CommNs += usToNs(d.comm.clip(w).total())
+			times.GPUIdleNs += times.StepNs - usToNs(d.all.clip(w).total())
+		}
+		result = append(result, times)
+	}
+	return result
+}
+
+// stepTrends returns the trend of each category over steps, or nil for
+// fewer than two steps
+func stepTrends(steps []StepCategoryTimes) []CategoryTrend {
+	if len(steps) < 2 {
+		return nil
+	}
+	categories := []struct {
+		name  string
+		value func(StepCategoryTimes) int64
+	}{
+		{"step", func(s StepCategoryTimes) int64 { return s.StepNs }},
+		{"kernel", func(s StepCategoryTimes) int64 { return s.KernelNs }},
+		{"memcpy", func(s StepCategoryTimes) int64 { return s.MemcpyNs }},
+		{"communication", func(s StepCategoryTimes) int64 { return s.CommNs }},
+		{"gpu idle", func(s StepCategoryTimes) int64 { return s.GPUIdleNs }},
+		{"python", func(s StepCategoryTimes) int64 { return s.PythonNs }},
+		{"dataloader", func(s StepCategoryTimes) int64 { return s.DataLoaderNs }},
+	}
+
+	n := float64(len(steps))
+	var stepSum float64
+	for _, s := range steps {
+		stepSum += float64(s.StepNs)
+	}
+	var trends []CategoryTrend
+	for _, c := range categories {
+		// Least squares over the step index rather than the step number,
+		// which may skip steps
+		var sumY, sumXY float64
+		for i, s := range steps {
+			y := float64(c.value(s))
+			sumY += y
+			sumXY += float64(i) * y
+		}
+		if sumY == 0 {
+			continue
+		}
+		meanX, meanY := (n-1)/2, sumY/n
+		var sxx float64
+		for i := range steps {
+			sxx += (float64(i) - meanX) * (float64(i) - meanX)
+		}
+		trend := CategoryTrend{
+			Category: c.name,
+			FirstNs:  c.value(steps[0]),
+			LastNs:   c.value(steps[len(steps)-1]),
+			MeanNs:   int64(math.Round(meanY)),
+			SlopeNs:  (sumXY - n*meanX*meanY) / sxx,
+		}
+		trend.Change = trend.SlopeNs * (n - 1) / meanY
+		trend.Drifting = len(steps) >= minTrendSteps && math.Abs(trend.Change) >= driftChange && meanY >= minDriftShare*stepSum/n
+		trends = append(trends, trend)
+	}
+	return trends
+}
@@ -0,0 +1,44 @@
+package converter
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeTraceStepTrends(t *testing.T) {
+	var events []TraceEvent
+	for i := range 4 {
+		ts := float64(1000 * i)
+		data := float64(100 * (i + 1)) // Data loading slows down step after step
+		events = append(events,
+			annotation(fmt.Sprintf("ProfilerStep#%d", i+1), ts, 1000),
+			annotation("enumerate(DataLoader)#_SingleProcessDataLoaderIter.__next__", ts, data),
+			threadSpan("train.py(10): step", "python_function", 1, ts+data, 500),
+			gpuKernel("gemm", ts+data, 400),
+			gpuEvent("Memcpy HtoD", "gpu_memcpy", 7, ts+data+400, 50),
+			gpuEvent("ncclDevKernel_AllReduce", "kernel", 8, ts+data+400, 100),
+		)
+	}
+	analysis := AnalyzeTrace(&TraceData{TraceEvents: events})
+
+	if len(analysis.StepCategories) != 4 {
+		t.Fatalf("Expected 4 steps, got %+v", analysis.StepCategories)
+	}
+	want := StepCategoryTimes{Step: 2, StepNs: 1000000, KernelNs: 400000, MemcpyNs: 50000, CommNs: 100000,
+		GPUIdleNs: 500000, PythonNs: 500000, DataLoaderNs: 200000}
+	if got := analysis.StepCategories[1]; got != want {
+		t.Errorf("Expected step 2 %+v, got %+v", want, got)
+	}
+
+	drifting := make(map[string]bool)
+	for _, trend := range analysis.StepTrends {
+		drifting[trend.Category] = trend.Drifting
+		if trend.Category == "dataloader" && (trend.SlopeNs != 100000 || trend.FirstNs != 100000 || trend.LastNs != 400000 || trend.Change != 1.2) {
+			t.Errorf("Unexpected dataloader trend %+v", trend)
+		}
+	}
+	if want := map[string]bool{"step": false, "kernel": false, "memcpy": false, "communication": false, "gpu idle": false, "python": false, "dataloader": true}; !reflect.DeepEqual(drifting, want) {
+		t.Errorf("Expected drifting categories %v, got %v", want, drifting)
+	}
+}
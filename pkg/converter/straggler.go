@@ -0,0 +1,175 @@
+package converter
+
+import (
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// RankTimeline is what straggler analysis keeps of the trace of one rank:
+// its ProfilerStep windows and the collectives it ran, in start order, so
+// that the traces of many ranks need not be held at once. Rank is the rank
+// recorded in the trace's distributedInfo, or -1.
+type RankTimeline struct {
+	Rank        int
+	steps       map[int]TimeWindow
+	collectives [][2]float64 // [start, end) in microseconds
+}
+
+// NewRankTimeline collects the steps and collectives of the trace of one
+// rank. Collectives are the NCCL kernels of its GPUs or, in traces without
+// GPU activity, its nccl: CPU ops.
+func NewRankTimeline(traceData *TraceData) *RankTimeline {
+	t := &RankTimeline{Rank: traceRank(traceData), steps: make(map[int]TimeWindow)}
+	var cpuCollectives [][2]float64
+	for _, e := range traceData.TraceEvents {
+		if e.Ph != "X" || e.Dur <= 0 {
+			continue
+		}
+		if step, ok := profilerStepNumber(e); ok {
+			t.steps[step] = TimeWindow{Start: e.Ts, End: e.Ts + e.Dur}
+		}
+		switch {
+		case isGPUEvent(e) && isCommunicationOp(e.Name):
+			t.collectives = append(t.collectives, [2]float64{e.Ts, e.Ts + e.Dur})
+		case !isGPUEvent(e) && strings.HasPrefix(e.Name, "nccl:"):
+			cpuCollectives = append(cpuCollectives, [2]float64{e.Ts, e.Ts + e.Dur})
+		}
+	}
+	if len(t.collectives) == 0 {
+		t.collectives = cpuCollectives
+	}
+	sort.Slice(t.collectives, func(i, j int) bool { return t.collectives[i][0] < t.collectives[j][0] })
+	return t
+}
+
+// StragglerAnalysis compares the ranks of a distributed job. The i-th
+// collective of every rank is taken to be the same operation, as the
+// ranks of a job issue collectives in the same order. A collective
+// completes on all ranks at about the same time, once the last of them
+// has joined, so the clocks of the ranks are aligned on its end and the
+// rank starting it last is the one that kept the others waiting.
+type StragglerAnalysis struct {
+	Ranks       []RankStragglerStats `json:"ranks"`
+	Collectives int                  `json:"collectives"` // Matched on all ranks
+	MeanSkewNs  int64                `json:"mean_skew_ns"`
+	P50SkewNs   int64                `json:"p50_skew_ns"`
+	MaxSkewNs   int64                `json:"max_skew_ns"`
+
+	// Straggler is the rank arriving last at most collectives, or -1 when
+	// no rank does so at least half the time
+	Straggler int `json:"straggler"`
+}
+
+// RankStragglerStats describes one rank: its step times and how often and
+// how late it arrived at collectives, relative to the earliest rank.
+// ClockOffsetNs is the difference between its clock and the clock of the
+// first rank, which aligning on collective ends removed.
+type RankStragglerStats struct {
+	Rank          int        `json:"rank"`
+	Steps         []StepTime `json:"steps"`
+	MeanStepNs    int64      `json:"mean_step_ns"`
+	MaxStepNs     int64      `json:"max_step_ns"`
+	LastArrivals  int        `json:"last_arrivals"`
+	LastFraction  float64    `json:"last_fraction"`
+	MeanLateNs    int64      `json:"mean_late_ns"`
+	ClockOffsetNs int64      `json:"clock_offset_ns"`
+}
+
+// StepTime is the duration of one ProfilerStep
+type StepTime struct {
+	Step  int   `json:"step"`
+	DurNs int64 `json:"dur_ns"`
+}
+
+// AnalyzeStragglers compares the timelines of the ranks of a job, sorted
+// by rank. Ranks whose traces recorded none keep the -1 of their timeline,
+// so callers should number them first.
+func AnalyzeStragglers(timelines []*RankTimeline) *StragglerAnalysis {
+	timelines = slices.Clone(timelines)
+	sort.SliceStable(timelines, func(i, j int) bool { return timelines[i].Rank < timelines[j].Rank })
+	analysis := &StragglerAnalysis{Straggler: -1}
+
+	matched := -1
+	for _, t := range timelines {
+		if matched < 0 || len(t.collectives) < matched {
+			matched = len(t.collectives)
+		}
+	}
+	analysis.Collectives = max(matched, 0)
+
+	// The offset of each clock from the first rank's, as the median
+	// difference of collective ends, which ignores the odd collective
+	// a rank had to retry or split
+	offsets := make([]float64, len(timelines))
+	for r, t := range timelines {
+		if r == 0 || matched == 0 {
+			continue
+		}
+		diffs := make([]float64, matched)
+		for i := range matched {
+			diffs[i] = t.collectives[i][1] - timelines[0].collectives[i][1]
+		}
+		slices.Sort(diffs)
+		offsets[r] = diffs[len(diffs)/2]
+	}
+
+	last := make([]int, len(timelines))
+	late := make([]float64, len(timelines))
+	var skews []int64
+	for i := range matched {
+		first, latest, lastRank := math.Inf(1), math.Inf(-1), 0
+		for r, t := range timelines {
+			start := t.collectives[i][0] - offsets[r]
+			first = min(first, start)
+			if start > latest {
+				latest, lastRank = start, r
+			}
+		}
+		for r, t := range timelines {
+			late[r] += t.collectives[i][0] - offsets[r] - first
+		}
+		last[lastRank]++
+		skews = append(skews, usToNs(latest-first))
+	}
+	if len(skews) > 0 {
+		var total int64
+		for _, s := range skews {
+			total += s
+		}
+		slices.Sort(skews)
+		analysis.MeanSkewNs = total / int64(len(skews))
+		analysis.P50SkewNs = percentile(skews, 50)
+		analysis.MaxSkewNs = skews[len(skews)-1]
+	}
+
+	for r, t := range timelines {
+		stats := RankStragglerStats{
+			Rank:          t.Rank,
+			LastArrivals:  last[r],
+			ClockOffsetNs: usToNs(offsets[r]),
+		}
+		var totalNs int64
+		for _, step := range slices.Sorted(maps.Keys(t.steps)) {
+			w := t.steps[step]
+			durNs := usToNs(w.End - w.Start)
+			stats.Steps = append(stats.Steps, StepTime{Step: step, DurNs: durNs})
+			totalNs += durNs
+			stats.MaxStepNs = max(stats.MaxStepNs, durNs)
+		}
+		if len(stats.Steps) > 0 {
+			stats.MeanStepNs = totalNs / int64(len(stats.Steps))
+		}
+		if matched > 0 {
+			stats.LastFraction = float64(last[r]) / float64(matched)
+			stats.MeanLateNs = usToNs(late[r] / float64(matched))
+		}
+		if len(timelines) > 1 && stats.LastFraction >= 0.5 && analysis.Straggler < 0 {
+			analysis.Straggler = t.Rank
+		}
+		analysis.Ranks = append(analysis.Ranks, stats)
+	}
+	return analysis
+}
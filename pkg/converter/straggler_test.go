@@ -0,0 +1,46 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeStragglers(t *testing.T) {
+	// rank returns the trace of a rank whose clock is offset by clock and
+	// which joins each collective late, all of them ending together
+	rank := func(r int, clock, late float64) *TraceData {
+		events := []TraceEvent{
+			span("ProfilerStep#1", clock, 400+late),
+			span("ProfilerStep#2", clock+400, 400),
+		}
+		for i := range 4 {
+			start := clock + float64(100+200*i)
+			events = append(events, gpuKernel("ncclDevKernel_AllReduce_Sum_f32_RING_LL", start+late, 60-late))
+		}
+		return &TraceData{
+			TraceEvents: events,
+			Metadata:    map[string]json.RawMessage{"distributedInfo": json.RawMessage(fmt.Sprintf(`{"rank": %d}`, r))},
+		}
+	}
+	analysis := AnalyzeStragglers([]*RankTimeline{
+		NewRankTimeline(rank(2, 0, 50)),
+		NewRankTimeline(rank(0, 0, 0)),
+		NewRankTimeline(rank(1, 1000, 10)),
+	})
+
+	if analysis.Collectives != 4 || analysis.MeanSkewNs != 50000 || analysis.MaxSkewNs != 50000 || analysis.Straggler != 2 {
+		t.Errorf("Unexpected analysis %+v", analysis)
+	}
+	want := []RankStragglerStats{
+		{Rank: 0, Steps: []StepTime{{1, 400000}, {2, 400000}}, MeanStepNs: 400000, MaxStepNs: 400000},
+		{Rank: 1, Steps: []StepTime{{1, 410000}, {2, 400000}}, MeanStepNs: 405000, MaxStepNs: 410000,
+			MeanLateNs: 10000, ClockOffsetNs: 1000000},
+		{Rank: 2, Steps: []StepTime{{1, 450000}, {2, 400000}}, MeanStepNs: 425000, MaxStepNs: 450000,
+			LastArrivals: 4, LastFraction: 1, MeanLateNs: 50000},
+	}
+	if !reflect.DeepEqual(analysis.Ranks, want) {
+		t.Errorf("Expected ranks %+v, got %+v", want, analysis.Ranks)
+	}
+}
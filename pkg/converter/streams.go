@@ -0,0 +1,95 @@
+package converter
+
+import "sort"
+
+// StreamConcurrencyStats describes how many streams of a GPU device ran
+// work at the same time. Streams counts the streams with any activity, the
+// most that could overlap; while the device was busy, it had on average
+// MeanActive of them active, Concurrency of Streams. Levels break the busy
+// time down by number of active streams, from one up to MaxActive.
+type StreamConcurrencyStats struct {
+	Device      string             `json:"device"`
+	Streams     int                `json:"streams"`
+	BusyNs      int64              `json:"busy_ns"`
+	MaxActive   int                `json:"max_active"`
+	MeanActive  float64            `json:"mean_active"`
+	Concurrency float64            `json:"concurrency"`
+	Levels      []ConcurrencyLevel `json:"levels"`
+}
+
+// ConcurrencyLevel is the time a device had exactly Active streams busy
+type ConcurrencyLevel struct {
+	Active   int     `json:"active"`
+	TimeNs   int64   `json:"time_ns"`
+	Fraction float64 `json:"fraction"` // Of the busy time
+}
+
+// streamConcurrency returns the concurrency of the streams of one device,
+// given the intervals of each
+func streamConcurrency(device string, streams map[string]intervals) StreamConcurrencyStats {
+	type edge struct {
+		ts    float64
+		delta int
+	}
+	var edges []edge
+	for _, ivs := range streams {
+		for _, iv := range ivs.merge() {
+			edges = append(edges, edge{iv[0], 1}, edge{iv[1], -1})
+		}
+	}
+	// Ends first, so back-to-back kernels on two streams do not count as
+	// overlapping
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].ts != edges[j].ts {
+			return edges[i].ts < edges[j].ts
+		}
+		return edges[i].delta < edges[j].delta
+	})
+
+	var levels []float64 // Microseconds at each number of active streams
+	active := 0
+	for i, e := range edges {
+		if active > 0 {
+			for len(levels) <= active {
+				levels = append(levels, 0)
+			}
+			levels[active] += e.ts - edges[i-1].ts
+		}
+		active += e.delta
+	}
+
+	stats := StreamConcurrencyStats{Device: device, Streams: len(streams)}
+	var busy, weighted float64
+	for n, us := range levels {
+		busy += us
+		weighted += float64(n) * us
+	}
+	stats.BusyNs = usToNs(busy)
+	for n := 1; n < len(levels); n++ {
+		level := ConcurrencyLevel{Active: n, TimeNs: usToNs(levels[n])}
+		if busy > 0 {
+			level.Fraction = levels[n] / busy
+		}
+		stats.Levels = append(stats.Levels, level)
+		if levels[n] > 0 {
+			stats.MaxActive = n
+		}
+	}
+	stats.Levels = stats.Levels[:stats.MaxActive]
+	if busy > 0 {
+		stats.MeanActive = weighted / busy
+		stats.Concurrency = stats.MeanActive / float64(stats.Streams)
+	}
+	return stats
+}
+
+// streamConcurrencyStats returns the stream concurrency of each device
+func (a *traceAnalyzer) streamConcurrencyStats() []StreamConcurrencyStats {
+	var result []StreamConcurrencyStats
+	for _, device := range a.deviceOrder {
+		if d := a.devices[device]; len(d.streams) > 0 {
+			result = append(result, streamConcurrency(device, d.streams))
+		}
+	}
+	return result
+}
@@ -0,0 +1,31 @@
+package converter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeTraceStreamConcurrency(t *testing.T) {
+	analysis := AnalyzeTrace(&TraceData{TraceEvents: []TraceEvent{
+		gpuEvent("gemm", "kernel", 7, 0, 40),
+		gpuEvent("gemm", "kernel", 8, 10, 20),
+		gpuEvent("gemm", "kernel", 9, 30, 10), // Starts as stream 8 ends, never three at once
+		gpuEvent("gemm", "kernel", 9, 60, 10),
+	}})
+
+	want := []StreamConcurrencyStats{{
+		Device:      "0",
+		Streams:     3,
+		BusyNs:      50000,
+		MaxActive:   2,
+		MeanActive:  1.6,
+		Concurrency: 1.6 / 3,
+		Levels: []ConcurrencyLevel{
+			{Active: 1, TimeNs: 20000, Fraction: 0.4},
+			{Active: 2, TimeNs: 30000, Fraction: 0.6},
+		},
+	}}
+	if !reflect.DeepEqual(analysis.StreamConcurrency, want) {
+		t.Errorf("Expected %+v, got %+v", want, analysis.StreamConcurrency)
+	}
+}
@@ -0,0 +1,111 @@
+package converter
+
+import (
+	"math"
+	"sort"
+)
+
+// Timeline is the utilization of each thread and GPU stream of a trace over
+// equal time buckets
+type Timeline struct {
+	StartUs  float64        `json:"start_us"`
+	EndUs    float64        `json:"end_us"`
+	BucketUs float64        `json:"bucket_us"`
+	Lanes    []TimelineLane `json:"lanes"`
+}
+
+// TimelineLane is one thread or GPU stream. Utilization holds the busy
+// fraction of each bucket, from 0 to 1.
+type TimelineLane struct {
+	Name        string    `json:"name"`
+	Pid         string    `json:"pid"`
+	Tid         string    `json:"tid"`
+	GPU         bool      `json:"gpu"`
+	BusyNs      int64     `json:"busy_ns"`
+	Utilization []float64 `json:"utilization"`
+}
+
+// BuildTimeline divides the span of the trace's complete events into the
+// given number of buckets and computes how busy each (pid, tid) lane is in
+// each of them. Lanes are ordered by pid and tid.
+func BuildTimeline(traceData *TraceData, buckets int) *Timeline {
+	buckets = max(buckets, 1)
+	md := collectMetadata(traceData.TraceEvents)
+
+	type lane struct {
+		pid, tid  interface{}
+		gpu       bool
+		intervals intervals
+	}
+	lanes := make(map[string]*lane)
+	var keys []string
+	start, end := math.Inf(1), math.Inf(-1)
+	for _, e := range traceData.TraceEvents {
+		if e.Ph != "X" || e.Dur <= 0 {
+			continue
+		}
+		key := threadKey(e.Pid, e.Tid)
+		l := lanes[key]
+		if l == nil {
+			l = &lane{pid: e.Pid, tid: e.Tid}
+			lanes[key] = l
+			keys = append(keys, key)
+		}
+		l.gpu = l.gpu || isGPUEvent(e)
+		l.intervals = append(l.intervals, [2]float64{e.Ts, e.Ts + e.Dur})
+		start = min(start, e.Ts)
+		end = max(end, e.Ts+e.Dur)
+	}
+
+	timeline := &Timeline{}
+	if len(keys) == 0 {
+		return timeline
+	}
+	timeline.StartUs, timeline.EndUs = start, end
+	timeline.BucketUs = (end - start) / float64(buckets)
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := lanes[keys[i]], lanes[keys[j]]
+		if pa, pb := idString(a.pid), idString(b.pid); pa != pb {
+			return lessID(pa, pb)
+		}
+		return lessID(idString(a.tid), idString(b.tid))
+	})
+	for _, key := range keys {
+		l := lanes[key]
+		tl := TimelineLane{
+			Name:        md.rootFrame(l.pid, l.tid).name,
+			Pid:         idString(l.pid),
+			Tid:         idString(l.tid),
+			GPU:         l.gpu,
+			Utilization: make([]float64, buckets),
+		}
+		for _, iv := range l.intervals.merge() {
+			tl.BusyNs += usToNs(iv[1] - iv[0])
+			timeline.spread(iv, tl.Utilization)
+		}
+		timeline.Lanes = append(timeline.Lanes, tl)
+	}
+	return timeline
+}
+
+// spread adds the part of iv falling in each bucket to util, as a fraction
+// of the bucket width
+func (t *Timeline) spread(iv [2]float64, util []float64) {
+	if t.BucketUs <= 0 {
+		util[0] = 1
+		return
+	}
+	last := len(util) - 1
+	first := min(int((iv[0]-t.StartUs)/t.BucketUs), last)
+	for b := first; b <= last; b++ {
+		lo := t.StartUs + float64(b)*t.BucketUs
+		hi := lo + t.BucketUs
+		if iv[1] <= lo {
+			break
+		}
+		if overlap := min(iv[1], hi) - max(iv[0], lo); overlap > 0 {
+			util[b] = min(util[b]+overlap/t.BucketUs, 1)
+		}
+	}
+}
@@ -0,0 +1,1020 @@
+// Package converter loads PyTorch profiler traces (Chrome Trace Event JSON,
+// plain or gzip-compressed) and converts them to pprof profiles, rebuilding
+// call stacks from the nesting of complete events on each thread and GPU
+// stream. It also analyzes, filters, splits and summarizes traces.
+//
+// A typical embedding loads a trace and converts it in process:
+//
+//	traceData, err := converter.LoadTraceFile("trace.json.gz")
+//	if err != nil {
+//		return err
+//	}
+//	p, err := converter.Convert(ctx, traceData, converter.WithThreadRoots())
+//	if err != nil {
+//		return err
+//	}
+//	data, err := p.Encode()
+package converter
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/olka/torch2pprof/pkg/profile"
+)
+
+// TraceEvent represents a single event in the PyTorch trace. Args holds
+// the decoded args object (shapes, correlation ids, kernel launch
+// parameters...), read with the Arg accessors.
+type TraceEvent struct {
+	Ph   string                 `json:"ph"`
+	Cat  string                 `json:"cat"`
+	Name string                 `json:"name"`
+	Pid  interface{}            `json:"pid"`
+	Tid  interface{}            `json:"tid"`
+	Ts   float64                `json:"ts"`
+	Dur  float64                `json:"dur"`
+	Tts  float64                `json:"tts,omitempty"`
+	Tdur float64                `json:"tdur,omitempty"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// TraceData represents the parsed trace JSON structure
+type TraceData struct {
+	TraceEvents []TraceEvent `json:"traceEvents"`
+
+	// Metadata holds the other top-level fields, such as deviceProperties
+	// and distributedInfo, so they survive WriteTrace
+	Metadata map[string]json.RawMessage `json:"-"`
+}
+
+// eventWithEnd is a complete event as converted: its time span, in
+// microseconds, and what its EventMapper mapped it to. It holds only what
+// building stacks needs, as it is copied while grouping and sorting
+// every converted event.
+type eventWithEnd struct {
+	Ts, Dur, End float64
+
+	// frames, labels and valueNs are the event as mapped by its
+	// EventMapper; untimed events have timed false
+	frames  []frame
+	labels  []sampleLabel
+	valueNs float64
+	timed   bool
+
+	// idOffset locates the frame IDs of the event in the buffer of the
+	// stackWorker converting its thread
+	idOffset int32
+
+	// LaunchLatency is the delay in microseconds until the GPU work
+	// launched by this event started, when attributed
+	LaunchLatency float64
+}
+
+// stackSample represents an aggregated stack sample
+type stackSample struct {
+	ids    []uint32 // Frame IDs, root first, see frameTable
+	labels []sampleLabel
+	hash   uint64  // Hash of the aggregation key, see appendStackKey
+	timeNs float64 // Unrounded, so sub-nanosecond remainders add up
+	weight float64 // Number of events represented (1 unless downsampled)
+}
+
+// LoadTraceFile loads and parses a PyTorch trace JSON file.
+// Supports both plain JSON and gzip-compressed JSON files.
+// Automatically detects compression based on file extension (.gz) or content.
+func LoadTraceFile(path string) (*TraceData, error) {
+	return LoadTraceFileContext(context.Background(), path, nil)
+}
+
+// ProgressFunc reports how far a stage of loading or converting a trace
+// has come. A stage is complete when done equals total.
+type ProgressFunc func(stage string, done, total int64)
+
+// Stages reported to a ProgressFunc, in order
+const (
+	// StageParse is reading the trace file; done and total count bytes of
+	// the (possibly compressed) file
+	StageParse = "parse"
+
+	// StageBuild is placing events on call stacks; done and total count
+	// events
+	StageBuild = "build"
+
+	// StageAggregate is adding the aggregated samples to the profile;
+	// done and total count samples
+	StageAggregate = "aggregate"
+)
+
+// progressInterval is the number of events between progress reports
+const progressInterval = 10000
+
+// eventChunkSize is the most events decodeTrace allocates at a time
+const eventChunkSize = 1 << 16
+
+// LoadTraceFileWithProgress is like LoadTraceFile but calls progress
+// periodically while parsing. progress may be nil.
+func LoadTraceFileWithProgress(path string, progress ProgressFunc) (*TraceData, error) {
+	return LoadTraceFileContext(context.Background(), path, progress)
+}
+
+// LoadTraceFileContext is like LoadTraceFileWithProgress but stops parsing
+// and returns ctx.Err() when ctx is cancelled
+func LoadTraceFileContext(ctx context.Context, path string, progress ProgressFunc) (*TraceData, error) {
+	return LoadTraceFileWithOptions(ctx, path, LoadOptions{Progress: progress})
+}
+
+// LoadOptions contains options for loading traces
+type LoadOptions struct {
+	// Progress, if set, is called periodically with the parse stage
+	Progress ProgressFunc
+
+	// Logger receives warnings about ignored input, e.g. data after the
+	// trace. Nothing is logged when it is nil.
+	Logger *slog.Logger
+
+	// Args, if not nil, lists the event args to keep; the others are
+	// skipped while parsing. Args hold most of the memory of traces
+	// recorded with Python stacks, and conversion and analysis only read
+	// UsedArgs.
+	Args []string
+}
+
+// keepArgs returns the set of Args, or nil to keep all args
+func (opts LoadOptions) keepArgs() map[string]bool {
+	if opts.Args == nil {
+		return nil
+	}
+	keep := make(map[string]bool, len(opts.Args))
+	for _, key := range opts.Args {
+		keep[key] = true
+	}
+	return keep
+}
+
+// LoadTraceFileWithOptions is like LoadTraceFileContext with all loading
+// options
+func LoadTraceFileWithOptions(ctx context.Context, path string, opts LoadOptions) (*TraceData, error) {
+	progress := opts.Progress
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	var size int64
+	if info, err := file.Stat(); err == nil {
+		size = info.Size()
+	}
+	counter := &countingReader{r: file}
+	var reader io.Reader = counter
+
+	// Check if file is gzip compressed by extension or magic number
+	isGzip := false
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".gz" {
+		isGzip = true
+	} else {
+		// Check magic number (gzip files start with 0x1f 0x8b)
+		header := make([]byte, 2)
+		n, readErr := file.Read(header)
+		if readErr == nil && n == 2 && header[0] == 0x1f && header[1] == 0x8b {
+			isGzip = true
+		}
+		// Reset file position
+		if _, seekErr := file.Seek(0, 0); seekErr != nil {
+			return nil, seekErr
+		}
+	}
+
+	// Parse uncompressed files in place where they can be mapped
+	logger := loggerOrDiscard(opts.Logger)
+	if !isGzip {
+		data, unmap, err := mapFile(file, size)
+		if err == nil {
+			defer func() { _ = unmap() }()
+			return decodeMapped(ctx, data, opts.keepArgs(), progress, logger)
+		}
+		logger.Debug("Streaming the trace, it cannot be mapped", "error", err)
+	}
+
+	// Wrap with gzip reader if compressed
+	if isGzip {
+		gzReader, err := gzip.NewReader(counter)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = gzReader.Close() }()
+		reader = gzReader
+	}
+
+	var report func(events int64)
+	if progress != nil {
+		report = func(int64) { progress(StageParse, counter.n, max(size, counter.n)) }
+	}
+	return decodeTrace(ctx, reader, opts.keepArgs(), report, logger)
+}
+
+// LoadTrace is like LoadTraceFileWithOptions for a trace read from r,
+// plain or gzip-compressed. The size of the input is not known, so
+// opts.Progress is called with a total of 0.
+func LoadTrace(ctx context.Context, r io.Reader, opts LoadOptions) (*TraceData, error) {
+	counter := &countingReader{r: r}
+	br := bufio.NewReader(counter)
+	var reader io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzReader, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = gzReader.Close() }()
+		reader = gzReader
+	}
+
+	var report func(events int64)
+	if opts.Progress != nil {
+		report = func(int64) { opts.Progress(StageParse, counter.n, 0) }
+	}
+	return decodeTrace(ctx, reader, opts.keepArgs(), report, loggerOrDiscard(opts.Logger))
+}
+
+// loggerOrDiscard returns logger, or a logger dropping all records if it
+// is nil
+func loggerOrDiscard(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return logger
+}
+
+// decodeTrace parses a trace JSON object, decoding traceEvents one event at
+// a time so progress can be reported and cancellation noticed; report may
+// be nil. Malformed input yields ErrNotATrace, ErrUnsupportedFormat or a
+// *ParseError.
+func decodeTrace(ctx context.Context, r io.Reader, keepArgs map[string]bool, report func(events int64), logger *slog.Logger) (*TraceData, error) {
+	var traceData TraceData
+	var cancelled error
+
+	// Events are collected in chunks and copied once at the end, rather
+	// than every time a single growing slice is reallocated
+	var chunks [][]TraceEvent
+	var chunk []TraceEvent
+	var n int64
+	err := decodeEvents(r, keepArgs, logger, func(key string, raw json.RawMessage) {
+		if traceData.Metadata == nil {
+			traceData.Metadata = make(map[string]json.RawMessage)
+		}
+		traceData.Metadata[key] = raw
+	}, func(e TraceEvent) bool {
+		if len(chunk) == cap(chunk) {
+			if chunk != nil {
+				chunks = append(chunks, chunk)
+			}
+			chunk = make([]TraceEvent, 0, min(max(2*cap(chunk), 1024), eventChunkSize))
+		}
+		chunk = append(chunk, e)
+		n++
+		if n%progressInterval == 0 {
+			if cancelled = ctx.Err(); cancelled != nil {
+				return false
+			}
+			if report != nil {
+				report(n)
+			}
+		}
+		return true
+	})
+	if cancelled != nil {
+		return nil, cancelled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		traceData.TraceEvents = chunk
+	} else {
+		traceData.TraceEvents = slices.Concat(append(chunks, chunk)...)
+	}
+	logger.Debug("Decoded trace", "events", len(traceData.TraceEvents), "metadata_fields", len(traceData.Metadata))
+	if report != nil {
+		report(int64(len(traceData.TraceEvents)))
+	}
+	return &traceData, nil
+}
+
+// WriteTraceFile writes the trace as Chrome trace JSON, gzip-compressed if
+// path ends in .gz. Only the event fields known to TraceEvent are written.
+func WriteTraceFile(path string, traceData *TraceData) error {
+	return createTraceFile(path, func(w io.Writer) error {
+		return WriteTrace(w, traceData)
+	})
+}
+
+// createTraceFile creates path and calls write with a writer to it,
+// gzip-compressing if path ends in .gz
+func createTraceFile(path string, write func(w io.Writer) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = file
+	var gzWriter *gzip.Writer
+	if strings.ToLower(filepath.Ext(path)) == ".gz" {
+		gzWriter = gzip.NewWriter(file)
+		w = gzWriter
+	}
+	if err := write(w); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if gzWriter != nil {
+		if err := gzWriter.Close(); err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// WriteTrace writes the trace as Chrome trace JSON with one event per line,
+// preceded by the top-level metadata fields
+func WriteTrace(w io.Writer, traceData *TraceData) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString("{\n"); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(traceData.Metadata))
+	for key := range traceData.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		name, _ := json.Marshal(key)
+		if _, err := fmt.Fprintf(bw, "%s: %s,\n", name, traceData.Metadata[key]); err != nil {
+			return err
+		}
+	}
+	if _, err := bw.WriteString("\"traceEvents\": [\n"); err != nil {
+		return err
+	}
+	for i, e := range traceData.TraceEvents {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("encoding event %d: %w", i, err)
+		}
+		if i > 0 {
+			if _, err := bw.WriteString(",\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := bw.Write(data); err != nil {
+			return err
+		}
+	}
+	if _, err := bw.WriteString("\n]}\n"); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// getTid converts a tid field to int64
+func getTid(tid interface{}) int64 {
+	switch v := tid.(type) {
+	case float64:
+		return int64(v)
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	case string:
+		var h int64
+		for _, c := range v {
+			h = h*31 + int64(c)
+		}
+		return h
+	default:
+		return 0
+	}
+}
+
+// frame is a single entry of a call stack: the event name and the category
+// used as the location's filename
+type frame struct {
+	name string
+	cat  string
+}
+
+// threadID identifies an execution context by process and thread
+type threadID struct {
+	pid int64
+	tid int64
+}
+
+// threadGroup holds the events recorded on a single (pid, tid) pair along
+// with the synthetic root frames and labels shared by all of its samples.
+// pid and tid are those of its first event, as found in the trace.
+type threadGroup struct {
+	events   []eventWithEnd
+	root     []frame
+	labels   []sampleLabel
+	pid, tid interface{}
+}
+
+const (
+	// idleFrame is the synthetic frame for gaps between events on a thread
+	idleFrame = "<idle>"
+
+	// idleCategory is the category of idle frames
+	idleCategory = "idle"
+)
+
+// processThreadEvents adds the samples of a single thread's events,
+// rebuilding their stacks with a stack-based algorithm in O(n) rather than
+// comparing events pairwise. Samples are aggregated by frame ID in place,
+// so only stacks not seen before allocate. It returns the number of events
+// partially overlapping an event still open when they started.
+func (w *stackWorker) processThreadEvents(ctx context.Context, group *threadGroup, counter *int64) (overlaps int) {
+	opts := w.opts
+	stack := w.stack[:0]
+	w.ids = w.ids[:0]
+	w.root = w.root[:0]
+	for _, f := range group.root {
+		w.root = append(w.root, w.frameID(f))
+	}
+	defer func() {
+		// Drop the references to this thread's events and frames
+		clear(stack[:cap(stack)])
+		clear(w.body[:cap(w.body)])
+		w.stack = stack[:0]
+	}()
+
+	// The stacks of events only need their frames when they are rewritten
+	// or filtered by name, otherwise the interned IDs of the stack are
+	// the sample's frames
+	byName := opts.Rewrite != nil || opts.Focus != nil || opts.Ignore != nil || opts.CollapseRecursion || opts.CommunicationRoot
+
+	// weight scales the samples of the current event when downsampling
+	weight := 1.0
+	var rng *rand.Rand
+	if opts.SampleRate > 0 && opts.SampleRate < 1 {
+		rng = rand.New(rand.NewSource(opts.SampleSeed + int64(len(group.events))))
+	}
+
+	// emit adds durNs and count events to the sample for the stack ending
+	// with its top entry, below any synthetic frames in prefix
+	emit := func(stack []*eventWithEnd, durNs, count float64, prefix ...frame) {
+		event := stack[len(stack)-1]
+		ids := append(w.stackIDs[:0], w.root...)
+		if byName {
+			body := append(w.body[:0], prefix...)
+			for _, s := range stack {
+				body = append(body, s.frames...)
+			}
+			w.body = body[:0]
+			if opts.Rewrite != nil {
+				if body = opts.rewriteFrames(body); len(body) == 0 {
+					return
+				}
+			}
+			if !opts.keepStack(body) {
+				return
+			}
+			if opts.CollapseRecursion {
+				body = collapseRecursion(body)
+			}
+			if opts.CommunicationRoot && hasCommunicationFrame(body) {
+				ids = append(ids, w.frameID(frame{name: communicationRoot, cat: communicationCategory}))
+			}
+			for _, f := range body {
+				ids = append(ids, w.frameID(f))
+			}
+		} else {
+			for _, f := range prefix {
+				ids = append(ids, w.frameID(f))
+			}
+			for _, s := range stack {
+				ids = append(ids, w.eventIDs(s)...)
+			}
+		}
+		w.stackIDs = ids[:0]
+		w.add(ids, group.labels, event.labels, durNs*weight, count*weight)
+	}
+
+	// Idle gaps and launch delays are wall time, not time the thread
+	// spent on a CPU
+	synthetic := opts.Clock != ClockThread
+
+	// Timestamps closer than eps (in microseconds) are considered equal
+	eps := float64(opts.Epsilon.Nanoseconds()) / 1000
+
+	// busyEnd is the end of the latest event seen, used to find idle gaps
+	busyEnd := math.Inf(-1)
+	if len(group.events) > 0 {
+		busyEnd = group.events[0].Ts
+	}
+
+	for i := range group.events {
+		event := &group.events[i]
+		if i%progressInterval == 0 && ctx.Err() != nil {
+			return
+		}
+		if opts.IdleFrames && synthetic && event.Ts > busyEnd+eps {
+			idle := frame{name: idleFrame, cat: idleCategory}
+			if opts.keepStack([]frame{idle}) {
+				ids := append(w.stackIDs[:0], w.root...)
+				ids = append(ids, w.frameID(idle))
+				w.stackIDs = ids[:0]
+				w.add(ids, group.labels, nil, (event.Ts-busyEnd)*1000, 1)
+			}
+		}
+		if event.End > busyEnd {
+			busyEnd = event.End
+		}
+
+		// Pop events from stack that have ended by the time current event starts
+		live := stack[:0]
+		for _, s := range stack {
+			if s.End > event.Ts+eps {
+				live = append(live, s)
+			}
+		}
+		stack = live
+		if len(stack) == 0 {
+			w.ids = w.ids[:0] // No event refers to the IDs anymore
+		}
+
+		// Events still open but ending before our event ends partially
+		// overlap it; they can't be a proper parent
+		overlapEnd := event.End
+		for _, s := range stack {
+			if s.End < event.End-eps && s.End < overlapEnd {
+				overlapEnd = s.End
+			}
+		}
+		if overlapEnd < event.End {
+			overlaps++
+		}
+
+		// Downsample leaf events (those not containing the next event);
+		// parents are always kept so the stacks of kept events are intact
+		weight = 1
+		if rng != nil && (i+1 == len(group.events) || group.events[i+1].Ts >= event.End-eps) {
+			if rng.Float64() >= opts.SampleRate {
+				atomic.AddInt64(counter, 1)
+				continue
+			}
+			weight = 1 / opts.SampleRate
+		}
+		if !byName {
+			w.intern(event)
+		}
+
+		durNs, timed := event.valueNs, event.timed
+		count := 1.0
+
+		if overlapEnd < event.End {
+			switch opts.Overlap {
+			case OverlapDrop:
+				atomic.AddInt64(counter, 1)
+				continue
+			case OverlapParent:
+				// Nest under the overlapping event as if it were a parent
+				stack = append(stack, event)
+				if timed {
+					emit(stack, durNs, 1)
+				}
+				atomic.AddInt64(counter, 1)
+				continue
+			default:
+				// Split: the part inside the overlapping events stays
+				// nested under them, the remainder moves to the events
+				// fully containing us. The event counts once, in the
+				// inside part.
+				insideNs := durNs * (overlapEnd - event.Ts) / event.Dur
+				if timed {
+					emit(append(stack, event), insideNs, 1)
+					count = 0
+				}
+				durNs -= insideNs
+
+				containing := stack[:0]
+				for _, s := range stack {
+					if s.End >= event.End-eps {
+						containing = append(containing, s)
+					}
+				}
+				stack = containing
+			}
+		}
+
+		// Push current event to stack
+		stack = append(stack, event)
+		if timed {
+			emit(stack, durNs, count)
+		}
+
+		// The delay is not time the launch call or its callers ran, so
+		// it goes under a root of its own rather than under the call
+		if event.LaunchLatency > 0 && synthetic {
+			emit(stack[len(stack)-1:], event.LaunchLatency*1000, 1, frame{name: launchLatencyFrame, cat: launchLatencyCategory})
+		}
+
+		atomic.AddInt64(counter, 1)
+	}
+	return overlaps
+}
+
+// ConvertOptions contains options for trace conversion. The zero value
+// converts with the defaults; NewConvertOptions builds and validates
+// options from Option functions.
+type ConvertOptions struct {
+	// NumWorkers is the number of goroutines converting threads in
+	// parallel; 0 uses one per CPU (GOMAXPROCS)
+	NumWorkers int
+
+	// ThreadRoots prefixes each stack with a synthetic frame naming the
+	// process and thread (or GPU stream) the events ran on
+	ThreadRoots bool
+
+	// CommunicationRoot prefixes stacks containing collective
+	// communication ops with a synthetic "Communication" root frame
+	CommunicationRoot bool
+
+	// Focus keeps only stacks with at least one frame matching it
+	Focus *regexp.Regexp
+
+	// Ignore drops stacks with any frame matching it
+	Ignore *regexp.Regexp
+
+	// CollapseRecursion merges consecutive identical frames into one
+	// frame carrying the repetition count
+	CollapseRecursion bool
+
+	// NormalizeKernelNames demangles GPU kernel names and strips template
+	// arguments, parameter lists and autogenerated suffixes
+	NormalizeKernelNames bool
+
+	// RawKernelNameLabel keeps the original name of normalized kernels
+	// as a "raw_name" label
+	RawKernelNameLabel bool
+
+	// MinDuration drops events shorter than it before stacks are built
+	MinDuration time.Duration
+
+	// LaunchLatency adds the delay from each kernel launch call until the
+	// kernel started on the GPU as a sample of the call under a synthetic
+	// "launch latency" root, outside the stack the call ran in. Not with
+	// ClockThread, as the delay is not CPU time.
+	LaunchLatency bool
+
+	// IdleFrames adds synthetic "<idle>" samples for gaps between events
+	// on each thread or GPU stream. Not with ClockThread, as gaps are not
+	// CPU time.
+	IdleFrames bool
+
+	// SampleRate, when in (0, 1), keeps each leaf event with this
+	// probability and scales kept samples by 1/SampleRate, trading
+	// precision for speed on very large traces
+	SampleRate float64
+
+	// SampleSeed seeds the downsampling random generator
+	SampleSeed int64
+
+	// Clock selects wall time (default) or thread CPU time for sample values
+	Clock Clock
+
+	// Epsilon is the tolerance when comparing event boundaries, so that
+	// nesting built from rounded timestamps is stable
+	Epsilon time.Duration
+
+	// Overlap selects how events partially overlapping an enclosing event
+	// on the same thread are attributed (default OverlapSplit)
+	Overlap OverlapPolicy
+
+	// SampleTypes selects the value columns of the profile, in order. All
+	// columns (samples, then time) are written when it is empty.
+	SampleTypes []SampleType
+
+	// Labels are attached to every sample as string labels and recorded
+	// as "key=value" profile comments, e.g. experiment or run ids
+	Labels map[string]string
+
+	// Progress, if set, is called periodically during the build and
+	// aggregate stages. ConvertTraceFile also reports the parse stage.
+	Progress ProgressFunc
+
+	// EventMappers maps the events of each category with a custom mapper
+	// instead of DefaultEventMapper, e.g. for in-house trace categories
+	EventMappers map[string]EventMapper
+
+	// Rewrite, if set, renames, merges or drops the frames of each stack
+	// before Focus, Ignore and CollapseRecursion apply
+	Rewrite FrameRewriter
+
+	// Logger receives warnings about events that are not converted and
+	// partially overlapping events, and debug details. Nothing is logged
+	// when it is nil.
+	Logger *slog.Logger
+
+	// MaxMemory, when positive, bounds the memory of conversion.
+	// Aggregated samples are spilled to temporary files in SpillDir
+	// (default os.TempDir()) and merged at the end when their estimated
+	// bytes exceed it, or when the live heap of the process does, so
+	// that estimates that are off do not exhaust memory either. The
+	// profile itself still holds every unique stack, in a compact form:
+	// conversion fails with ErrMemoryBudget once its samples are
+	// estimated to exceed MaxMemory, rather than growing past it. The
+	// loaded trace is not counted.
+	MaxMemory int64
+	SpillDir  string
+
+	// AnalyzeBy, if set, also analyzes the trace in the pass over its
+	// events that groups them for conversion, reporting what AnalyzeTraceBy
+	// would in Diagnostics.Analysis, so a trace need not be visited twice.
+	// AnalyzeReports selects its optional reports.
+	AnalyzeBy      GroupBy
+	AnalyzeReports Reports
+}
+
+// sampleData represents aggregated sample data
+type sampleData struct {
+	locationIds []uint64
+	labels      []*profile.Label
+	count       float64
+	timeNs      float64
+
+	// ids and sampleLabels identify the sample among those with the
+	// same hash, chained by next
+	ids          []uint32
+	sampleLabels []sampleLabel
+	next         *sampleData
+}
+
+// reportProgress calls progress for stage with the value of counter every
+// 100ms until the returned stop function is called, which reports
+// completion. progress may be nil.
+func reportProgress(progress ProgressFunc, stage string, counter *int64, total int64) (stop func()) {
+	if progress == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				progress(stage, atomic.LoadInt64(counter), total)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		progress(stage, total, total)
+	}
+}
+
+// ConvertTrace converts PyTorch trace data to a pprof profile. It never
+// returns nil: invalid options fall back to their defaults and samples stay
+// in memory if they cannot be spilled to disk, with a warning to
+// opts.Logger. Use Convert or ConvertTraceContext to get the error instead.
+func ConvertTrace(traceData *TraceData, opts ConvertOptions) *profile.Profile {
+	p, err := ConvertTraceContext(context.Background(), traceData, opts)
+	if err != nil {
+		loggerOrDiscard(opts.Logger).Warn("Converting with default options", "error", err)
+		p, _ = ConvertTraceContext(context.Background(), traceData, opts.orDefaults())
+	}
+	return p
+}
+
+// ConvertTraceFile loads the trace at path and converts it, reporting the
+// parse stage to opts.Progress and loading warnings to opts.Logger as well.
+// Without opts.EventMappers, only UsedArgs are loaded.
+func ConvertTraceFile(ctx context.Context, path string, opts ConvertOptions) (*profile.Profile, error) {
+	load := LoadOptions{Progress: opts.Progress, Logger: opts.Logger}
+	if len(opts.EventMappers) == 0 {
+		load.Args = usedArgs
+	}
+	traceData, err := LoadTraceFileWithOptions(ctx, path, load)
+	if err != nil {
+		return nil, err
+	}
+	return ConvertTraceContext(ctx, traceData, opts)
+}
+
+// ConvertTraceContext is like ConvertTrace but stops converting and returns
+// ctx.Err() when ctx is cancelled
+func ConvertTraceContext(ctx context.Context, traceData *TraceData, opts ConvertOptions) (*profile.Profile, error) {
+	p, _, err := ConvertTraceWithDiagnostics(ctx, traceData, opts)
+	return p, err
+}
+
+// ConvertTraceWithDiagnostics is like ConvertTraceContext and also reports
+// the events the conversion skipped and what it had to guess
+func ConvertTraceWithDiagnostics(ctx context.Context, traceData *TraceData, opts ConvertOptions) (*profile.Profile, *Diagnostics, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, nil, err
+	}
+	memory := newMemoryTracker()
+	md := collectMetadata(traceData.TraceEvents)
+
+	var gpuStarts map[int64]float64
+	if opts.LaunchLatency {
+		gpuStarts = gpuStartTimes(traceData.TraceEvents)
+	}
+	diag := &Diagnostics{
+		Events:        len(traceData.TraceEvents),
+		SkippedPhases: make(map[string]int),
+		TimeUnit:      guessTimeUnit(traceData),
+	}
+
+	// The analysis needs the events in trace order, so it is gathered
+	// while they are grouped
+	var analyzed sync.WaitGroup
+	if opts.AnalyzeBy != "" {
+		analyzer := newTraceAnalyzer(md, opts.AnalyzeBy, traceRank(traceData), opts.AnalyzeReports)
+		analyzed.Add(1)
+		go func() {
+			defer analyzed.Done()
+			for _, e := range traceData.TraceEvents {
+				analyzer.add(e)
+			}
+			diag.Analysis = analyzer.finish()
+		}()
+	}
+	groups := groupByThread(traceData.TraceEvents, opts, md, gpuStarts, diag)
+	analyzed.Wait()
+	memory.end(stageGroup)
+
+	logger := loggerOrDiscard(opts.Logger)
+	diag.logSkipped(logger)
+
+	pb := profile.NewBuilder()
+	timeType := "time"
+	if opts.Clock == ClockThread {
+		timeType = "cpu_time"
+	}
+	sampleTypes := opts.SampleTypes
+	if len(sampleTypes) == 0 {
+		sampleTypes = []SampleType{SampleCount, SampleTime}
+	}
+	var valueTypes []struct{ Type, Unit string }
+	for _, t := range sampleTypes {
+		if t == SampleCount {
+			valueTypes = append(valueTypes, struct{ Type, Unit string }{"samples", "count"})
+		} else {
+			valueTypes = append(valueTypes, struct{ Type, Unit string }{timeType, "nanoseconds"})
+		}
+	}
+	pb.SetSampleTypes(valueTypes)
+	pb.SetPeriodType("cpu", "nanoseconds")
+	pb.SetPeriod(1000000)
+
+	// Progress counter
+	var processedCount int64
+	var totalEvents int64
+	for _, group := range groups {
+		totalEvents += int64(len(group.events))
+	}
+	stopProgress := reportProgress(opts.Progress, StageBuild, &processedCount, totalEvents)
+
+	// Aggregate the samples of all workers, sharded by stack hash
+	frames := newFrameTable()
+	agg := newShardedAggregator(aggregationShards(opts), opts, logger, func(sample stackSample) *sampleData {
+		// Build location IDs (pprof wants leaf first)
+		locationIds := make([]uint64, len(sample.ids))
+		for i, id := range sample.ids {
+			f := frames.frame(id)
+			locId := pb.GetOrCreateLocation(f.name, f.cat)
+			// Reverse order: leaf first
+			locationIds[len(sample.ids)-1-i] = locId
+		}
+		var labels []*profile.Label
+		for _, l := range sample.labels {
+			if l.isNum {
+				labels = append(labels, pb.NewNumLabel(l.key, l.num, l.unit))
+			} else {
+				labels = append(labels, pb.NewStringLabel(l.key, l.str))
+			}
+		}
+		return &sampleData{locationIds: locationIds, labels: labels}
+	})
+	defer agg.close()
+
+	// Process threads on a pool of workers, longest threads first so a
+	// long thread started last does not keep a single worker busy at the end
+	queue := make(chan *threadGroup)
+	var wg sync.WaitGroup
+	var overlapsMu sync.Mutex
+	for range min(opts.workers(), len(groups)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := newStackWorker(opts, frames, agg.sink())
+			for group := range queue {
+				if n := w.processThreadEvents(ctx, group, &processedCount); n > 0 {
+					overlapsMu.Lock()
+					diag.Overlaps = append(diag.Overlaps, ThreadOverlap{Pid: group.pid, Tid: group.tid, Events: len(group.events), Overlaps: n})
+					overlapsMu.Unlock()
+				}
+				group.events = nil // Free them while other threads convert
+			}
+			w.done()
+		}()
+	}
+	for _, group := range groups {
+		queue <- group
+	}
+	close(queue)
+	wg.Wait()
+	aggErr := agg.wait()
+	memory.end(StageBuild)
+
+	stopProgress()
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if aggErr != nil {
+		return nil, nil, aggErr
+	}
+	sortOverlaps(diag.Overlaps)
+	diag.logOverlaps(logger, opts.Overlap)
+
+	labelKeys := make([]string, 0, len(opts.Labels))
+	for key := range opts.Labels {
+		labelKeys = append(labelKeys, key)
+	}
+	sort.Strings(labelKeys)
+	var extraLabels []*profile.Label
+	for _, key := range labelKeys {
+		extraLabels = append(extraLabels, pb.NewStringLabel(key, opts.Labels[key]))
+		pb.AddComment(key + "=" + opts.Labels[key])
+	}
+
+	// Add samples to profile
+	var addedCount int64
+	stopProgress = reportProgress(opts.Progress, StageAggregate, &addedCount, agg.len())
+	defer stopProgress()
+	values := make([]int64, len(sampleTypes))
+	var profileBytes int64
+	err := agg.each(func(s *sampleData) {
+		if opts.MaxMemory > 0 && profileBytes > opts.MaxMemory {
+			return // Over budget, finish merging the runs to close them
+		}
+		profileBytes += profileSampleBytes(len(s.locationIds), len(values), len(s.labels)+len(extraLabels))
+		for i, t := range sampleTypes {
+			if t == SampleCount {
+				values[i] = int64(math.Round(s.count))
+			} else {
+				values[i] = int64(math.Round(s.timeNs))
+			}
+		}
+		pb.AppendNewSample(s.locationIds, values, s.labels, extraLabels)
+		atomic.AddInt64(&addedCount, 1)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.MaxMemory > 0 && profileBytes > opts.MaxMemory {
+		return nil, nil, fmt.Errorf("%w: the profile samples need more than %d bytes, downsample the trace or raise the limit",
+			ErrMemoryBudget, opts.MaxMemory)
+	}
+	memory.end(StageAggregate)
+	diag.Memory = memory.phases
+
+	return pb.Build(), diag, nil
+}
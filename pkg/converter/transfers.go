@@ -0,0 +1,197 @@
+package converter
+
+import (
+	"sort"
+	"strings"
+)
+
+// TransferStats summarizes the host-device copies of one direction, "HtoD"
+// or "DtoH", from the gpu_memcpy events of a trace. BandwidthGBs is the
+// bytes copied over the time the copies ran. Pinned counts the copies from
+// or to page-locked host memory; Synchronous those that blocked the CPU
+// thread: made with cudaMemcpy rather than cudaMemcpyAsync, or with
+// pageable host memory, which CUDA copies synchronously either way. They
+// include the .cpu(), .item() and .tolist() calls stalling the CPU until
+// the GPU catches up. Sites are the ops making the most synchronous copies,
+// then the most time copying.
+type TransferStats struct {
+	Direction    string         `json:"direction"`
+	Count        int            `json:"count"`
+	Bytes        int64          `json:"bytes"`
+	TimeNs       int64          `json:"time_ns"`
+	BandwidthGBs float64        `json:"bandwidth_gbs"`
+	Pinned       int            `json:"pinned"`
+	Synchronous  int            `json:"synchronous"`
+	Sites        []TransferSite `json:"sites"`
+}
+
+// TransferSite is the innermost CPU event running on the thread making
+// the copies, "" for copies made outside any event or whose call was not
+// recorded
+type TransferSite struct {
+	Op          string `json:"op"`
+	Count       int    `json:"count"`
+	Bytes       int64  `json:"bytes"`
+	TimeNs      int64  `json:"time_ns"`
+	Synchronous int    `json:"synchronous"`
+}
+
+// maxTransferSites is the number of Sites reported per direction
+const maxTransferSites = 10
+
+// transfer is a host-device copy on the GPU
+type transfer struct {
+	direction string
+	host      string // Pinned, Pageable or "" when not recorded
+	bytes     int64
+	durNs     int64
+}
+
+// memcpyCall is a runtime call copying memory
+type memcpyCall struct {
+	ts     float64
+	name   string
+	thread threadID
+}
+
+// isMemcpyCall reports whether e is a CUDA runtime or driver call copying
+// memory
+func isMemcpyCall(e TraceEvent) bool {
+	return (e.Cat == "cuda_runtime" || e.Cat == "cuda_driver") &&
+		(strings.HasPrefix(e.Name, "cudaMemcpy") || strings.HasPrefix(e.Name, "cuMemcpy"))
+}
+
+// parseTransfer returns the direction and host memory of a memcpy named
+// like "Memcpy DtoH (Device -> Pinned)", or false for copies that do not
+// involve the host
+func parseTransfer(name string) (direction, host string, ok bool) {
+	fields := strings.Fields(name)
+	if len(fields) < 2 || fields[0] != "Memcpy" || (fields[1] != "HtoD" && fields[1] != "DtoH") {
+		return "", "", false
+	}
+	direction = fields[1]
+	if _, memory, found := strings.Cut(name, "("); found {
+		from, to, _ := strings.Cut(strings.TrimSuffix(memory, ")"), " -> ")
+		host = strings.TrimSpace(to)
+		if direction == "HtoD" {
+			host = strings.TrimSpace(from)
+		}
+	}
+	return direction, host, true
+}
+
+// addTransfer records e if it is a host-device copy or a call making one
+func (a *traceAnalyzer) addTransfer(e TraceEvent, thread threadID, durNs int64) {
+	id, ok := correlationID(e)
+	if !ok {
+		return
+	}
+	if isMemcpyCall(e) {
+		a.memcpyCalls[id] = memcpyCall{ts: e.Ts, name: e.Name, thread: thread}
+		return
+	}
+	if e.Cat != "gpu_memcpy" {
+		return
+	}
+	if direction, host, ok := parseTransfer(e.Name); ok {
+		bytes, _ := e.ArgInt("bytes")
+		a.transfers[id] = transfer{direction: direction, host: host, bytes: bytes, durNs: durNs}
+	}
+}
+
+// transferStats returns the host-device copies of each direction, or nil
+// if the trace has none, given the CPU events of each thread
+func (a *traceAnalyzer) transferStats(cpu map[threadID][]cpuEvent) []TransferStats {
+	if len(a.transfers) == 0 {
+		return nil
+	}
+	type hostCopy struct {
+		transfer
+		call        memcpyCall
+		synchronous bool
+	}
+	// Copies whose call was not recorded have no thread
+	type callThread struct {
+		id     threadID
+		called bool
+	}
+	byThread := make(map[callThread][]hostCopy)
+	stats := make(map[string]*TransferStats)
+	for id, t := range a.transfers {
+		s := stats[t.direction]
+		if s == nil {
+			s = &TransferStats{Direction: t.direction}
+			stats[t.direction] = s
+		}
+		call, called := a.memcpyCalls[id]
+		c := hostCopy{transfer: t, call: call, synchronous: t.host == "Pageable" || called && !strings.Contains(call.name, "Async")}
+		s.Count++
+		s.Bytes += t.bytes
+		s.TimeNs += t.durNs
+		if t.host == "Pinned" {
+			s.Pinned++
+		}
+		if c.synchronous {
+			s.Synchronous++
+		}
+		thread := callThread{id: call.thread, called: called}
+		byThread[thread] = append(byThread[thread], c)
+	}
+
+	sites := make(map[[2]string]*TransferSite) // By direction and op
+	for thread, copies := range byThread {
+		sort.SliceStable(copies, func(i, j int) bool { return copies[i].call.ts < copies[j].call.ts })
+		var events []cpuEvent
+		if thread.called {
+			events = cpu[thread.id]
+		}
+		eachStack(events, len(copies), func(i int) float64 { return copies[i].call.ts }, func(i int, stack []cpuEvent) {
+			c := copies[i]
+			op := ""
+			for j := len(stack) - 1; j >= 0; j-- {
+				if stack[j].name != c.call.name { // The call itself
+					op = stack[j].name
+					break
+				}
+			}
+			key := [2]string{c.direction, op}
+			site := sites[key]
+			if site == nil {
+				site = &TransferSite{Op: op}
+				sites[key] = site
+			}
+			site.Count++
+			site.Bytes += c.bytes
+			site.TimeNs += c.durNs
+			if c.synchronous {
+				site.Synchronous++
+			}
+		})
+	}
+	for key, site := range sites {
+		stats[key[0]].Sites = append(stats[key[0]].Sites, *site)
+	}
+
+	var result []TransferStats
+	for _, direction := range []string{"HtoD", "DtoH"} {
+		s := stats[direction]
+		if s == nil {
+			continue
+		}
+		if s.TimeNs > 0 {
+			s.BandwidthGBs = float64(s.Bytes) / float64(s.TimeNs) // Bytes per ns are GB/s
+		}
+		sort.Slice(s.Sites, func(i, j int) bool {
+			if s.Sites[i].Synchronous != s.Sites[j].Synchronous {
+				return s.Sites[i].Synchronous > s.Sites[j].Synchronous
+			}
+			if s.Sites[i].TimeNs != s.Sites[j].TimeNs {
+				return s.Sites[i].TimeNs > s.Sites[j].TimeNs
+			}
+			return s.Sites[i].Op < s.Sites[j].Op
+		})
+		s.Sites = s.Sites[:min(len(s.Sites), maxTransferSites)]
+		result = append(result, *s)
+	}
+	return result
+}
@@ -0,0 +1,41 @@
+package converter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeTraceTransfers(t *testing.T) {
+	call := func(name string, ts, id float64) TraceEvent {
+		return correlated(runtimeCall(name, ts, 5), id)
+	}
+	memcpy := func(name string, ts, id, bytes float64) TraceEvent {
+		return withArgs(gpuEvent(name, "gpu_memcpy", 7, ts, 10), map[string]interface{}{"correlation": id, "bytes": bytes})
+	}
+	events := []TraceEvent{
+		cpuOp("aten::to", 0, 100),
+		cpuOp("aten::copy_", 10, 80),
+		call("cudaMemcpyAsync", 20, 1),
+		memcpy("Memcpy HtoD (Pinned -> Device)", 30, 1, 1e6),
+		cpuOp("aten::_local_scalar_dense", 200, 50),
+		call("cudaMemcpyAsync", 210, 2),
+		memcpy("Memcpy DtoH (Device -> Pageable)", 220, 2, 4),
+		call("cudaMemcpy", 300, 3),
+		memcpy("Memcpy HtoD (Pinned -> Device)", 310, 3, 1e6),
+		memcpy("Memcpy DtoD (Device -> Device)", 400, 4, 1e6),
+	}
+	analysis := AnalyzeTrace(&TraceData{TraceEvents: events})
+
+	want := []TransferStats{
+		{Direction: "HtoD", Count: 2, Bytes: 2e6, TimeNs: 20000, BandwidthGBs: 100, Pinned: 2, Synchronous: 1,
+			Sites: []TransferSite{
+				{Op: "", Count: 1, Bytes: 1e6, TimeNs: 10000, Synchronous: 1},
+				{Op: "aten::copy_", Count: 1, Bytes: 1e6, TimeNs: 10000},
+			}},
+		{Direction: "DtoH", Count: 1, Bytes: 4, TimeNs: 10000, BandwidthGBs: 0.0004, Synchronous: 1,
+			Sites: []TransferSite{{Op: "aten::_local_scalar_dense", Count: 1, Bytes: 4, TimeNs: 10000, Synchronous: 1}}},
+	}
+	if !reflect.DeepEqual(analysis.Transfers, want) {
+		t.Errorf("Expected transfers %+v, got %+v", want, analysis.Transfers)
+	}
+}
@@ -0,0 +1,138 @@
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Resources describes what a conversion can use on this machine
+type Resources struct {
+	// CPUs is the number of CPUs the process may run on, within its
+	// cgroup CPU quota
+	CPUs int
+
+	// Memory is the number of bytes still available to the process: the
+	// smaller of the cgroup memory limit less current usage and the memory
+	// the system reports as available, or 0 if neither is known
+	Memory int64
+}
+
+// DetectResources returns the CPUs and memory available to the process,
+// honouring the limits of the container it runs in on Linux
+func DetectResources() Resources {
+	return readResources(os.DirFS("/"), runtime.GOMAXPROCS(0))
+}
+
+// readResources detects the resources from the cgroup and proc files of
+// fsys, starting from cpus usable CPUs
+func readResources(fsys fs.FS, cpus int) Resources {
+	res := Resources{CPUs: cpus}
+	if quota := cgroupCPUQuota(fsys); quota > 0 {
+		res.CPUs = max(min(res.CPUs, int(math.Ceil(quota))), 1)
+	}
+	for _, m := range []int64{cgroupMemory(fsys), memAvailable(fsys)} {
+		if m > 0 && (res.Memory == 0 || m < res.Memory) {
+			res.Memory = m
+		}
+	}
+	return res
+}
+
+// cgroupCPUQuota returns the CPUs the cgroup may use, or 0 without a quota
+func cgroupCPUQuota(fsys fs.FS) float64 {
+	// cgroup v2: "max 100000" or "<quota> <period>"
+	if quota, period, ok := strings.Cut(readFirstLine(fsys, "sys/fs/cgroup/cpu.max"), " "); ok {
+		return ratio(quota, period)
+	}
+	// cgroup v1: a quota of -1 means no limit
+	return ratio(readFirstLine(fsys, "sys/fs/cgroup/cpu/cpu.cfs_quota_us"), readFirstLine(fsys, "sys/fs/cgroup/cpu/cpu.cfs_period_us"))
+}
+
+// ratio returns a/b for positive integers a and b, or 0
+func ratio(a, b string) float64 {
+	x, errX := strconv.ParseInt(a, 10, 64)
+	y, errY := strconv.ParseInt(b, 10, 64)
+	if errX != nil || errY != nil || x <= 0 || y <= 0 {
+		return 0
+	}
+	return float64(x) / float64(y)
+}
+
+// cgroupMemory returns the memory limit of the cgroup less its usage, or
+// 0 without a limit
+func cgroupMemory(fsys fs.FS) int64 {
+	for _, files := range [][2]string{
+		{"sys/fs/cgroup/memory.max", "sys/fs/cgroup/memory.current"},
+		{"sys/fs/cgroup/memory/memory.limit_in_bytes", "sys/fs/cgroup/memory/memory.usage_in_bytes"},
+	} {
+		limit, err := strconv.ParseInt(readFirstLine(fsys, files[0]), 10, 64)
+		// cgroup v1 reports no limit as a number near the largest int64
+		if err != nil || limit <= 0 || limit >= math.MaxInt64/2 {
+			continue
+		}
+		usage, _ := strconv.ParseInt(readFirstLine(fsys, files[1]), 10, 64)
+		return max(limit-usage, 1)
+	}
+	return 0
+}
+
+// memAvailable returns MemAvailable of /proc/meminfo in bytes, or 0
+func memAvailable(fsys fs.FS) int64 {
+	data, err := fs.ReadFile(fsys, "proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		if rest, ok := strings.CutPrefix(sc.Text(), "MemAvailable:"); ok {
+			kb, err := strconv.ParseInt(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(rest), "kB")), 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb << 10
+		}
+	}
+	return 0
+}
+
+// readFirstLine returns the first line of a file of fsys, or "" if it
+// cannot be read
+func readFirstLine(fsys fs.FS, name string) string {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return ""
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	return strings.TrimSpace(line)
+}
+
+// workerEvents is the fewest events worth a conversion worker of their own
+const workerEvents = 50000
+
+// worstCaseSampleBytes is the estimated memory of an aggregated sample with
+// a stack 16 frames deep, as counted by the aggregator
+const worstCaseSampleBytes = sampleEntryOverhead + 16*(4+8)
+
+// AutoTune fills in the resource options left unset in opts for converting
+// traceData with res. NumWorkers becomes one per CPU, but no more than the
+// number of events warrants. MaxMemory becomes half the available memory,
+// if it is known and could be exceeded were every event a unique stack, so
+// spilling to disk is only enabled where it may be needed.
+func AutoTune(opts ConvertOptions, traceData *TraceData, res Resources) ConvertOptions {
+	events := len(traceData.TraceEvents)
+	if opts.NumWorkers == 0 {
+		opts.NumWorkers = max(min(res.CPUs, events/workerEvents), 1)
+	}
+	if opts.MaxMemory == 0 && res.Memory > 0 {
+		if budget := res.Memory / 2; int64(events)*worstCaseSampleBytes > budget {
+			opts.MaxMemory = budget
+		}
+	}
+	return opts
+}
@@ -0,0 +1,344 @@
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Parse reads a pprof profile, gunzipping it if needed
+func Parse(r io.Reader) (*Profile, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		data, err = io.ReadAll(gz)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return Decode(data)
+}
+
+// Decode decodes an uncompressed protobuf-encoded pprof profile.
+// Unknown fields are skipped.
+func Decode(data []byte) (*Profile, error) {
+	p := &Profile{}
+	err := decodeMessage(data, func(field int, d *decoder) error {
+		switch field {
+		case 1:
+			vt := &ValueType{}
+			p.SampleType = append(p.SampleType, vt)
+			return d.message(vt.decode)
+		case 2:
+			s := &Sample{}
+			p.Sample = append(p.Sample, s)
+			return d.message(s.decode)
+		case 3:
+			m := &Mapping{}
+			p.Mapping = append(p.Mapping, m)
+			return d.message(m.decode)
+		case 4:
+			loc := &Location{}
+			p.Location = append(p.Location, loc)
+			return d.message(loc.decode)
+		case 5:
+			fn := &Function{}
+			p.Function = append(p.Function, fn)
+			return d.message(fn.decode)
+		case 6:
+			b, err := d.bytes()
+			p.StringTable = append(p.StringTable, string(b))
+			return err
+		case 7:
+			return d.int64(&p.DropFrames)
+		case 8:
+			return d.int64(&p.KeepFrames)
+		case 9:
+			return d.int64(&p.TimeNanos)
+		case 10:
+			return d.int64(&p.DurationNanos)
+		case 11:
+			p.PeriodType = &ValueType{}
+			return d.message(p.PeriodType.decode)
+		case 12:
+			return d.int64(&p.Period)
+		case 13:
+			return d.repeatedInt64(&p.Comment)
+		case 14:
+			return d.int64(&p.DefaultSampleType)
+		}
+		return d.skip()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(p.StringTable) == 0 {
+		// A valid profile always has at least the empty string
+		p.StringTable = []string{""}
+	}
+	return p, nil
+}
+
+func (vt *ValueType) decode(field int, d *decoder) error {
+	switch field {
+	case 1:
+		return d.int64(&vt.Type)
+	case 2:
+		return d.int64(&vt.Unit)
+	}
+	return d.skip()
+}
+
+func (s *Sample) decode(field int, d *decoder) error {
+	switch field {
+	case 1:
+		return d.repeatedUint64(&s.LocationId)
+	case 2:
+		return d.repeatedInt64(&s.Value)
+	case 3:
+		l := &Label{}
+		s.Label = append(s.Label, l)
+		return d.message(l.decode)
+	}
+	return d.skip()
+}
+
+func (l *Label) decode(field int, d *decoder) error {
+	switch field {
+	case 1:
+		return d.int64(&l.Key)
+	case 2:
+		return d.int64(&l.Str)
+	case 3:
+		return d.int64(&l.Num)
+	case 4:
+		return d.int64(&l.NumUnit)
+	}
+	return d.skip()
+}
+
+func (m *Mapping) decode(field int, d *decoder) error {
+	switch field {
+	case 1:
+		return d.uint64(&m.Id)
+	case 2:
+		return d.uint64(&m.MemoryStart)
+	case 3:
+		return d.uint64(&m.MemoryLimit)
+	case 4:
+		return d.uint64(&m.FileOffset)
+	case 5:
+		return d.int64(&m.Filename)
+	case 6:
+		return d.int64(&m.BuildId)
+	case 7:
+		return d.bool(&m.HasFunctions)
+	case 8:
+		return d.bool(&m.HasFilenames)
+	case 9:
+		return d.bool(&m.HasLineNumbers)
+	case 10:
+		return d.bool(&m.HasInlineFrames)
+	}
+	return d.skip()
+}
+
+func (loc *Location) decode(field int, d *decoder) error {
+	switch field {
+	case 1:
+		return d.uint64(&loc.Id)
+	case 2:
+		return d.uint64(&loc.MappingId)
+	case 3:
+		return d.uint64(&loc.Address)
+	case 4:
+		line := &Line{}
+		loc.Line = append(loc.Line, line)
+		return d.message(line.decode)
+	case 5:
+		return d.bool(&loc.IsFolded)
+	}
+	return d.skip()
+}
+
+func (line *Line) decode(field int, d *decoder) error {
+	switch field {
+	case 1:
+		return d.uint64(&line.FunctionId)
+	case 2:
+		return d.int64(&line.Line)
+	case 3:
+		return d.int64(&line.Column)
+	}
+	return d.skip()
+}
+
+func (fn *Function) decode(field int, d *decoder) error {
+	switch field {
+	case 1:
+		return d.uint64(&fn.Id)
+	case 2:
+		return d.int64(&fn.Name)
+	case 3:
+		return d.int64(&fn.SystemName)
+	case 4:
+		return d.int64(&fn.Filename)
+	case 5:
+		return d.int64(&fn.StartLine)
+	}
+	return d.skip()
+}
+
+// errTruncated is returned for messages ending in the middle of a field
+var errTruncated = errors.New("profile: truncated protobuf message")
+
+// decoder reads the value of the current field of a protobuf message
+type decoder struct {
+	data     []byte
+	wireType int
+}
+
+// decodeMessage calls fn for each field of the message in data
+func decodeMessage(data []byte, fn func(field int, d *decoder) error) error {
+	d := &decoder{data: data}
+	for len(d.data) > 0 {
+		key, err := d.varint()
+		if err != nil {
+			return err
+		}
+		d.wireType = int(key & 7)
+		if err := fn(int(key>>3), d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *decoder) varint() (uint64, error) {
+	var v uint64
+	for i := 0; i < len(d.data) && i < 10; i++ {
+		b := d.data[i]
+		v |= uint64(b&0x7f) << (7 * uint(i))
+		if b < 0x80 {
+			d.data = d.data[i+1:]
+			return v, nil
+		}
+	}
+	return 0, errTruncated
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	if d.wireType != 2 {
+		return nil, fmt.Errorf("profile: expected length-delimited field, got wire type %d", d.wireType)
+	}
+	n, err := d.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(d.data)) {
+		return nil, errTruncated
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b, nil
+}
+
+func (d *decoder) message(fn func(field int, d *decoder) error) error {
+	b, err := d.bytes()
+	if err != nil {
+		return err
+	}
+	return decodeMessage(b, fn)
+}
+
+func (d *decoder) uint64(v *uint64) error {
+	if d.wireType != 0 {
+		return fmt.Errorf("profile: expected varint field, got wire type %d", d.wireType)
+	}
+	x, err := d.varint()
+	*v = x
+	return err
+}
+
+func (d *decoder) int64(v *int64) error {
+	var x uint64
+	err := d.uint64(&x)
+	*v = int64(x)
+	return err
+}
+
+func (d *decoder) bool(v *bool) error {
+	var x uint64
+	err := d.uint64(&x)
+	*v = x != 0
+	return err
+}
+
+// repeatedUint64 reads a packed or unpacked repeated varint field
+func (d *decoder) repeatedUint64(v *[]uint64) error {
+	if d.wireType != 2 {
+		var x uint64
+		if err := d.uint64(&x); err != nil {
+			return err
+		}
+		*v = append(*v, x)
+		return nil
+	}
+	b, err := d.bytes()
+	if err != nil {
+		return err
+	}
+	packed := &decoder{data: b}
+	for len(packed.data) > 0 {
+		x, err := packed.varint()
+		if err != nil {
+			return err
+		}
+		*v = append(*v, x)
+	}
+	return nil
+}
+
+func (d *decoder) repeatedInt64(v *[]int64) error {
+	var u []uint64
+	if err := d.repeatedUint64(&u); err != nil {
+		return err
+	}
+	for _, x := range u {
+		*v = append(*v, int64(x))
+	}
+	return nil
+}
+
+// skip discards the value of an unknown field
+func (d *decoder) skip() error {
+	switch d.wireType {
+	case 0:
+		_, err := d.varint()
+		return err
+	case 1:
+		if len(d.data) < 8 {
+			return errTruncated
+		}
+		d.data = d.data[8:]
+	case 2:
+		_, err := d.bytes()
+		return err
+	case 5:
+		if len(d.data) < 4 {
+			return errTruncated
+		}
+		d.data = d.data[4:]
+	default:
+		return fmt.Errorf("profile: unsupported wire type %d", d.wireType)
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package profile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Merge combines profiles with identical sample types into a single profile,
+// unifying their string, function, location and mapping tables. Samples with
+// the same stack and labels are aggregated by summing their values.
+func Merge(profiles []*Profile) (*Profile, error) {
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("profile: no profiles to merge")
+	}
+
+	m := &merger{
+		out:       &Profile{StringTable: []string{""}},
+		strings:   map[string]int64{"": 0},
+		mappings:  map[string]uint64{},
+		functions: map[string]uint64{},
+		locations: map[string]uint64{},
+		samples:   map[string]*Sample{},
+	}
+
+	first := profiles[0]
+	for _, vt := range first.SampleType {
+		m.out.SampleType = append(m.out.SampleType, m.valueType(first, vt))
+	}
+	if first.PeriodType != nil {
+		m.out.PeriodType = m.valueType(first, first.PeriodType)
+	}
+	m.out.Period = first.Period
+	m.out.DefaultSampleType = m.str(first, first.DefaultSampleType)
+
+	var end int64
+	for i, p := range profiles {
+		if err := m.checkSampleTypes(p); err != nil {
+			return nil, fmt.Errorf("profile %d: %w", i, err)
+		}
+		if p.TimeNanos != 0 && (m.out.TimeNanos == 0 || p.TimeNanos < m.out.TimeNanos) {
+			m.out.TimeNanos = p.TimeNanos
+		}
+		if e := p.TimeNanos + p.DurationNanos; e > end {
+			end = e
+		}
+		for _, c := range p.Comment {
+			m.out.Comment = appendUnique(m.out.Comment, m.str(p, c))
+		}
+		if err := m.addSamples(p); err != nil {
+			return nil, fmt.Errorf("profile %d: %w", i, err)
+		}
+	}
+	if m.out.TimeNanos != 0 {
+		m.out.DurationNanos = end - m.out.TimeNanos
+	}
+
+	return m.out, nil
+}
+
+// merger holds the deduplication indices used while merging
+type merger struct {
+	out       *Profile
+	strings   map[string]int64
+	mappings  map[string]uint64
+	functions map[string]uint64
+	locations map[string]uint64
+	samples   map[string]*Sample
+}
+
+// str re-interns a string index of p into the merged string table
+func (m *merger) str(p *Profile, idx int64) int64 {
+	s := lookupString(p, idx)
+	if i, ok := m.strings[s]; ok {
+		return i
+	}
+	i := int64(len(m.out.StringTable))
+	m.out.StringTable = append(m.out.StringTable, s)
+	m.strings[s] = i
+	return i
+}
+
+func (m *merger) valueType(p *Profile, vt *ValueType) *ValueType {
+	return &ValueType{Type: m.str(p, vt.Type), Unit: m.str(p, vt.Unit)}
+}
+
+func (m *merger) checkSampleTypes(p *Profile) error {
+	if len(p.SampleType) != len(m.out.SampleType) {
+		return fmt.Errorf("has %d sample types, expected %d", len(p.SampleType), len(m.out.SampleType))
+	}
+	for i, vt := range p.SampleType {
+		want := m.out.SampleType[i]
+		gotType, gotUnit := lookupString(p, vt.Type), lookupString(p, vt.Unit)
+		if gotType != m.out.StringTable[want.Type] || gotUnit != m.out.StringTable[want.Unit] {
+			return fmt.Errorf("sample type %d is %s/%s, expected %s/%s", i, gotType, gotUnit,
+				m.out.StringTable[want.Type], m.out.StringTable[want.Unit])
+		}
+	}
+	return nil
+}
+
+func (m *merger) addSamples(p *Profile) error {
+	mappingIds := make(map[uint64]uint64, len(p.Mapping))
+	for _, mp := range p.Mapping {
+		mappingIds[mp.Id] = m.mapping(p, mp)
+	}
+	functionIds := make(map[uint64]uint64, len(p.Function))
+	for _, fn := range p.Function {
+		functionIds[fn.Id] = m.function(p, fn)
+	}
+	locationIds := make(map[uint64]uint64, len(p.Location))
+	for _, loc := range p.Location {
+		id, err := m.location(loc, mappingIds, functionIds)
+		if err != nil {
+			return err
+		}
+		locationIds[loc.Id] = id
+	}
+
+	for _, s := range p.Sample {
+		if len(s.Value) != len(m.out.SampleType) {
+			return fmt.Errorf("sample has %d values, expected %d", len(s.Value), len(m.out.SampleType))
+		}
+		merged := &Sample{LocationId: make([]uint64, len(s.LocationId))}
+		var key strings.Builder
+		for i, id := range s.LocationId {
+			newId, ok := locationIds[id]
+			if !ok {
+				return fmt.Errorf("sample references unknown location %d", id)
+			}
+			merged.LocationId[i] = newId
+			key.WriteString(strconv.FormatUint(newId, 10))
+			key.WriteByte(',')
+		}
+		for _, l := range s.Label {
+			nl := &Label{Key: m.str(p, l.Key), Str: m.str(p, l.Str), Num: l.Num, NumUnit: m.str(p, l.NumUnit)}
+			merged.Label = append(merged.Label, nl)
+			fmt.Fprintf(&key, "|%d=%d/%d/%d", nl.Key, nl.Str, nl.Num, nl.NumUnit)
+		}
+
+		if existing, ok := m.samples[key.String()]; ok {
+			for i, v := range s.Value {
+				existing.Value[i] += v
+			}
+			continue
+		}
+		merged.Value = append([]int64(nil), s.Value...)
+		m.samples[key.String()] = merged
+		m.out.Sample = append(m.out.Sample, merged)
+	}
+	return nil
+}
+
+func (m *merger) mapping(p *Profile, mp *Mapping) uint64 {
+	nm := *mp
+	nm.Filename = m.str(p, mp.Filename)
+	nm.BuildId = m.str(p, mp.BuildId)
+	nm.Id = 0
+	key := fmt.Sprintf("%+v", nm)
+	if id, ok := m.mappings[key]; ok {
+		return id
+	}
+	nm.Id = uint64(len(m.out.Mapping) + 1)
+	m.out.Mapping = append(m.out.Mapping, &nm)
+	m.mappings[key] = nm.Id
+	return nm.Id
+}
+
+func (m *merger) function(p *Profile, fn *Function) uint64 {
+	nf := &Function{
+		Name:       m.str(p, fn.Name),
+		SystemName: m.str(p, fn.SystemName),
+		Filename:   m.str(p, fn.Filename),
+		StartLine:  fn.StartLine,
+	}
+	key := fmt.Sprintf("%d/%d/%d/%d", nf.Name, nf.SystemName, nf.Filename, nf.StartLine)
+	if id, ok := m.functions[key]; ok {
+		return id
+	}
+	nf.Id = uint64(len(m.out.Function) + 1)
+	m.out.Function = append(m.out.Function, nf)
+	m.functions[key] = nf.Id
+	return nf.Id
+}
+
+func (m *merger) location(loc *Location, mappingIds, functionIds map[uint64]uint64) (uint64, error) {
+	nl := &Location{
+		MappingId: mappingIds[loc.MappingId],
+		Address:   loc.Address,
+		IsFolded:  loc.IsFolded,
+	}
+	var key strings.Builder
+	fmt.Fprintf(&key, "%d/%d/%t", nl.MappingId, nl.Address, nl.IsFolded)
+	for _, line := range loc.Line {
+		fnId, ok := functionIds[line.FunctionId]
+		if !ok {
+			return 0, fmt.Errorf("location %d references unknown function %d", loc.Id, line.FunctionId)
+		}
+		nl.Line = append(nl.Line, &Line{FunctionId: fnId, Line: line.Line, Column: line.Column})
+		fmt.Fprintf(&key, "|%d:%d:%d", fnId, line.Line, line.Column)
+	}
+	if id, ok := m.locations[key.String()]; ok {
+		return id, nil
+	}
+	nl.Id = uint64(len(m.out.Location) + 1)
+	m.out.Location = append(m.out.Location, nl)
+	m.locations[key.String()] = nl.Id
+	return nl.Id, nil
+}
+
+// SetLabel sets a string label on every sample, replacing any label with
+// the same key. Labeling profiles before merging them keeps their samples
+// apart, e.g. by source file.
+func (p *Profile) SetLabel(key, value string) {
+	keyIdx, valueIdx := p.stringIndex(key), p.stringIndex(value)
+	for _, s := range p.Sample {
+		labels := s.Label[:0]
+		for _, l := range s.Label {
+			if l.Key != keyIdx {
+				labels = append(labels, l)
+			}
+		}
+		s.Label = append(labels, &Label{Key: keyIdx, Str: valueIdx})
+	}
+}
+
+// stringIndex returns the index of s in the string table, adding it if needed
+func (p *Profile) stringIndex(s string) int64 {
+	for i, str := range p.StringTable {
+		if str == s {
+			return int64(i)
+		}
+	}
+	p.StringTable = append(p.StringTable, s)
+	return int64(len(p.StringTable) - 1)
+}
+
+// lookupString returns the string at idx, or "" if it is out of range
+func lookupString(p *Profile, idx int64) string {
+	if idx < 0 || idx >= int64(len(p.StringTable)) {
+		return ""
+	}
+	return p.StringTable[idx]
+}
+
+func appendUnique(s []int64, v int64) []int64 {
+	for _, x := range s {
+		if x == v {
+			return s
+		}
+	}
+	return append(s, v)
+}
+
+// Diff returns a delta profile holding candidate minus base. Stacks that got
+// faster have negative values; stacks that are unchanged sum to zero and are
+// dropped.
+func Diff(base, candidate *Profile) (*Profile, error) {
+	negated := *base
+	negated.Sample = make([]*Sample, len(base.Sample))
+	for i, s := range base.Sample {
+		ns := *s
+		ns.Value = make([]int64, len(s.Value))
+		for j, v := range s.Value {
+			ns.Value[j] = -v
+		}
+		negated.Sample[i] = &ns
+	}
+
+	delta, err := Merge([]*Profile{candidate, &negated})
+	if err != nil {
+		return nil, err
+	}
+
+	kept := delta.Sample[:0]
+	for _, s := range delta.Sample {
+		for _, v := range s.Value {
+			if v != 0 {
+				kept = append(kept, s)
+				break
+			}
+		}
+	}
+	delta.Sample = kept
+	return delta, nil
+}
+
+// LeafTotals sums the value at index for each leaf function name
+func (p *Profile) LeafTotals(index int) map[string]int64 {
+	locationNames := p.locationNames()
+	totals := make(map[string]int64)
+	for _, s := range p.Sample {
+		if len(s.LocationId) == 0 || index >= len(s.Value) {
+			continue
+		}
+		if frames := locationNames[s.LocationId[0]]; len(frames) > 0 {
+			totals[frames[len(frames)-1]] += s.Value[index]
+		}
+	}
+	return totals
+}
@@ -0,0 +1,761 @@
+// Package profile implements the pprof profile format (profile.proto): a
+// Builder to create profiles, encoding to and decoding from gzip-compressed
+// protobuf, merging, diffing and validation, and call tree and top table
+// views of the samples. It has no dependencies outside the standard library.
+package profile
+
+import (
+	"fmt"
+	"hash/maphash"
+	"io"
+	"sort"
+	"sync"
+)
+
+// ValueType represents the value type for samples in a pprof profile
+type ValueType struct {
+	Type int64
+	Unit int64
+}
+
+// Sample represents a single sample in a pprof profile
+type Sample struct {
+	LocationId []uint64
+	Value      []int64
+	Label      []*Label
+}
+
+// Label attaches a string or numeric key/value to a sample.
+// Key, Str and NumUnit are string table indices.
+type Label struct {
+	Key     int64
+	Str     int64
+	Num     int64
+	NumUnit int64
+}
+
+// Line represents a line of code in a function
+type Line struct {
+	FunctionId uint64
+	Line       int64
+	Column     int64
+}
+
+// Location represents a location (line of code) in the profile
+type Location struct {
+	Id        uint64
+	MappingId uint64
+	Address   uint64
+	Line      []*Line
+	IsFolded  bool
+}
+
+// Function represents a function in the profile
+type Function struct {
+	Id         uint64
+	Name       int64
+	SystemName int64
+	Filename   int64
+	StartLine  int64
+}
+
+// Mapping represents a binary mapping. Profiles produced from traces have
+// none, but they are preserved when decoding and merging other profiles.
+type Mapping struct {
+	Id              uint64
+	MemoryStart     uint64
+	MemoryLimit     uint64
+	FileOffset      uint64
+	Filename        int64
+	BuildId         int64
+	HasFunctions    bool
+	HasFilenames    bool
+	HasLineNumbers  bool
+	HasInlineFrames bool
+}
+
+// Profile represents a pprof profile
+type Profile struct {
+	SampleType        []*ValueType
+	Sample            []*Sample
+	Mapping           []*Mapping
+	Location          []*Location
+	Function          []*Function
+	StringTable       []string
+	DropFrames        int64
+	KeepFrames        int64
+	TimeNanos         int64
+	DurationNanos     int64
+	PeriodType        *ValueType
+	Period            int64
+	Comment           []int64
+	DefaultSampleType int64
+}
+
+// Encode encodes the profile to protobuf format. The size of the output is
+// computed first, so it is written into a single allocation.
+func (p *Profile) Encode() ([]byte, error) {
+	e := encoder{buf: make([]byte, 0, p.size())}
+	p.encode(&e)
+	return e.buf, nil
+}
+
+// encodeChunkSize is how much of the encoded profile WriteTo buffers before
+// writing it
+const encodeChunkSize = 64 << 10
+
+// WriteTo writes the profile in protobuf format to w as it is encoded,
+// without holding the whole encoding in memory
+func (p *Profile) WriteTo(w io.Writer) (int64, error) {
+	e := encoder{buf: make([]byte, 0, 2*encodeChunkSize), w: w}
+	p.encode(&e)
+	e.flush()
+	return e.n, e.err
+}
+
+// encoder collects an encoded profile in buf, writing it to w, if set,
+// whenever a top-level field ends past encodeChunkSize
+type encoder struct {
+	buf []byte
+	w   io.Writer
+	n   int64
+	err error
+}
+
+// next is called after each top-level field
+func (e *encoder) next() {
+	if e.w != nil && len(e.buf) >= encodeChunkSize {
+		e.flush()
+	}
+}
+
+// flush writes buf to w, unless an earlier write failed
+func (e *encoder) flush() {
+	if e.err == nil && len(e.buf) > 0 {
+		var n int
+		n, e.err = e.w.Write(e.buf)
+		e.n += int64(n)
+	}
+	e.buf = e.buf[:0]
+}
+
+// size returns the encoded size of the profile
+func (p *Profile) size() int {
+	n := 0
+	for _, vt := range p.SampleType {
+		n += messageSize(1, valueTypeSize(vt))
+	}
+	for _, s := range p.Sample {
+		n += messageSize(2, sampleSize(s))
+	}
+	for _, m := range p.Mapping {
+		n += messageSize(3, mappingSize(m))
+	}
+	for _, loc := range p.Location {
+		n += messageSize(4, locationSize(loc))
+	}
+	for _, fn := range p.Function {
+		n += messageSize(5, functionSize(fn))
+	}
+	for _, s := range p.StringTable {
+		n += messageSize(6, len(s))
+	}
+	n += optionalFieldSize(7, uint64(p.DropFrames))
+	n += optionalFieldSize(8, uint64(p.KeepFrames))
+	n += optionalFieldSize(9, uint64(p.TimeNanos))
+	n += optionalFieldSize(10, uint64(p.DurationNanos))
+	if p.PeriodType != nil {
+		n += messageSize(11, valueTypeSize(p.PeriodType))
+	}
+	n += optionalFieldSize(12, uint64(p.Period))
+	for _, c := range p.Comment {
+		n += fieldSize(13, uint64(c))
+	}
+	n += optionalFieldSize(14, uint64(p.DefaultSampleType))
+	return n
+}
+
+// encode encodes the profile field by field into e
+func (p *Profile) encode(e *encoder) {
+	for _, vt := range p.SampleType {
+		e.buf = appendMessageHeader(e.buf, 1, valueTypeSize(vt))
+		e.buf = appendValueType(e.buf, vt)
+		e.next()
+	}
+	for _, s := range p.Sample {
+		e.buf = appendMessageHeader(e.buf, 2, sampleSize(s))
+		e.buf = appendSample(e.buf, s)
+		e.next()
+	}
+	for _, m := range p.Mapping {
+		e.buf = appendMessageHeader(e.buf, 3, mappingSize(m))
+		e.buf = appendMapping(e.buf, m)
+		e.next()
+	}
+	for _, loc := range p.Location {
+		e.buf = appendMessageHeader(e.buf, 4, locationSize(loc))
+		e.buf = appendLocation(e.buf, loc)
+		e.next()
+	}
+	for _, fn := range p.Function {
+		e.buf = appendMessageHeader(e.buf, 5, functionSize(fn))
+		e.buf = appendFunction(e.buf, fn)
+		e.next()
+	}
+	for _, s := range p.StringTable {
+		e.buf = appendMessageHeader(e.buf, 6, len(s))
+		e.buf = append(e.buf, s...)
+		e.next()
+	}
+	e.buf = appendOptionalField(e.buf, 7, uint64(p.DropFrames))
+	e.buf = appendOptionalField(e.buf, 8, uint64(p.KeepFrames))
+	e.buf = appendOptionalField(e.buf, 9, uint64(p.TimeNanos))
+	e.buf = appendOptionalField(e.buf, 10, uint64(p.DurationNanos))
+	if p.PeriodType != nil {
+		e.buf = appendMessageHeader(e.buf, 11, valueTypeSize(p.PeriodType))
+		e.buf = appendValueType(e.buf, p.PeriodType)
+	}
+	e.buf = appendOptionalField(e.buf, 12, uint64(p.Period))
+	for _, c := range p.Comment {
+		e.buf = appendField(e.buf, 13, uint64(c))
+	}
+	e.buf = appendOptionalField(e.buf, 14, uint64(p.DefaultSampleType))
+}
+
+// varintSize returns the encoded size of v
+func varintSize(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		n++
+		v >>= 7
+	}
+	return n
+}
+
+func appendVarint(buf []byte, v uint64) []byte {
+	for v >= 0x80 {
+		buf = append(buf, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(buf, byte(v))
+}
+
+func appendTag(buf []byte, fieldNum, wireType int) []byte {
+	return appendVarint(buf, uint64(fieldNum<<3|wireType))
+}
+
+// fieldSize returns the encoded size of a varint field. Field numbers of
+// profile.proto are below 16, so tags take one byte.
+func fieldSize(fieldNum int, v uint64) int {
+	return 1 + varintSize(v)
+}
+
+func appendField(buf []byte, fieldNum int, v uint64) []byte {
+	return appendVarint(appendTag(buf, fieldNum, 0), v)
+}
+
+// optionalFieldSize returns the encoded size of a varint field that is
+// left out when zero
+func optionalFieldSize(fieldNum int, v uint64) int {
+	if v == 0 {
+		return 0
+	}
+	return fieldSize(fieldNum, v)
+}
+
+func appendOptionalField(buf []byte, fieldNum int, v uint64) []byte {
+	if v == 0 {
+		return buf
+	}
+	return appendField(buf, fieldNum, v)
+}
+
+// messageSize returns the encoded size of a length-delimited field with
+// size bytes of content
+func messageSize(fieldNum int, size int) int {
+	return 1 + varintSize(uint64(size)) + size
+}
+
+// appendMessageHeader appends the tag and length of a length-delimited
+// field, to be followed by size bytes of content
+func appendMessageHeader(buf []byte, fieldNum int, size int) []byte {
+	return appendVarint(appendTag(buf, fieldNum, 2), uint64(size))
+}
+
+func valueTypeSize(vt *ValueType) int {
+	return fieldSize(1, uint64(vt.Type)) + fieldSize(2, uint64(vt.Unit))
+}
+
+func appendValueType(buf []byte, vt *ValueType) []byte {
+	buf = appendField(buf, 1, uint64(vt.Type))
+	return appendField(buf, 2, uint64(vt.Unit))
+}
+
+func sampleSize(s *Sample) int {
+	n := 0
+	if len(s.LocationId) > 0 {
+		n += messageSize(1, packedSize(s.LocationId))
+	}
+	if len(s.Value) > 0 {
+		n += messageSize(2, packedSize(s.Value))
+	}
+	for _, l := range s.Label {
+		n += messageSize(3, labelSize(l))
+	}
+	return n
+}
+
+func appendSample(buf []byte, s *Sample) []byte {
+	if len(s.LocationId) > 0 {
+		buf = appendMessageHeader(buf, 1, packedSize(s.LocationId))
+		for _, id := range s.LocationId {
+			buf = appendVarint(buf, id)
+		}
+	}
+	if len(s.Value) > 0 {
+		buf = appendMessageHeader(buf, 2, packedSize(s.Value))
+		for _, v := range s.Value {
+			buf = appendVarint(buf, uint64(v))
+		}
+	}
+	for _, l := range s.Label {
+		buf = appendMessageHeader(buf, 3, labelSize(l))
+		buf = appendLabel(buf, l)
+	}
+	return buf
+}
+
+// packedSize returns the encoded size of the values of a packed repeated
+// varint field
+func packedSize[T uint64 | int64](values []T) int {
+	n := 0
+	for _, v := range values {
+		n += varintSize(uint64(v))
+	}
+	return n
+}
+
+func labelSize(l *Label) int {
+	return fieldSize(1, uint64(l.Key)) + optionalFieldSize(2, uint64(l.Str)) +
+		optionalFieldSize(3, uint64(l.Num)) + optionalFieldSize(4, uint64(l.NumUnit))
+}
+
+func appendLabel(buf []byte, l *Label) []byte {
+	buf = appendField(buf, 1, uint64(l.Key))
+	buf = appendOptionalField(buf, 2, uint64(l.Str))
+	buf = appendOptionalField(buf, 3, uint64(l.Num))
+	return appendOptionalField(buf, 4, uint64(l.NumUnit))
+}
+
+// mappingFields returns the varint fields of a mapping after its ID
+func mappingFields(m *Mapping) [9]uint64 {
+	return [9]uint64{
+		m.MemoryStart,
+		m.MemoryLimit,
+		m.FileOffset,
+		uint64(m.Filename),
+		uint64(m.BuildId),
+		encodeBool(m.HasFunctions),
+		encodeBool(m.HasFilenames),
+		encodeBool(m.HasLineNumbers),
+		encodeBool(m.HasInlineFrames),
+	}
+}
+
+func mappingSize(m *Mapping) int {
+	n := fieldSize(1, m.Id)
+	for i, v := range mappingFields(m) {
+		n += optionalFieldSize(i+2, v)
+	}
+	return n
+}
+
+func appendMapping(buf []byte, m *Mapping) []byte {
+	buf = appendField(buf, 1, m.Id)
+	for i, v := range mappingFields(m) {
+		buf = appendOptionalField(buf, i+2, v)
+	}
+	return buf
+}
+
+func encodeBool(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func locationSize(loc *Location) int {
+	n := fieldSize(1, loc.Id) + optionalFieldSize(2, loc.MappingId) + optionalFieldSize(3, loc.Address)
+	for _, line := range loc.Line {
+		n += messageSize(4, lineSize(line))
+	}
+	return n + optionalFieldSize(5, encodeBool(loc.IsFolded))
+}
+
+func appendLocation(buf []byte, loc *Location) []byte {
+	buf = appendField(buf, 1, loc.Id)
+	buf = appendOptionalField(buf, 2, loc.MappingId)
+	buf = appendOptionalField(buf, 3, loc.Address)
+	for _, line := range loc.Line {
+		buf = appendMessageHeader(buf, 4, lineSize(line))
+		buf = appendLine(buf, line)
+	}
+	return appendOptionalField(buf, 5, encodeBool(loc.IsFolded))
+}
+
+func lineSize(line *Line) int {
+	return fieldSize(1, line.FunctionId) + optionalFieldSize(2, uint64(line.Line)) + optionalFieldSize(3, uint64(line.Column))
+}
+
+func appendLine(buf []byte, line *Line) []byte {
+	buf = appendField(buf, 1, line.FunctionId)
+	buf = appendOptionalField(buf, 2, uint64(line.Line))
+	return appendOptionalField(buf, 3, uint64(line.Column))
+}
+
+func functionSize(fn *Function) int {
+	return fieldSize(1, fn.Id) + fieldSize(2, uint64(fn.Name)) + fieldSize(3, uint64(fn.SystemName)) +
+		fieldSize(4, uint64(fn.Filename)) + optionalFieldSize(5, uint64(fn.StartLine))
+}
+
+func appendFunction(buf []byte, fn *Function) []byte {
+	buf = appendField(buf, 1, fn.Id)
+	buf = appendField(buf, 2, uint64(fn.Name))
+	buf = appendField(buf, 3, uint64(fn.SystemName))
+	buf = appendField(buf, 4, uint64(fn.Filename))
+	return appendOptionalField(buf, 5, uint64(fn.StartLine))
+}
+
+// Builder provides thread-safe profile construction. Lookups of existing
+// strings, functions and locations only lock one of several index shards,
+// so that many goroutines can build stacks at once; mu guards the tables
+// of the profile, which are only appended to when an entry is created, and
+// the slabs its entries are allocated from.
+type Builder struct {
+	profile       *Profile
+	stringIndex   shardedIndex[string, int64]
+	functionIndex shardedIndex[frameKey, uint64]
+	locationIndex shardedIndex[frameKey, uint64]
+	mu            sync.RWMutex
+
+	samples     slab[Sample]
+	locations   slab[Location]
+	lines       slab[Line]
+	functions   slab[Function]
+	labels      slab[Label]
+	linePtrs    slab[*Line]
+	labelPtrs   slab[*Label]
+	values      slab[int64]
+	locationIds slab[uint64]
+}
+
+// frameKey identifies the function and location of a frame by the string
+// table indices of its name and filename, which hash much faster than the
+// strings themselves, long kernel names in particular
+type frameKey struct {
+	name, filename int64
+}
+
+// indexShards is the number of shards of each Builder index
+const indexShards = 64
+
+// indexSeed hashes keys to index shards
+var indexSeed = maphash.MakeSeed()
+
+// shardedIndex maps keys to table entries, with keys spread over shards
+// locked separately
+type shardedIndex[K comparable, V any] struct {
+	shards [indexShards]indexShard[K, V]
+}
+
+// indexShard is one shard of a shardedIndex
+type indexShard[K comparable, V any] struct {
+	mu sync.RWMutex
+	m  map[K]V
+}
+
+// getOrCreate returns the value of key, calling create for a new key with
+// its shard locked, so each key is created once
+func (x *shardedIndex[K, V]) getOrCreate(key K, create func() V) V {
+	return x.getOrCreateHashed(maphash.Comparable(indexSeed, key), key, create)
+}
+
+// getOrCreateHashed is getOrCreate for a key whose hash is already known.
+// A given key must always come with the same hash.
+func (x *shardedIndex[K, V]) getOrCreateHashed(hash uint64, key K, create func() V) V {
+	shard := &x.shards[hash%indexShards]
+	shard.mu.RLock()
+	v, ok := shard.m[key]
+	shard.mu.RUnlock()
+	if ok {
+		return v
+	}
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	// Double-check after acquiring write lock
+	if v, ok := shard.m[key]; ok {
+		return v
+	}
+	v = create()
+	if shard.m == nil {
+		shard.m = make(map[K]V)
+	}
+	shard.m[key] = v
+	return v
+}
+
+// slabSize is the number of entries in each chunk of a slab
+const slabSize = 1024
+
+// slab hands out entries from chunks of slabSize, so that a profile of
+// millions of samples and locations is made of a few thousand heap objects
+// rather than one per entry, which the garbage collector would have to
+// track. A chunk lives as long as any of its entries.
+type slab[T any] struct {
+	chunk []T
+}
+
+// alloc returns n zeroed entries. Large requests get their own array.
+func (s *slab[T]) alloc(n int) []T {
+	if n > slabSize/8 {
+		return make([]T, n)
+	}
+	if cap(s.chunk)-len(s.chunk) < n {
+		s.chunk = make([]T, 0, slabSize)
+	}
+	start := len(s.chunk)
+	s.chunk = s.chunk[:start+n]
+	return s.chunk[start : start+n : start+n]
+}
+
+// new returns a zeroed entry
+func (s *slab[T]) new() *T {
+	return &s.alloc(1)[0]
+}
+
+// NewBuilder creates a new profile builder
+func NewBuilder() *Builder {
+	pb := &Builder{
+		profile: &Profile{
+			StringTable: []string{""},
+		},
+	}
+	pb.stringIndex.getOrCreateHashed(maphash.String(indexSeed, ""), "", func() int64 { return 0 })
+	return pb
+}
+
+// AddString adds a string to the string table and returns its index
+func (pb *Builder) AddString(s string) int64 {
+	// Strings are hashed with maphash.String, like the bytes of AddBytes
+	return pb.stringIndex.getOrCreateHashed(maphash.String(indexSeed, s), s, func() int64 {
+		pb.mu.Lock()
+		defer pb.mu.Unlock()
+		idx := int64(len(pb.profile.StringTable))
+		pb.profile.StringTable = append(pb.profile.StringTable, s)
+		return idx
+	})
+}
+
+// AddBytes is AddString for the bytes of a string, such as a name in a
+// buffer being parsed. Bytes already in the table are found without
+// copying them to a new string.
+func (pb *Builder) AddBytes(b []byte) int64 {
+	shard := &pb.stringIndex.shards[maphash.Bytes(indexSeed, b)%indexShards]
+	shard.mu.RLock()
+	idx, ok := shard.m[string(b)]
+	shard.mu.RUnlock()
+	if ok {
+		return idx
+	}
+	return pb.AddString(string(b))
+}
+
+// String returns the string at index idx of the string table
+func (pb *Builder) String(idx int64) string {
+	pb.mu.RLock()
+	defer pb.mu.RUnlock()
+	return pb.profile.StringTable[idx]
+}
+
+// GetOrCreateFunction gets or creates a function and returns its ID
+func (pb *Builder) GetOrCreateFunction(name, filename string) uint64 {
+	nameID := pb.AddString(name)
+	return pb.GetOrCreateFunctionByID(nameID, pb.AddString(filename))
+}
+
+// GetOrCreateFunctionByID is GetOrCreateFunction for a name and filename
+// already added with AddString, given by their string table indices
+func (pb *Builder) GetOrCreateFunctionByID(name, filename int64) uint64 {
+	return pb.functionIndex.getOrCreate(frameKey{name, filename}, func() uint64 {
+		pb.mu.Lock()
+		defer pb.mu.Unlock()
+		fn := pb.functions.new()
+		*fn = Function{
+			Id:         uint64(len(pb.profile.Function) + 1),
+			Name:       name,
+			SystemName: name,
+			Filename:   filename,
+		}
+		pb.profile.Function = append(pb.profile.Function, fn)
+		return fn.Id
+	})
+}
+
+// GetOrCreateLocation gets or creates a location and returns its ID
+func (pb *Builder) GetOrCreateLocation(name, filename string) uint64 {
+	nameID := pb.AddString(name)
+	return pb.GetOrCreateLocationByID(nameID, pb.AddString(filename))
+}
+
+// GetOrCreateLocationByID is GetOrCreateLocation for a name and filename
+// already added with AddString. Callers that keep the string indices of
+// their frames look locations up without hashing the strings again.
+func (pb *Builder) GetOrCreateLocationByID(name, filename int64) uint64 {
+	return pb.locationIndex.getOrCreate(frameKey{name, filename}, func() uint64 {
+		fnID := pb.GetOrCreateFunctionByID(name, filename)
+		pb.mu.Lock()
+		defer pb.mu.Unlock()
+		line := pb.lines.new()
+		line.FunctionId = fnID
+		loc := pb.locations.new()
+		loc.Id = uint64(len(pb.profile.Location) + 1)
+		loc.Line = pb.linePtrs.alloc(1)
+		loc.Line[0] = line
+		pb.profile.Location = append(pb.profile.Location, loc)
+		return loc.Id
+	})
+}
+
+// NewStringLabel creates a string-valued sample label
+func (pb *Builder) NewStringLabel(key, value string) *Label {
+	return pb.newLabel(Label{Key: pb.AddString(key), Str: pb.AddString(value)})
+}
+
+// NewNumLabel creates a numeric sample label with an optional unit
+func (pb *Builder) NewNumLabel(key string, value int64, unit string) *Label {
+	l := Label{Key: pb.AddString(key), Num: value}
+	if unit != "" {
+		l.NumUnit = pb.AddString(unit)
+	}
+	return pb.newLabel(l)
+}
+
+// newLabel returns a copy of l allocated from the label slab
+func (pb *Builder) newLabel(l Label) *Label {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	p := pb.labels.new()
+	*p = l
+	return p
+}
+
+// SetSampleTypes sets the sample types in the profile
+func (pb *Builder) SetSampleTypes(types []struct{ Type, Unit string }) {
+	for _, t := range types {
+		pb.profile.SampleType = append(pb.profile.SampleType, &ValueType{
+			Type: pb.AddString(t.Type),
+			Unit: pb.AddString(t.Unit),
+		})
+	}
+}
+
+// SetPeriodType sets the period type in the profile
+func (pb *Builder) SetPeriodType(typeName, unit string) {
+	pb.profile.PeriodType = &ValueType{
+		Type: pb.AddString(typeName),
+		Unit: pb.AddString(unit),
+	}
+}
+
+// SetPeriod sets the sampling period, in units of the period type
+func (pb *Builder) SetPeriod(period int64) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	pb.profile.Period = period
+}
+
+// AddComment adds a free-form comment to the profile
+func (pb *Builder) AddComment(comment string) {
+	idx := pb.AddString(comment)
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	pb.profile.Comment = append(pb.profile.Comment, idx)
+}
+
+// Frame is a function frame of a sample's stack
+type Frame struct {
+	Name     string
+	Filename string
+}
+
+// AddSample adds a sample for a root-first stack of frames, creating its
+// locations as needed. Labels become string labels, in key order. It is
+// safe for concurrent use and fails when the number of values does not
+// match the sample types.
+func (pb *Builder) AddSample(frames []Frame, values []int64, labels map[string]string) error {
+	pb.mu.RLock()
+	numTypes := len(pb.profile.SampleType)
+	pb.mu.RUnlock()
+	if len(values) != numTypes {
+		return fmt.Errorf("got %d sample values for %d sample types", len(values), numTypes)
+	}
+
+	// pprof stacks are leaf first
+	locationIDs := make([]uint64, len(frames))
+	for i, f := range frames {
+		locationIDs[len(frames)-1-i] = pb.GetOrCreateLocation(f.Name, f.Filename)
+	}
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	sampleLabels := make([]*Label, 0, len(keys))
+	for _, key := range keys {
+		sampleLabels = append(sampleLabels, pb.NewStringLabel(key, labels[key]))
+	}
+
+	pb.AppendNewSample(locationIDs, values, sampleLabels)
+	return nil
+}
+
+// AppendNewSample adds a sample with copies of locationIds, values and the
+// concatenation of labels, allocated from slabs of the builder rather than
+// one by one. The locations and labels must have been created with this
+// builder. It is safe for concurrent use.
+func (pb *Builder) AppendNewSample(locationIds []uint64, values []int64, labels ...[]*Label) {
+	numLabels := 0
+	for _, ls := range labels {
+		numLabels += len(ls)
+	}
+
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	s := pb.samples.new()
+	s.LocationId = pb.locationIds.alloc(len(locationIds))
+	copy(s.LocationId, locationIds)
+	s.Value = pb.values.alloc(len(values))
+	copy(s.Value, values)
+	if numLabels > 0 {
+		s.Label = pb.labelPtrs.alloc(numLabels)[:0]
+		for _, ls := range labels {
+			s.Label = append(s.Label, ls...)
+		}
+	}
+	pb.profile.Sample = append(pb.profile.Sample, s)
+}
+
+// AppendSample adds a sample whose locations and labels were created with
+// this builder. It is safe for concurrent use.
+func (pb *Builder) AppendSample(s *Sample) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	pb.profile.Sample = append(pb.profile.Sample, s)
+}
+
+// Build returns the constructed profile
+func (pb *Builder) Build() *Profile {
+	return pb.profile
+}
@@ -0,0 +1,749 @@
+package profile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNewBuilder(t *testing.T) {
+	pb := NewBuilder()
+	if pb == nil {
+		t.Fatal("NewBuilder returned nil")
+	}
+	if pb.profile == nil {
+		t.Fatal("Builder profile is nil")
+	}
+	if len(pb.profile.StringTable) != 1 || pb.profile.StringTable[0] != "" {
+		t.Error("StringTable should start with empty string")
+	}
+	if pb.AddString("") != 0 {
+		t.Error("Empty string should have index 0")
+	}
+}
+
+func TestAddString(t *testing.T) {
+	pb := NewBuilder()
+
+	// Add first string
+	idx1 := pb.AddString("test")
+	if idx1 != 1 {
+		t.Errorf("Expected index 1, got %d", idx1)
+	}
+
+	// Add same string again (should return same index)
+	idx2 := pb.AddString("test")
+	if idx2 != idx1 {
+		t.Errorf("Expected same index %d, got %d", idx1, idx2)
+	}
+
+	// Add different string
+	idx3 := pb.AddString("other")
+	if idx3 != 2 {
+		t.Errorf("Expected index 2, got %d", idx3)
+	}
+
+	// Verify string table
+	if len(pb.profile.StringTable) != 3 {
+		t.Errorf("Expected 3 strings, got %d", len(pb.profile.StringTable))
+	}
+}
+
+func TestAddBytes(t *testing.T) {
+	pb := NewBuilder()
+	idx := pb.AddString("test")
+
+	buf := []byte("test")
+	if got := pb.AddBytes(buf); got != idx {
+		t.Errorf("Expected index %d for existing bytes, got %d", idx, got)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { pb.AddBytes(buf) }); allocs != 0 {
+		t.Errorf("Expected no allocation looking up existing bytes, got %v", allocs)
+	}
+
+	// New bytes are copied, so the buffer can be reused
+	other := pb.AddBytes(buf[:2])
+	buf[0] = 'x'
+	if other != 2 || pb.String(other) != "te" || pb.AddString("te") != other {
+		t.Errorf("Expected \"te\" at index 2, got %q at %d", pb.String(other), other)
+	}
+}
+
+func TestGetOrCreateFunction(t *testing.T) {
+	pb := NewBuilder()
+
+	// Create first function
+	id1 := pb.GetOrCreateFunction("func1", "file1.py")
+	if id1 != 1 {
+		t.Errorf("Expected function ID 1, got %d", id1)
+	}
+
+	// Get same function (should return same ID)
+	id2 := pb.GetOrCreateFunction("func1", "file1.py")
+	if id2 != id1 {
+		t.Errorf("Expected same ID %d, got %d", id1, id2)
+	}
+
+	// Create different function
+	id3 := pb.GetOrCreateFunction("func2", "file2.py")
+	if id3 != 2 {
+		t.Errorf("Expected function ID 2, got %d", id3)
+	}
+
+	// Verify functions created
+	if len(pb.profile.Function) != 2 {
+		t.Errorf("Expected 2 functions, got %d", len(pb.profile.Function))
+	}
+
+	// Verify function details
+	fn := pb.profile.Function[0]
+	if fn.Id != 1 {
+		t.Errorf("Expected function ID 1, got %d", fn.Id)
+	}
+}
+
+func TestGetOrCreateLocation(t *testing.T) {
+	pb := NewBuilder()
+
+	// Create first location
+	id1 := pb.GetOrCreateLocation("func1", "file1.py")
+	if id1 != 1 {
+		t.Errorf("Expected location ID 1, got %d", id1)
+	}
+
+	// Get same location (should return same ID)
+	id2 := pb.GetOrCreateLocation("func1", "file1.py")
+	if id2 != id1 {
+		t.Errorf("Expected same ID %d, got %d", id1, id2)
+	}
+
+	// Create different location
+	id3 := pb.GetOrCreateLocation("func2", "file2.py")
+	if id3 != 2 {
+		t.Errorf("Expected location ID 2, got %d", id3)
+	}
+
+	// Verify locations and functions created
+	if len(pb.profile.Location) != 2 {
+		t.Errorf("Expected 2 locations, got %d", len(pb.profile.Location))
+	}
+	if len(pb.profile.Function) != 2 {
+		t.Errorf("Expected 2 functions, got %d", len(pb.profile.Function))
+	}
+
+	// Verify location has line with function reference
+	loc := pb.profile.Location[0]
+	if len(loc.Line) != 1 {
+		t.Errorf("Expected 1 line, got %d", len(loc.Line))
+	}
+	if loc.Line[0].FunctionId != 1 {
+		t.Errorf("Expected function ID 1, got %d", loc.Line[0].FunctionId)
+	}
+}
+
+func TestGetOrCreateLocationByID(t *testing.T) {
+	pb := NewBuilder()
+
+	id1 := pb.GetOrCreateLocation("func1", "file1.py")
+	name, filename := pb.AddString("func1"), pb.AddString("file1.py")
+	if id := pb.GetOrCreateLocationByID(name, filename); id != id1 {
+		t.Errorf("Expected location ID %d by string indices, got %d", id1, id)
+	}
+
+	// Same name in another file is another location
+	other := pb.GetOrCreateLocationByID(name, pb.AddString("file2.py"))
+	if other == id1 {
+		t.Error("Expected a new location for another filename")
+	}
+	fn := pb.profile.Function[len(pb.profile.Function)-1]
+	if fn.Name != name || fn.SystemName != name {
+		t.Errorf("Expected function names %d, got %d and %d", name, fn.Name, fn.SystemName)
+	}
+	if len(pb.profile.StringTable) != 4 {
+		t.Errorf("Expected 4 strings, got %d", len(pb.profile.StringTable))
+	}
+}
+
+func TestSetSampleTypes(t *testing.T) {
+	pb := NewBuilder()
+
+	types := []struct{ Type, Unit string }{
+		{"samples", "count"},
+		{"time", "nanoseconds"},
+	}
+	pb.SetSampleTypes(types)
+
+	if len(pb.profile.SampleType) != 2 {
+		t.Errorf("Expected 2 sample types, got %d", len(pb.profile.SampleType))
+	}
+
+	// Verify strings were added
+	if len(pb.profile.StringTable) < 5 { // "", "samples", "count", "time", "nanoseconds"
+		t.Errorf("Expected at least 5 strings, got %d", len(pb.profile.StringTable))
+	}
+}
+
+func TestSetPeriodType(t *testing.T) {
+	pb := NewBuilder()
+
+	pb.SetPeriodType("cpu", "nanoseconds")
+
+	if pb.profile.PeriodType == nil {
+		t.Fatal("PeriodType is nil")
+	}
+
+	// Verify strings were added
+	if len(pb.profile.StringTable) < 3 { // "", "cpu", "nanoseconds"
+		t.Errorf("Expected at least 3 strings, got %d", len(pb.profile.StringTable))
+	}
+}
+
+func TestProfileEncode(t *testing.T) {
+	pb := NewBuilder()
+
+	// Set up a minimal profile
+	pb.SetSampleTypes([]struct{ Type, Unit string }{
+		{"samples", "count"},
+	})
+	pb.SetPeriodType("cpu", "nanoseconds")
+
+	// Create a location
+	locId := pb.GetOrCreateLocation("test_func", "test.py")
+
+	// Add a sample
+	pb.profile.Sample = append(pb.profile.Sample, &Sample{
+		LocationId: []uint64{locId},
+		Value:      []int64{1},
+	})
+
+	// Build and encode
+	profile := pb.Build()
+	data, err := profile.Encode()
+
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	if len(data) == 0 {
+		t.Error("Encoded data is empty")
+	}
+
+	// Basic sanity check - encoded data should be substantial
+	if len(data) < 20 {
+		t.Errorf("Encoded data seems too small: %d bytes", len(data))
+	}
+}
+
+func TestEncodeVarint(t *testing.T) {
+	tests := []struct {
+		input    uint64
+		expected []byte
+	}{
+		{0, []byte{0}},
+		{1, []byte{1}},
+		{127, []byte{127}},
+		{128, []byte{0x80, 0x01}},
+		{300, []byte{0xac, 0x02}},
+	}
+
+	for _, tt := range tests {
+		result := appendVarint(nil, tt.input)
+		if len(result) != varintSize(tt.input) {
+			t.Errorf("varintSize(%d): expected %d, got %d", tt.input, len(result), varintSize(tt.input))
+		}
+		if len(result) != len(tt.expected) {
+			t.Errorf("appendVarint(%d): expected length %d, got %d", tt.input, len(tt.expected), len(result))
+			continue
+		}
+		for i := range result {
+			if result[i] != tt.expected[i] {
+				t.Errorf("appendVarint(%d): expected %v, got %v", tt.input, tt.expected, result)
+				break
+			}
+		}
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	pb := NewBuilder()
+
+	// Test concurrent string addition
+	done := make(chan bool)
+	for i := 0; i < 10; i++ {
+		go func(n int) {
+			for j := 0; j < 100; j++ {
+				pb.AddString("test")
+			}
+			done <- true
+		}(i)
+	}
+
+	for i := 0; i < 10; i++ {
+		<-done
+	}
+
+	// "test" should only appear once in the string table
+	count := 0
+	for _, s := range pb.profile.StringTable {
+		if s == "test" {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("Expected 'test' to appear once, found %d times", count)
+	}
+}
+
+func TestConcurrentLocations(t *testing.T) {
+	pb := NewBuilder()
+	var wg sync.WaitGroup
+	ids := make([][]uint64, 8)
+	for w := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 500 {
+				ids[w] = append(ids[w], pb.GetOrCreateLocation(fmt.Sprintf("op%d", i), "cpu_op"))
+			}
+		}()
+	}
+	wg.Wait()
+
+	p := pb.Build()
+	if len(p.Location) != 500 || len(p.Function) != 500 || len(p.StringTable) != 502 {
+		t.Fatalf("Expected 500 locations and functions and 502 strings, got %d, %d and %d", len(p.Location), len(p.Function), len(p.StringTable))
+	}
+	for w := range ids {
+		for i, id := range ids[w] {
+			if id != ids[0][i] {
+				t.Fatalf("Worker %d got location %d for op%d, worker 0 got %d", w, id, i, ids[0][i])
+			}
+			loc := p.Location[id-1]
+			if fn := p.Function[loc.Line[0].FunctionId-1]; loc.Id != id || p.StringTable[fn.Name] != fmt.Sprintf("op%d", i) {
+				t.Fatalf("Location %d does not point to op%d", id, i)
+			}
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	pb := NewBuilder()
+
+	pb.SetSampleTypes([]struct{ Type, Unit string }{
+		{"samples", "count"},
+		{"time", "nanoseconds"},
+	})
+
+	profile := pb.Build()
+
+	if profile == nil {
+		t.Fatal("Build returned nil")
+	}
+
+	if len(profile.SampleType) != 2 {
+		t.Errorf("Expected 2 sample types, got %d", len(profile.SampleType))
+	}
+}
+
+func TestLabels(t *testing.T) {
+	pb := NewBuilder()
+
+	str := pb.NewStringLabel("grid", "128x1x1")
+	if pb.profile.StringTable[str.Key] != "grid" || pb.profile.StringTable[str.Str] != "128x1x1" {
+		t.Errorf("Unexpected string label: %+v", str)
+	}
+
+	num := pb.NewNumLabel("shared_memory", 1024, "bytes")
+	if num.Num != 1024 || pb.profile.StringTable[num.NumUnit] != "bytes" {
+		t.Errorf("Unexpected numeric label: %+v", num)
+	}
+
+	encoded := appendSample(nil, &Sample{LocationId: []uint64{1}, Value: []int64{1}, Label: []*Label{str, num}})
+	plain := appendSample(nil, &Sample{LocationId: []uint64{1}, Value: []int64{1}})
+	if len(encoded) <= len(plain) {
+		t.Errorf("Expected labels to be encoded, got %d bytes vs %d without labels", len(encoded), len(plain))
+	}
+}
+
+// buildTestProfile builds a two-value profile with one sample per stack
+func buildTestProfile(stacks map[string]int64) *Profile {
+	pb := NewBuilder()
+	pb.SetSampleTypes([]struct{ Type, Unit string }{
+		{"samples", "count"},
+		{"time", "nanoseconds"},
+	})
+	pb.SetPeriodType("time", "nanoseconds")
+	for name, value := range stacks {
+		if err := pb.AddSample([]Frame{{Name: name}}, []int64{1, value}, map[string]string{"pid": "1"}); err != nil {
+			panic(err)
+		}
+	}
+	return pb.Build()
+}
+
+func TestAddSample(t *testing.T) {
+	pb := NewBuilder()
+	pb.SetSampleTypes([]struct{ Type, Unit string }{{"time", "nanoseconds"}})
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			frames := []Frame{{Name: "forward", Filename: "python"}, {Name: fmt.Sprintf("op%d", i%2)}}
+			if err := pb.AddSample(frames, []int64{100}, map[string]string{"rank": "0", "gpu": "1"}); err != nil {
+				t.Errorf("AddSample: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	p := pb.Build()
+	if len(p.Sample) != 8 || len(p.Location) != 3 {
+		t.Fatalf("Expected 8 samples and 3 locations, got %d and %d", len(p.Sample), len(p.Location))
+	}
+	s := p.Sample[0]
+	leaf := p.StringTable[p.Function[p.Location[s.LocationId[0]-1].Line[0].FunctionId-1].Name]
+	root := p.StringTable[p.Function[p.Location[s.LocationId[1]-1].Line[0].FunctionId-1].Name]
+	if root != "forward" || !strings.HasPrefix(leaf, "op") {
+		t.Errorf("Expected leaf-first [op, forward], got [%s, %s]", leaf, root)
+	}
+	if len(s.Label) != 2 || p.StringTable[s.Label[0].Key] != "gpu" || p.StringTable[s.Label[1].Key] != "rank" {
+		t.Errorf("Expected labels sorted by key, got %+v", s.Label)
+	}
+
+	if err := pb.AddSample([]Frame{{Name: "x"}}, []int64{1, 2}, nil); err == nil {
+		t.Error("Expected an error for mismatched values")
+	}
+}
+
+func TestAppendNewSample(t *testing.T) {
+	pb := NewBuilder()
+	pb.SetSampleTypes([]struct{ Type, Unit string }{{"samples", "count"}, {"time", "nanoseconds"}})
+	loc := pb.GetOrCreateLocation("op", "")
+	rank := []*Label{pb.NewStringLabel("rank", "0")}
+
+	// Enough samples to span several slab chunks, from reused buffers
+	n := 3 * slabSize
+	values := make([]int64, 2)
+	for i := range n {
+		values[0], values[1] = 1, int64(i)
+		pb.AppendNewSample([]uint64{loc}, values, []*Label{pb.NewNumLabel("step", int64(i), "")}, rank)
+	}
+
+	p := pb.Build()
+	if len(p.Sample) != n {
+		t.Fatalf("Expected %d samples, got %d", n, len(p.Sample))
+	}
+	for i, s := range p.Sample {
+		if s.Value[1] != int64(i) || s.Label[0].Num != int64(i) {
+			t.Fatalf("Sample %d: got value %d and step %d", i, s.Value[1], s.Label[0].Num)
+		}
+		if len(s.LocationId) != 1 || len(s.Label) != 2 || p.StringTable[s.Label[1].Key] != "rank" {
+			t.Fatalf("Sample %d: got locations %v and %d labels", i, s.LocationId, len(s.Label))
+		}
+	}
+
+	// Entries handed out from one chunk must not overlap
+	p.Sample[0].Value = append(p.Sample[0].Value, 0)
+	p.Sample[0].Label = append(p.Sample[0].Label, rank...)
+	if p.Sample[1].Value[0] != 1 || p.Sample[1].Label[0].Num != 1 {
+		t.Error("Appending to a sample overwrote the next one")
+	}
+}
+
+func TestDecodeRoundTrip(t *testing.T) {
+	p := buildTestProfile(map[string]int64{"matmul": 100})
+	p.Mapping = []*Mapping{{Id: 1, MemoryStart: 0x1000, HasFunctions: true}}
+	p.Location[0].MappingId = 1
+	p.Location[0].IsFolded = true
+	p.TimeNanos = 42
+	p.Sample[0].Value[0] = -1 // Ten-byte varint
+
+	data, err := p.Encode()
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if cap(data) != len(data) {
+		t.Errorf("Expected Encode to allocate exactly %d bytes, got capacity %d", len(data), cap(data))
+	}
+	decoded, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	if len(decoded.Sample) != 1 || decoded.Sample[0].Value[1] != 100 {
+		t.Fatalf("Unexpected samples: %+v", decoded.Sample)
+	}
+	if len(decoded.Sample[0].Label) != 1 || decoded.StringTable[decoded.Sample[0].Label[0].Key] != "pid" {
+		t.Errorf("Expected pid label, got %+v", decoded.Sample[0].Label)
+	}
+	if len(decoded.Mapping) != 1 || decoded.Mapping[0].MemoryStart != 0x1000 || !decoded.Mapping[0].HasFunctions {
+		t.Errorf("Unexpected mappings: %+v", decoded.Mapping)
+	}
+	if decoded.Sample[0].Value[0] != -1 {
+		t.Errorf("Expected value -1, got %d", decoded.Sample[0].Value[0])
+	}
+	if decoded.Location[0].MappingId != 1 || !decoded.Location[0].IsFolded {
+		t.Errorf("Expected folded location with mapping 1, got %+v", decoded.Location[0])
+	}
+	fn := decoded.Function[0]
+	if decoded.StringTable[fn.Name] != "matmul" {
+		t.Errorf("Expected function 'matmul', got %q", decoded.StringTable[fn.Name])
+	}
+	if decoded.TimeNanos != 42 || decoded.PeriodType == nil {
+		t.Errorf("Unexpected header: time %d, period type %v", decoded.TimeNanos, decoded.PeriodType)
+	}
+
+	if _, err := Decode(data[:len(data)-1]); err == nil {
+		t.Error("Expected error for truncated profile")
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	stacks := make(map[string]int64)
+	for i := range 5000 {
+		stacks[fmt.Sprintf("main;op%d;kernel%d", i%50, i)] = int64(i)
+	}
+	p := buildTestProfile(stacks)
+	want, err := p.Encode()
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if len(want) < 2*encodeChunkSize {
+		t.Fatalf("Expected a profile of several chunks, got %d bytes", len(want))
+	}
+
+	var buf bytes.Buffer
+	n, err := p.WriteTo(&buf)
+	if err != nil || n != int64(len(want)) || !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("WriteTo wrote %d bytes (%v), different from Encode's %d", n, err, len(want))
+	}
+
+	errWrite := errors.New("disk full")
+	if _, err := p.WriteTo(&failingWriter{n: encodeChunkSize, err: errWrite}); !errors.Is(err, errWrite) {
+		t.Errorf("Expected the write error, got %v", err)
+	}
+}
+
+// failingWriter accepts n bytes, then fails with err
+type failingWriter struct {
+	n   int
+	err error
+}
+
+func (w *failingWriter) Write(b []byte) (int, error) {
+	if len(b) > w.n {
+		n := w.n
+		w.n = 0
+		return n, w.err
+	}
+	w.n -= len(b)
+	return len(b), nil
+}
+
+func TestMerge(t *testing.T) {
+	a := buildTestProfile(map[string]int64{"matmul": 100, "relu": 10})
+	b := buildTestProfile(map[string]int64{"matmul": 50, "softmax": 5})
+
+	merged, err := Merge([]*Profile{a, b})
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+
+	got := map[string][]int64{}
+	for _, s := range merged.Sample {
+		loc := merged.Location[s.LocationId[0]-1]
+		fn := merged.Function[loc.Line[0].FunctionId-1]
+		got[merged.StringTable[fn.Name]] = s.Value
+	}
+	want := map[string][]int64{"matmul": {2, 150}, "relu": {1, 10}, "softmax": {1, 5}}
+	for name, values := range want {
+		if v := got[name]; len(v) != 2 || v[0] != values[0] || v[1] != values[1] {
+			t.Errorf("%s: expected %v, got %v", name, values, v)
+		}
+	}
+	if len(merged.Sample) != 3 || len(merged.Function) != 3 {
+		t.Errorf("Expected 3 samples and functions, got %d and %d", len(merged.Sample), len(merged.Function))
+	}
+
+	other := NewBuilder()
+	other.SetSampleTypes([]struct{ Type, Unit string }{{"alloc", "bytes"}})
+	if _, err := Merge([]*Profile{a, other.Build()}); err == nil {
+		t.Error("Expected error for mismatched sample types")
+	}
+}
+
+func TestSetLabel(t *testing.T) {
+	a := buildTestProfile(map[string]int64{"matmul": 100})
+	b := buildTestProfile(map[string]int64{"matmul": 50})
+	a.SetLabel("source", "r0.json")
+	b.SetLabel("source", "r1.json")
+	b.SetLabel("source", "rank1.json")
+
+	merged, err := Merge([]*Profile{a, b})
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if len(merged.Sample) != 2 {
+		t.Fatalf("Expected labeled samples to stay apart, got %d samples", len(merged.Sample))
+	}
+	got := map[string]int64{}
+	for _, s := range merged.Sample {
+		var sources []string
+		for _, l := range s.Label {
+			if merged.StringTable[l.Key] == "source" {
+				sources = append(sources, merged.StringTable[l.Str])
+			}
+		}
+		if len(sources) != 1 {
+			t.Fatalf("Expected a single source label, got %v", sources)
+		}
+		got[sources[0]] = s.Value[1]
+	}
+	if got["r0.json"] != 100 || got["rank1.json"] != 50 {
+		t.Errorf("Unexpected values by source: %v", got)
+	}
+	if errs := merged.Validate(); len(errs) > 0 {
+		t.Errorf("Merged profile is invalid: %v", errs)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	base := buildTestProfile(map[string]int64{"matmul": 100, "relu": 10, "gelu": 7})
+	candidate := buildTestProfile(map[string]int64{"matmul": 150, "softmax": 5, "gelu": 7})
+
+	delta, err := Diff(base, candidate)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+
+	got := delta.LeafTotals(1)
+	want := map[string]int64{"matmul": 50, "relu": -10, "softmax": 5}
+	for name, v := range want {
+		if got[name] != v {
+			t.Errorf("%s: expected %d, got %d", name, v, got[name])
+		}
+	}
+	if _, ok := got["gelu"]; ok || len(delta.Sample) != 3 {
+		t.Errorf("Expected unchanged stacks to be dropped, got %v", got)
+	}
+	if base.Sample[0].Value[1] <= 0 {
+		t.Error("Diff must not modify the baseline profile")
+	}
+}
+
+func TestCallTreeAndTop(t *testing.T) {
+	pb := NewBuilder()
+	pb.SetSampleTypes([]struct{ Type, Unit string }{{"time", "nanoseconds"}})
+	p := pb.Build()
+	forward := pb.GetOrCreateLocation("forward", "")
+	matmul := pb.GetOrCreateLocation("matmul", "")
+	p.Sample = []*Sample{
+		{LocationId: []uint64{matmul, forward, forward}, Value: []int64{30}},
+		{LocationId: []uint64{forward}, Value: []int64{10}},
+	}
+
+	root := p.CallTree(0)
+	if root.Total != 40 || len(root.Children) != 1 {
+		t.Fatalf("Unexpected root: %+v", root)
+	}
+	fwd := root.Children[0]
+	if fwd.Name != "forward" || fwd.Total != 40 || fwd.Self != 10 {
+		t.Errorf("Unexpected forward node: %+v", fwd)
+	}
+
+	top := p.Top(0)
+	if len(top) != 2 || top[0] != (TopEntry{Name: "matmul", Flat: 30, Cum: 30}) {
+		t.Fatalf("Unexpected top: %+v", top)
+	}
+	// Recursive frames count once towards cum
+	if top[1] != (TopEntry{Name: "forward", Flat: 10, Cum: 40}) {
+		t.Errorf("Unexpected forward entry: %+v", top[1])
+	}
+
+	if i, err := p.SampleIndex("time"); err != nil || i != 0 {
+		t.Errorf("SampleIndex(time) = %d, %v", i, err)
+	}
+	if _, err := p.SampleIndex("alloc"); err == nil {
+		t.Error("Expected error for unknown sample type")
+	}
+}
+
+func TestWriteFolded(t *testing.T) {
+	pb := NewBuilder()
+	pb.SetSampleTypes([]struct{ Type, Unit string }{{"time", "nanoseconds"}})
+	p := pb.Build()
+	forward := pb.GetOrCreateLocation("forward", "")
+	matmul := pb.GetOrCreateLocation("a;b", "")
+	p.Sample = []*Sample{
+		{LocationId: []uint64{matmul, forward}, Value: []int64{30}},
+		{LocationId: []uint64{forward}, Value: []int64{10}},
+		{LocationId: []uint64{matmul, forward}, Value: []int64{5}},
+	}
+
+	var buf strings.Builder
+	if err := p.WriteFolded(&buf, 0); err != nil {
+		t.Fatalf("WriteFolded failed: %v", err)
+	}
+	want := "forward 10\nforward;a:b 35\n"
+	if buf.String() != want {
+		t.Errorf("Expected %q, got %q", want, buf.String())
+	}
+}
+
+func TestLeafStacks(t *testing.T) {
+	pb := NewBuilder()
+	pb.SetSampleTypes([]struct{ Type, Unit string }{{"time", "nanoseconds"}})
+	p := pb.Build()
+	forward := pb.GetOrCreateLocation("forward", "")
+	backward := pb.GetOrCreateLocation("backward", "")
+	matmul := pb.GetOrCreateLocation("matmul", "")
+	p.Sample = []*Sample{
+		{LocationId: []uint64{matmul, forward}, Value: []int64{10}},
+		{LocationId: []uint64{matmul, backward}, Value: []int64{30}},
+		{LocationId: []uint64{matmul, forward}, Value: []int64{5}},
+		{LocationId: []uint64{forward}, Value: []int64{100}},
+	}
+
+	stacks := p.LeafStacks(0, "matmul")
+	if len(stacks) != 2 {
+		t.Fatalf("Expected 2 stacks, got %+v", stacks)
+	}
+	if strings.Join(stacks[0].Frames, ";") != "backward;matmul" || stacks[0].Value != 30 {
+		t.Errorf("Unexpected first stack: %+v", stacks[0])
+	}
+	if strings.Join(stacks[1].Frames, ";") != "forward;matmul" || stacks[1].Value != 15 {
+		t.Errorf("Unexpected second stack: %+v", stacks[1])
+	}
+}
+
+func TestValidate(t *testing.T) {
+	p := buildTestProfile(map[string]int64{"matmul": 100})
+	if problems := p.Validate(); len(problems) != 0 {
+		t.Fatalf("Expected a valid profile, got %v", problems)
+	}
+
+	p.Sample = append(p.Sample, &Sample{LocationId: []uint64{99}, Value: []int64{1}})
+	p.Location[0].Line[0].FunctionId = 42
+	p.Function[0].Name = int64(len(p.StringTable))
+
+	problems := p.Validate()
+	want := []string{
+		"function 1 name: string index",
+		"location 1 references unknown function 42",
+		"sample 1 has 1 values, expected 2",
+		"sample 1 references unknown location 99",
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("Expected %d problems, got %v", len(want), problems)
+	}
+	for i, w := range want {
+		if !strings.HasPrefix(problems[i].Error(), w) {
+			t.Errorf("Problem %d: expected %q, got %q", i, w, problems[i])
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package profile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Node is a frame of a call tree aggregated from profile samples
+type Node struct {
+	Name     string
+	Self     int64
+	Total    int64
+	Children []*Node
+}
+
+// Stack is a distinct call stack, root first, and its aggregated value
+type Stack struct {
+	Frames []string
+	Value  int64
+}
+
+// TopEntry holds the flat and cumulative value of one function
+type TopEntry struct {
+	Name string
+	Flat int64
+	Cum  int64
+}
+
+// SampleIndex returns the index of the sample type named name. An empty name
+// selects the last sample type, matching pprof's default.
+func (p *Profile) SampleIndex(name string) (int, error) {
+	if len(p.SampleType) == 0 {
+		return 0, fmt.Errorf("profile has no sample types")
+	}
+	if name == "" {
+		return len(p.SampleType) - 1, nil
+	}
+	var names []string
+	for i, st := range p.SampleType {
+		typ := lookupString(p, st.Type)
+		if typ == name {
+			return i, nil
+		}
+		names = append(names, typ)
+	}
+	return 0, fmt.Errorf("unknown sample type %q (available: %v)", name, names)
+}
+
+// CallTree aggregates the value at index of all samples into a tree rooted
+// at a synthetic "root" node. Children are sorted by decreasing total.
+func (p *Profile) CallTree(index int) *Node {
+	root := &Node{Name: "root"}
+	locationNames := p.locationNames()
+	children := map[*Node]map[string]*Node{}
+	for _, s := range p.Sample {
+		if index >= len(s.Value) {
+			continue
+		}
+		v := s.Value[index]
+		node := root
+		node.Total += v
+		for _, name := range stackNames(s, locationNames) {
+			byName := children[node]
+			if byName == nil {
+				byName = map[string]*Node{}
+				children[node] = byName
+			}
+			child := byName[name]
+			if child == nil {
+				child = &Node{Name: name}
+				byName[name] = child
+				node.Children = append(node.Children, child)
+			}
+			child.Total += v
+			node = child
+		}
+		node.Self += v
+	}
+	root.sort()
+	return root
+}
+
+func (n *Node) sort() {
+	sort.Slice(n.Children, func(i, j int) bool {
+		if n.Children[i].Total != n.Children[j].Total {
+			return n.Children[i].Total > n.Children[j].Total
+		}
+		return n.Children[i].Name < n.Children[j].Name
+	})
+	for _, c := range n.Children {
+		c.sort()
+	}
+}
+
+// Top returns the flat and cumulative value at index of every function,
+// sorted by decreasing flat value. A function appearing several times in one
+// stack counts once towards its cumulative value.
+func (p *Profile) Top(index int) []TopEntry {
+	entries := map[string]*TopEntry{}
+	get := func(name string) *TopEntry {
+		e := entries[name]
+		if e == nil {
+			e = &TopEntry{Name: name}
+			entries[name] = e
+		}
+		return e
+	}
+	locationNames := p.locationNames()
+	for _, s := range p.Sample {
+		if index >= len(s.Value) {
+			continue
+		}
+		v := s.Value[index]
+		names := stackNames(s, locationNames)
+		if len(names) == 0 {
+			continue
+		}
+		get(names[len(names)-1]).Flat += v
+		seen := make(map[string]bool, len(names))
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				get(name).Cum += v
+			}
+		}
+	}
+
+	result := make([]TopEntry, 0, len(entries))
+	for _, e := range entries {
+		result = append(result, *e)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Flat != result[j].Flat {
+			return result[i].Flat > result[j].Flat
+		}
+		if result[i].Cum != result[j].Cum {
+			return result[i].Cum > result[j].Cum
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// LeafStacks returns the distinct stacks whose leaf frame is name, with the
+// value at index summed per stack, sorted by decreasing value
+func (p *Profile) LeafStacks(index int, name string) []Stack {
+	byKey := make(map[string]*Stack)
+	var stacks []*Stack
+	locationNames := p.locationNames()
+	for _, s := range p.Sample {
+		if index >= len(s.Value) {
+			continue
+		}
+		names := stackNames(s, locationNames)
+		if len(names) == 0 || names[len(names)-1] != name {
+			continue
+		}
+		key := strings.Join(names, "\x00")
+		st := byKey[key]
+		if st == nil {
+			st = &Stack{Frames: names}
+			byKey[key] = st
+			stacks = append(stacks, st)
+		}
+		st.Value += s.Value[index]
+	}
+
+	result := make([]Stack, len(stacks))
+	for i, st := range stacks {
+		result[i] = *st
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Value > result[j].Value })
+	return result
+}
+
+// WriteFolded writes the value at index of every distinct stack in folded
+// format ("root;child;leaf value" per line), as consumed by flamegraph.pl
+// and speedscope. Semicolons in frame names are replaced with colons.
+func (p *Profile) WriteFolded(w io.Writer, index int) error {
+	totals := make(map[string]int64)
+	var stacks []string
+	locationNames := p.locationNames()
+	for _, s := range p.Sample {
+		if index >= len(s.Value) {
+			continue
+		}
+		names := stackNames(s, locationNames)
+		for i, name := range names {
+			names[i] = strings.ReplaceAll(name, ";", ":")
+		}
+		stack := strings.Join(names, ";")
+		if _, ok := totals[stack]; !ok {
+			stacks = append(stacks, stack)
+		}
+		totals[stack] += s.Value[index]
+	}
+
+	sort.Strings(stacks)
+	bw := bufio.NewWriter(w)
+	for _, stack := range stacks {
+		if _, err := fmt.Fprintf(bw, "%s %d\n", stack, totals[stack]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// locationNames returns the function names of every location, root first.
+// Inlined frames of a location are expanded.
+func (p *Profile) locationNames() map[uint64][]string {
+	functionNames := make(map[uint64]string, len(p.Function))
+	for _, fn := range p.Function {
+		functionNames[fn.Id] = lookupString(p, fn.Name)
+	}
+	names := make(map[uint64][]string, len(p.Location))
+	for _, loc := range p.Location {
+		frames := make([]string, 0, len(loc.Line))
+		// Lines are listed innermost first
+		for i := len(loc.Line) - 1; i >= 0; i-- {
+			frames = append(frames, functionNames[loc.Line[i].FunctionId])
+		}
+		names[loc.Id] = frames
+	}
+	return names
+}
+
+// stackNames returns the function names of a sample's stack, root first
+func stackNames(s *Sample, locationNames map[uint64][]string) []string {
+	var names []string
+	for i := len(s.LocationId) - 1; i >= 0; i-- {
+		names = append(names, locationNames[s.LocationId[i]]...)
+	}
+	return names
+}
+
+// FormatValue renders a sample value in a human-friendly unit
+func FormatValue(v int64, unit string) string {
+	if unit != "nanoseconds" {
+		return fmt.Sprint(v)
+	}
+	switch abs := max(v, -v); {
+	case abs >= 1e9:
+		return fmt.Sprintf("%.2fs", float64(v)/1e9)
+	case abs >= 1e6:
+		return fmt.Sprintf("%.2fms", float64(v)/1e6)
+	case abs >= 1e3:
+		return fmt.Sprintf("%.2fus", float64(v)/1e3)
+	}
+	return fmt.Sprintf("%dns", v)
+}
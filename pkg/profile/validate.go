@@ -0,0 +1,114 @@
+package profile
+
+import "fmt"
+
+// maxProblems caps the problems Validate reports, so a thoroughly broken
+// profile does not produce millions of lines
+const maxProblems = 100
+
+// Validate checks the referential integrity of the profile: string indices,
+// location, function and mapping ids, and the number of values per sample.
+// It returns the problems found, at most maxProblems, or nil if there are none.
+func (p *Profile) Validate() []error {
+	v := &validator{p: p}
+
+	if len(p.StringTable) == 0 || p.StringTable[0] != "" {
+		v.addf("string table must start with the empty string")
+	}
+	if len(p.SampleType) == 0 {
+		v.addf("profile has no sample types")
+	}
+	for i, st := range p.SampleType {
+		v.checkString(st.Type, "sample type %d type", i)
+		v.checkString(st.Unit, "sample type %d unit", i)
+	}
+	if p.PeriodType != nil {
+		v.checkString(p.PeriodType.Type, "period type")
+		v.checkString(p.PeriodType.Unit, "period unit")
+	}
+	v.checkString(p.DropFrames, "drop_frames")
+	v.checkString(p.KeepFrames, "keep_frames")
+	v.checkString(p.DefaultSampleType, "default sample type")
+	for i, c := range p.Comment {
+		v.checkString(c, "comment %d", i)
+	}
+
+	mappings := make(map[uint64]bool, len(p.Mapping))
+	for _, m := range p.Mapping {
+		v.checkID(m.Id, mappings, "mapping")
+		v.checkString(m.Filename, "mapping %d filename", m.Id)
+		v.checkString(m.BuildId, "mapping %d build id", m.Id)
+	}
+
+	functions := make(map[uint64]bool, len(p.Function))
+	for _, fn := range p.Function {
+		v.checkID(fn.Id, functions, "function")
+		v.checkString(fn.Name, "function %d name", fn.Id)
+		v.checkString(fn.SystemName, "function %d system name", fn.Id)
+		v.checkString(fn.Filename, "function %d filename", fn.Id)
+	}
+
+	locations := make(map[uint64]bool, len(p.Location))
+	for _, loc := range p.Location {
+		v.checkID(loc.Id, locations, "location")
+		if loc.MappingId != 0 && !mappings[loc.MappingId] {
+			v.addf("location %d references unknown mapping %d", loc.Id, loc.MappingId)
+		}
+		for _, line := range loc.Line {
+			if !functions[line.FunctionId] {
+				v.addf("location %d references unknown function %d", loc.Id, line.FunctionId)
+			}
+		}
+	}
+
+	for i, s := range p.Sample {
+		if len(s.Value) != len(p.SampleType) {
+			v.addf("sample %d has %d values, expected %d", i, len(s.Value), len(p.SampleType))
+		}
+		for _, id := range s.LocationId {
+			if !locations[id] {
+				v.addf("sample %d references unknown location %d", i, id)
+			}
+		}
+		for _, l := range s.Label {
+			v.checkString(l.Key, "sample %d label key", i)
+			v.checkString(l.Str, "sample %d label value", i)
+			v.checkString(l.NumUnit, "sample %d label unit", i)
+			if l.Str != 0 && l.Num != 0 {
+				v.addf("sample %d label %q has both a string and a numeric value", i, lookupString(p, l.Key))
+			}
+		}
+	}
+
+	return v.problems
+}
+
+// validator collects problems found by Validate
+type validator struct {
+	p        *Profile
+	problems []error
+}
+
+func (v *validator) addf(format string, args ...interface{}) {
+	if len(v.problems) < maxProblems {
+		v.problems = append(v.problems, fmt.Errorf(format, args...))
+	}
+}
+
+// checkString reports a string index outside the string table
+func (v *validator) checkString(idx int64, format string, args ...interface{}) {
+	if idx < 0 || idx >= int64(len(v.p.StringTable)) {
+		v.addf("%s: string index %d out of range (%d strings)", fmt.Sprintf(format, args...), idx, len(v.p.StringTable))
+	}
+}
+
+// checkID reports zero and duplicate ids
+func (v *validator) checkID(id uint64, seen map[uint64]bool, kind string) {
+	switch {
+	case id == 0:
+		v.addf("%s has id 0", kind)
+	case seen[id]:
+		v.addf("duplicate %s id %d", kind, id)
+	}
+	seen[id] = true
+}